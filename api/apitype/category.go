@@ -2,6 +2,7 @@ package apitype
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"vincit.fi/image-sorter/common"
 )
@@ -10,28 +11,45 @@ type CategoryId int64
 
 const NoCategory = CategoryId(-1)
 
+// Separates the levels of nested category names, e.g. "Travel/Japan/Kyoto"
+const CategoryNameSeparator = "/"
+
 type Category struct {
 	id       CategoryId
+	parent   *Category
 	name     string
 	subPath  string
-	shortcut uint
+	shortcut common.KeySequence
+	// File name template of the category, e.g. "{exif:Model}_{seq:3}". Empty if
+	// the template given when the categories are applied is used.
+	renameTemplate string
 }
 
 func NewPersistedCategory(id CategoryId, category *Category) *Category {
 	return &Category{
-		id:       id,
-		name:     category.name,
-		subPath:  category.subPath,
-		shortcut: category.shortcut,
+		id:             id,
+		parent:         category.parent,
+		name:           category.name,
+		subPath:        category.subPath,
+		shortcut:       category.shortcut,
+		renameTemplate: category.renameTemplate,
 	}
 }
 
 func NewCategoryWithId(id CategoryId, name string, subPath string, shortcut string) *Category {
+	return NewCategoryWithParent(id, nil, name, subPath, shortcut)
+}
+
+// Parent is nil for the top level categories. The shortcut is a key sequence such as "G 3"
+// or "Ctrl+G". Invalid shortcuts are ignored; they are validated when they are entered.
+func NewCategoryWithParent(id CategoryId, parent *Category, name string, subPath string, shortcut string) *Category {
+	sequence, _ := common.ParseKeySequence(shortcut)
 	return &Category{
 		id:       id,
+		parent:   parent,
 		name:     name,
 		subPath:  subPath,
-		shortcut: common.KeyToUint(shortcut),
+		shortcut: sequence,
 	}
 }
 
@@ -43,31 +61,91 @@ func (s *Category) Id() CategoryId {
 	return s.id
 }
 
+func (s *Category) Parent() *Category {
+	return s.parent
+}
+
+func (s *Category) ParentId() CategoryId {
+	if s.parent == nil {
+		return NoCategory
+	}
+	return s.parent.id
+}
+
+// Returns true if the category is the given category or any of its sub categories
+func (s *Category) IsInCategory(categoryId CategoryId) bool {
+	for category := s; category != nil; category = category.parent {
+		if category.id == categoryId {
+			return true
+		}
+	}
+	return false
+}
+
+// Path of the category itself. See Path for the path derived from the parents.
 func (s *Category) SubPath() string {
 	return s.subPath
 }
 
+// Path of the category under the parent categories, e.g. "travel/japan/kyoto".
+// Relative paths of sub categories are relative to the parent; absolute paths
+// are used as is.
+func (s *Category) Path() string {
+	if s.parent == nil || filepath.IsAbs(s.subPath) {
+		return s.subPath
+	}
+	return filepath.Join(s.parent.Path(), s.subPath)
+}
+
+func (s *Category) RenameTemplate() string {
+	return s.renameTemplate
+}
+
+func (s *Category) SetRenameTemplate(renameTemplate string) {
+	s.renameTemplate = renameTemplate
+}
+
 func (s *Category) Name() string {
 	return s.name
 }
 
+// Name including the parent categories, e.g. "Travel/Japan/Kyoto"
+func (s *Category) FullName() string {
+	if s.parent == nil {
+		return s.name
+	}
+	return s.parent.FullName() + CategoryNameSeparator + s.name
+}
+
 func (s *Category) String() string {
-	return s.name
+	return s.FullName()
 }
 
-func (s *Category) Shortcut() uint {
+func (s *Category) Shortcut() common.KeySequence {
 	return s.shortcut
 }
 
 func (s *Category) ShortcutAsString() string {
-	return common.KeyvalName(s.shortcut)
+	return s.shortcut.String()
 }
 
-func (s *Category) HasShortcut(val uint) bool {
-	return s.shortcut == val
+func (s *Category) HasShortcut(sequence common.KeySequence) bool {
+	return s.shortcut.Equals(sequence)
 }
 
 func (s *Category) Serialize() string {
-	shortcut := strings.ToUpper(common.KeyvalName(s.shortcut))
-	return fmt.Sprintf("%s:%s:%s", s.name, s.subPath, shortcut)
+	shortcut := s.shortcut.String()
+	return fmt.Sprintf("%s:%s:%s", s.FullName(), s.subPath, shortcut)
+}
+
+// Splits a nested category name to the names of the levels,
+// e.g. "Travel/Japan/Kyoto" to "Travel", "Japan" and "Kyoto"
+func SplitCategoryName(fullName string) []string {
+	var names []string
+	for _, name := range strings.Split(fullName, CategoryNameSeparator) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
@@ -0,0 +1,54 @@
+package apitype
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictPolicy defines what is done when a copied image already exists in the target directory
+type ConflictPolicy string
+
+const (
+	// Replace the existing file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// Keep the existing file and don't copy the image
+	ConflictSkip ConflictPolicy = "skip"
+	// Add a number to the file name, e.g. IMG_1_1.jpg
+	ConflictRenameWithNumber ConflictPolicy = "rename"
+	// Add a hash of the image contents to the file name, e.g. IMG_1_3f2a9c1b.jpg
+	ConflictRenameWithHash ConflictPolicy = "rename-hash"
+	// Don't copy if the existing file is identical, otherwise rename with a number
+	ConflictSkipIdentical ConflictPolicy = "skip-identical"
+)
+
+var ConflictPolicies = []ConflictPolicy{
+	ConflictOverwrite, ConflictSkip, ConflictRenameWithNumber, ConflictRenameWithHash, ConflictSkipIdentical,
+}
+
+// Parses the policy by name. Empty value is the default policy.
+func ConflictPolicyFromString(value string) (ConflictPolicy, error) {
+	if value == "" {
+		return ConflictOverwrite, nil
+	}
+	for _, policy := range ConflictPolicies {
+		if strings.EqualFold(string(policy), value) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy '%s'", value)
+}
+
+func (s ConflictPolicy) Description() string {
+	switch s {
+	case ConflictSkip:
+		return "Skip"
+	case ConflictRenameWithNumber:
+		return "Rename with number"
+	case ConflictRenameWithHash:
+		return "Rename with hash"
+	case ConflictSkipIdentical:
+		return "Skip if identical"
+	default:
+		return "Overwrite"
+	}
+}
@@ -0,0 +1,47 @@
+package apitype
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CopyMethod defines how the images that are not modified are copied to the categories
+type CopyMethod string
+
+const (
+	// Copy the file content
+	CopyMethodCopy CopyMethod = "copy"
+	// Create a hard link to the original file. Copies share the content with the original,
+	// so changing one changes all of them.
+	CopyMethodHardlink CopyMethod = "hardlink"
+	// Create a copy-on-write clone. Supported e.g. by Btrfs and XFS on Linux.
+	CopyMethodReflink CopyMethod = "reflink"
+)
+
+var CopyMethods = []CopyMethod{
+	CopyMethodCopy, CopyMethodHardlink, CopyMethodReflink,
+}
+
+// Parses the copy method by name. Empty value is the default method.
+func CopyMethodFromString(value string) (CopyMethod, error) {
+	if value == "" {
+		return CopyMethodCopy, nil
+	}
+	for _, method := range CopyMethods {
+		if strings.EqualFold(string(method), value) {
+			return method, nil
+		}
+	}
+	return "", fmt.Errorf("unknown copy method '%s'", value)
+}
+
+func (s CopyMethod) Description() string {
+	switch s {
+	case CopyMethodHardlink:
+		return "Hard link"
+	case CopyMethodReflink:
+		return "Reflink (copy-on-write)"
+	default:
+		return "Copy"
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/rwcarlsen/goexif/tiff"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"strings"
 	"time"
 	"vincit.fi/image-sorter/common/logger"
@@ -109,6 +110,17 @@ func NewInvalidExifData() *ExifData {
 	}
 }
 
+// Exif data for images without Exif block. Size is resolved from the image itself.
+func NewExifDataWithSize(width uint32, height uint32) *ExifData {
+	return &ExifData{
+		width:       width,
+		height:      height,
+		orientation: 1,
+		created:     time.Unix(0, 0),
+		values:      map[string]string{},
+	}
+}
+
 func NewExifDataFromMap(values map[string]string) *ExifData {
 	return &ExifData{
 		orientation: 1,
@@ -118,6 +130,9 @@ func NewExifDataFromMap(values map[string]string) *ExifData {
 }
 
 func (s *ExifData) ResetExifRotate() {
+	if !s.HasRawExifData() {
+		return
+	}
 	orientationByteIndex, err := findOrientationByteIndex(s.raw.Raw, s.orientation)
 	if err != nil {
 		return
@@ -144,6 +159,11 @@ func (s *ExifData) CreatedTime() time.Time {
 	return s.created
 }
 
+// Returns true if the image had Exif block which can be written to a new image
+func (s *ExifData) HasRawExifData() bool {
+	return s != nil && s.raw != nil
+}
+
 func (s *ExifData) RawExifData() []byte {
 	return s.raw.Raw
 }
@@ -172,6 +192,33 @@ func (s *ExifData) ImageHeight() uint32 {
 	return s.height
 }
 
+// Decodes the thumbnail embedded in the Exif block (IFD1). Thumbnail is not rotated.
+// Cameras often add black bars to the thumbnail when its aspect ratio is different from the image's,
+// so the thumbnail is cropped to the image's aspect ratio.
+func (s *ExifData) Thumbnail() (image.Image, error) {
+	if !s.HasRawExifData() {
+		return nil, errors.New("no Exif data")
+	}
+	// Offset and length are checked since exif.JpegThumbnail panics if they are invalid
+	offset, err := getInt(s.raw, exif.ThumbJPEGInterchangeFormat)
+	if err != nil {
+		return nil, err
+	}
+	length, err := getInt(s.raw, exif.ThumbJPEGInterchangeFormatLength)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || length <= 0 || offset+length > len(s.raw.Raw) {
+		return nil, errors.New("invalid Exif thumbnail")
+	}
+
+	thumbnail, err := jpeg.Decode(bytes.NewReader(s.raw.Raw[offset : offset+length]))
+	if err != nil {
+		return nil, err
+	}
+	return cropToAspectRatio(thumbnail, s.width, s.height), nil
+}
+
 func ExifRotateImage(loadedImage image.Image, rotation float64, flipped bool) (image.Image, error) {
 	loadedImage = imaging.Rotate(loadedImage, rotation, color.Black)
 	if flipped {
@@ -181,6 +228,26 @@ func ExifRotateImage(loadedImage image.Image, rotation float64, flipped bool) (i
 	}
 }
 
+func cropToAspectRatio(thumbnail image.Image, width uint32, height uint32) image.Image {
+	if width == 0 || height == 0 {
+		return thumbnail
+	}
+	thumbnailWidth := thumbnail.Bounds().Dx()
+	thumbnailHeight := thumbnail.Bounds().Dy()
+	ratio := float64(width) / float64(height)
+	croppedWidth := int(float64(thumbnailHeight)*ratio + 0.5)
+	croppedHeight := thumbnailHeight
+	if croppedWidth > thumbnailWidth {
+		croppedWidth = thumbnailWidth
+		croppedHeight = int(float64(thumbnailWidth)/ratio + 0.5)
+	}
+
+	if thumbnailWidth-croppedWidth > 1 || thumbnailHeight-croppedHeight > 1 {
+		return imaging.CropCenter(thumbnail, croppedWidth, croppedHeight)
+	}
+	return thumbnail
+}
+
 func getInt(decodedExif *exif.Exif, tagName exif.FieldName) (int, error) {
 	if tag, err := decodedExif.Get(tagName); err != nil {
 		return 0, err
@@ -1,9 +1,12 @@
 package apitype
 
 import (
+	"io/fs"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"vincit.fi/image-sorter/common/logger"
 )
 
@@ -16,15 +19,20 @@ type ImageMetaData struct {
 }
 
 type ImageFile struct {
-	id        ImageId
-	directory string
-	filename  string
-	path      string
-	byteSize  int64
-	rotation  float64
-	flipped   bool
-	width     int
-	height    int
+	id            ImageId
+	rootDirectory string
+	relativePath  string
+	directory     string
+	filename      string
+	path          string
+	companions    []string
+	sidecars      []string
+	byteSize      int64
+	modifiedTime  time.Time
+	rotation      float64
+	flipped       bool
+	width         int
+	height        int
 }
 
 func (s *ImageFile) IsValid() bool {
@@ -32,40 +40,38 @@ func (s *ImageFile) IsValid() bool {
 }
 
 var (
-	EmptyImageFile       = ImageFile{id: NoImage, path: ""}
-	supportedFileEndings = map[string]bool{".jpg": true, ".jpeg": true}
+	EmptyImageFile = ImageFile{id: NoImage, path: ""}
 )
 
-func NewImageFileWithId(id ImageId, fileDir string, fileName string, width int, height int) *ImageFile {
-	return &ImageFile{
-		id:        id,
-		directory: fileDir,
-		filename:  fileName,
-		path:      filepath.Join(fileDir, fileName),
-		byteSize:  0,
-		rotation:  0.0,
-		flipped:   false,
-		width:     width,
-		height:    height,
-	}
+func NewImageFileWithId(id ImageId, rootDir string, relativePath string, width int, height int) *ImageFile {
+	return NewImageFileWithIdSizeAndOrientation(id, rootDir, relativePath, 0, 0.0, false, width, height)
 }
 
-func NewImageFileWithIdSizeAndOrientation(id ImageId, fileDir string, fileName string, byteSize int64, rotation float64, flipped bool, width int, height int) *ImageFile {
+// Creates a new image file. The relativePath is relative to the rootDir and
+// may contain sub directories if the image is not directly in the rootDir.
+func NewImageFileWithIdSizeAndOrientation(id ImageId, rootDir string, relativePath string, byteSize int64, rotation float64, flipped bool, width int, height int) *ImageFile {
+	subDirectory, fileName := filepath.Split(relativePath)
+	directory := rootDir
+	if subDirectory != "" {
+		directory = filepath.Join(rootDir, subDirectory)
+	}
 	return &ImageFile{
-		id:        id,
-		directory: fileDir,
-		filename:  fileName,
-		path:      filepath.Join(fileDir, fileName),
-		byteSize:  byteSize,
-		rotation:  rotation,
-		flipped:   flipped,
-		width:     width,
-		height:    height,
+		id:            id,
+		rootDirectory: rootDir,
+		relativePath:  relativePath,
+		directory:     directory,
+		filename:      fileName,
+		path:          filepath.Join(rootDir, relativePath),
+		byteSize:      byteSize,
+		rotation:      rotation,
+		flipped:       flipped,
+		width:         width,
+		height:        height,
 	}
 }
 
-func NewImageFile(fileDir string, fileName string) *ImageFile {
-	return NewImageFileWithIdSizeAndOrientation(NoImage, fileDir, fileName, 0, 0.0, false, 0, 0)
+func NewImageFile(rootDir string, relativePath string) *ImageFile {
+	return NewImageFileWithIdSizeAndOrientation(NoImage, rootDir, relativePath, 0, 0.0, false, 0, 0)
 }
 
 func GetEmptyImageFile() *ImageFile {
@@ -116,6 +122,36 @@ func (s *ImageFile) Directory() string {
 	}
 }
 
+// Directory of the image library the image belongs to
+func (s *ImageFile) RootDirectory() string {
+	if s != nil {
+		return s.rootDirectory
+	} else {
+		return ""
+	}
+}
+
+// Path of the image relative to the root directory
+func (s *ImageFile) RelativePath() string {
+	if s != nil {
+		return s.relativePath
+	} else {
+		return ""
+	}
+}
+
+// Sub directory of the image relative to the root directory.
+// Empty if the image is directly in the root directory.
+func (s *ImageFile) SubDirectory() string {
+	if s == nil {
+		return ""
+	} else if subDirectory := filepath.Dir(s.relativePath); subDirectory != "." {
+		return subDirectory
+	} else {
+		return ""
+	}
+}
+
 func (s *ImageFile) FileName() string {
 	if s != nil {
 		return s.filename
@@ -124,6 +160,50 @@ func (s *ImageFile) FileName() string {
 	}
 }
 
+// Image format resolved from the file extension
+func (s *ImageFile) Format() ImageFormat {
+	if s != nil {
+		return ImageFormatOf(s.filename)
+	} else {
+		return UnknownFormat
+	}
+}
+
+// Files that are handled together with the image, e.g. the RAW file of
+// a RAW+JPEG pair. Companion files are in the same directory as the image.
+func (s *ImageFile) CompanionFiles() []string {
+	if s != nil {
+		return s.companions
+	} else {
+		return nil
+	}
+}
+
+func (s *ImageFile) SetCompanionFiles(fileNames []string) {
+	s.companions = fileNames
+}
+
+// Sidecar files (e.g. XMP) of the image. Sidecar files are in the same directory as the image.
+func (s *ImageFile) SidecarFiles() []string {
+	if s != nil {
+		return s.sidecars
+	} else {
+		return nil
+	}
+}
+
+func (s *ImageFile) SetSidecarFiles(fileNames []string) {
+	s.sidecars = fileNames
+}
+
+// All the files that are copied and removed together with the image:
+// companion files and sidecar files
+func (s *ImageFile) AssociatedFiles() []string {
+	var fileNames []string
+	fileNames = append(fileNames, s.CompanionFiles()...)
+	return append(fileNames, s.SidecarFiles()...)
+}
+
 func (s *ImageFile) SetMetaData(byteSize int64, rotation float64, flipped bool) {
 	s.byteSize = byteSize
 	s.rotation = rotation
@@ -138,6 +218,15 @@ func (s *ImageFile) ByteSize() int64 {
 	}
 }
 
+// Modification time of the file. Only known for the image files loaded from the directory.
+func (s *ImageFile) ModifiedTime() time.Time {
+	if s != nil {
+		return s.modifiedTime
+	} else {
+		return time.Time{}
+	}
+}
+
 func (s *ImageFile) ByteSizeInMB() float64 {
 	if s != nil {
 		return float64(s.byteSize) / (1024.0 * 1024.0)
@@ -178,8 +267,12 @@ func (s *ImageMetaData) MetaData() map[string]string {
 	}
 }
 
-func LoadImageFiles(dir string) []*ImageFile {
+// Loads the image files from the dir. Files with the sidecar extensions
+// are attached to the images as sidecar files.
+func LoadImageFiles(dir string, sidecarExtensions []string) []*ImageFile {
+	sidecars := newSidecarMatcher(sidecarExtensions)
 	var imageFiles []*ImageFile
+	var sidecarPaths []string
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		logger.Error.Fatal(err)
@@ -188,15 +281,186 @@ func LoadImageFiles(dir string) []*ImageFile {
 	logger.Debug.Printf("Scanning directory '%s'", dir)
 	for _, file := range files {
 		extension := filepath.Ext(file.Name())
-		if isSupported(extension) {
+		if file.IsDir() {
+			continue
+		} else if isSupported(extension) {
 			imageFiles = append(imageFiles, NewImageFile(dir, file.Name()))
+		} else if sidecars.isSidecar(file.Name()) {
+			sidecarPaths = append(sidecarPaths, file.Name())
 		}
 	}
+	imageFiles = groupCompanionFiles(imageFiles)
+	attachSidecarFiles(dir, imageFiles, sidecarPaths)
 	logger.Debug.Printf("Found %d images", len(imageFiles))
 
 	return imageFiles
 }
 
+// Loads image files from the dir and all of its sub directories. Hidden
+// directories (e.g. the .image-sorter directory) and the given excluded
+// directories are skipped. Excluded directories are relative to the dir.
+func LoadImageFilesRecursively(dir string, excludedDirs []string, sidecarExtensions []string) ([]*ImageFile, error) {
+	sidecars := newSidecarMatcher(sidecarExtensions)
+	excluded := map[string]bool{}
+	for _, excludedDir := range excludedDirs {
+		excluded[filepath.Clean(excludedDir)] = true
+	}
+
+	var imageFiles []*ImageFile
+	var sidecarPaths []string
+	logger.Debug.Printf("Scanning directory '%s' recursively", dir)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path != dir && (strings.HasPrefix(entry.Name(), ".") || excluded[relativePath]) {
+				logger.Trace.Printf("Skipping directory '%s'", relativePath)
+				return filepath.SkipDir
+			}
+		} else if isSupported(filepath.Ext(entry.Name())) {
+			imageFiles = append(imageFiles, NewImageFile(dir, relativePath))
+		} else if sidecars.isSidecar(entry.Name()) {
+			sidecarPaths = append(sidecarPaths, relativePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	imageFiles = groupCompanionFiles(imageFiles)
+	attachSidecarFiles(dir, imageFiles, sidecarPaths)
+	logger.Debug.Printf("Found %d images", len(imageFiles))
+
+	return imageFiles, nil
+}
+
+// Loads the image files directly in the sub directory of the dir without the files
+// in its sub directories. Paths of the images are relative to the dir.
+func LoadImageFilesInSubDirectory(dir string, subDirectory string, sidecarExtensions []string) ([]*ImageFile, error) {
+	imageFiles, _, err := loadImageFilesInSubDirectory(dir, subDirectory, newSidecarMatcher(sidecarExtensions))
+	return imageFiles, err
+}
+
+type WalkImageFilesFunc func(subDirectory string, imageFiles []*ImageFile) error
+
+// Calls walkFn for each directory with the image files directly in it, starting from the dir.
+// Each directory is read only once and the images have their byte size and modification time
+// from the directory entries. Sub directories are walked if recursive is true, skipping the
+// hidden and the excluded directories like LoadImageFilesRecursively.
+func WalkImageFiles(dir string, recursive bool, excludedDirs []string, sidecarExtensions []string, walkFn WalkImageFilesFunc) error {
+	sidecars := newSidecarMatcher(sidecarExtensions)
+	excluded := map[string]bool{}
+	for _, excludedDir := range excludedDirs {
+		excluded[filepath.Clean(excludedDir)] = true
+	}
+
+	subDirectories := []string{""}
+	for len(subDirectories) > 0 {
+		subDirectory := subDirectories[0]
+		subDirectories = subDirectories[1:]
+
+		imageFiles, children, err := loadImageFilesInSubDirectory(dir, subDirectory, sidecars)
+		if err != nil {
+			return err
+		} else if err := walkFn(subDirectory, imageFiles); err != nil {
+			return err
+		}
+
+		if recursive {
+			for _, child := range children {
+				if strings.HasPrefix(filepath.Base(child), ".") || excluded[child] {
+					logger.Trace.Printf("Skipping directory '%s'", child)
+				} else {
+					subDirectories = append(subDirectories, child)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the image files and the sub directories directly in the sub directory
+func loadImageFilesInSubDirectory(dir string, subDirectory string, sidecars sidecarMatcher) ([]*ImageFile, []string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, subDirectory))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var imageFiles []*ImageFile
+	var sidecarPaths []string
+	var subDirectories []string
+	logger.Debug.Printf("Scanning directory '%s' in '%s'", subDirectory, dir)
+	for _, entry := range entries {
+		relativePath := filepath.Join(subDirectory, entry.Name())
+		if entry.IsDir() {
+			subDirectories = append(subDirectories, relativePath)
+		} else if isSupported(filepath.Ext(entry.Name())) {
+			info, err := entry.Info()
+			if os.IsNotExist(err) {
+				// Removed after the directory was read
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+			imageFile := NewImageFile(dir, relativePath)
+			imageFile.byteSize = info.Size()
+			imageFile.modifiedTime = info.ModTime()
+			imageFiles = append(imageFiles, imageFile)
+		} else if sidecars.isSidecar(entry.Name()) {
+			sidecarPaths = append(sidecarPaths, relativePath)
+		}
+	}
+	imageFiles = groupCompanionFiles(imageFiles)
+	attachSidecarFiles(dir, imageFiles, sidecarPaths)
+	logger.Debug.Printf("Found %d images", len(imageFiles))
+
+	return imageFiles, subDirectories, nil
+}
+
+// Returns true for the images and the sidecar files that are loaded with the images
+func IsLibraryFile(fileName string, sidecarExtensions []string) bool {
+	return isSupported(filepath.Ext(fileName)) || newSidecarMatcher(sidecarExtensions).isSidecar(fileName)
+}
+
+// Groups RAW files with the other images that have the same name in the same
+// directory (e.g. IMG_1.CR2 and IMG_1.JPG) so that they are handled as a single
+// image. JPEG is preferred as the primary image and the RAW file becomes its
+// companion file. RAW files without a pair are returned as is.
+func groupCompanionFiles(imageFiles []*ImageFile) []*ImageFile {
+	primaries := map[string]*ImageFile{}
+	for _, imageFile := range imageFiles {
+		if imageFile.Format() != RAW {
+			key := companionKey(imageFile)
+			if existing, ok := primaries[key]; !ok || (imageFile.Format() == JPEG && existing.Format() != JPEG) {
+				primaries[key] = imageFile
+			}
+		}
+	}
+
+	var grouped []*ImageFile
+	for _, imageFile := range imageFiles {
+		if primary, ok := primaries[companionKey(imageFile)]; ok && imageFile.Format() == RAW {
+			logger.Trace.Printf("Grouping '%s' with '%s'", imageFile.RelativePath(), primary.RelativePath())
+			primary.companions = append(primary.companions, imageFile.FileName())
+		} else {
+			grouped = append(grouped, imageFile)
+		}
+	}
+	return grouped
+}
+
+func companionKey(imageFile *ImageFile) string {
+	fileName := imageFile.FileName()
+	return filepath.Join(imageFile.Directory(), strings.ToLower(strings.TrimSuffix(fileName, filepath.Ext(fileName))))
+}
+
 func isSupported(extension string) bool {
-	return supportedFileEndings[strings.ToLower(extension)]
+	return formatsByFileEnding[strings.ToLower(extension)] != UnknownFormat
 }
@@ -2,8 +2,12 @@ package apitype
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestGetEmptyImageFile(t *testing.T) {
@@ -39,6 +43,19 @@ func TestValidImageFile(t *testing.T) {
 	})
 }
 
+func TestImageFileInSubDirectory(t *testing.T) {
+	a := assert.New(t)
+
+	imageFile := NewImageFileWithId(1, "some/dir", filepath.Join("sub", "file.jpeg"), 400, 300)
+
+	a.Equal("file.jpeg", imageFile.FileName())
+	a.Equal("some/dir", imageFile.RootDirectory())
+	a.Equal(filepath.Join("sub", "file.jpeg"), imageFile.RelativePath())
+	a.Equal("sub", imageFile.SubDirectory())
+	a.Equal(filepath.Join("some", "dir", "sub"), imageFile.Directory())
+	a.Equal(filepath.Join("some", "dir", "sub", "file.jpeg"), imageFile.Path())
+}
+
 func TestInvalidImageFile(t *testing.T) {
 	a := assert.New(t)
 
@@ -77,6 +94,7 @@ func TestIsSupported(t *testing.T) {
 	t.Run("Valid", func(t *testing.T) {
 		validValues := []string{
 			"jpeg", "JPEG", "jpg", "JPG",
+			"png", "PNG", "gif", "tif", "tiff", "TIFF", "webp", "WEBP",
 		}
 		for _, value := range validValues {
 			a.True(isSupported("." + value))
@@ -92,3 +110,222 @@ func TestIsSupported(t *testing.T) {
 		}
 	})
 }
+
+func TestIsLibraryFile(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(IsLibraryFile("IMG_1.JPG", nil))
+	a.True(IsLibraryFile("IMG_1.xmp", []string{".xmp"}))
+	a.False(IsLibraryFile("IMG_1.xmp", nil))
+	a.False(IsLibraryFile("image-sorter.db", []string{".xmp"}))
+}
+
+func TestLoadImageFilesRecursively(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir := t.TempDir()
+	for _, file := range []string{
+		"image1.jpg",
+		"text.txt",
+		filepath.Join("sub1", "image1.jpg"),
+		filepath.Join("sub1", "sub2", "image2.JPEG"),
+		filepath.Join("category", "image3.jpg"),
+		filepath.Join(".image-sorter", "image4.jpg"),
+	} {
+		r.Nil(os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755))
+		r.Nil(ioutil.WriteFile(filepath.Join(dir, file), []byte{}, 0644))
+	}
+
+	t.Run("Non-recursive", func(t *testing.T) {
+		imageFiles := LoadImageFiles(dir, nil)
+
+		r.Equal(1, len(imageFiles))
+		a.Equal("image1.jpg", imageFiles[0].RelativePath())
+	})
+
+	t.Run("Recursive", func(t *testing.T) {
+		imageFiles, err := LoadImageFilesRecursively(dir, []string{"category"}, nil)
+		r.Nil(err)
+
+		r.Equal(3, len(imageFiles))
+		a.Equal("image1.jpg", imageFiles[0].RelativePath())
+		a.Equal(filepath.Join("sub1", "image1.jpg"), imageFiles[1].RelativePath())
+		a.Equal(filepath.Join("sub1", "sub2", "image2.JPEG"), imageFiles[2].RelativePath())
+		a.Equal(filepath.Join(dir, "sub1", "sub2"), imageFiles[2].Directory())
+		a.Equal(dir, imageFiles[2].RootDirectory())
+	})
+}
+
+func TestWalkImageFiles(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir := t.TempDir()
+	for _, file := range []string{
+		"image1.jpg",
+		"text.txt",
+		filepath.Join("sub1", "image1.jpg"),
+		filepath.Join("sub1", "image1.jpg.xmp"),
+		filepath.Join("sub1", "sub2", "image2.JPEG"),
+		filepath.Join("empty", "text.txt"),
+		filepath.Join("category", "image3.jpg"),
+		filepath.Join(".image-sorter", "image4.jpg"),
+	} {
+		r.Nil(os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755))
+		r.Nil(ioutil.WriteFile(filepath.Join(dir, file), []byte("image"), 0644))
+	}
+	modifiedTime := time.Date(2023, 6, 15, 12, 0, 0, 0, time.Local)
+	r.Nil(os.Chtimes(filepath.Join(dir, "image1.jpg"), modifiedTime, modifiedTime))
+
+	walk := func(recursive bool) map[string][]*ImageFile {
+		directories := map[string][]*ImageFile{}
+		r.Nil(WalkImageFiles(dir, recursive, []string{"category"}, []string{".xmp"},
+			func(subDirectory string, imageFiles []*ImageFile) error {
+				directories[subDirectory] = imageFiles
+				return nil
+			}))
+		return directories
+	}
+
+	t.Run("Non-recursive", func(t *testing.T) {
+		directories := walk(false)
+
+		r.Equal(1, len(directories))
+		r.Equal(1, len(directories[""]))
+		a.Equal("image1.jpg", directories[""][0].RelativePath())
+		a.Equal(int64(5), directories[""][0].ByteSize())
+		a.True(modifiedTime.Equal(directories[""][0].ModifiedTime()))
+	})
+
+	t.Run("Recursive", func(t *testing.T) {
+		directories := walk(true)
+
+		r.Equal(4, len(directories))
+		r.Equal(1, len(directories[""]))
+		r.Equal(1, len(directories["sub1"]))
+		a.Equal(filepath.Join("sub1", "image1.jpg"), directories["sub1"][0].RelativePath())
+		a.Equal([]string{"image1.jpg.xmp"}, directories["sub1"][0].SidecarFiles())
+		r.Equal(1, len(directories[filepath.Join("sub1", "sub2")]))
+		a.Equal(filepath.Join("sub1", "sub2", "image2.JPEG"), directories[filepath.Join("sub1", "sub2")][0].RelativePath())
+		a.Empty(directories["empty"])
+		a.NotContains(directories, "category")
+		a.NotContains(directories, ".image-sorter")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		a.NotNil(WalkImageFiles(filepath.Join(dir, "missing"), false, nil, nil,
+			func(subDirectory string, imageFiles []*ImageFile) error {
+				return nil
+			}))
+	})
+}
+
+func TestImageFile_Format(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(JPEG, NewImageFile("dir", "file.JPG").Format())
+	a.Equal(PNG, NewImageFile("dir", filepath.Join("sub", "file.png")).Format())
+	a.Equal(TIFF, NewImageFile("dir", "file.tif").Format())
+	a.Equal(WEBP, NewImageFile("dir", "file.webp").Format())
+	a.Equal(UnknownFormat, NewImageFile("dir", "file.exe").Format())
+	a.Equal(UnknownFormat, NewImageFile("dir", "file").Format())
+}
+
+func TestLoadImageFiles_RawAndJpegPairs(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir := t.TempDir()
+	for _, file := range []string{
+		"image1.CR2",
+		"image1.jpg",
+		"image2.nef",
+		"image3.png",
+		"IMAGE3.dng",
+		"image4.dng",
+		"image4.tif",
+		"image4.JPG",
+	} {
+		r.Nil(ioutil.WriteFile(filepath.Join(dir, file), []byte{}, 0644))
+	}
+
+	imageFiles := LoadImageFiles(dir, nil)
+
+	r.Equal(5, len(imageFiles))
+	a.Equal("image1.jpg", imageFiles[0].FileName())
+	a.Equal([]string{"image1.CR2"}, imageFiles[0].CompanionFiles())
+	a.Equal("image2.nef", imageFiles[1].FileName())
+	a.Nil(imageFiles[1].CompanionFiles())
+	a.Equal("image3.png", imageFiles[2].FileName())
+	a.Equal([]string{"IMAGE3.dng"}, imageFiles[2].CompanionFiles())
+	a.Equal("image4.JPG", imageFiles[3].FileName())
+	a.Equal([]string{"image4.dng"}, imageFiles[3].CompanionFiles())
+	a.Equal("image4.tif", imageFiles[4].FileName())
+	a.Nil(imageFiles[4].CompanionFiles())
+}
+
+func TestLoadImageFiles_SidecarFiles(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir := t.TempDir()
+	for _, file := range []string{
+		"IMG_1.JPG",
+		"IMG_1.xmp",
+		"IMG_1.AAE",
+		"IMG_1.MOV",
+		"IMG_2.jpg",
+		"IMG_2.CR2",
+		"IMG_2.CR2.xmp",
+		"IMG_2.thm",
+		"IMG_3.xmp",
+		"IMG_4.jpg",
+		"IMG_4.txt",
+		filepath.Join("sub", "IMG_5.png"),
+		filepath.Join("sub", "IMG_5.png.xmp"),
+	} {
+		r.Nil(os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755))
+		r.Nil(ioutil.WriteFile(filepath.Join(dir, file), []byte{}, 0644))
+	}
+	sidecarExtensions := []string{".xmp", ".aae", ".thm", ".mov"}
+
+	t.Run("Non-recursive", func(t *testing.T) {
+		imageFiles := LoadImageFiles(dir, sidecarExtensions)
+
+		r.Equal(3, len(imageFiles))
+		a.Equal("IMG_1.JPG", imageFiles[0].FileName())
+		a.Equal([]string{"IMG_1.AAE", "IMG_1.MOV", "IMG_1.xmp"}, imageFiles[0].SidecarFiles())
+		a.Equal("IMG_2.jpg", imageFiles[1].FileName())
+		a.Equal([]string{"IMG_2.CR2"}, imageFiles[1].CompanionFiles())
+		a.Equal([]string{"IMG_2.CR2.xmp", "IMG_2.thm"}, imageFiles[1].SidecarFiles())
+		a.Equal([]string{"IMG_2.CR2", "IMG_2.CR2.xmp", "IMG_2.thm"}, imageFiles[1].AssociatedFiles())
+		a.Equal("IMG_4.jpg", imageFiles[2].FileName())
+		a.Nil(imageFiles[2].SidecarFiles())
+	})
+
+	t.Run("Recursive", func(t *testing.T) {
+		imageFiles, err := LoadImageFilesRecursively(dir, nil, sidecarExtensions)
+		r.Nil(err)
+
+		r.Equal(4, len(imageFiles))
+		a.Equal(filepath.Join("sub", "IMG_5.png"), imageFiles[3].RelativePath())
+		a.Equal([]string{"IMG_5.png.xmp"}, imageFiles[3].SidecarFiles())
+	})
+
+	t.Run("Sub directory", func(t *testing.T) {
+		imageFiles, err := LoadImageFilesInSubDirectory(dir, "sub", sidecarExtensions)
+		r.Nil(err)
+
+		r.Equal(1, len(imageFiles))
+		a.Equal(filepath.Join("sub", "IMG_5.png"), imageFiles[0].RelativePath())
+		a.Equal([]string{"IMG_5.png.xmp"}, imageFiles[0].SidecarFiles())
+	})
+
+	t.Run("Sidecars disabled", func(t *testing.T) {
+		imageFiles := LoadImageFiles(dir, nil)
+
+		r.Equal(3, len(imageFiles))
+		a.Nil(imageFiles[0].SidecarFiles())
+	})
+}
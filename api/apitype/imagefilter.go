@@ -0,0 +1,30 @@
+package apitype
+
+// ImageFilter selects the images that are shown. Zero values don't filter anything.
+type ImageFilter struct {
+	// Images in the category or in its sub categories
+	CategoryId CategoryId
+	// Images rated with at least this many stars
+	MinRating Rating
+	// Images marked with the color label
+	ColorLabel ColorLabel
+	// Images tagged with the tag
+	Tag string
+	// Images matching the search query
+	Query *SearchQuery
+	// Order of the images. Doesn't affect which images are selected.
+	Order SortOrder
+}
+
+// Filter that selects all the images
+func NoImageFilter() *ImageFilter {
+	return &ImageFilter{CategoryId: NoCategory}
+}
+
+func NewCategoryFilter(categoryId CategoryId) *ImageFilter {
+	return &ImageFilter{CategoryId: categoryId}
+}
+
+func (s *ImageFilter) IsEmpty() bool {
+	return s == nil || (s.CategoryId == NoCategory && s.MinRating == NoRating && s.ColorLabel == NoColorLabel && s.Tag == "" && s.Query == nil)
+}
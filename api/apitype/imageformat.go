@@ -0,0 +1,53 @@
+package apitype
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type ImageFormat string
+
+const (
+	UnknownFormat ImageFormat = ""
+	JPEG          ImageFormat = "jpeg"
+	PNG           ImageFormat = "png"
+	GIF           ImageFormat = "gif"
+	TIFF          ImageFormat = "tiff"
+	WEBP          ImageFormat = "webp"
+	// TIFF based camera RAW formats. Only the embedded preview is decoded.
+	RAW ImageFormat = "raw"
+)
+
+var formatsByFileEnding = map[string]ImageFormat{
+	".jpg":  JPEG,
+	".jpeg": JPEG,
+	".png":  PNG,
+	".gif":  GIF,
+	".tif":  TIFF,
+	".tiff": TIFF,
+	".webp": WEBP,
+	".cr2":  RAW,
+	".nef":  RAW,
+	".arw":  RAW,
+	".dng":  RAW,
+}
+
+// Resolves the image format from the file extension. Returns UnknownFormat
+// if the file is not a supported image.
+func ImageFormatOf(fileName string) ImageFormat {
+	return formatsByFileEnding[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// File extension used for the format
+func (s ImageFormat) FileEnding() string {
+	switch s {
+	case JPEG:
+		return ".jpg"
+	case TIFF:
+		return ".tif"
+	case UnknownFormat, RAW:
+		return ""
+	default:
+		return "." + string(s)
+	}
+}
@@ -10,12 +10,26 @@ type ImageOperation interface {
 	String() string
 }
 
+// FileJournal records the changes made to the files so that they can be rolled back
+type FileJournal interface {
+	// Records that the file is about to be created
+	Creating(path string) error
+	// Moves the file aside instead of removing or overwriting it
+	Hold(path string) error
+	// Records that the file is about to be moved
+	Moving(src string, dst string) error
+}
+
 type ImageOperationGroup struct {
 	imageFile       *ImageFile
 	exifData        *ExifData
 	imageLoader     func()
 	imageData       image.Image
 	hasBeenModified bool
+	keepOriginal    bool
+	results         []string
+	targets         []string
+	fileJournal     FileJournal
 	operations      []ImageOperation
 	loadImage       func(ImageId) (image.Image, error)
 	loadExifData    func(*ImageFile) (*ExifData, error)
@@ -47,6 +61,42 @@ func (s *ImageOperationGroup) Operations() []ImageOperation {
 	return s.operations
 }
 
+// Describes what was done to the image, e.g. where it was copied
+func (s *ImageOperationGroup) AddResult(result string) {
+	s.results = append(s.results, result)
+}
+
+func (s *ImageOperationGroup) Results() []string {
+	return s.results
+}
+
+// Records where the image was written, e.g. the copy in a category directory
+func (s *ImageOperationGroup) AddTarget(path string) {
+	s.targets = append(s.targets, path)
+}
+
+func (s *ImageOperationGroup) Targets() []string {
+	return s.targets
+}
+
+// Original must not be removed e.g. when it couldn't be copied to all categories
+func (s *ImageOperationGroup) SetKeepOriginal() {
+	s.keepOriginal = true
+}
+
+func (s *ImageOperationGroup) KeepOriginal() bool {
+	return s.keepOriginal
+}
+
+func (s *ImageOperationGroup) SetFileJournal(fileJournal FileJournal) {
+	s.fileJournal = fileJournal
+}
+
+// Returns nil if the file changes are not recorded
+func (s *ImageOperationGroup) FileJournal() FileJournal {
+	return s.fileJournal
+}
+
 func NewImageOperationGroup(imageFile *ImageFile, loadImage func(ImageId) (image.Image, error), data func(*ImageFile) (*ExifData, error), operations []ImageOperation) *ImageOperationGroup {
 	return &ImageOperationGroup{
 		imageFile:       imageFile,
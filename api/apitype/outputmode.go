@@ -0,0 +1,51 @@
+package apitype
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OutputMode defines what is written to the category directories
+type OutputMode string
+
+const (
+	// Copy or move the images to the categories
+	OutputFiles OutputMode = "files"
+	// Create symbolic links to the originals. Originals are always kept.
+	OutputSymlinks OutputMode = "symlink"
+	// Create hard links to the originals. Originals are always kept.
+	OutputHardlinks OutputMode = "hardlink"
+)
+
+var OutputModes = []OutputMode{
+	OutputFiles, OutputSymlinks, OutputHardlinks,
+}
+
+// Parses the output mode by name. Empty value is the default mode.
+func OutputModeFromString(value string) (OutputMode, error) {
+	if value == "" {
+		return OutputFiles, nil
+	}
+	for _, mode := range OutputModes {
+		if strings.EqualFold(string(mode), value) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output mode '%s'", value)
+}
+
+// Links are kept in sync with the categories when the categories are applied again
+func (s OutputMode) IsLink() bool {
+	return s == OutputSymlinks || s == OutputHardlinks
+}
+
+func (s OutputMode) Description() string {
+	switch s {
+	case OutputSymlinks:
+		return "Symbolic links"
+	case OutputHardlinks:
+		return "Hard links"
+	default:
+		return "Files"
+	}
+}
@@ -0,0 +1,317 @@
+package apitype
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholders that can be used in the category paths, e.g.
+// "/archive/{category}/{exif:DateTimeOriginal:2006}/{exif:Model}/{filename}"
+const (
+	placeholderCategory = "category"
+	placeholderFileName = "filename"
+	placeholderName     = "name"
+	placeholderExt      = "ext"
+	placeholderExif     = "exif"
+	placeholderSequence = "seq"
+	placeholderHash     = "hash"
+)
+
+// Used when the image doesn't have the meta data used in the path
+const UnknownPathValue = "unknown"
+
+// Layout of the Exif date values, e.g. "2021:03:14 15:09:26"
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// Length of the content hash used by {hash}
+const pathHashLength = 8
+
+type pathTemplatePart struct {
+	text        string
+	placeholder string
+	field       string
+	layout      string
+	width       int
+}
+
+// Values the placeholders are expanded from
+type PathTemplateValues struct {
+	Category  *Category
+	ImageFile *ImageFile
+	MetaData  *ImageMetaData
+	// Number of the image in the target directory, used by {seq}
+	Sequence int
+	// Content hash of the original image, used by {hash}
+	Hash string
+}
+
+// PathTemplate is a category path with placeholders that are expanded for each image
+type PathTemplate struct {
+	template string
+	parts    []pathTemplatePart
+}
+
+// Parses the path. Paths without placeholders are valid templates too.
+func ParsePathTemplate(template string) (*PathTemplate, error) {
+	var parts []pathTemplatePart
+	rest := template
+	for rest != "" {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			parts = append(parts, pathTemplatePart{text: rest})
+			break
+		} else if rest[start] == '}' {
+			return nil, fmt.Errorf("unexpected '}' in '%s'", template)
+		} else if start > 0 {
+			parts = append(parts, pathTemplatePart{text: rest[:start]})
+		}
+
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] == '{' {
+			return nil, fmt.Errorf("unclosed '{' in '%s'", template)
+		}
+		if part, err := parsePlaceholder(rest[start+1 : start+1+end]); err != nil {
+			return nil, err
+		} else {
+			parts = append(parts, part)
+		}
+		rest = rest[start+1+end+1:]
+	}
+	return &PathTemplate{
+		template: template,
+		parts:    parts,
+	}, nil
+}
+
+// Parses a template for the file name only, e.g. "{exif:DateTimeOriginal:2006-01-02_150405}_{exif:Model}".
+// Returns nil if the template is empty.
+func ParseFileNameTemplate(template string) (*PathTemplate, error) {
+	if template == "" {
+		return nil, nil
+	} else if pathTemplate, err := ParsePathTemplate(template); err != nil {
+		return nil, err
+	} else {
+		for _, part := range pathTemplate.parts {
+			if strings.ContainsAny(part.text, "/\\") {
+				return nil, fmt.Errorf("file name template '%s' must not contain directories", template)
+			}
+		}
+		return pathTemplate, nil
+	}
+}
+
+// Returns true if the path has placeholders
+func (s *PathTemplate) IsTemplate() bool {
+	for _, part := range s.parts {
+		if part.placeholder != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the path includes the file name, i.e. it uses {filename}, {name}, {ext},
+// {seq} or {hash}. Otherwise the path is a directory and the original file name is used.
+func (s *PathTemplate) NamesFile() bool {
+	for _, part := range s.parts {
+		switch part.placeholder {
+		case placeholderFileName, placeholderName, placeholderExt, placeholderSequence, placeholderHash:
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the image meta data is needed to expand the path
+func (s *PathTemplate) UsesMetaData() bool {
+	return s.uses(placeholderExif)
+}
+
+// Returns true if the path has a sequence number that must be unique in the directory
+func (s *PathTemplate) UsesSequence() bool {
+	return s.uses(placeholderSequence)
+}
+
+// Returns true if the content hash of the image is needed to expand the path
+func (s *PathTemplate) UsesHash() bool {
+	return s.uses(placeholderHash)
+}
+
+// Expands the placeholders. Values are sanitized so that they can't change the directory structure.
+func (s *PathTemplate) Expand(values *PathTemplateValues) string {
+	var builder strings.Builder
+	for _, part := range s.parts {
+		if part.placeholder == "" {
+			builder.WriteString(part.text)
+		} else {
+			builder.WriteString(sanitizePathValue(part.value(values)))
+		}
+	}
+	return filepath.FromSlash(builder.String())
+}
+
+// Returns the directory that is the same for all the images of the category,
+// e.g. "Archive/Good" for "Archive/{category}/{exif:Model}". Empty if the
+// first directory already depends on the image.
+func (s *PathTemplate) StaticDirectory(category *Category) string {
+	var builder strings.Builder
+	for _, part := range s.parts {
+		if part.placeholder == "" {
+			builder.WriteString(part.text)
+		} else if part.placeholder == placeholderCategory {
+			builder.WriteString(sanitizePathValue(category.Name()))
+		} else {
+			// Only complete directories are static
+			static := filepath.FromSlash(builder.String())
+			if index := strings.LastIndex(static, string(filepath.Separator)); index >= 0 {
+				return filepath.Clean(static[:index+1])
+			}
+			return ""
+		}
+	}
+	if builder.Len() == 0 {
+		return ""
+	}
+	return filepath.Clean(filepath.FromSlash(builder.String()))
+}
+
+func (s *PathTemplate) String() string {
+	return s.template
+}
+
+// Resolves the directory and the file name the image is written to in the category.
+// Relative paths are relative to the image root directory. The sub directory of the
+// image is preserved for plain paths; templates define the whole structure themselves.
+// The rename template is used for the file name if the category path doesn't name the file.
+func ResolveCategoryTarget(values *PathTemplateValues, renameTemplate *PathTemplate, subDir string) (string, string, error) {
+	category, imageFile := values.Category, values.ImageFile
+	template, err := ParsePathTemplate(category.Path())
+	if err != nil {
+		return "", "", err
+	}
+
+	var dir, file string
+	if !template.IsTemplate() {
+		dir, file = absolutePath(imageFile.RootDirectory(), filepath.Join(category.Path(), subDir)), imageFile.FileName()
+	} else if path := absolutePath(imageFile.RootDirectory(), template.Expand(values)); template.NamesFile() {
+		return filepath.Dir(path), template.withExtension(filepath.Base(path), imageFile), nil
+	} else {
+		dir, file = path, imageFile.FileName()
+	}
+
+	if renameTemplate != nil {
+		file = renameTemplate.withExtension(renameTemplate.Expand(values), imageFile)
+	}
+	return dir, file, nil
+}
+
+// Private API
+
+func (s *PathTemplate) uses(placeholder string) bool {
+	for _, part := range s.parts {
+		if part.placeholder == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// Original extension is added unless the template has it already,
+// e.g. "{name}_{exif:Model}" becomes "IMG_1_X100.jpg"
+func (s *PathTemplate) withExtension(fileName string, imageFile *ImageFile) string {
+	if s.uses(placeholderFileName) || s.uses(placeholderExt) {
+		return fileName
+	} else if last := s.parts[len(s.parts)-1]; last.placeholder == "" && filepath.Ext(last.text) != "" {
+		return fileName
+	}
+	return fileName + filepath.Ext(imageFile.FileName())
+}
+
+func parsePlaceholder(value string) (pathTemplatePart, error) {
+	// Layout may contain colons too, e.g. {exif:DateTimeOriginal:15:04}
+	fields := strings.SplitN(value, ":", 3)
+	switch fields[0] {
+	case placeholderCategory, placeholderFileName, placeholderName, placeholderExt, placeholderHash:
+		if len(fields) > 1 {
+			return pathTemplatePart{}, fmt.Errorf("placeholder '{%s}' doesn't take arguments", fields[0])
+		}
+		return pathTemplatePart{placeholder: fields[0]}, nil
+	case placeholderExif:
+		if len(fields) < 2 || fields[1] == "" {
+			return pathTemplatePart{}, fmt.Errorf("placeholder '{%s}' needs a field name, e.g. '{exif:Model}'", value)
+		} else if len(fields) == 3 && fields[2] == "" {
+			return pathTemplatePart{}, fmt.Errorf("placeholder '{%s}' has an empty date layout", value)
+		}
+		part := pathTemplatePart{placeholder: placeholderExif, field: fields[1]}
+		if len(fields) == 3 {
+			part.layout = fields[2]
+		}
+		return part, nil
+	case placeholderSequence:
+		// Optional zero padded width, e.g. {seq:3} for 001
+		part := pathTemplatePart{placeholder: placeholderSequence, width: 1}
+		if len(fields) == 2 {
+			if width, err := strconv.Atoi(fields[1]); err != nil || width < 1 || width > 10 {
+				return pathTemplatePart{}, fmt.Errorf("placeholder '{%s}' must have a width between 1 and 10", value)
+			} else {
+				part.width = width
+			}
+		} else if len(fields) > 2 {
+			return pathTemplatePart{}, fmt.Errorf("placeholder '{%s}' has too many arguments", value)
+		}
+		return part, nil
+	default:
+		return pathTemplatePart{}, fmt.Errorf("unknown placeholder '{%s}'", value)
+	}
+}
+
+func (s *pathTemplatePart) value(values *PathTemplateValues) string {
+	fileName := values.ImageFile.FileName()
+	switch s.placeholder {
+	case placeholderCategory:
+		return values.Category.Name()
+	case placeholderFileName:
+		return fileName
+	case placeholderName:
+		return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	case placeholderExt:
+		return strings.TrimPrefix(filepath.Ext(fileName), ".")
+	case placeholderExif:
+		value := values.MetaData.MetaData()[s.field]
+		if s.layout == "" || value == "" {
+			return value
+		} else if t, err := time.Parse(exifTimeLayout, value); err != nil {
+			return ""
+		} else {
+			return t.Format(s.layout)
+		}
+	case placeholderSequence:
+		return fmt.Sprintf("%0*d", s.width, values.Sequence)
+	case placeholderHash:
+		if len(values.Hash) > pathHashLength {
+			return values.Hash[:pathHashLength]
+		}
+		return values.Hash
+	default:
+		return ""
+	}
+}
+
+// Values must not contain path separators or refer to the parent directories
+func sanitizePathValue(value string) string {
+	value = strings.TrimSpace(strings.NewReplacer("/", "_", "\\", "_").Replace(value))
+	if value == "" || value == "." || value == ".." {
+		return UnknownPathValue
+	}
+	return value
+}
+
+func absolutePath(rootDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(rootDir, path)
+}
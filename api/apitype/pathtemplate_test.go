@@ -0,0 +1,145 @@
+package apitype
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParsePathTemplate_Invalid(t *testing.T) {
+	a := assert.New(t)
+
+	for _, template := range []string{
+		"{category",
+		"category}",
+		"{cat{egory}",
+		"{unknown}",
+		"{category:foo}",
+		"{exif}",
+		"{exif:}",
+		"{exif:DateTimeOriginal:}",
+		"{seq:0}",
+		"{seq:x}",
+		"{hash:8}",
+	} {
+		_, err := ParsePathTemplate(template)
+		a.NotNil(err, template)
+	}
+}
+
+func TestPathTemplate_Expand(t *testing.T) {
+	a := assert.New(t)
+
+	category := NewCategory("Good", "", "G")
+	imageFile := NewImageFile("/photos", "IMG_1.JPG")
+	metaData := NewImageMetaData(map[string]string{
+		"DateTimeOriginal": "2021:03:14 15:09:26",
+		"Model":            "Canon EOS/5D",
+	})
+
+	template, err := ParsePathTemplate("/archive/{category}/{exif:DateTimeOriginal:2006}/{exif:Model}/{name}_{exif:DateTimeOriginal:15:04}.{ext}")
+	require.Nil(t, err)
+	a.True(template.IsTemplate())
+	a.True(template.NamesFile())
+	a.True(template.UsesMetaData())
+	a.Equal("/archive/Good/2021/Canon EOS_5D/IMG_1_15:09.JPG", template.Expand(&PathTemplateValues{Category: category, ImageFile: imageFile, MetaData: metaData}))
+	a.Equal("/archive/Good/unknown/unknown/IMG_1_unknown.JPG", template.Expand(&PathTemplateValues{Category: category, ImageFile: imageFile}))
+	a.Equal("/archive/Good", template.StaticDirectory(category))
+
+	plain, err := ParsePathTemplate("sorted/good")
+	require.Nil(t, err)
+	a.False(plain.IsTemplate())
+	a.False(plain.NamesFile())
+	a.Equal("sorted/good", plain.StaticDirectory(category))
+
+	dynamic, err := ParsePathTemplate("{exif:Model}/{category}")
+	require.Nil(t, err)
+	a.False(dynamic.NamesFile())
+	a.Equal("", dynamic.StaticDirectory(category))
+
+	numbered, err := ParsePathTemplate("{seq:3}_{hash}")
+	require.Nil(t, err)
+	a.True(numbered.NamesFile())
+	a.True(numbered.UsesSequence())
+	a.True(numbered.UsesHash())
+	a.Equal("012_6105d6cc", numbered.Expand(&PathTemplateValues{Category: category, ImageFile: imageFile, Sequence: 12, Hash: "6105d6cc7e"}))
+}
+
+func TestParseFileNameTemplate(t *testing.T) {
+	a := assert.New(t)
+
+	template, err := ParseFileNameTemplate("")
+	a.Nil(err)
+	a.Nil(template)
+
+	_, err = ParseFileNameTemplate("{category}/{name}")
+	a.NotNil(err)
+
+	template, err = ParseFileNameTemplate("{exif:DateTimeOriginal:2006-01-02_150405}_{exif:Model}")
+	a.Nil(err)
+	a.NotNil(template)
+}
+
+func TestResolveCategoryTarget(t *testing.T) {
+	a := assert.New(t)
+
+	imageFile := NewImageFile("/photos", "2021/IMG_1.JPG")
+	metaData := NewImageMetaData(map[string]string{"Model": "X100", "DateTimeOriginal": "2024:06:01 14:22:33"})
+	values := func(subPath string) *PathTemplateValues {
+		return &PathTemplateValues{
+			Category:  NewCategory("Good", subPath, "G"),
+			ImageFile: imageFile,
+			MetaData:  metaData,
+			Sequence:  1,
+		}
+	}
+
+	dir, file, err := ResolveCategoryTarget(values("good"), nil, "2021")
+	a.Nil(err)
+	a.Equal("/photos/good/2021", dir)
+	a.Equal("IMG_1.JPG", file)
+
+	dir, file, err = ResolveCategoryTarget(values("/archive/good"), nil, "2021")
+	a.Nil(err)
+	a.Equal("/archive/good/2021", dir)
+	a.Equal("IMG_1.JPG", file)
+
+	dir, file, err = ResolveCategoryTarget(values("{category}/{exif:Model}"), nil, "2021")
+	a.Nil(err)
+	a.Equal("/photos/Good/X100", dir)
+	a.Equal("IMG_1.JPG", file)
+
+	dir, file, err = ResolveCategoryTarget(values("/archive/{exif:Model}_{filename}"), nil, "2021")
+	a.Nil(err)
+	a.Equal("/archive", dir)
+	a.Equal("X100_IMG_1.JPG", file)
+
+	dir, file, err = ResolveCategoryTarget(values("/archive/{exif:Model}_{seq:4}"), nil, "2021")
+	a.Nil(err)
+	a.Equal("/archive", dir)
+	a.Equal("X100_0001.JPG", file)
+
+	_, _, err = ResolveCategoryTarget(values("{foo}"), nil, "")
+	a.NotNil(err)
+
+	t.Run("Rename template", func(t *testing.T) {
+		renameTemplate, err := ParseFileNameTemplate("{exif:DateTimeOriginal:2006-01-02_150405}_{exif:Model}")
+		a.Nil(err)
+
+		dir, file, err := ResolveCategoryTarget(values("good"), renameTemplate, "2021")
+		a.Nil(err)
+		a.Equal("/photos/good/2021", dir)
+		a.Equal("2024-06-01_142233_X100.JPG", file)
+
+		// Category path that names the file wins
+		_, file, err = ResolveCategoryTarget(values("good/{name}.{ext}"), renameTemplate, "")
+		a.Nil(err)
+		a.Equal("IMG_1.JPG", file)
+
+		withExtension, err := ParseFileNameTemplate("{name}.jpeg")
+		a.Nil(err)
+		_, file, err = ResolveCategoryTarget(values("good"), withExtension, "")
+		a.Nil(err)
+		a.Equal("IMG_1.jpeg", file)
+	})
+}
@@ -0,0 +1,88 @@
+package apitype
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rating is the number of stars given to an image, 0 (not rated) - 5
+type Rating int
+
+const (
+	NoRating  Rating = 0
+	MaxRating Rating = 5
+)
+
+// Parses the rating from a number, e.g. "3". Empty value is no rating.
+func RatingFromString(value string) (Rating, error) {
+	if value == "" {
+		return NoRating, nil
+	} else if rating, err := strconv.Atoi(value); err != nil {
+		return NoRating, fmt.Errorf("invalid rating '%s'", value)
+	} else if !Rating(rating).IsValid() {
+		return NoRating, fmt.Errorf("rating must be between %d and %d", NoRating, MaxRating)
+	} else {
+		return Rating(rating), nil
+	}
+}
+
+func (s Rating) IsValid() bool {
+	return s >= NoRating && s <= MaxRating
+}
+
+func (s Rating) String() string {
+	if s == NoRating {
+		return "Not rated"
+	} else if s == 1 {
+		return "1 star"
+	} else {
+		return fmt.Sprintf("%d stars", s)
+	}
+}
+
+// ColorLabel marks the image with a color the same way as in Lightroom
+type ColorLabel string
+
+const (
+	NoColorLabel ColorLabel = ""
+	ColorRed     ColorLabel = "Red"
+	ColorYellow  ColorLabel = "Yellow"
+	ColorGreen   ColorLabel = "Green"
+	ColorBlue    ColorLabel = "Blue"
+	ColorPurple  ColorLabel = "Purple"
+)
+
+var ColorLabels = []ColorLabel{
+	ColorRed, ColorYellow, ColorGreen, ColorBlue, ColorPurple,
+}
+
+// Parses the color label by name. Empty value is no label.
+func ColorLabelFromString(value string) (ColorLabel, error) {
+	if value == "" {
+		return NoColorLabel, nil
+	}
+	for _, label := range ColorLabels {
+		if strings.EqualFold(string(label), value) {
+			return label, nil
+		}
+	}
+	return NoColorLabel, fmt.Errorf("unknown color label '%s'", value)
+}
+
+func (s ColorLabel) String() string {
+	if s == NoColorLabel {
+		return "No label"
+	}
+	return string(s)
+}
+
+// Rating and color label of an image
+type ImageRating struct {
+	Rating     Rating
+	ColorLabel ColorLabel
+}
+
+func (s *ImageRating) IsSet() bool {
+	return s.Rating != NoRating || s.ColorLabel != NoColorLabel
+}
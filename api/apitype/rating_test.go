@@ -0,0 +1,52 @@
+package apitype
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRatingFromString(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		value  string
+		rating Rating
+		valid  bool
+	}{
+		{value: "", rating: NoRating, valid: true},
+		{value: "0", rating: NoRating, valid: true},
+		{value: "3", rating: 3, valid: true},
+		{value: "5", rating: MaxRating, valid: true},
+		{value: "6", valid: false},
+		{value: "-1", valid: false},
+		{value: "three", valid: false},
+	}
+	for _, tt := range tests {
+		rating, err := RatingFromString(tt.value)
+		if tt.valid {
+			a.Nil(err, tt.value)
+			a.Equal(tt.rating, rating, tt.value)
+		} else {
+			a.NotNil(err, tt.value)
+		}
+	}
+}
+
+func TestColorLabelFromString(t *testing.T) {
+	a := assert.New(t)
+
+	label, err := ColorLabelFromString("")
+	a.Nil(err)
+	a.Equal(NoColorLabel, label)
+
+	label, err = ColorLabelFromString("red")
+	a.Nil(err)
+	a.Equal(ColorRed, label)
+
+	label, err = ColorLabelFromString("PURPLE")
+	a.Nil(err)
+	a.Equal(ColorPurple, label)
+
+	_, err = ColorLabelFromString("orange")
+	a.NotNil(err)
+}
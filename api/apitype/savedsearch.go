@@ -0,0 +1,50 @@
+package apitype
+
+import "vincit.fi/image-sorter/common"
+
+type SavedSearchId int64
+
+const NoSavedSearch = SavedSearchId(-1)
+
+// Named search query that is listed next to the categories, e.g. "Sharp without category"
+type SavedSearch struct {
+	id       SavedSearchId
+	name     string
+	query    *SearchQuery
+	shortcut common.KeySequence
+}
+
+// Invalid shortcuts are ignored; they are validated when they are entered
+func NewSavedSearch(id SavedSearchId, name string, query *SearchQuery, shortcut string) *SavedSearch {
+	sequence, _ := common.ParseKeySequence(shortcut)
+	return &SavedSearch{
+		id:       id,
+		name:     name,
+		query:    query,
+		shortcut: sequence,
+	}
+}
+
+func (s *SavedSearch) Id() SavedSearchId {
+	return s.id
+}
+
+func (s *SavedSearch) Name() string {
+	return s.name
+}
+
+func (s *SavedSearch) Query() *SearchQuery {
+	return s.query
+}
+
+func (s *SavedSearch) Shortcut() common.KeySequence {
+	return s.shortcut
+}
+
+func (s *SavedSearch) ShortcutAsString() string {
+	return s.shortcut.String()
+}
+
+func (s *SavedSearch) String() string {
+	return s.name
+}
@@ -0,0 +1,434 @@
+package apitype
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fields that can be used in the search queries, e.g. "category:Good AND width>3000"
+type SearchField string
+
+const (
+	SearchFieldCategory      SearchField = "category"
+	SearchFieldTag           SearchField = "tag"
+	SearchFieldLabel         SearchField = "label"
+	SearchFieldRating        SearchField = "rating"
+	SearchFieldName          SearchField = "name"
+	SearchFieldWidth         SearchField = "width"
+	SearchFieldHeight        SearchField = "height"
+	SearchFieldSize          SearchField = "size"
+	SearchFieldDate          SearchField = "date"
+	SearchFieldExif          SearchField = "exif"
+	SearchFieldUncategorized SearchField = "uncategorized"
+)
+
+type SearchOperator string
+
+const (
+	SearchMatch        SearchOperator = ":"
+	SearchGreater      SearchOperator = ">"
+	SearchGreaterEqual SearchOperator = ">="
+	SearchLess         SearchOperator = "<"
+	SearchLessEqual    SearchOperator = "<="
+)
+
+// Matches any number of characters in the text values, e.g. "name:IMG_*"
+const SearchWildcard = "*"
+
+// Separates the start and the end of a date range, e.g. "date:2023-06..2023-07"
+const searchDateRangeSeparator = ".."
+
+var searchDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+var searchSizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"GB", 1024 * 1024 * 1024},
+	{"MB", 1024 * 1024},
+	{"KB", 1024},
+	{"B", 1},
+}
+
+// SearchNode is one of SearchAnd, SearchOr, SearchNot or SearchTerm
+type SearchNode interface {
+	searchNode()
+}
+
+type SearchAnd struct {
+	Nodes []SearchNode
+}
+
+type SearchOr struct {
+	Nodes []SearchNode
+}
+
+type SearchNot struct {
+	Node SearchNode
+}
+
+// Single condition, e.g. "width>3000". Value is parsed to the type the field uses.
+type SearchTerm struct {
+	Field SearchField
+	// Name of the Exif field with SearchFieldExif, e.g. "Model"
+	ExifField string
+	Operator  SearchOperator
+	// Category, tag, label, name and Exif values. May contain SearchWildcard.
+	Text string
+	// Rating, width, height, size in bytes and numeric Exif values
+	Number float64
+	// Date range [From, To). Zero value means that the range is open.
+	From time.Time
+	To   time.Time
+}
+
+func (s *SearchAnd) searchNode()  {}
+func (s *SearchOr) searchNode()   {}
+func (s *SearchNot) searchNode()  {}
+func (s *SearchTerm) searchNode() {}
+
+// SearchQuery selects the images with conditions combined with AND, OR, NOT and parentheses,
+// e.g. `category:Good AND NOT category:Bad AND exif.Model:"Canon EOS R5" AND width>3000`.
+// Conditions without an operator between them must all match.
+type SearchQuery struct {
+	query string
+	root  SearchNode
+}
+
+// Parses the query. Returns nil if the query is empty.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	tokens, err := tokenizeSearchQuery(query)
+	if err != nil {
+		return nil, err
+	} else if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	parser := &searchQueryParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	} else if token := parser.peek(); token != nil {
+		return nil, fmt.Errorf("unexpected '%s' at %d", token.text, token.position)
+	}
+	return &SearchQuery{query: strings.TrimSpace(query), root: root}, nil
+}
+
+func (s *SearchQuery) Root() SearchNode {
+	return s.root
+}
+
+func (s *SearchQuery) String() string {
+	return s.query
+}
+
+// Private API
+
+type searchTokenType int
+
+const (
+	searchTokenWord searchTokenType = iota
+	searchTokenString
+	searchTokenOperator
+	searchTokenOpen
+	searchTokenClose
+)
+
+type searchToken struct {
+	tokenType searchTokenType
+	text      string
+	position  int
+}
+
+func (s *searchToken) isKeyword(keyword string) bool {
+	return s.tokenType == searchTokenWord && strings.EqualFold(s.text, keyword)
+}
+
+// Value after an operator extends to the next white space or ")", so that
+// e.g. "date:2023-06..2023-07" and "exif.ExposureTime:1/200" are single values
+func tokenizeSearchQuery(query string) ([]*searchToken, error) {
+	var tokens []*searchToken
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		afterOperator := len(tokens) > 0 && tokens[len(tokens)-1].tokenType == searchTokenOperator
+		if unicode.IsSpace(c) {
+			i++
+		} else if c == '(' && !afterOperator {
+			tokens = append(tokens, &searchToken{tokenType: searchTokenOpen, text: "(", position: i})
+			i++
+		} else if c == ')' {
+			tokens = append(tokens, &searchToken{tokenType: searchTokenClose, text: ")", position: i})
+			i++
+		} else if c == '"' {
+			var text strings.Builder
+			start := i
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unclosed '\"' at %d", start)
+			}
+			i++
+			tokens = append(tokens, &searchToken{tokenType: searchTokenString, text: text.String(), position: start})
+		} else if strings.ContainsRune(":<>", c) && !afterOperator {
+			operator := string(c)
+			if c != ':' && i+1 < len(runes) && runes[i+1] == '=' {
+				operator += "="
+			}
+			tokens = append(tokens, &searchToken{tokenType: searchTokenOperator, text: operator, position: i})
+			i += len(operator)
+		} else {
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()\"", runes[i]) &&
+				(afterOperator || !strings.ContainsRune(":<>", runes[i])) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected '%c' at %d", c, i)
+			}
+			tokens = append(tokens, &searchToken{tokenType: searchTokenWord, text: string(runes[start:i]), position: start})
+		}
+	}
+	return tokens, nil
+}
+
+// Recursive descent parser. NOT binds tighter than AND and AND tighter than OR.
+type searchQueryParser struct {
+	tokens   []*searchToken
+	position int
+}
+
+func (s *searchQueryParser) peek() *searchToken {
+	if s.position < len(s.tokens) {
+		return s.tokens[s.position]
+	}
+	return nil
+}
+
+func (s *searchQueryParser) next() *searchToken {
+	token := s.peek()
+	if token != nil {
+		s.position++
+	}
+	return token
+}
+
+func (s *searchQueryParser) parseOr() (SearchNode, error) {
+	var nodes []SearchNode
+	for {
+		node, err := s.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if token := s.peek(); token == nil || !token.isKeyword("OR") {
+			break
+		}
+		s.next()
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &SearchOr{Nodes: nodes}, nil
+}
+
+func (s *searchQueryParser) parseAnd() (SearchNode, error) {
+	var nodes []SearchNode
+	for {
+		node, err := s.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+
+		token := s.peek()
+		if token != nil && token.isKeyword("AND") {
+			s.next()
+		} else if token == nil || token.tokenType == searchTokenClose || token.isKeyword("OR") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &SearchAnd{Nodes: nodes}, nil
+}
+
+func (s *searchQueryParser) parseNot() (SearchNode, error) {
+	if token := s.peek(); token != nil && token.isKeyword("NOT") {
+		s.next()
+		node, err := s.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchNot{Node: node}, nil
+	}
+	return s.parsePrimary()
+}
+
+func (s *searchQueryParser) parsePrimary() (SearchNode, error) {
+	token := s.next()
+	if token == nil {
+		return nil, errors.New("unexpected end of query")
+	} else if token.tokenType == searchTokenOpen {
+		node, err := s.parseOr()
+		if err != nil {
+			return nil, err
+		} else if closing := s.next(); closing == nil || closing.tokenType != searchTokenClose {
+			return nil, fmt.Errorf("unclosed '(' at %d", token.position)
+		}
+		return node, nil
+	} else if token.tokenType != searchTokenWord || token.isKeyword("AND") || token.isKeyword("OR") {
+		return nil, fmt.Errorf("unexpected '%s' at %d", token.text, token.position)
+	}
+
+	if operator := s.peek(); operator != nil && operator.tokenType == searchTokenOperator {
+		s.next()
+		value := s.next()
+		if value == nil || (value.tokenType != searchTokenWord && value.tokenType != searchTokenString) {
+			return nil, fmt.Errorf("missing value for '%s' at %d", token.text, operator.position)
+		}
+		term, err := parseSearchTerm(token.text, SearchOperator(operator.text), value.text)
+		if err != nil {
+			return nil, fmt.Errorf("%s at %d", err, token.position)
+		}
+		return term, nil
+	} else if SearchField(strings.ToLower(token.text)) == SearchFieldUncategorized {
+		return &SearchTerm{Field: SearchFieldUncategorized}, nil
+	}
+	return nil, fmt.Errorf("missing condition for '%s' at %d", token.text, token.position)
+}
+
+func parseSearchTerm(name string, operator SearchOperator, value string) (*SearchTerm, error) {
+	term := &SearchTerm{Field: SearchField(strings.ToLower(name)), Operator: operator, Text: value}
+	if prefix := string(SearchFieldExif) + "."; strings.HasPrefix(strings.ToLower(name), prefix) && len(name) > len(prefix) {
+		term.Field = SearchFieldExif
+		term.ExifField = name[len(prefix):]
+	}
+
+	var err error
+	switch term.Field {
+	case SearchFieldCategory, SearchFieldTag, SearchFieldName:
+		if operator != SearchMatch {
+			return nil, fmt.Errorf("'%s' only supports '%s'", name, SearchMatch)
+		}
+	case SearchFieldLabel:
+		var colorLabel ColorLabel
+		if operator != SearchMatch {
+			return nil, fmt.Errorf("'%s' only supports '%s'", name, SearchMatch)
+		} else if colorLabel, err = ColorLabelFromString(value); err == nil {
+			term.Text = string(colorLabel)
+		}
+	case SearchFieldRating:
+		var rating Rating
+		if rating, err = RatingFromString(value); err == nil {
+			term.Number = float64(rating)
+		}
+	case SearchFieldWidth, SearchFieldHeight:
+		var number int
+		if number, err = strconv.Atoi(value); err != nil {
+			err = fmt.Errorf("invalid number '%s'", value)
+		}
+		term.Number = float64(number)
+	case SearchFieldSize:
+		term.Number, err = parseSearchSize(value)
+	case SearchFieldDate:
+		term.From, term.To, err = parseSearchDateRange(operator, value)
+	case SearchFieldExif:
+		if operator != SearchMatch {
+			if term.Number, err = strconv.ParseFloat(value, 64); err != nil {
+				err = fmt.Errorf("invalid number '%s'", value)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown field '%s'", name)
+	}
+	return term, err
+}
+
+// Size in bytes, e.g. "5MB"
+func parseSearchSize(value string) (float64, error) {
+	multiplier := 1.0
+	number := strings.ToUpper(value)
+	for _, unit := range searchSizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSuffix(number, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	if size, err := strconv.ParseFloat(number, 64); err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", value)
+	} else {
+		return size * multiplier, nil
+	}
+}
+
+// Dates are periods, e.g. "2023-06" is the whole June. The range is
+// converted so that the operator can be applied to the start of the range.
+func parseSearchDateRange(operator SearchOperator, value string) (time.Time, time.Time, error) {
+	if strings.Contains(value, searchDateRangeSeparator) {
+		if operator != SearchMatch {
+			return time.Time{}, time.Time{}, fmt.Errorf("date range '%s' only supports '%s'", value, SearchMatch)
+		}
+		parts := strings.SplitN(value, searchDateRangeSeparator, 2)
+		var from, to time.Time
+		var err error
+		if parts[0] != "" {
+			if from, _, err = parseSearchDate(parts[0]); err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+		}
+		if parts[1] != "" {
+			if _, to, err = parseSearchDate(parts[1]); err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+		}
+		if from.IsZero() && to.IsZero() {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date range '%s'", value)
+		}
+		return from, to, nil
+	}
+
+	start, end, err := parseSearchDate(value)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	switch operator {
+	case SearchGreater:
+		return end, time.Time{}, nil
+	case SearchGreaterEqual:
+		return start, time.Time{}, nil
+	case SearchLess:
+		return time.Time{}, start, nil
+	case SearchLessEqual:
+		return time.Time{}, end, nil
+	default:
+		return start, end, nil
+	}
+}
+
+// Returns the start of the period and the start of the next period
+func parseSearchDate(value string) (time.Time, time.Time, error) {
+	for _, layout := range searchDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			switch len(layout) {
+			case len("2006"):
+				return date, date.AddDate(1, 0, 0), nil
+			case len("2006-01"):
+				return date, date.AddDate(0, 1, 0), nil
+			default:
+				return date, date.AddDate(0, 0, 1), nil
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date '%s', use YYYY, YYYY-MM or YYYY-MM-DD", value)
+}
@@ -0,0 +1,107 @@
+package apitype
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery_Empty(t *testing.T) {
+	a := assert.New(t)
+
+	for _, query := range []string{"", "  "} {
+		parsed, err := ParseSearchQuery(query)
+		a.Nil(err)
+		a.Nil(parsed)
+	}
+}
+
+func TestParseSearchQuery_Invalid(t *testing.T) {
+	a := assert.New(t)
+
+	for _, query := range []string{
+		"category",
+		"category:",
+		"category>Good",
+		"unknown:foo",
+		"(category:Good",
+		"category:Good)",
+		"category:Good AND",
+		"OR category:Good",
+		"NOT",
+		`exif.Model:"XZ-1`,
+		"exif.ISO>high",
+		"width>wide",
+		"rating:6",
+		"label:pink",
+		"size>5TB",
+		"date:2023-13",
+		"date>2023..2024",
+		"date:..",
+	} {
+		_, err := ParseSearchQuery(query)
+		a.NotNil(err, query)
+	}
+}
+
+func TestParseSearchQuery_Precedence(t *testing.T) {
+	a := assert.New(t)
+
+	query, err := ParseSearchQuery(`category:Good AND NOT category:Bad OR (tag:beach uncategorized)`)
+	require.Nil(t, err)
+	a.Equal(`category:Good AND NOT category:Bad OR (tag:beach uncategorized)`, query.String())
+
+	or, ok := query.Root().(*SearchOr)
+	require.True(t, ok)
+	require.Equal(t, 2, len(or.Nodes))
+
+	and, ok := or.Nodes[0].(*SearchAnd)
+	require.True(t, ok)
+	require.Equal(t, 2, len(and.Nodes))
+	a.Equal(&SearchTerm{Field: SearchFieldCategory, Operator: SearchMatch, Text: "Good"}, and.Nodes[0])
+	a.Equal(&SearchNot{Node: &SearchTerm{Field: SearchFieldCategory, Operator: SearchMatch, Text: "Bad"}}, and.Nodes[1])
+
+	a.Equal(&SearchAnd{Nodes: []SearchNode{
+		&SearchTerm{Field: SearchFieldTag, Operator: SearchMatch, Text: "beach"},
+		&SearchTerm{Field: SearchFieldUncategorized},
+	}}, or.Nodes[1])
+}
+
+func TestParseSearchQuery_Terms(t *testing.T) {
+	a := assert.New(t)
+
+	date := func(value string) time.Time {
+		parsed, _ := time.Parse("2006-01-02", value)
+		return parsed
+	}
+
+	tests := []struct {
+		query string
+		term  *SearchTerm
+	}{
+		{`Category:"Travel/Japan"`, &SearchTerm{Field: SearchFieldCategory, Operator: SearchMatch, Text: "Travel/Japan"}},
+		{`exif.Model:"Canon EOS R5"`, &SearchTerm{Field: SearchFieldExif, ExifField: "Model", Operator: SearchMatch, Text: "Canon EOS R5"}},
+		{`exif.ExposureTime:1/200`, &SearchTerm{Field: SearchFieldExif, ExifField: "ExposureTime", Operator: SearchMatch, Text: "1/200"}},
+		{`exif.ISOSpeedRatings>=800`, &SearchTerm{Field: SearchFieldExif, ExifField: "ISOSpeedRatings", Operator: SearchGreaterEqual, Text: "800", Number: 800}},
+		{`name:IMG_*`, &SearchTerm{Field: SearchFieldName, Operator: SearchMatch, Text: "IMG_*"}},
+		{`label:red`, &SearchTerm{Field: SearchFieldLabel, Operator: SearchMatch, Text: "Red"}},
+		{`rating>=3`, &SearchTerm{Field: SearchFieldRating, Operator: SearchGreaterEqual, Text: "3", Number: 3}},
+		{`width>3000`, &SearchTerm{Field: SearchFieldWidth, Operator: SearchGreater, Text: "3000", Number: 3000}},
+		{`height<1000`, &SearchTerm{Field: SearchFieldHeight, Operator: SearchLess, Text: "1000", Number: 1000}},
+		{`size>2KB`, &SearchTerm{Field: SearchFieldSize, Operator: SearchGreater, Text: "2KB", Number: 2048}},
+		{`date:2023-06..2023-07`, &SearchTerm{Field: SearchFieldDate, Operator: SearchMatch, Text: "2023-06..2023-07", From: date("2023-06-01"), To: date("2023-08-01")}},
+		{`date:2023-06-15`, &SearchTerm{Field: SearchFieldDate, Operator: SearchMatch, Text: "2023-06-15", From: date("2023-06-15"), To: date("2023-06-16")}},
+		{`date:2023..`, &SearchTerm{Field: SearchFieldDate, Operator: SearchMatch, Text: "2023..", From: date("2023-01-01")}},
+		{`date>2023`, &SearchTerm{Field: SearchFieldDate, Operator: SearchGreater, Text: "2023", From: date("2024-01-01")}},
+		{`date<=2023-06`, &SearchTerm{Field: SearchFieldDate, Operator: SearchLessEqual, Text: "2023-06", To: date("2023-07-01")}},
+		{`UNCATEGORIZED`, &SearchTerm{Field: SearchFieldUncategorized}},
+	}
+
+	for _, tt := range tests {
+		query, err := ParseSearchQuery(tt.query)
+		if a.Nil(err, tt.query) {
+			a.Equal(tt.term, query.Root(), tt.query)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package apitype
+
+import (
+	"path/filepath"
+	"strings"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// Matches the sidecar files by the file extension
+type sidecarMatcher map[string]bool
+
+func newSidecarMatcher(extensions []string) sidecarMatcher {
+	matcher := sidecarMatcher{}
+	for _, extension := range extensions {
+		matcher[strings.ToLower(extension)] = true
+	}
+	return matcher
+}
+
+func (s sidecarMatcher) isSidecar(fileName string) bool {
+	return s[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// Attaches the sidecar files to the images with the same base name in the same
+// directory. Both IMG_1.xmp and IMG_1.JPG.xmp are sidecars of IMG_1.JPG. Sidecar
+// files are given as paths relative to the rootDir. Sidecars without an image are ignored.
+func attachSidecarFiles(rootDir string, imageFiles []*ImageFile, sidecarPaths []string) {
+	if len(sidecarPaths) == 0 {
+		return
+	}
+
+	imagesByKey := map[string]*ImageFile{}
+	for _, imageFile := range imageFiles {
+		imagesByKey[companionKey(imageFile)] = imageFile
+	}
+
+	for _, sidecarPath := range sidecarPaths {
+		directory := filepath.Join(rootDir, filepath.Dir(sidecarPath))
+		fileName := filepath.Base(sidecarPath)
+		withoutExtension := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+		imageFile, ok := imagesByKey[sidecarKey(directory, withoutExtension)]
+		if !ok && isSupported(filepath.Ext(withoutExtension)) {
+			imageFile, ok = imagesByKey[sidecarKey(directory, strings.TrimSuffix(withoutExtension, filepath.Ext(withoutExtension)))]
+		}
+		if ok {
+			logger.Trace.Printf("Found sidecar '%s' for '%s'", sidecarPath, imageFile.RelativePath())
+			imageFile.sidecars = append(imageFile.sidecars, fileName)
+		}
+	}
+}
+
+func sidecarKey(directory string, fileName string) string {
+	return filepath.Join(directory, strings.ToLower(fileName))
+}
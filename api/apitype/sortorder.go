@@ -0,0 +1,124 @@
+package apitype
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type SortField string
+
+const (
+	// File name, numbers in the names are compared as numbers, e.g. IMG_2 before IMG_10
+	SortByName SortField = "name"
+	// Exif capture time
+	SortByDate       SortField = "date"
+	SortBySize       SortField = "size"
+	SortByModified   SortField = "modified"
+	SortByDimensions SortField = "dimensions"
+	// Similar images next to each other
+	SortBySimilarity SortField = "similarity"
+	// Random order that stays the same as long as the seed is the same
+	SortByRandom SortField = "random"
+)
+
+var SortFields = []SortField{SortByName, SortByDate, SortBySize, SortByModified, SortByDimensions, SortBySimilarity, SortByRandom}
+
+const sortDescending = "desc"
+const sortAscending = "asc"
+
+// Order of the images, e.g. "date desc" or "random:42"
+type SortOrder struct {
+	Field      SortField
+	Descending bool
+	// Seed of the random order
+	Seed int64
+}
+
+// Zero value is sorted by name too
+func DefaultSortOrder() SortOrder {
+	return SortOrder{Field: SortByName}
+}
+
+// Parses the order from "<field>[:<seed>] [asc|desc]". Empty value is the default order.
+func ParseSortOrder(value string) (SortOrder, error) {
+	words := strings.Fields(strings.ToLower(value))
+	if len(words) == 0 {
+		return DefaultSortOrder(), nil
+	} else if len(words) > 2 {
+		return SortOrder{}, fmt.Errorf("invalid sort order '%s'", value)
+	}
+
+	order := SortOrder{}
+	field := words[0]
+	if name, seed, found := strings.Cut(field, ":"); found {
+		var err error
+		if order.Seed, err = strconv.ParseInt(seed, 10, 64); err != nil || name != string(SortByRandom) {
+			return SortOrder{}, fmt.Errorf("invalid sort order '%s', only %s takes a seed", value, SortByRandom)
+		}
+		field = name
+	}
+	for _, sortField := range SortFields {
+		if string(sortField) == field {
+			order.Field = sortField
+		}
+	}
+	if order.Field == "" {
+		return SortOrder{}, fmt.Errorf("unknown sort field '%s', use one of: %s", field, SortFieldNames())
+	}
+
+	if len(words) == 2 {
+		if words[1] == sortDescending {
+			order.Descending = true
+		} else if words[1] != sortAscending {
+			return SortOrder{}, fmt.Errorf("invalid sort direction '%s', use %s or %s", words[1], sortAscending, sortDescending)
+		}
+	}
+	return order, nil
+}
+
+func (s SortField) Description() string {
+	switch s {
+	case SortByDate:
+		return "Capture time"
+	case SortBySize:
+		return "File size"
+	case SortByModified:
+		return "Modified time"
+	case SortByDimensions:
+		return "Dimensions"
+	case SortBySimilarity:
+		return "Similarity"
+	case SortByRandom:
+		return "Random"
+	default:
+		return "Name"
+	}
+}
+
+func (s SortOrder) SortField() SortField {
+	if s.Field == "" {
+		return SortByName
+	}
+	return s.Field
+}
+
+func (s SortOrder) String() string {
+	value := string(s.SortField())
+	if s.SortField() == SortByRandom {
+		value += ":" + strconv.FormatInt(s.Seed, 10)
+	}
+	if s.Descending {
+		value += " " + sortDescending
+	}
+	return value
+}
+
+// Comma separated list of the sort fields, e.g. for help texts
+func SortFieldNames() string {
+	names := make([]string, len(SortFields))
+	for i, field := range SortFields {
+		names[i] = string(field)
+	}
+	return strings.Join(names, ", ")
+}
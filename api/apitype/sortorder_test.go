@@ -0,0 +1,40 @@
+package apitype
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseSortOrder(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		value    string
+		expected SortOrder
+		asString string
+	}{
+		{"", SortOrder{Field: SortByName}, "name"},
+		{"name", SortOrder{Field: SortByName}, "name"},
+		{" Date  DESC ", SortOrder{Field: SortByDate, Descending: true}, "date desc"},
+		{"size asc", SortOrder{Field: SortBySize}, "size"},
+		{"modified", SortOrder{Field: SortByModified}, "modified"},
+		{"dimensions desc", SortOrder{Field: SortByDimensions, Descending: true}, "dimensions desc"},
+		{"similarity", SortOrder{Field: SortBySimilarity}, "similarity"},
+		{"random", SortOrder{Field: SortByRandom}, "random:0"},
+		{"random:-42 desc", SortOrder{Field: SortByRandom, Seed: -42, Descending: true}, "random:-42 desc"},
+	}
+	for _, tt := range tests {
+		order, err := ParseSortOrder(tt.value)
+		if a.Nil(err, tt.value) {
+			a.Equal(tt.expected, order, tt.value)
+			a.Equal(tt.asString, order.String(), tt.value)
+		}
+	}
+
+	for _, value := range []string{"color", "name:1", "random:x", "date up", "date desc now"} {
+		_, err := ParseSortOrder(value)
+		a.NotNil(err, value)
+	}
+
+	a.Equal("name", SortOrder{}.String())
+}
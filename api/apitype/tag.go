@@ -0,0 +1,30 @@
+package apitype
+
+import "strings"
+
+// Separates the tags in the tag input and on the command line, e.g. "beach, sunset"
+const TagSeparator = ","
+
+// Trims the surrounding and collapses the inner white space of the name.
+// Returns an empty string if the name can't be used as a tag.
+func NormalizeTagName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if strings.Contains(name, TagSeparator) {
+		return ""
+	}
+	return name
+}
+
+// Parses the separated tags. Empty and duplicate tags are skipped. Tags are
+// compared case-insensitively, since "Beach" and "beach" are the same tag.
+func ParseTags(value string) []string {
+	var tags []string
+	found := map[string]bool{}
+	for _, name := range strings.Split(value, TagSeparator) {
+		if tag := NormalizeTagName(name); tag != "" && !found[strings.ToLower(tag)] {
+			found[strings.ToLower(tag)] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
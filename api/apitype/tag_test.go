@@ -0,0 +1,23 @@
+package apitype
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizeTagName(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("beach", NormalizeTagName("beach"))
+	a.Equal("sunset at beach", NormalizeTagName("  sunset   at\tbeach "))
+	a.Equal("", NormalizeTagName("   "))
+	a.Equal("", NormalizeTagName("beach,sunset"))
+}
+
+func TestParseTags(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{"beach", "Sunset", "family trip"}, ParseTags("beach, Sunset,,family  trip, Beach"))
+	a.Nil(ParseTags(""))
+	a.Nil(ParseTags(" , "))
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"time"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+type ApplyJobStatus string
+
+const (
+	ApplyJobRunning    ApplyJobStatus = "running"
+	ApplyJobCompleted  ApplyJobStatus = "completed"
+	ApplyJobRolledBack ApplyJobStatus = "rolled-back"
+	// Files held by the job have been removed, so it can't be rolled back anymore
+	ApplyJobDiscarded ApplyJobStatus = "discarded"
+)
+
+type ApplyJobImageStatus string
+
+const (
+	ApplyJobImageDone   ApplyJobImageStatus = "done"
+	ApplyJobImageFailed ApplyJobImageStatus = "failed"
+)
+
+type FileOperation string
+
+const (
+	// File was created, rolled back by removing the file
+	FileCreate FileOperation = "create"
+	// File was moved to the holding folder instead of removing or overwriting it,
+	// rolled back by moving the file back
+	FileHold FileOperation = "hold"
+	// File was moved from the source path, rolled back by moving the file back
+	FileMove FileOperation = "move"
+)
+
+type FileOperationStatus string
+
+const (
+	// Operation has been started but the image has not been completely applied
+	FileOperationPending    FileOperationStatus = "pending"
+	FileOperationDone       FileOperationStatus = "done"
+	FileOperationRolledBack FileOperationStatus = "rolled-back"
+)
+
+// Applying the categories is stored as a job so that an interrupted
+// job can be resumed and a completed job can be rolled back
+type ApplyJob struct {
+	Id           int64                         `json:"id"`
+	Status       ApplyJobStatus                `json:"status"`
+	Options      *PersistCategorizationCommand `json:"options"`
+	CreatedTime  time.Time                     `json:"created"`
+	FinishedTime time.Time                     `json:"finished"`
+	// Number of images applied successfully
+	DoneImages   int `json:"doneImages"`
+	FailedImages int `json:"failedImages"`
+}
+
+// Single file change made while applying an image
+type ApplyJobOperation struct {
+	Id          int64
+	JobId       int64
+	ImageId     apitype.ImageId
+	Operation   FileOperation
+	Path        string
+	SourcePath  string
+	HoldingPath string
+	Status      FileOperationStatus
+}
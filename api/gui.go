@@ -20,6 +20,8 @@ type UpdateProgressCommand struct {
 	Total     int
 	CanCancel bool
 	Modal     bool
+	// Outcome of the latest processed item, e.g. where an image was copied
+	Details string
 	apitype.NotThrottled
 }
 
@@ -29,11 +31,11 @@ type UpdateCategoriesCommand struct {
 }
 
 type UpdateImageCommand struct {
-	Image      *apitype.ImageFile
-	MetaData   *apitype.ImageMetaData
-	Index      int
-	Total      int
-	CategoryId apitype.CategoryId
+	Image    *apitype.ImageFile
+	MetaData *apitype.ImageMetaData
+	Index    int
+	Total    int
+	Filter   *apitype.ImageFilter
 	apitype.NotThrottled
 }
 
@@ -48,6 +50,11 @@ type Gui interface {
 	SetImages(*SetImagesCommand)
 	UpdateCategories(*UpdateCategoriesCommand)
 	SetImageCategory(*CategoriesCommand)
+	SetImageRating(*ImageRatingCommand)
+	SetImageTags(*ImageTagsCommand)
+	SetTags(*TagsCommand)
+	SetSavedSearches(*SavedSearchesCommand)
+	ShowApplyPlan(*ApplyPlanCommand)
 	ShowError(*ErrorCommand)
 	Run()
 
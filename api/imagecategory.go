@@ -29,6 +29,20 @@ type CategorizedImage struct {
 	apitype.NotThrottled
 }
 
+// Categories of an image and the operation of each category
+type ImageCategoryState map[apitype.CategoryId]apitype.Operation
+
+// Categorization recorded in the journal so that it can be undone and redone
+type CategorizationAction struct {
+	Id              int64
+	ImageId         apitype.ImageId
+	CategoryId      apitype.CategoryId
+	Operation       apitype.Operation
+	ForceToCategory bool
+	Before          ImageCategoryState
+	After           ImageCategoryState
+}
+
 type ImageCategoryQuery struct {
 	ImageId apitype.ImageId
 
@@ -39,6 +53,73 @@ type PersistCategorizationCommand struct {
 	KeepOriginals  bool
 	FixOrientation bool
 	Quality        int
+	// Copy images from sub directories directly to the category
+	// directory instead of preserving the sub directory structure
+	FlattenSubDirectories bool
+	// What to do when the target file already exists
+	ConflictPolicy apitype.ConflictPolicy
+	// How the images that are not modified are copied
+	CopyMethod apitype.CopyMethod
+	// Link the originals to the categories instead of copying them
+	OutputMode apitype.OutputMode
+	// Template for the file names, e.g. "{exif:DateTimeOriginal:2006-01-02_150405}_{exif:Model}".
+	// Used for the categories whose path doesn't name the file and that don't have a template of their own.
+	RenameTemplate string
+	// Write the rating and the color label to the XMP sidecars of the copies
+	WriteRatings bool
+	// Write the tags as keywords to the XMP sidecars and to the JPEG copies
+	WriteTags bool
+
+	apitype.NotThrottled
+}
+
+// Changes that applying the categories would make. Nothing is written
+// to the disk when the plan is made.
+type ApplyPlan struct {
+	Images []*ImageApplyPlan `json:"images"`
+	// Number of targets that already exist
+	Conflicts int `json:"conflicts"`
+}
+
+type ImageApplyPlan struct {
+	Source string `json:"source"`
+	// Filters (e.g. Exif rotate) applied to the image before copying it
+	Filters        []string       `json:"filters"`
+	Copies         []*PlannedCopy `json:"copies"`
+	RemoveOriginal bool           `json:"removeOriginal"`
+	// Links that are removed because the image is no longer in the category
+	RemovedLinks []string `json:"removedLinks,omitempty"`
+}
+
+type PlannedCopy struct {
+	Target string `json:"target"`
+	// Image is moved to the target instead of copying it
+	Move bool `json:"move"`
+	// Target is a link to the image instead of a copy
+	Link bool `json:"link,omitempty"`
+	// Companion and sidecar files copied together with the image
+	AssociatedTargets []string `json:"associatedTargets,omitempty"`
+	// The target or one of the associated targets already exists
+	Conflict bool `json:"conflict"`
+}
+
+// Link created to a category directory when the categories are applied with a link output mode
+type CategoryLink struct {
+	ImageId    apitype.ImageId
+	CategoryId apitype.CategoryId
+	Path       string
+}
+
+type ApplyPlanCommand struct {
+	Plan    *ApplyPlan
+	Options *PersistCategorizationCommand
+
+	apitype.NotThrottled
+}
+
+// Removes the files held for rolling back the job. Zero job ID discards all completed jobs.
+type DiscardApplyJobCommand struct {
+	JobId int64
 
 	apitype.NotThrottled
 }
@@ -49,8 +130,15 @@ type ImageCategoryService interface {
 	RequestCategory(*ImageCategoryQuery)
 	GetCategories(*ImageCategoryQuery) map[apitype.CategoryId]*CategorizedImage
 	SetCategory(*CategorizeCommand)
+	UndoCategorization()
+	RedoCategorization()
 
+	PlanImageCategories(*PersistCategorizationCommand) *ApplyPlan
+	RequestApplyPlan(*PersistCategorizationCommand)
 	PersistImageCategories(*PersistCategorizationCommand)
+	GetApplyJobs() []*ApplyJob
+	RollbackApplyJob()
+	DiscardApplyJob(*DiscardApplyJobCommand)
 	PersistImageCategory(*apitype.ImageFile, map[apitype.CategoryId]*CategorizedImage)
 
 	PersistCategorization()
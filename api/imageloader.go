@@ -9,4 +9,6 @@ type ImageLoader interface {
 	LoadImage(apitype.ImageId) (image.Image, error)
 	LoadImageScaled(apitype.ImageId, apitype.Size) (image.Image, error)
 	LoadExifData(*apitype.ImageFile) (*apitype.ExifData, error)
+	// Thumbnail embedded in the image's Exif data, rotated like the image
+	LoadExifThumbnail(apitype.ImageId) (image.Image, error)
 }
@@ -0,0 +1,33 @@
+package api
+
+import "vincit.fi/image-sorter/api/apitype"
+
+type RateImageCommand struct {
+	ImageId apitype.ImageId
+	Rating  apitype.Rating
+
+	apitype.NotThrottled
+}
+
+type ColorLabelCommand struct {
+	ImageId    apitype.ImageId
+	ColorLabel apitype.ColorLabel
+
+	apitype.NotThrottled
+}
+
+type ImageRatingCommand struct {
+	ImageId apitype.ImageId
+	Rating  *apitype.ImageRating
+
+	apitype.NotThrottled
+}
+
+type ImageRatingService interface {
+	RequestRating(*ImageCategoryQuery)
+	GetRating(apitype.ImageId) *apitype.ImageRating
+	SetRating(*RateImageCommand)
+	SetColorLabel(*ColorLabelCommand)
+
+	Close()
+}
@@ -11,7 +11,18 @@ type ImageStore interface {
 	GetScaled(apitype.ImageId, apitype.Size) (image.Image, error)
 	GetThumbnail(apitype.ImageId) (image.Image, error)
 	GetExifData(apitype.ImageId) *apitype.ExifData
+	// Loads the images in the background so that they are ready when shown
+	Prefetch([]apitype.ImageId)
+	// Removes the images so that they are loaded again from the files
+	Invalidate([]apitype.ImageId)
 	GetByteSize() uint64
 	GetSizeInMB() float64
 	Purge()
 }
+
+// Thumbnails stored on disk so that they don't have to be decoded again on every start
+type ThumbnailCache interface {
+	Get(apitype.ImageId) image.Image
+	Put(apitype.ImageId, image.Image)
+	Prune(directory string, imageFiles []*apitype.ImageFile) (removed int, byteSize int64, err error)
+}
@@ -11,6 +11,18 @@ type DirectoryChangedCommand struct {
 	apitype.NotThrottled
 }
 
+// Options for scanning the images of a directory
+type ScanOptions struct {
+	// Scan also sub directories
+	Recursive bool
+	// Sub directories (relative to the scanned directory) which are not scanned.
+	// Only applies to recursive scans.
+	ExcludedDirectories []string
+	// File extensions (e.g. ".xmp") of the sidecar files that are
+	// copied and removed together with the images
+	SidecarExtensions []string
+}
+
 type ImagesQuery struct {
 	ImageFiles []*apitype.ImageFile
 
@@ -34,6 +46,36 @@ type SelectCategoryCommand struct {
 	apitype.NotThrottled
 }
 
+// Shows only the images that have at least the rating and the color label
+type RatingFilterCommand struct {
+	MinRating  apitype.Rating
+	ColorLabel apitype.ColorLabel
+
+	apitype.NotThrottled
+}
+
+// Shows only the images that match the query. Nil query removes the query filter.
+type SearchCommand struct {
+	Query *apitype.SearchQuery
+
+	apitype.NotThrottled
+}
+
+// Changes the order of the images. The order is stored per directory.
+type SortOrderCommand struct {
+	Order apitype.SortOrder
+
+	apitype.NotThrottled
+}
+
+// Files or directories that have been added, modified or removed in the image directory.
+// Paths are relative to the image directory.
+type ImageFilesChangedCommand struct {
+	Paths []string
+
+	apitype.NotThrottled
+}
+
 type ImageListCommand struct {
 	ImageListSize int
 
@@ -46,7 +88,7 @@ type SimilarImagesCommand struct {
 }
 
 type ImageService interface {
-	InitializeFromDirectory(directory string)
+	InitializeFromDirectory(directory string, options *ScanOptions)
 
 	RequestImages()
 	RequestNextImage()
@@ -61,10 +103,16 @@ type ImageService interface {
 
 	GetImageFiles() []*apitype.ImageFile
 	AddImageFiles([]*apitype.ImageFile)
+	UpdateImageFiles(*ImageFilesChangedCommand)
 	GetImageFileById(apitype.ImageId) *apitype.ImageFile
+	GetImageMetaData(apitype.ImageId) *apitype.ImageMetaData
 
 	ShowAllImages()
 	ShowOnlyImages(*SelectCategoryCommand)
+	ShowOnlyRatedImages(*RatingFilterCommand)
+	ShowOnlyMatchingImages(*SearchCommand)
+	SetSortOrder(*SortOrderCommand)
+	GetSortOrder() apitype.SortOrder
 
 	SetImageListSize(*ImageListCommand)
 	SetSendSimilarImages(*SimilarImagesCommand)
@@ -73,18 +121,26 @@ type ImageService interface {
 }
 
 type ImageLibrary interface {
-	InitializeFromDirectory(directory string) (time.Time, error)
+	InitializeFromDirectory(directory string, options *ScanOptions) (time.Time, error)
 
 	AddImageFiles(imageList []*apitype.ImageFile) error
+	UpdateImageFiles(paths []string) error
 
 	GetImages() []*apitype.ImageFile
 	GetTotalImages(categoryId apitype.CategoryId) int
+	GetTotalFilteredImages(filter *apitype.ImageFilter) int
 
 	GetImagesInCategory(number int, offset int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error)
+	GetFilteredImages(number int, offset int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error)
 	GetImageFileById(imageId apitype.ImageId) *apitype.ImageFile
+	GetImageMetaData(imageId apitype.ImageId) (*apitype.ImageMetaData, error)
 	GetImageAtIndex(index int, categoryId apitype.CategoryId) (*apitype.ImageFile, *apitype.ImageMetaData, int, error)
+	GetFilteredImageAtIndex(index int, filter *apitype.ImageFilter) (*apitype.ImageFile, *apitype.ImageMetaData, int, error)
 	GetNextImages(index int, count int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error)
+	GetNextFilteredImages(index int, count int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error)
 	GetPreviousImages(index int, count int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error)
+	GetPreviousFilteredImages(index int, count int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error)
+	PrefetchImages(imageFiles []*apitype.ImageFile)
 
 	GenerateHashes() bool
 	GetSimilarImages(imageId apitype.ImageId) ([]*apitype.ImageFile, bool, error)
@@ -0,0 +1,39 @@
+package api
+
+import "vincit.fi/image-sorter/api/apitype"
+
+// Saves the query with the name. Existing search with the same name is replaced.
+type SaveSearchCommand struct {
+	Name     string
+	Query    string
+	Shortcut string
+
+	apitype.NotThrottled
+}
+
+type RemoveSavedSearchCommand struct {
+	Id apitype.SavedSearchId
+
+	apitype.NotThrottled
+}
+
+// Saved search and how many images match it at the moment
+type SavedSearchCount struct {
+	SavedSearch *apitype.SavedSearch
+	ImageCount  int
+}
+
+type SavedSearchesCommand struct {
+	SavedSearches []*SavedSearchCount
+
+	apitype.NotThrottled
+}
+
+type SavedSearchService interface {
+	RequestSavedSearches()
+	GetSavedSearches() []*SavedSearchCount
+	SaveSearch(*SaveSearchCommand)
+	RemoveSavedSearch(*RemoveSavedSearchCommand)
+
+	Close()
+}
@@ -0,0 +1,45 @@
+package api
+
+import "vincit.fi/image-sorter/api/apitype"
+
+type TagImageCommand struct {
+	ImageId apitype.ImageId
+	Tag     string
+	// Removes the tag instead of adding it
+	Remove bool
+
+	apitype.NotThrottled
+}
+
+// Tags all the images the filter selects, e.g. the images currently shown
+type TagFilteredImagesCommand struct {
+	Filter *apitype.ImageFilter
+	Tag    string
+	Remove bool
+
+	apitype.NotThrottled
+}
+
+type ImageTagsCommand struct {
+	ImageId apitype.ImageId
+	Tags    []string
+
+	apitype.NotThrottled
+}
+
+// All the tags in use, e.g. for completing the tag input
+type TagsCommand struct {
+	Tags []string
+
+	apitype.NotThrottled
+}
+
+type TagService interface {
+	RequestTags(*ImageCategoryQuery)
+	GetImageTags(apitype.ImageId) []string
+	GetTags() []string
+	TagImage(*TagImageCommand)
+	TagFilteredImages(*TagFilteredImagesCommand)
+
+	Close()
+}
@@ -21,14 +21,43 @@ const (
 	ImageRequestSimilar        Topic = "image-request-similar"
 	ImageShowOnly              Topic = "image-show-only"
 	ImageShowAll               Topic = "image-show-all"
+	ImageShowMatching          Topic = "image-show-matching"
+	ImageSetSortOrder          Topic = "image-set-sort-order"
 	ImageChanged               Topic = "image-changed"
 	ImageListUpdated           Topic = "image-list-updated"
 	ImageCurrentUpdated        Topic = "image-current-updated"
 	ImageListSizeChanged       Topic = "image-list-size-changed"
+	ImageFilesChanged          Topic = "image-files-changed"
+
+	// Rating
+	ImageRate          Topic = "image-rate"
+	ImageSetColorLabel Topic = "image-set-color-label"
+	ImageRatingUpdated Topic = "image-rating-updated"
+	ImageShowRated     Topic = "image-show-rated"
+
+	// Tags
+	ImageTag         Topic = "image-tag"
+	ImageTagFiltered Topic = "image-tag-filtered"
+	ImageTagsUpdated Topic = "image-tags-updated"
+	TagsUpdated      Topic = "tags-updated"
+
+	// Categories, ratings or tags of some images were changed
+	ImageAnnotationsUpdated Topic = "image-annotations-updated"
+
+	// Saved searches
+	SavedSearchSave      Topic = "saved-search-save"
+	SavedSearchRemove    Topic = "saved-search-remove"
+	SavedSearchesUpdated Topic = "saved-searches-updated"
 
 	// Categorization
 	CategorizeImage       Topic = "categorize-image"
+	CategorizeUndo        Topic = "categorize-undo"
+	CategorizeRedo        Topic = "categorize-redo"
 	CategoryPersistAll    Topic = "category-persist-all"
+	CategoryPlanRequest   Topic = "category-plan-request"
+	CategoryPlanUpdated   Topic = "category-plan-updated"
+	CategoryRollback      Topic = "category-rollback"
+	CategoryDiscardJob    Topic = "category-discard-job"
 	CategoriesUpdated     Topic = "categories-updated"
 	CategoryImageUpdate   Topic = "category-image-update"
 	CategoriesSave        Topic = "categories-save"
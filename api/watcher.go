@@ -0,0 +1,7 @@
+package api
+
+// Watches the image directory for changes made by other applications
+type Watcher interface {
+	Watch(directory string, options *ScanOptions) error
+	Close()
+}
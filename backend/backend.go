@@ -2,7 +2,9 @@ package backend
 
 import (
 	"os/user"
+	"path/filepath"
 	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/backend/dbapi"
 	"vincit.fi/image-sorter/backend/internal/caster"
 	"vincit.fi/image-sorter/backend/internal/category"
@@ -10,7 +12,12 @@ import (
 	"vincit.fi/image-sorter/backend/internal/filter"
 	"vincit.fi/image-sorter/backend/internal/imagecategory"
 	"vincit.fi/image-sorter/backend/internal/imageloader"
+	"vincit.fi/image-sorter/backend/internal/imagerating"
 	"vincit.fi/image-sorter/backend/internal/library"
+	"vincit.fi/image-sorter/backend/internal/savedsearch"
+	"vincit.fi/image-sorter/backend/internal/tag"
+	"vincit.fi/image-sorter/backend/internal/util"
+	"vincit.fi/image-sorter/backend/internal/watcher"
 	"vincit.fi/image-sorter/common"
 	"vincit.fi/image-sorter/common/event"
 	"vincit.fi/image-sorter/common/logger"
@@ -23,6 +30,11 @@ type Stores struct {
 	CategoryStore        *database.CategoryStore
 	DefaultCategoryStore *database.CategoryStore
 	ImageCategoryStore   *database.ImageCategoryStore
+	JournalStore         *database.ImageCategoryJournalStore
+	ApplyJobStore        *database.ApplyJobStore
+	ImageRatingStore     *database.ImageRatingStore
+	TagStore             *database.TagStore
+	SavedSearchStore     *database.SavedSearchStore
 	StatusStore          *database.StatusStore
 	homeDirDb            *database.Database
 	workDirDb            *database.Database
@@ -48,9 +60,14 @@ type Services struct {
 	ImageLibrary           api.ImageLibrary
 	FilterService          *filter.FilterService
 	ImageCategoryService   api.ImageCategoryService
+	ImageRatingService     api.ImageRatingService
+	TagService             api.TagService
+	SavedSearchService     api.SavedSearchService
 	CasterInstance         api.Caster
 	ImageLoader            api.ImageLoader
 	ImageCache             api.ImageStore
+	ThumbnailCache         api.ThumbnailCache
+	Watcher                api.Watcher
 }
 
 func (s *Services) Close() {
@@ -58,7 +75,11 @@ func (s *Services) Close() {
 	defer s.DefaultCategoryService.Close()
 	defer s.ImageService.Close()
 	defer s.ImageCategoryService.Close()
+	defer s.ImageRatingService.Close()
+	defer s.TagService.Close()
+	defer s.SavedSearchService.Close()
 	defer s.CasterInstance.Close()
+	defer s.Watcher.Close()
 }
 
 type Brokers struct {
@@ -76,10 +97,24 @@ func InitializeEventBrokers(eventBusQueueSize int) *Brokers {
 	return brokers
 }
 
+// Initialize brokers that handle the messages synchronously. Meant for
+// running without UI where the caller must be able to rely on all the
+// messages having been handled once a service call returns.
+func InitializeSyncEventBrokers() *Brokers {
+	logger.Debug.Printf("Initialize synchronous event brokers...")
+	brokers := &Brokers{
+		Broker:        event.InitSyncBus(),
+		DevNullBroker: event.InitDevNullBus(),
+	}
+	logger.Debug.Printf("Event brokers initialized")
+	return brokers
+}
+
 func InitializeServices(params *common.Params, stores *Stores, brokers *Brokers) *Services {
 	logger.Debug.Printf("Initialize services...")
 	imageLoader := imageloader.NewImageLoader(stores.ImageStore)
-	imageCache := imageloader.NewImageCache(imageLoader)
+	thumbnailCache := imageloader.NewThumbnailCache(stores.ImageStore, params.ThumbnailCacheSize())
+	imageCache := imageloader.NewImageCache(imageLoader, thumbnailCache, params.ImageCacheSize())
 
 	filterService := filter.NewFilterService()
 	progressReporter := api.NewSenderProgressReporter(brokers.Broker)
@@ -91,30 +126,103 @@ func InitializeServices(params *common.Params, stores *Stores, brokers *Brokers)
 		ImageService:           imageService,
 		ImageLibrary:           imageLibrary,
 		FilterService:          filterService,
-		ImageCategoryService:   imagecategory.NewImageCategoryService(brokers.Broker, imageService, filterService, imageLoader, stores.ImageCategoryStore),
+		ImageCategoryService:   imagecategory.NewImageCategoryService(brokers.Broker, imageService, filterService, imageLoader, stores.ImageCategoryStore, stores.JournalStore, stores.ApplyJobStore, stores.ImageRatingStore, stores.TagStore),
+		ImageRatingService:     imagerating.NewImageRatingService(brokers.Broker, stores.ImageRatingStore),
+		TagService:             tag.NewTagService(brokers.Broker, stores.ImageStore, stores.TagStore),
+		SavedSearchService:     savedsearch.NewSavedSearchService(brokers.Broker, stores.ImageStore, stores.CategoryStore, stores.SavedSearchStore),
 		CasterInstance:         caster.NewCaster(params, brokers.Broker, imageCache),
 		ImageLoader:            imageLoader,
 		ImageCache:             imageCache,
+		ThumbnailCache:         thumbnailCache,
+		Watcher:                watcher.NewWatcher(brokers.Broker),
 	}
 	logger.Debug.Printf("Services initialized")
 	return services
 }
 
+// Initialize stores and services for the given work directory. Opens the work
+// dir DB, runs migrations and loads the categories and images.
+// Image cache is not initialized since it is only needed when showing images.
+func InitializeForDirectory(params *common.Params, stores *Stores, services *Services, directory string, databaseFileName string) error {
+	logger.Info.Printf("Initializing directory '%s'", directory)
+
+	if err := stores.InitializeForDirectory(directory, databaseFileName); err != nil {
+		return err
+	}
+
+	if tableExist := stores.Migrate(); tableExist == dbapi.TableNotExist {
+		if defaultCategories, err := stores.DefaultCategoryStore.GetCategories(); err != nil {
+			logger.Error.Print("Error while trying to load default categories ", err)
+		} else {
+			services.CategoryService.InitializeFromDirectory(params.Categories(), defaultCategories)
+		}
+	}
+	// Interrupted apply jobs are resumed before scanning so that
+	// the images that are still being applied are not lost
+	services.ImageCategoryService.InitializeForDirectory(directory)
+	services.ImageService.InitializeFromDirectory(directory, getScanOptions(params, services, directory))
+
+	return nil
+}
+
+// Starts watching the directory so that the images added, modified or removed by other
+// applications are updated to the library. Stops watching the previous directory.
+func WatchDirectory(params *common.Params, services *Services, directory string) error {
+	if !params.Watch() {
+		services.Watcher.Close()
+		return nil
+	}
+	return services.Watcher.Watch(directory, getScanOptions(params, services, directory))
+}
+
+func getScanOptions(params *common.Params, services *Services, directory string) *api.ScanOptions {
+	return &api.ScanOptions{
+		Recursive:           params.Recursive(),
+		ExcludedDirectories: getCategoryDirectories(directory, services.CategoryService.GetCategories()),
+		SidecarExtensions:   params.SidecarExtensions(),
+	}
+}
+
+// Category directories are excluded from the scan so that
+// the already sorted images are not sorted again. Only the part of the
+// templated paths that is the same for all the images can be excluded.
+func getCategoryDirectories(directory string, categories []*apitype.Category) []string {
+	var directories []string
+	for _, category := range categories {
+		if template, err := apitype.ParsePathTemplate(category.Path()); err != nil {
+			logger.Warn.Printf("Invalid path for category '%s': %s", category.FullName(), err)
+		} else if categoryDir := template.StaticDirectory(category); categoryDir == "" {
+			logger.Warn.Printf("Directory of category '%s' can't be excluded from the scan", category.FullName())
+		} else if !filepath.IsAbs(categoryDir) {
+			directories = append(directories, categoryDir)
+		} else if relativeDir, ok := util.RelativePathInside(directory, categoryDir); ok {
+			directories = append(directories, relativeDir)
+		}
+	}
+	return directories
+}
+
 // Initialize the configuration DB in user's home folder and
 // DB for the working directory. Home dir DB can be initialized
 // right away. workDirDb will be initialized once the work dir
 // is known.
 func InitializeStores(databaseFileName string) *Stores {
+	currentUser, err := user.Current()
+	if err != nil {
+		logger.Error.Fatal("Cannot load user")
+	}
+	return InitializeStoresWithHomeDirectory(currentUser.HomeDir, databaseFileName)
+}
+
+// Same as InitializeStores but the configuration DB is in the given directory
+// instead of the user's home folder
+func InitializeStoresWithHomeDirectory(homeDirectory string, databaseFileName string) *Stores {
 	logger.Debug.Printf("Initialize databases...")
 	homeDirDb := database.NewDatabase()
-	if currentUser, err := user.Current(); err != nil {
-		logger.Error.Fatal("Cannot load user")
+	if err := homeDirDb.InitializeForDirectory(homeDirectory, databaseFileName); err != nil {
+		logger.Error.Fatal("Error opening database", err)
 	} else {
-		if err := homeDirDb.InitializeForDirectory(currentUser.HomeDir, databaseFileName); err != nil {
-			logger.Error.Fatal("Error opening database", err)
-		} else {
-			_ = homeDirDb.Migrate()
-		}
+		_ = homeDirDb.Migrate()
 	}
 
 	workDirDb := database.NewDatabase()
@@ -126,6 +234,11 @@ func InitializeStores(databaseFileName string) *Stores {
 		SimilarityIndex:      database.NewSimilarityIndex(workDirDb),
 		CategoryStore:        database.NewCategoryStore(workDirDb),
 		ImageCategoryStore:   database.NewImageCategoryStore(workDirDb),
+		JournalStore:         database.NewImageCategoryJournalStore(workDirDb),
+		ApplyJobStore:        database.NewApplyJobStore(workDirDb),
+		ImageRatingStore:     database.NewImageRatingStore(workDirDb),
+		TagStore:             database.NewTagStore(workDirDb),
+		SavedSearchStore:     database.NewSavedSearchStore(workDirDb),
 		DefaultCategoryStore: database.NewCategoryStore(homeDirDb),
 		StatusStore:          database.NewStatusStore(workDirDb),
 		homeDirDb:            homeDirDb,
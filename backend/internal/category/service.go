@@ -1,6 +1,7 @@
 package category
 
 import (
+	"sort"
 	"strings"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
@@ -22,13 +23,19 @@ func Parse(value string) (name string, path string, shortcut string) {
 	parts := strings.Split(value, ":")
 
 	if len(parts) == 2 {
+		// Sub categories are under the parent's directory, so by default
+		// the path is only the last part of the nested name
 		name = parts[0]
 		path = parts[0]
+		if names := apitype.SplitCategoryName(name); len(names) > 0 {
+			path = names[len(names)-1]
+		}
 		shortcut = parts[1]
 	} else {
+		// Path may contain colons too, e.g. "{exif:Model}"
 		name = parts[0]
-		path = parts[1]
-		shortcut = parts[2]
+		path = strings.Join(parts[1:len(parts)-1], ":")
+		shortcut = parts[len(parts)-1]
 	}
 	return
 }
@@ -56,6 +63,13 @@ func (s *Service) InitializeFromDirectory(cmdLineCategories []string, dbCategori
 		loadedCategories = dbCategories
 	}
 
+	// Parents are added first so that their own paths and shortcuts are used
+	// instead of the defaults of the automatically added parents
+	sort.SliceStable(loadedCategories, func(i, j int) bool {
+		return len(apitype.SplitCategoryName(loadedCategories[i].FullName())) <
+			len(apitype.SplitCategoryName(loadedCategories[j].FullName()))
+	})
+
 	for i, category := range loadedCategories {
 		if category, err := s.categoryStore.AddCategory(category); err != nil {
 			s.sender.SendError("Error while loading categories", err)
@@ -104,6 +118,9 @@ func fromCategoriesStrings(categories []string) []*apitype.Category {
 	for _, categoryName := range categories {
 		if len(categoryName) > 0 {
 			name, subPath, shorcut := Parse(categoryName)
+			if _, err := common.ParseKeySequence(shorcut); err != nil {
+				logger.Warn.Printf("Invalid shortcut for category '%s': %s", name, err)
+			}
 			categoryEntries = append(categoryEntries, apitype.NewCategory(name, subPath, shorcut))
 		}
 	}
@@ -3,6 +3,7 @@ package category
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"path/filepath"
 	"testing"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
@@ -38,6 +39,18 @@ func TestParse(t *testing.T) {
 		a.Equal("Path", path)
 		a.Equal("P", shortcut)
 	})
+	t.Run("Nested name", func(t *testing.T) {
+		category, path, shortcut := Parse("Travel/Japan/Kyoto:K")
+		a.Equal("Travel/Japan/Kyoto", category)
+		a.Equal("Kyoto", path)
+		a.Equal("K", shortcut)
+	})
+	t.Run("Path with colons", func(t *testing.T) {
+		category, path, shortcut := Parse("Name:/archive/{exif:DateTimeOriginal:2006}/{filename}:P")
+		a.Equal("Name", category)
+		a.Equal("/archive/{exif:DateTimeOriginal:2006}/{filename}", path)
+		a.Equal("P", shortcut)
+	})
 }
 
 func TestFromCategoriesStrings(t *testing.T) {
@@ -78,6 +91,11 @@ func TestFromCategoriesStrings(t *testing.T) {
 		a.Equal("Some", categories[2].SubPath())
 		a.Equal("S", categories[2].ShortcutAsString())
 	})
+	t.Run("Shortcut sequence", func(t *testing.T) {
+		categories := fromCategoriesStrings([]string{"Name:Path:ctrl+g 3"})
+		a.Equal(1, len(categories))
+		a.Equal("Ctrl+G 3", categories[0].ShortcutAsString())
+	})
 	t.Run("Nil", func(t *testing.T) {
 		categories := fromCategoriesStrings(nil)
 		a.Equal(0, len(categories))
@@ -118,3 +136,25 @@ func TestResetCategories(t *testing.T) {
 		a.Equal(categories[2].Name(), "Cat 5")
 	}
 }
+
+func TestInitializeFromDirectory_NestedCategories(t *testing.T) {
+	a := assert.New(t)
+
+	params := common.NewEmptyParams()
+	sender := new(MockSender)
+	categoryStore := database.NewCategoryStore(database.NewInMemoryDatabase(""))
+	sut := NewCategoryService(params, sender, categoryStore)
+
+	sut.InitializeFromDirectory([]string{"Travel/Japan/Kyoto:K", "Travel/Japan:J", "Work:W"}, nil)
+
+	categories := sut.GetCategories()
+	if a.Equal(4, len(categories)) {
+		a.Equal("Travel", categories[0].FullName())
+		a.Equal("Travel/Japan", categories[1].FullName())
+		a.Equal("J", categories[1].ShortcutAsString())
+		a.Equal("Travel/Japan/Kyoto", categories[2].FullName())
+		a.Equal(categories[1].Id(), categories[2].ParentId())
+		a.Equal(filepath.Join("Travel", "Japan", "Kyoto"), categories[2].Path())
+		a.Equal("Work", categories[3].FullName())
+	}
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"encoding/json"
+	"github.com/upper/db/v4"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// ApplyJobStore stores the jobs that apply the categories to the files
+// and the file operations made by each job
+type ApplyJobStore struct {
+	database            *Database
+	collection          db.Collection
+	imageCollection     db.Collection
+	operationCollection db.Collection
+}
+
+func NewApplyJobStore(database *Database) *ApplyJobStore {
+	return &ApplyJobStore{
+		database: database,
+	}
+}
+
+func (s *ApplyJobStore) getCollection() db.Collection {
+	if s.collection == nil {
+		s.collection = s.database.Session().Collection("apply_job")
+	}
+	return s.collection
+}
+
+func (s *ApplyJobStore) getImageCollection() db.Collection {
+	if s.imageCollection == nil {
+		s.imageCollection = s.database.Session().Collection("apply_job_image")
+	}
+	return s.imageCollection
+}
+
+func (s *ApplyJobStore) getOperationCollection() db.Collection {
+	if s.operationCollection == nil {
+		s.operationCollection = s.database.Session().Collection("apply_job_operation")
+	}
+	return s.operationCollection
+}
+
+func (s *ApplyJobStore) AddJob(options *api.PersistCategorizationCommand) (*api.ApplyJob, error) {
+	if optionsJson, err := json.Marshal(options); err != nil {
+		return nil, err
+	} else if result, err := s.getCollection().Insert(&ApplyJob{
+		Status:      string(api.ApplyJobRunning),
+		Options:     string(optionsJson),
+		CreatedTime: time.Now(),
+	}); err != nil {
+		return nil, err
+	} else {
+		return s.GetJob(result.ID().(int64))
+	}
+}
+
+func (s *ApplyJobStore) GetJob(jobId int64) (*api.ApplyJob, error) {
+	var job ApplyJob
+	if err := s.getCollection().Find(db.Cond{"id": jobId}).One(&job); err != nil {
+		return nil, err
+	} else {
+		return s.toApiApplyJob(&job)
+	}
+}
+
+// Returns all the jobs, latest first
+func (s *ApplyJobStore) GetJobs() ([]*api.ApplyJob, error) {
+	return s.findJobs(s.getCollection().Find().OrderBy("-id"))
+}
+
+// Returns the jobs that were interrupted before they were completed
+func (s *ApplyJobStore) GetRunningJobs() ([]*api.ApplyJob, error) {
+	return s.findJobs(s.getCollection().Find(db.Cond{"status": string(api.ApplyJobRunning)}).OrderBy("id"))
+}
+
+// Returns the completed jobs, latest first
+func (s *ApplyJobStore) GetCompletedJobs() ([]*api.ApplyJob, error) {
+	return s.findJobs(s.getCollection().Find(db.Cond{"status": string(api.ApplyJobCompleted)}).OrderBy("-id"))
+}
+
+// Returns the latest completed job or nil if there is none
+func (s *ApplyJobStore) GetLatestCompletedJob() (*api.ApplyJob, error) {
+	var job ApplyJob
+	if err := s.getCollection().Find(db.Cond{"status": string(api.ApplyJobCompleted)}).OrderBy("-id").One(&job); err == db.ErrNoMoreRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else {
+		return s.toApiApplyJob(&job)
+	}
+}
+
+func (s *ApplyJobStore) SetJobStatus(jobId int64, status api.ApplyJobStatus) error {
+	return s.getCollection().Find(db.Cond{"id": jobId}).Update(map[string]interface{}{
+		"status":             string(status),
+		"finished_timestamp": time.Now(),
+	})
+}
+
+// Returns the images that have already been applied or failed in the job
+func (s *ApplyJobStore) GetProcessedImages(jobId int64) (map[apitype.ImageId]bool, error) {
+	var images []ApplyJobImage
+	if err := s.getImageCollection().Find(db.Cond{"job_id": jobId}).All(&images); err != nil {
+		return nil, err
+	}
+	processed := map[apitype.ImageId]bool{}
+	for _, image := range images {
+		processed[image.ImageId] = true
+	}
+	return processed, nil
+}
+
+// Marks the image processed. Pending operations of the image are marked done
+// if the image was applied successfully.
+func (s *ApplyJobStore) SetImageStatus(jobId int64, imageId apitype.ImageId, status api.ApplyJobImageStatus) error {
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		if _, err := session.Collection("apply_job_image").Insert(&ApplyJobImage{
+			JobId:   jobId,
+			ImageId: imageId,
+			Status:  string(status),
+		}); err != nil {
+			return err
+		} else if status != api.ApplyJobImageDone {
+			return nil
+		} else {
+			return session.Collection("apply_job_operation").
+				Find(db.Cond{"job_id": jobId, "image_id": imageId, "status": string(api.FileOperationPending)}).
+				Update(map[string]interface{}{"status": string(api.FileOperationDone)})
+		}
+	})
+}
+
+// Records a pending file operation. Must be called before the file is touched.
+func (s *ApplyJobStore) AddOperation(operation *api.ApplyJobOperation) error {
+	if result, err := s.getOperationCollection().Insert(&ApplyJobOperation{
+		JobId:       operation.JobId,
+		ImageId:     operation.ImageId,
+		Operation:   string(operation.Operation),
+		Path:        operation.Path,
+		SourcePath:  operation.SourcePath,
+		HoldingPath: operation.HoldingPath,
+		Status:      string(api.FileOperationPending),
+	}); err != nil {
+		return err
+	} else {
+		operation.Id = result.ID().(int64)
+		operation.Status = api.FileOperationPending
+		logger.Trace.Printf("Added %s operation %d for '%s'", operation.Operation, operation.Id, operation.Path)
+		return nil
+	}
+}
+
+// Returns the operations of the job with the status in the reverse order so
+// that they can be rolled back
+func (s *ApplyJobStore) GetOperationsToRollback(jobId int64, status api.FileOperationStatus) ([]*api.ApplyJobOperation, error) {
+	var operations []ApplyJobOperation
+	if err := s.getOperationCollection().
+		Find(db.Cond{"job_id": jobId, "status": string(status)}).
+		OrderBy("-id").
+		All(&operations); err != nil {
+		return nil, err
+	}
+	return toApiApplyJobOperations(operations), nil
+}
+
+func (s *ApplyJobStore) SetOperationStatus(operationId int64, status api.FileOperationStatus) error {
+	return s.getOperationCollection().Find(db.Cond{"id": operationId}).Update(map[string]interface{}{"status": string(status)})
+}
+
+// Private API
+
+func (s *ApplyJobStore) findJobs(result db.Result) ([]*api.ApplyJob, error) {
+	var jobs []ApplyJob
+	if err := result.All(&jobs); err != nil {
+		return nil, err
+	}
+	apiJobs := make([]*api.ApplyJob, len(jobs))
+	for i := range jobs {
+		if job, err := s.toApiApplyJob(&jobs[i]); err != nil {
+			return nil, err
+		} else {
+			apiJobs[i] = job
+		}
+	}
+	return apiJobs, nil
+}
+
+func (s *ApplyJobStore) toApiApplyJob(job *ApplyJob) (*api.ApplyJob, error) {
+	if doneImages, err := s.getImageCollection().Find(db.Cond{"job_id": job.Id, "status": string(api.ApplyJobImageDone)}).Count(); err != nil {
+		return nil, err
+	} else if failedImages, err := s.getImageCollection().Find(db.Cond{"job_id": job.Id, "status": string(api.ApplyJobImageFailed)}).Count(); err != nil {
+		return nil, err
+	} else {
+		return toApiApplyJob(job, int(doneImages), int(failedImages))
+	}
+}
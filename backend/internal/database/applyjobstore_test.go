@@ -0,0 +1,110 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func initApplyJobStoreTest() *ApplyJobStore {
+	return NewApplyJobStore(NewInMemoryDatabase(""))
+}
+
+func TestApplyJobStore_Job(t *testing.T) {
+	a := require.New(t)
+
+	sut := initApplyJobStoreTest()
+
+	options := &api.PersistCategorizationCommand{
+		KeepOriginals:  true,
+		Quality:        80,
+		ConflictPolicy: apitype.ConflictSkip,
+	}
+	job, err := sut.AddJob(options)
+	a.Nil(err)
+
+	t.Run("New job is running", func(t *testing.T) {
+		a.Equal(api.ApplyJobRunning, job.Status)
+		a.Equal(options, job.Options)
+
+		running, err := sut.GetRunningJobs()
+		a.Nil(err)
+		a.Equal([]*api.ApplyJob{job}, running)
+
+		completed, err := sut.GetLatestCompletedJob()
+		a.Nil(err)
+		a.Nil(completed)
+	})
+
+	t.Run("Processed images", func(t *testing.T) {
+		a.Nil(sut.SetImageStatus(job.Id, 1, api.ApplyJobImageDone))
+		a.Nil(sut.SetImageStatus(job.Id, 2, api.ApplyJobImageFailed))
+
+		processed, err := sut.GetProcessedImages(job.Id)
+		a.Nil(err)
+		a.Equal(map[apitype.ImageId]bool{1: true, 2: true}, processed)
+	})
+
+	t.Run("Completed job", func(t *testing.T) {
+		a.Nil(sut.SetJobStatus(job.Id, api.ApplyJobCompleted))
+
+		running, err := sut.GetRunningJobs()
+		a.Nil(err)
+		a.Empty(running)
+
+		completed, err := sut.GetLatestCompletedJob()
+		a.Nil(err)
+		a.Equal(job.Id, completed.Id)
+		a.Equal(api.ApplyJobCompleted, completed.Status)
+		a.Equal(1, completed.DoneImages)
+		a.Equal(1, completed.FailedImages)
+
+		jobs, err := sut.GetCompletedJobs()
+		a.Nil(err)
+		a.Equal([]*api.ApplyJob{completed}, jobs)
+	})
+}
+
+func TestApplyJobStore_Operations(t *testing.T) {
+	a := require.New(t)
+
+	sut := initApplyJobStoreTest()
+	job, err := sut.AddJob(&api.PersistCategorizationCommand{})
+	a.Nil(err)
+
+	create := &api.ApplyJobOperation{JobId: job.Id, ImageId: 1, Operation: api.FileCreate, Path: "/cat/image1.jpg"}
+	hold := &api.ApplyJobOperation{JobId: job.Id, ImageId: 1, Operation: api.FileHold, Path: "/image1.jpg", HoldingPath: "/holding/image1.jpg"}
+	other := &api.ApplyJobOperation{JobId: job.Id, ImageId: 2, Operation: api.FileCreate, Path: "/cat/image2.jpg"}
+	a.Nil(sut.AddOperation(create))
+	a.Nil(sut.AddOperation(hold))
+	a.Nil(sut.AddOperation(other))
+
+	t.Run("Pending operations in reverse order", func(t *testing.T) {
+		operations, err := sut.GetOperationsToRollback(job.Id, api.FileOperationPending)
+		a.Nil(err)
+		a.Equal([]*api.ApplyJobOperation{other, hold, create}, operations)
+	})
+
+	t.Run("Operations of applied image are done", func(t *testing.T) {
+		a.Nil(sut.SetImageStatus(job.Id, 1, api.ApplyJobImageDone))
+
+		done, err := sut.GetOperationsToRollback(job.Id, api.FileOperationDone)
+		a.Nil(err)
+		a.Equal(2, len(done))
+		a.Equal(hold.Id, done[0].Id)
+		a.Equal(create.Id, done[1].Id)
+
+		pending, err := sut.GetOperationsToRollback(job.Id, api.FileOperationPending)
+		a.Nil(err)
+		a.Equal([]*api.ApplyJobOperation{other}, pending)
+	})
+
+	t.Run("Rolled back operation", func(t *testing.T) {
+		a.Nil(sut.SetOperationStatus(other.Id, api.FileOperationRolledBack))
+
+		pending, err := sut.GetOperationsToRollback(job.Id, api.FileOperationPending)
+		a.Nil(err)
+		a.Empty(pending)
+	})
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"github.com/upper/db/v4"
+	"sort"
 	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/common/logger"
 )
@@ -24,34 +25,77 @@ func (s *CategoryStore) getCollection() db.Collection {
 	return s.collection
 }
 
+// Adds the category unless a category with the same name already exists under the same parent.
+// Nested names such as "Travel/Japan/Kyoto" add the missing parent categories too.
 func (s *CategoryStore) AddCategory(category *apitype.Category) (*apitype.Category, error) {
 	return addCategory(s.getCollection(), category)
 }
 
 func addCategory(collection db.Collection, category *apitype.Category) (*apitype.Category, error) {
+	if parent, name, err := addParentCategories(collection, category); err != nil {
+		return nil, err
+	} else {
+		added := apitype.NewCategoryWithParent(apitype.NoCategory, parent, name, category.SubPath(), category.ShortcutAsString())
+		added.SetRenameTemplate(category.RenameTemplate())
+		return findOrAddCategory(collection, added)
+	}
+}
+
+// Adds the parents of the category that don't exist yet, e.g. "Travel" and "Japan" for
+// "Travel/Japan/Kyoto". Returns the direct parent and the name of the category itself.
+func addParentCategories(collection db.Collection, category *apitype.Category) (*apitype.Category, string, error) {
+	names := apitype.SplitCategoryName(category.FullName())
+	if len(names) == 0 {
+		return nil, category.Name(), nil
+	}
+
+	var parent *apitype.Category
+	for _, name := range names[:len(names)-1] {
+		var err error
+		if parent, err = findOrAddCategory(collection, apitype.NewCategoryWithParent(
+			apitype.NoCategory, parent, name, name, "")); err != nil {
+			return nil, "", err
+		}
+	}
+	return parent, names[len(names)-1], nil
+}
+
+func findOrAddCategory(collection db.Collection, category *apitype.Category) (*apitype.Category, error) {
 	var existing []Category
-	if err := collection.Find(db.Cond{"name": category.Name()}).
+	if err := collection.Find(db.Cond{"parent_id": category.ParentId(), "name": category.Name()}).
 		All(&existing); err != nil {
 		return nil, err
 	} else if len(existing) > 0 {
-		return toApiCategory(existing[0]), nil
+		return toApiCategory(existing[0], category.Parent()), nil
 	}
 
 	result, err := collection.Insert(Category{
-		Name:     category.Name(),
-		SubPath:  category.SubPath(),
-		Shortcut: category.ShortcutAsString(),
+		ParentId:       category.ParentId(),
+		Name:           category.Name(),
+		SubPath:        category.SubPath(),
+		Shortcut:       category.ShortcutAsString(),
+		RenameTemplate: category.RenameTemplate(),
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	logger.Debug.Printf("Stored category %s (%d) to DB", category.Name(), category.Id())
+	logger.Debug.Printf("Stored category %s (%d) to DB", category.FullName(), category.Id())
 	return apitype.NewPersistedCategory(idToCategoryId(result.ID()), category), err
 }
 
+// Replaces the categories with the given ones. Parents of the nested categories are
+// kept even if they are not in the list.
 func (s *CategoryStore) ResetCategories(categories []*apitype.Category) error {
+	// Parents are handled before their sub categories
+	sortedCategories := make([]*apitype.Category, len(categories))
+	copy(sortedCategories, categories)
+	sort.SliceStable(sortedCategories, func(i, j int) bool {
+		return len(apitype.SplitCategoryName(sortedCategories[i].FullName())) <
+			len(apitype.SplitCategoryName(sortedCategories[j].FullName()))
+	})
+
 	return s.getCollection().Session().Tx(func(sess db.Session) error {
 		collection := sess.Collection("category")
 		if existingCategoriesById, err := s.getExistingById(collection); err != nil {
@@ -59,16 +103,15 @@ func (s *CategoryStore) ResetCategories(categories []*apitype.Category) error {
 		} else {
 			// Add and update the ones that still exist delete from the list
 			// so the only ones left are the ones that should be removed
-			for _, category := range categories {
-				categoryKey := category.Id()
-				if _, ok := existingCategoriesById[categoryKey]; ok {
-					if err := updateCategory(collection, category); err != nil {
-						return err
-					} else {
-						delete(existingCategoriesById, categoryKey)
-					}
-				} else if _, err := addCategory(collection, category); err != nil {
+			for _, category := range sortedCategories {
+				if parent, name, err := addParentCategories(collection, category); err != nil {
+					return err
+				} else if stored, err := resetCategory(collection, existingCategoriesById, category, parent, name); err != nil {
 					return err
+				} else {
+					for ; stored != nil; stored = stored.Parent() {
+						delete(existingCategoriesById, stored.Id())
+					}
 				}
 			}
 
@@ -84,6 +127,25 @@ func (s *CategoryStore) ResetCategories(categories []*apitype.Category) error {
 	})
 }
 
+// Updates the existing category or the one with the same name under the parent, otherwise adds a new one
+func resetCategory(collection db.Collection, existingCategoriesById map[apitype.CategoryId]*apitype.Category, category *apitype.Category, parent *apitype.Category, name string) (*apitype.Category, error) {
+	updated := apitype.NewCategoryWithParent(category.Id(), parent, name, category.SubPath(), category.ShortcutAsString())
+	updated.SetRenameTemplate(category.RenameTemplate())
+	if _, ok := existingCategoriesById[category.Id()]; ok {
+		return updated, updateCategory(collection, updated)
+	}
+
+	var existing []Category
+	if err := collection.Find(db.Cond{"parent_id": updated.ParentId(), "name": name}).All(&existing); err != nil {
+		return nil, err
+	} else if len(existing) > 0 {
+		updated = apitype.NewPersistedCategory(existing[0].Id, updated)
+		return updated, updateCategory(collection, updated)
+	} else {
+		return findOrAddCategory(collection, updated)
+	}
+}
+
 func (s *CategoryStore) getExistingById(collection db.Collection) (map[apitype.CategoryId]*apitype.Category, error) {
 	var existingCategoriesById = map[apitype.CategoryId]*apitype.Category{}
 	var persistedCategories []Category
@@ -91,7 +153,7 @@ func (s *CategoryStore) getExistingById(collection db.Collection) (map[apitype.C
 		return nil, err
 	} else {
 		for _, category := range persistedCategories {
-			existingCategoriesById[category.Id] = toApiCategory(category)
+			existingCategoriesById[category.Id] = toApiCategory(category, nil)
 		}
 	}
 	return existingCategoriesById, nil
@@ -111,12 +173,20 @@ func (s *CategoryStore) GetCategories() ([]*apitype.Category, error) {
 }
 
 func (s *CategoryStore) GetCategoryById(id apitype.CategoryId) *apitype.Category {
-	var category Category
-	if err := s.getCollection().Find(db.Cond{"id": id}).One(&category); err != nil {
+	if categoriesById, err := getCategoriesById(s.getCollection()); err != nil {
 		return nil
 	} else {
-		return toApiCategory(category)
+		return categoriesById[id]
+	}
+}
+
+// Categories are linked to their parents
+func getCategoriesById(collection db.Collection) (map[apitype.CategoryId]*apitype.Category, error) {
+	var categories []Category
+	if err := collection.Find().All(&categories); err != nil {
+		return nil, err
 	}
+	return toApiCategoriesById(categories), nil
 }
 
 func removeCategory(collection db.Collection, categoryId apitype.CategoryId) error {
@@ -125,9 +195,11 @@ func removeCategory(collection db.Collection, categoryId apitype.CategoryId) err
 
 func updateCategory(collection db.Collection, category *apitype.Category) error {
 	return collection.Find(db.Cond{"id": category.Id()}).Update(&Category{
-		Id:       category.Id(),
-		Name:     category.Name(),
-		SubPath:  category.SubPath(),
-		Shortcut: category.ShortcutAsString(),
+		Id:             category.Id(),
+		ParentId:       category.ParentId(),
+		Name:           category.Name(),
+		SubPath:        category.SubPath(),
+		Shortcut:       category.ShortcutAsString(),
+		RenameTemplate: category.RenameTemplate(),
 	})
 }
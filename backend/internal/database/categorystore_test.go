@@ -2,6 +2,7 @@ package database
 
 import (
 	"github.com/stretchr/testify/assert"
+	"path/filepath"
 	"testing"
 	"vincit.fi/image-sorter/api/apitype"
 )
@@ -22,7 +23,7 @@ func TestCategoryStore_AddCategory(t *testing.T) {
 			a.Equal(apitype.CategoryId(1), category.Id())
 			a.Equal("Category 1", category.Name())
 			a.Equal("cat1", category.SubPath())
-			a.Equal(uint(0x43), category.Shortcut())
+			a.Equal("C", category.ShortcutAsString())
 		}
 	})
 
@@ -33,7 +34,7 @@ func TestCategoryStore_AddCategory(t *testing.T) {
 			a.Equal(apitype.CategoryId(2), category.Id())
 			a.Equal("Category 2", category.Name())
 			a.Equal("cat2", category.SubPath())
-			a.Equal(uint(0x44), category.Shortcut())
+			a.Equal("D", category.ShortcutAsString())
 		}
 	})
 
@@ -44,7 +45,7 @@ func TestCategoryStore_AddCategory(t *testing.T) {
 			a.Equal(apitype.CategoryId(1), category.Id())
 			a.Equal("Category 1", category.Name())
 			a.Equal("cat1", category.SubPath())
-			a.Equal(uint(0x43), category.Shortcut())
+			a.Equal("C", category.ShortcutAsString())
 		}
 	})
 
@@ -55,7 +56,7 @@ func TestCategoryStore_AddCategory(t *testing.T) {
 			a.Equal(apitype.CategoryId(1), category.Id())
 			a.Equal("Category 1", category.Name())
 			a.Equal("cat1", category.SubPath())
-			a.Equal(uint(0x43), category.Shortcut())
+			a.Equal("C", category.ShortcutAsString())
 		}
 	})
 
@@ -66,12 +67,12 @@ func TestCategoryStore_AddCategory(t *testing.T) {
 		a.Equal(apitype.CategoryId(1), categories[0].Id())
 		a.Equal("Category 1", categories[0].Name())
 		a.Equal("cat1", categories[0].SubPath())
-		a.Equal(uint(0x43), categories[0].Shortcut())
+		a.Equal("C", categories[0].ShortcutAsString())
 
 		a.Equal(apitype.CategoryId(2), categories[1].Id())
 		a.Equal("Category 2", categories[1].Name())
 		a.Equal("cat2", categories[1].SubPath())
-		a.Equal(uint(0x44), categories[1].Shortcut())
+		a.Equal("D", categories[1].ShortcutAsString())
 	}
 }
 
@@ -102,17 +103,17 @@ func TestCategoryStore_ResetCategories(t *testing.T) {
 				a.Equal(apitype.CategoryId(1), categories[0].Id())
 				a.Equal("Category 1", categories[0].Name())
 				a.Equal("cat1", categories[0].SubPath())
-				a.Equal(uint(0x43), categories[0].Shortcut())
+				a.Equal("C", categories[0].ShortcutAsString())
 
 				a.Equal(apitype.CategoryId(2), categories[1].Id())
 				a.Equal("Category 2", categories[1].Name())
 				a.Equal("cat2", categories[1].SubPath())
-				a.Equal(uint(0x44), categories[1].Shortcut())
+				a.Equal("D", categories[1].ShortcutAsString())
 
 				a.Equal(apitype.CategoryId(4), categories[2].Id())
 				a.Equal("Category 4", categories[2].Name())
 				a.Equal("cat4", categories[2].SubPath())
-				a.Equal(uint(0x46), categories[2].Shortcut())
+				a.Equal("F", categories[2].ShortcutAsString())
 			}
 
 		}
@@ -132,12 +133,12 @@ func TestCategoryStore_ResetCategories(t *testing.T) {
 				a.Equal(apitype.CategoryId(5), categories[0].Id())
 				a.Equal("Category 5", categories[0].Name())
 				a.Equal("cat5", categories[0].SubPath())
-				a.Equal(uint(0x47), categories[0].Shortcut())
+				a.Equal("G", categories[0].ShortcutAsString())
 
 				a.Equal(apitype.CategoryId(6), categories[1].Id())
 				a.Equal("Category 6", categories[1].Name())
 				a.Equal("cat6", categories[1].SubPath())
-				a.Equal(uint(0x48), categories[1].Shortcut())
+				a.Equal("H", categories[1].ShortcutAsString())
 			}
 
 		}
@@ -175,7 +176,7 @@ func TestCategoryStore_GetCategoryById(t *testing.T) {
 		a.Equal(apitype.CategoryId(1), category1.Id())
 		a.Equal("Category 1", category1.Name())
 		a.Equal("cat1", category1.SubPath())
-		a.Equal(uint(0x43), category1.Shortcut())
+		a.Equal("C", category1.ShortcutAsString())
 	}
 
 	category2 := sut.GetCategoryById(cat2.Id())
@@ -184,6 +185,90 @@ func TestCategoryStore_GetCategoryById(t *testing.T) {
 		a.Equal(apitype.CategoryId(2), category2.Id())
 		a.Equal("Category 2", category2.Name())
 		a.Equal("cat2", category2.SubPath())
-		a.Equal(uint(0x44), category2.Shortcut())
+		a.Equal("D", category2.ShortcutAsString())
 	}
 }
+
+func TestCategoryStore_NestedCategories(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initSUT()
+
+	kyoto, err := sut.AddCategory(apitype.NewCategory("Travel/Japan/Kyoto", "Kyoto", "K"))
+	a.Nil(err)
+	_, err = sut.AddCategory(apitype.NewCategory("Work/Japan", "Japan", "W"))
+	a.Nil(err)
+
+	t.Run("Parents are added", func(t *testing.T) {
+		categories, err := sut.GetCategories()
+		if a.Nil(err) && a.Equal(5, len(categories)) {
+			a.Equal("Travel", categories[0].FullName())
+			a.Equal("Travel/Japan", categories[1].FullName())
+			a.Equal("Travel/Japan/Kyoto", categories[2].FullName())
+			a.Equal("Work", categories[3].FullName())
+			a.Equal("Work/Japan", categories[4].FullName())
+
+			a.Equal("Kyoto", categories[2].Name())
+			a.Equal(categories[1].Id(), categories[2].ParentId())
+			a.Equal(filepath.Join("Travel", "Japan", "Kyoto"), categories[2].Path())
+		}
+	})
+
+	t.Run("Get by id includes the parents", func(t *testing.T) {
+		category := sut.GetCategoryById(kyoto.Id())
+		if a.NotNil(category) {
+			a.Equal("Travel/Japan/Kyoto", category.FullName())
+		}
+	})
+
+	t.Run("Reset keeps the parents of the remaining categories", func(t *testing.T) {
+		err := sut.ResetCategories([]*apitype.Category{
+			apitype.NewCategoryWithId(kyoto.Id(), "Travel/Japan/Kyoto", "/archive/kyoto", "K"),
+			apitype.NewCategory("Travel/Japan/Tokyo", "Tokyo", "T"),
+			apitype.NewCategory("Travel", "trips", "R"),
+		})
+		a.Nil(err)
+
+		categories, err := sut.GetCategories()
+		if a.Nil(err) && a.Equal(4, len(categories)) {
+			a.Equal("Travel", categories[0].FullName())
+			a.Equal("trips", categories[0].SubPath())
+			a.Equal("Travel/Japan", categories[1].FullName())
+			a.Equal(kyoto.Id(), categories[2].Id())
+			a.Equal("/archive/kyoto", categories[2].Path())
+			a.Equal("Travel/Japan/Tokyo", categories[3].FullName())
+			a.Equal(filepath.Join("trips", "Japan", "Tokyo"), categories[3].Path())
+		}
+	})
+}
+
+func TestCategoryStore_ShortcutSequence(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initSUT()
+
+	category, err := sut.AddCategory(apitype.NewCategory("Category 1", "cat1", "Ctrl+G Key Pad 3"))
+	a.Nil(err)
+
+	stored := sut.GetCategoryById(category.Id())
+	if a.NotNil(stored) {
+		a.Equal("Ctrl+G Key Pad 3", stored.ShortcutAsString())
+		a.Equal(2, len(stored.Shortcut()))
+	}
+}
+
+func TestCategoryStore_RenameTemplate(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initSUT()
+
+	category := apitype.NewCategory("Category 1", "cat1", "C")
+	category.SetRenameTemplate("{exif:Model}_{seq:3}")
+	added, err := sut.AddCategory(category)
+	a.Nil(err)
+	a.Equal("{exif:Model}_{seq:3}", sut.GetCategoryById(added.Id()).RenameTemplate())
+
+	updated := apitype.NewCategoryWithId(added.Id(), "Category 1", "cat1", "C")
+	a.Nil(sut.ResetCategories([]*apitype.Category{updated}))
+	a.Equal("", sut.GetCategoryById(added.Id()).RenameTemplate())
+}
@@ -167,6 +167,11 @@ func (s *Database) runMigration(session db.Session, migration migration, migrati
 		if _, err := session.SQL().Exec(migration.query); err != nil {
 			return err
 		}
+		if migration.update != nil {
+			if err := migration.update(session); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
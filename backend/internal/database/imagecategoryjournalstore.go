@@ -0,0 +1,83 @@
+package database
+
+import (
+	"github.com/upper/db/v4"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// Only the latest actions are kept in the journal
+const maxJournalEntries = 1000
+
+// ImageCategoryJournalStore stores the categorization actions so that they
+// can be undone and redone. Undone actions are kept until a new action is added.
+type ImageCategoryJournalStore struct {
+	database   *Database
+	collection db.Collection
+}
+
+func NewImageCategoryJournalStore(database *Database) *ImageCategoryJournalStore {
+	return &ImageCategoryJournalStore{
+		database: database,
+	}
+}
+
+func (s *ImageCategoryJournalStore) getCollection() db.Collection {
+	if s.collection == nil {
+		s.collection = s.database.Session().Collection("image_category_journal")
+	}
+	return s.collection
+}
+
+// Adds the action to the journal. The undone actions can't be redone after this.
+func (s *ImageCategoryJournalStore) AddAction(action *api.CategorizationAction) error {
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		collection := session.Collection("image_category_journal")
+		if err := collection.Find(db.Cond{"undone": true}).Delete(); err != nil {
+			return err
+		} else if result, err := collection.Insert(&ImageCategoryJournalEntry{
+			ImageId:          action.ImageId,
+			CategoryId:       action.CategoryId,
+			Operation:        int64(action.Operation),
+			ForceCategory:    action.ForceToCategory,
+			BeforeCategories: toDbImageCategoryState(action.Before),
+			AfterCategories:  toDbImageCategoryState(action.After),
+			Undone:           false,
+			CreatedTime:      time.Now(),
+		}); err != nil {
+			return err
+		} else {
+			action.Id = result.ID().(int64)
+			logger.Trace.Printf("Added categorization action %d to journal", action.Id)
+			return collection.Find(db.Cond{"id <=": action.Id - maxJournalEntries}).Delete()
+		}
+	})
+}
+
+// Returns the latest action that can be undone or nil if there is nothing to undo
+func (s *ImageCategoryJournalStore) GetUndoAction() (*api.CategorizationAction, error) {
+	return s.findAction(s.getCollection().Find(db.Cond{"undone": false}).OrderBy("-id"))
+}
+
+// Returns the earliest undone action or nil if there is nothing to redo
+func (s *ImageCategoryJournalStore) GetRedoAction() (*api.CategorizationAction, error) {
+	return s.findAction(s.getCollection().Find(db.Cond{"undone": true}).OrderBy("id"))
+}
+
+func (s *ImageCategoryJournalStore) SetUndone(actionId int64, undone bool) error {
+	return s.getCollection().Find(db.Cond{"id": actionId}).Update(map[string]interface{}{"undone": undone})
+}
+
+// Private API
+
+func (s *ImageCategoryJournalStore) findAction(result db.Result) (*api.CategorizationAction, error) {
+	var entry ImageCategoryJournalEntry
+	if err := result.One(&entry); err == db.ErrNoMoreRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else {
+		return toApiCategorizationAction(&entry)
+	}
+}
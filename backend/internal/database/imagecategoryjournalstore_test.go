@@ -0,0 +1,87 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func initImageCategoryJournalStoreTest() *ImageCategoryJournalStore {
+	return NewImageCategoryJournalStore(NewInMemoryDatabase(""))
+}
+
+func TestImageCategoryJournalStore_UndoRedo(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageCategoryJournalStoreTest()
+
+	t.Run("Empty journal", func(t *testing.T) {
+		undo, err := sut.GetUndoAction()
+		a.Nil(err)
+		a.Nil(undo)
+		redo, err := sut.GetRedoAction()
+		a.Nil(err)
+		a.Nil(redo)
+	})
+
+	first := &api.CategorizationAction{
+		ImageId:    1,
+		CategoryId: 2,
+		Operation:  apitype.CATEGORIZE,
+		Before:     api.ImageCategoryState{},
+		After:      api.ImageCategoryState{2: apitype.CATEGORIZE},
+	}
+	second := &api.CategorizationAction{
+		ImageId:         1,
+		CategoryId:      3,
+		Operation:       apitype.CATEGORIZE,
+		ForceToCategory: true,
+		Before:          api.ImageCategoryState{2: apitype.CATEGORIZE},
+		After:           api.ImageCategoryState{3: apitype.CATEGORIZE},
+	}
+	a.Nil(sut.AddAction(first))
+	a.Nil(sut.AddAction(second))
+
+	t.Run("Latest action is undone first", func(t *testing.T) {
+		undo, err := sut.GetUndoAction()
+		a.Nil(err)
+		a.Equal(second, undo)
+	})
+
+	t.Run("Undone action can be redone", func(t *testing.T) {
+		a.Nil(sut.SetUndone(second.Id, true))
+
+		undo, err := sut.GetUndoAction()
+		a.Nil(err)
+		a.Equal(first, undo)
+		redo, err := sut.GetRedoAction()
+		a.Nil(err)
+		a.Equal(second, redo)
+	})
+
+	t.Run("Earliest undone action is redone first", func(t *testing.T) {
+		a.Nil(sut.SetUndone(first.Id, true))
+
+		redo, err := sut.GetRedoAction()
+		a.Nil(err)
+		a.Equal(first, redo)
+	})
+
+	t.Run("New action removes undone actions", func(t *testing.T) {
+		a.Nil(sut.AddAction(&api.CategorizationAction{
+			ImageId:    2,
+			CategoryId: 2,
+			Operation:  apitype.CATEGORIZE,
+			Before:     api.ImageCategoryState{},
+			After:      api.ImageCategoryState{2: apitype.CATEGORIZE},
+		}))
+
+		redo, err := sut.GetRedoAction()
+		a.Nil(err)
+		a.Nil(redo)
+		undo, err := sut.GetUndoAction()
+		a.Nil(err)
+		a.Equal(apitype.ImageId(2), undo.ImageId)
+	})
+}
@@ -7,8 +7,10 @@ import (
 )
 
 type ImageCategoryStore struct {
-	database   *Database
-	collection db.Collection
+	database           *Database
+	collection         db.Collection
+	linkCollection     db.Collection
+	categoryCollection db.Collection
 }
 
 func NewImageCategoryStore(database *Database) *ImageCategoryStore {
@@ -24,6 +26,20 @@ func (s *ImageCategoryStore) getCollection() db.Collection {
 	return s.collection
 }
 
+func (s *ImageCategoryStore) getLinkCollection() db.Collection {
+	if s.linkCollection == nil {
+		s.linkCollection = s.database.Session().Collection("category_link")
+	}
+	return s.linkCollection
+}
+
+func (s *ImageCategoryStore) getCategoryCollection() db.Collection {
+	if s.categoryCollection == nil {
+		s.categoryCollection = s.database.Session().Collection("category")
+	}
+	return s.categoryCollection
+}
+
 func (s *ImageCategoryStore) RemoveImageCategories(imageId apitype.ImageId) error {
 	_, err := s.getCollection().Session().SQL().Exec(`
 			DELETE FROM image_category WHERE image_id = ?
@@ -49,6 +65,26 @@ func (s *ImageCategoryStore) CategorizeImage(imageId apitype.ImageId, categoryId
 	}
 }
 
+// Replaces all the categories of the image with the given state
+func (s *ImageCategoryStore) SetImageCategories(imageId apitype.ImageId, state api.ImageCategoryState) error {
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		collection := session.Collection("image_category")
+		if err := collection.Find(db.Cond{"image_id": imageId}).Delete(); err != nil {
+			return err
+		}
+		for categoryId, operation := range state {
+			if _, err := collection.Insert(&ImageCategory{
+				ImageId:    imageId,
+				CategoryId: categoryId,
+				Operation:  int64(operation),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *ImageCategoryStore) GetImagesCategories(imageId apitype.ImageId) ([]*api.CategorizedImage, error) {
 	var categories []CategorizedImage
 	err := s.getCollection().Session().SQL().
@@ -66,9 +102,11 @@ func (s *ImageCategoryStore) GetImagesCategories(imageId apitype.ImageId) ([]*ap
 
 	if err != nil {
 		return nil, err
+	} else if categoriesById, err := getCategoriesById(s.getCategoryCollection()); err != nil {
+		return nil, err
+	} else {
+		return toApiCategorizedImages(categories, categoriesById), nil
 	}
-
-	return toApiCategorizedImages(categories), nil
 }
 
 func (s *ImageCategoryStore) GetCategorizedImages() (map[apitype.ImageId]map[apitype.CategoryId]*api.CategorizedImage, error) {
@@ -88,6 +126,10 @@ func (s *ImageCategoryStore) GetCategorizedImages() (map[apitype.ImageId]map[api
 	if err != nil {
 		return nil, err
 	}
+	categoriesById, err := getCategoriesById(s.getCategoryCollection())
+	if err != nil {
+		return nil, err
+	}
 
 	var categoryImagesByImageIdAndCategoryId = map[apitype.ImageId]map[apitype.CategoryId]*api.CategorizedImage{}
 	for _, categorizedImage := range categorizedImages {
@@ -98,7 +140,53 @@ func (s *ImageCategoryStore) GetCategorizedImages() (map[apitype.ImageId]map[api
 			categorizedImagesByCategoryId = map[apitype.CategoryId]*api.CategorizedImage{}
 			categoryImagesByImageIdAndCategoryId[categorizedImage.ImageId] = categorizedImagesByCategoryId
 		}
-		categorizedImagesByCategoryId[categorizedImage.CategoryId] = toApiCategorizedImage(&categorizedImage)
+		categorizedImagesByCategoryId[categorizedImage.CategoryId] = toApiCategorizedImage(&categorizedImage, categoriesById)
 	}
 	return categoryImagesByImageIdAndCategoryId, nil
 }
+
+// Returns the links created to the category directories for the image
+func (s *ImageCategoryStore) GetImageLinks(imageId apitype.ImageId) ([]*api.CategoryLink, error) {
+	var links []CategoryLink
+	if err := s.getLinkCollection().Find(db.Cond{"image_id": imageId}).OrderBy("id").All(&links); err != nil {
+		return nil, err
+	}
+	return toApiCategoryLinks(links), nil
+}
+
+// Returns the images that have links in any category directory
+func (s *ImageCategoryStore) GetLinkedImageIds() ([]apitype.ImageId, error) {
+	var links []CategoryLink
+	if err := s.getLinkCollection().Session().SQL().
+		Select().
+		Distinct("image_id").
+		From("category_link").
+		All(&links); err != nil {
+		return nil, err
+	}
+	imageIds := make([]apitype.ImageId, len(links))
+	for i, link := range links {
+		imageIds[i] = link.ImageId
+	}
+	return imageIds, nil
+}
+
+// Replaces all the links of the image with the given links
+func (s *ImageCategoryStore) SetImageLinks(imageId apitype.ImageId, links []*api.CategoryLink) error {
+	return s.getLinkCollection().Session().Tx(func(session db.Session) error {
+		collection := session.Collection("category_link")
+		if err := collection.Find(db.Cond{"image_id": imageId}).Delete(); err != nil {
+			return err
+		}
+		for _, link := range links {
+			if _, err := collection.Insert(&CategoryLink{
+				ImageId:    imageId,
+				CategoryId: link.CategoryId,
+				Path:       link.Path,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
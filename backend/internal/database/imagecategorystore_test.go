@@ -3,6 +3,7 @@ package database
 import (
 	"github.com/stretchr/testify/require"
 	"testing"
+	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
 )
 
@@ -276,3 +277,60 @@ func createImages() []*apitype.ImageFile {
 		image5,
 	}
 }
+
+func TestImageCategoryStore_SetImageLinks(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageCategoryStoreTest()
+	images := createImages()
+	categories := createCategories()
+
+	t.Run("No links", func(t *testing.T) {
+		links, err := sut.GetImageLinks(images[0].Id())
+		a.Nil(err)
+		a.Equal(0, len(links))
+
+		imageIds, err := sut.GetLinkedImageIds()
+		a.Nil(err)
+		a.Equal(0, len(imageIds))
+	})
+
+	t.Run("Set links", func(t *testing.T) {
+		a.Nil(sut.SetImageLinks(images[0].Id(), []*api.CategoryLink{
+			{CategoryId: categories[0].Id(), Path: "cat1/image1"},
+			{CategoryId: categories[1].Id(), Path: "cat2/image1"},
+		}))
+		a.Nil(sut.SetImageLinks(images[1].Id(), []*api.CategoryLink{
+			{CategoryId: categories[0].Id(), Path: "cat1/image2"},
+		}))
+
+		links, err := sut.GetImageLinks(images[0].Id())
+		a.Nil(err)
+		a.Equal(2, len(links))
+		a.Equal(images[0].Id(), links[0].ImageId)
+		a.Equal(categories[0].Id(), links[0].CategoryId)
+		a.Equal("cat1/image1", links[0].Path)
+		a.Equal(categories[1].Id(), links[1].CategoryId)
+		a.Equal("cat2/image1", links[1].Path)
+
+		imageIds, err := sut.GetLinkedImageIds()
+		a.Nil(err)
+		a.ElementsMatch([]apitype.ImageId{images[0].Id(), images[1].Id()}, imageIds)
+	})
+
+	t.Run("Replace links", func(t *testing.T) {
+		a.Nil(sut.SetImageLinks(images[0].Id(), []*api.CategoryLink{
+			{CategoryId: categories[1].Id(), Path: "cat2/image1"},
+		}))
+		a.Nil(sut.SetImageLinks(images[1].Id(), nil))
+
+		links, err := sut.GetImageLinks(images[0].Id())
+		a.Nil(err)
+		a.Equal(1, len(links))
+		a.Equal(categories[1].Id(), links[0].CategoryId)
+
+		imageIds, err := sut.GetLinkedImageIds()
+		a.Nil(err)
+		a.Equal([]apitype.ImageId{images[0].Id()}, imageIds)
+	})
+}
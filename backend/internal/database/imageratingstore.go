@@ -0,0 +1,64 @@
+package database
+
+import (
+	"github.com/upper/db/v4"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+type ImageRatingStore struct {
+	database   *Database
+	collection db.Collection
+}
+
+func NewImageRatingStore(database *Database) *ImageRatingStore {
+	return &ImageRatingStore{
+		database: database,
+	}
+}
+
+func (s *ImageRatingStore) getCollection() db.Collection {
+	if s.collection == nil {
+		s.collection = s.database.Session().Collection("image_rating")
+	}
+	return s.collection
+}
+
+func (s *ImageRatingStore) SetRating(imageId apitype.ImageId, rating apitype.Rating) error {
+	return s.setValue(imageId, "rating", int(rating))
+}
+
+func (s *ImageRatingStore) SetColorLabel(imageId apitype.ImageId, colorLabel apitype.ColorLabel) error {
+	return s.setValue(imageId, "color_label", string(colorLabel))
+}
+
+// Returns an empty rating if the image hasn't been rated
+func (s *ImageRatingStore) GetRating(imageId apitype.ImageId) (*apitype.ImageRating, error) {
+	var ratings []ImageRating
+	if err := s.getCollection().Find(db.Cond{"image_id": imageId}).All(&ratings); err != nil {
+		return nil, err
+	} else if len(ratings) == 0 {
+		return &apitype.ImageRating{}, nil
+	} else {
+		return toApiImageRating(&ratings[0]), nil
+	}
+}
+
+// Private API
+
+// Images that have neither rating nor color label are not stored
+func (s *ImageRatingStore) setValue(imageId apitype.ImageId, column string, value interface{}) error {
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		if _, err := session.SQL().Exec(`
+			INSERT INTO image_rating (image_id, `+column+`)
+			VALUES(?, ?)
+			ON CONFLICT(image_id) DO
+			UPDATE SET `+column+` = ?
+		`, imageId, value, value); err != nil {
+			return err
+		}
+		_, err := session.SQL().Exec(`
+			DELETE FROM image_rating WHERE image_id = ? AND rating = 0 AND color_label = ''
+		`, imageId)
+		return err
+	})
+}
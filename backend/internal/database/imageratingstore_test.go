@@ -0,0 +1,59 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func TestImageRatingStore_SetRating(t *testing.T) {
+	a := require.New(t)
+
+	sut := NewImageRatingStore(NewInMemoryDatabase(""))
+
+	t.Run("Not rated", func(t *testing.T) {
+		rating, err := sut.GetRating(1)
+		a.Nil(err)
+		a.Equal(apitype.NoRating, rating.Rating)
+		a.Equal(apitype.NoColorLabel, rating.ColorLabel)
+		a.False(rating.IsSet())
+	})
+
+	t.Run("Rating and color label", func(t *testing.T) {
+		a.Nil(sut.SetRating(1, 3))
+		a.Nil(sut.SetColorLabel(1, apitype.ColorRed))
+		a.Nil(sut.SetRating(2, 5))
+
+		rating, err := sut.GetRating(1)
+		a.Nil(err)
+		a.Equal(apitype.Rating(3), rating.Rating)
+		a.Equal(apitype.ColorRed, rating.ColorLabel)
+
+		rating, err = sut.GetRating(2)
+		a.Nil(err)
+		a.Equal(apitype.Rating(5), rating.Rating)
+		a.Equal(apitype.NoColorLabel, rating.ColorLabel)
+	})
+
+	t.Run("Change rating keeps color label", func(t *testing.T) {
+		a.Nil(sut.SetRating(1, 1))
+
+		rating, err := sut.GetRating(1)
+		a.Nil(err)
+		a.Equal(apitype.Rating(1), rating.Rating)
+		a.Equal(apitype.ColorRed, rating.ColorLabel)
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		a.Nil(sut.SetRating(1, apitype.NoRating))
+		a.Nil(sut.SetColorLabel(1, apitype.NoColorLabel))
+
+		rating, err := sut.GetRating(1)
+		a.Nil(err)
+		a.False(rating.IsSet())
+
+		exists, err := sut.getCollection().Find("image_id", 1).Exists()
+		a.Nil(err)
+		a.False(exists)
+	})
+}
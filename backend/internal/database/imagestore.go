@@ -2,6 +2,8 @@ package database
 
 import (
 	"github.com/upper/db/v4"
+	"path"
+	"path/filepath"
 	"sync"
 	"time"
 	"vincit.fi/image-sorter/api/apitype"
@@ -35,17 +37,28 @@ func (s *ImageStore) getCollection() db.Collection {
 }
 
 func (s *ImageStore) AddImages(imageFiles []*apitype.ImageFile) error {
+	_, err := s.InsertImages(imageFiles)
+	return err
+}
+
+// Adds the images like AddImages and returns them with their IDs
+func (s *ImageStore) InsertImages(imageFiles []*apitype.ImageFile) ([]*apitype.ImageFile, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	return s.getCollection().Session().Tx(func(sess db.Session) error {
+	var added []*apitype.ImageFile
+	err := s.getCollection().Session().Tx(func(sess db.Session) error {
+		added = nil
 		for _, imageFile := range imageFiles {
-			if _, err := s.addImage(sess, imageFile); err != nil {
+			if addedImageFile, err := s.addImage(sess, imageFile); err != nil {
 				logger.Error.Printf("Error while adding image '%s' to DB", imageFile.Path())
 				return err
+			} else if addedImageFile != nil {
+				added = append(added, addedImageFile)
 			}
 		}
 		return nil
 	})
+	return added, err
 }
 
 func (s *ImageStore) AddImage(imageFile *apitype.ImageFile) (*apitype.ImageFile, error) {
@@ -77,6 +90,8 @@ func (s *ImageStore) addImage(session db.Session, imageFile *apitype.ImageFile)
 		imageFileToImageDbEnd := time.Now()
 		logger.Trace.Printf(" - Loaded image meta data in %s", imageFileToImageDbEnd.Sub(imageFileToDbImageStart))
 
+		image.SortName = naturalSortKey(image.Name)
+		image.Directory = toDbDirectory(imageFile.SubDirectory())
 		insertStart := time.Now()
 		if _, err := collection.Insert(image); err != nil {
 			return nil, err
@@ -84,7 +99,7 @@ func (s *ImageStore) addImage(session db.Session, imageFile *apitype.ImageFile)
 		insertEnd := time.Now()
 		logger.Trace.Printf(" - Added image to DB in %s", insertEnd.Sub(insertStart))
 
-		return s.findByFileName(collection, imageFile)
+		return s.findByRelativePath(collection, imageFile)
 	}
 
 	modifiedId, err := s.findModifiedId(collection, imageFile)
@@ -104,6 +119,8 @@ func (s *ImageStore) addImage(session db.Session, imageFile *apitype.ImageFile)
 		imageFileToDbImageEnd := time.Now()
 		logger.Trace.Printf(" - Loaded image meta data in %s", imageFileToDbImageEnd.Sub(imageFileToDbImageStart))
 
+		image.SortName = naturalSortKey(image.Name)
+		image.Directory = toDbDirectory(imageFile.SubDirectory())
 		updateStart := time.Now()
 		err = s.update(collection, modifiedId, image)
 		if err != nil {
@@ -112,25 +129,30 @@ func (s *ImageStore) addImage(session db.Session, imageFile *apitype.ImageFile)
 		updateEnd := time.Now()
 		logger.Trace.Printf(" - Image meta data updated %s", updateEnd.Sub(updateStart))
 
-		return s.findByFileName(collection, imageFile)
+		return s.findByRelativePath(collection, imageFile)
+	} else if err := s.updateAssociatedFiles(collection, imageFile); err != nil {
+		return nil, err
 	} else {
-		return s.findByFileName(collection, imageFile)
+		return s.findByRelativePath(collection, imageFile)
 	}
 }
 
 func (s *ImageStore) GetImageCount(categoryId apitype.CategoryId) int {
+	return s.GetFilteredImageCount(apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageStore) GetFilteredImageCount(filter *apitype.ImageFilter) int {
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	conditions, err := filterConditions(filter)
+	if err != nil {
+		logger.Error.Print("Invalid image filter ", err)
+		return 0
+	}
 	res := s.getCollection().Session().SQL().
 		Select(db.Raw("count(1) AS c")).
-		From("image")
-
-	if categoryId != apitype.NoCategory {
-		res = res.
-			Join("image_category").On("image_category.image_id = image.id").
-			Join("category").On("image_category.category_id = category.id").
-			Where("category.id", categoryId)
-	}
+		From("image").
+		Where(conditions)
 
 	var counter Count
 	if err := res.One(&counter); err != nil {
@@ -163,7 +185,7 @@ func (s *ImageStore) GetLatestModifiedImage() time.Time {
 func (s *ImageStore) GetAllImages() ([]*apitype.ImageFile, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	return s.getImagesInCategory(-1, 0, apitype.NoCategory)
+	return s.getImagesInCategory(-1, 0, apitype.NoImageFilter())
 }
 
 func (s *ImageStore) GetAllImagesModifiedAfter(timestamp time.Time) ([]*apitype.ImageFile, error) {
@@ -182,13 +204,21 @@ func (s *ImageStore) GetAllImagesModifiedAfter(timestamp time.Time) ([]*apitype.
 }
 
 func (s *ImageStore) GetNextImagesInCategory(number int, currentIndex int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
+	return s.GetNextFilteredImages(number, currentIndex, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageStore) GetNextFilteredImages(number int, currentIndex int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	startIndex := currentIndex + 1
-	return s.getImagesInCategory(number, startIndex, categoryId)
+	return s.getImagesInCategory(number, startIndex, filter)
 }
 
 func (s *ImageStore) GetPreviousImagesInCategory(number int, currentIndex int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
+	return s.GetPreviousFilteredImages(number, currentIndex, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageStore) GetPreviousFilteredImages(number int, currentIndex int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	prevIndex := currentIndex - number
@@ -199,7 +229,7 @@ func (s *ImageStore) GetPreviousImagesInCategory(number int, currentIndex int, c
 
 	if size < 0 {
 		return []*apitype.ImageFile{}, nil
-	} else if images, err := s.getImagesInCategory(size, prevIndex, categoryId); err != nil {
+	} else if images, err := s.getImagesInCategory(size, prevIndex, filter); err != nil {
 		return nil, err
 	} else {
 		util.Reverse(images)
@@ -208,33 +238,41 @@ func (s *ImageStore) GetPreviousImagesInCategory(number int, currentIndex int, c
 }
 
 func (s *ImageStore) GetImagesInCategory(number int, offset int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
+	return s.GetFilteredImages(number, offset, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageStore) GetFilteredImages(number int, offset int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	return s.getImagesInCategory(number, offset, categoryId)
+	return s.getImagesInCategory(number, offset, filter)
 }
 
-func (s *ImageStore) getImagesInCategory(number int, offset int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
+func (s *ImageStore) getImagesInCategory(number int, offset int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
 	if number == 0 {
 		return make([]*apitype.ImageFile, 0), nil
 	}
 
+	conditions, err := filterConditions(filter)
+	if err != nil {
+		return nil, err
+	}
+
 	var images []Image
 	res := s.getCollection().Session().SQL().
 		Select("image.*").
-		From("image")
+		From("image").
+		Where(conditions)
 
-	if categoryId != apitype.NoCategory {
-		res = res.
-			Join("image_category").On("image_category.image_id = image.id").
-			Join("category").On("image_category.category_id = category.id").
-			Where("category.id", categoryId)
-	}
 	if number >= 0 {
 		res = res.Limit(number).
 			Offset(offset)
 	}
 
-	res = res.OrderBy("image.name")
+	order := apitype.DefaultSortOrder()
+	if filter != nil {
+		order = filter.Order
+	}
+	res = res.OrderBy(orderBy(order))
 
 	if err := res.All(&images); err != nil {
 		return nil, err
@@ -243,6 +281,54 @@ func (s *ImageStore) getImagesInCategory(number int, offset int, categoryId apit
 	}
 }
 
+// Conditions for the images that the filter selects. All the conditions must match.
+func filterConditions(filter *apitype.ImageFilter) (*db.AndExpr, error) {
+	conditions := db.And()
+	if filter.IsEmpty() {
+		return conditions, nil
+	}
+	if filter.CategoryId != apitype.NoCategory {
+		conditions = conditions.And(inCategoryTree(filter.CategoryId))
+	}
+	if filter.MinRating != apitype.NoRating {
+		conditions = conditions.And(db.Raw(`image.id IN (
+			SELECT image_rating.image_id FROM image_rating WHERE image_rating.rating >= ?
+		)`, int(filter.MinRating)))
+	}
+	if filter.ColorLabel != apitype.NoColorLabel {
+		conditions = conditions.And(db.Raw(`image.id IN (
+			SELECT image_rating.image_id FROM image_rating WHERE image_rating.color_label = ?
+		)`, string(filter.ColorLabel)))
+	}
+	if filter.Tag != "" {
+		conditions = conditions.And(db.Raw(`image.id IN (
+			SELECT image_tag.image_id FROM image_tag JOIN tag ON tag.id = image_tag.tag_id WHERE tag.name = ?
+		)`, filter.Tag))
+	}
+	if filter.Query != nil {
+		condition, args, err := searchCondition(filter.Query.Root())
+		if err != nil {
+			return nil, err
+		}
+		conditions = conditions.And(db.Raw(condition, args...))
+	}
+	return conditions, nil
+}
+
+// Images in the category or in any of its sub categories
+func inCategoryTree(categoryId apitype.CategoryId) *db.RawExpr {
+	return db.Raw(`image.id IN (
+		SELECT image_category.image_id FROM image_category WHERE image_category.category_id IN (
+			WITH RECURSIVE category_tree(id) AS (
+				SELECT ?
+				UNION
+				SELECT category.id FROM category JOIN category_tree ON category.parent_id = category_tree.id
+			)
+			SELECT id FROM category_tree
+		)
+	)`, categoryId)
+}
+
 type sortDir string
 
 const (
@@ -250,17 +336,17 @@ const (
 	desc sortDir = "DESC"
 )
 
-func (s *ImageStore) FindByFileName(imageFile *apitype.ImageFile) (*apitype.ImageFile, error) {
+func (s *ImageStore) FindByRelativePath(imageFile *apitype.ImageFile) (*apitype.ImageFile, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	return s.findByFileName(s.getCollection(), imageFile)
+	return s.findByRelativePath(s.getCollection(), imageFile)
 }
 
-func (s *ImageStore) findByFileName(collection db.Collection, imageFile *apitype.ImageFile) (*apitype.ImageFile, error) {
+func (s *ImageStore) findByRelativePath(collection db.Collection, imageFile *apitype.ImageFile) (*apitype.ImageFile, error) {
 	var imageFiles []Image
 	err := collection.
 		Find(db.Cond{
-			"file_name": imageFile.FileName(),
+			"relative_path": toDbRelativePath(imageFile),
 		}).
 		All(&imageFiles)
 	if err != nil {
@@ -279,7 +365,7 @@ func (s *ImageStore) Exists(imageFile *apitype.ImageFile) (bool, error) {
 func (s *ImageStore) exists(collection db.Collection, imageFile *apitype.ImageFile) (bool, error) {
 	return collection.
 		Find(db.Cond{
-			"file_name": imageFile.FileName(),
+			"relative_path": toDbRelativePath(imageFile),
 		}).
 		Exists()
 }
@@ -301,7 +387,7 @@ func (s *ImageStore) findModifiedId(collection db.Collection, imageFile *apitype
 	var images []Image
 	err = collection.
 		Find(db.Cond{
-			"file_name":            imageFile.FileName(),
+			"relative_path":        toDbRelativePath(imageFile),
 			"modified_timestamp <": stat.ModTime(),
 		}).All(&images)
 
@@ -316,6 +402,23 @@ func (s *ImageStore) findModifiedId(collection db.Collection, imageFile *apitype
 	}
 }
 
+// Companion and sidecar files may change without the image being modified
+// (e.g. when a RAW or an XMP file is added next to an existing JPEG)
+func (s *ImageStore) updateAssociatedFiles(collection db.Collection, imageFile *apitype.ImageFile) error {
+	companionFiles := toDbFileNames(imageFile.CompanionFiles())
+	sidecarFiles := toDbFileNames(imageFile.SidecarFiles())
+	return collection.
+		Find(db.Cond{"relative_path": toDbRelativePath(imageFile)}).
+		And(db.Or(
+			db.Cond{"companion_files <>": companionFiles},
+			db.Cond{"sidecar_files <>": sidecarFiles},
+		)).
+		Update(map[string]interface{}{
+			"companion_files": companionFiles,
+			"sidecar_files":   sidecarFiles,
+		})
+}
+
 func (s *ImageStore) update(collection db.Collection, imageId apitype.ImageId, image *Image) error {
 	return collection.Find(db.Cond{"id": imageId}).Update(image)
 }
@@ -344,3 +447,154 @@ func (s *ImageStore) RemoveImage(imageId apitype.ImageId) error {
 	defer s.mux.Unlock()
 	return s.getCollection().Find(db.Cond{"id": imageId}).Delete()
 }
+
+func (s *ImageStore) RemoveImages(imageIds []apitype.ImageId) error {
+	if len(imageIds) == 0 {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.getCollection().Find(db.Cond{"id IN": imageIds}).Delete()
+}
+
+// Returns the stored state of the images directly in the sub directory
+func (s *ImageStore) GetImageFileStates(subDirectory string) ([]*ImageFileState, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var states []*ImageFileState
+	if err := s.getCollection().
+		Find(db.Cond{"directory": toDbDirectory(subDirectory)}).
+		Select("id", "relative_path", "companion_files", "sidecar_files",
+			"byte_size", "modified_timestamp", "fingerprint").
+		All(&states); err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		state.RelativePath = filepath.FromSlash(state.RelativePath)
+	}
+	return states, nil
+}
+
+// Returns the sub directories that have images
+func (s *ImageStore) GetImageDirectories() ([]string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var rows []struct {
+		Directory string `db:"directory"`
+	}
+	if err := s.getCollection().Session().SQL().
+		Select(db.Raw("DISTINCT directory AS directory")).
+		From("image").
+		All(&rows); err != nil {
+		return nil, err
+	}
+	directories := make([]string, len(rows))
+	for i, row := range rows {
+		directories[i] = filepath.FromSlash(row.Directory)
+	}
+	return directories, nil
+}
+
+// Updates the images whose files have been modified. Returns the updated images.
+func (s *ImageStore) UpdateImages(imageFiles map[apitype.ImageId]*apitype.ImageFile) ([]*apitype.ImageFile, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var updated []*apitype.ImageFile
+	err := s.getCollection().Session().Tx(func(session db.Session) error {
+		collection := s.getCollectionForSession(session)
+		updated = nil
+		for imageId, imageFile := range imageFiles {
+			logger.Trace.Printf("Updating image '%s'", imageFile.String())
+			if image, _, err := s.imageFileConverter.ImageFileToDbImage(imageFile); err != nil {
+				return err
+			} else {
+				image.SortName = naturalSortKey(image.Name)
+				image.Directory = toDbDirectory(imageFile.SubDirectory())
+				if err := s.update(collection, imageId, image); err != nil {
+					return err
+				} else if updatedImageFile, err := s.findByRelativePath(collection, imageFile); err != nil {
+					return err
+				} else if updatedImageFile != nil {
+					updated = append(updated, updatedImageFile)
+				}
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// Moves the image to the new path of its file. The image keeps its ID, so its categories,
+// ratings and tags are kept. Fingerprint is only stored if it is given.
+func (s *ImageStore) RenameImage(imageId apitype.ImageId, imageFile *apitype.ImageFile, fingerprint string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	name := toDbRelativePath(imageFile)
+	values := map[string]interface{}{
+		"name":            name,
+		"sort_name":       naturalSortKey(name),
+		"file_name":       imageFile.FileName(),
+		"relative_path":   toDbRelativePath(imageFile),
+		"directory":       toDbDirectory(imageFile.SubDirectory()),
+		"companion_files": toDbFileNames(imageFile.CompanionFiles()),
+		"sidecar_files":   toDbFileNames(imageFile.SidecarFiles()),
+	}
+	if fingerprint != "" {
+		values["fingerprint"] = fingerprint
+	}
+	return s.getCollection().Find(db.Cond{"id": imageId}).Update(values)
+}
+
+// Updates the companion and sidecar files of the images that have not been modified
+func (s *ImageStore) UpdateAssociatedFiles(imageFiles []*apitype.ImageFile) error {
+	if len(imageFiles) == 0 {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		collection := s.getCollectionForSession(session)
+		for _, imageFile := range imageFiles {
+			if err := s.updateAssociatedFiles(collection, imageFile); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ImageStore) GetImageFileFingerprint(imageFile *apitype.ImageFile) (string, error) {
+	return s.imageFileConverter.GetImageFileFingerprint(imageFile)
+}
+
+// File has been modified if its size or modification time differs from the stored ones
+func (s *ImageFileState) IsModified(imageFile *apitype.ImageFile) bool {
+	return s.ByteSize != imageFile.ByteSize() || !s.ModifiedTime.Equal(imageFile.ModifiedTime())
+}
+
+func (s *ImageFileState) HasSameAssociatedFiles(imageFile *apitype.ImageFile) bool {
+	return s.CompanionFiles == toDbFileNames(imageFile.CompanionFiles()) &&
+		s.SidecarFiles == toDbFileNames(imageFile.SidecarFiles())
+}
+
+// Directories for the images that were added before the directory was stored
+func updateImageDirectories(session db.Session) error {
+	var images []Image
+	if err := session.SQL().Select("id", "relative_path").From("image").All(&images); err != nil {
+		return err
+	}
+	for _, image := range images {
+		directory := path.Dir(image.RelativePath)
+		if directory == "." {
+			directory = ""
+		}
+		if _, err := session.SQL().
+			Update("image").
+			Set("directory", directory).
+			Where(db.Cond{"id": image.Id}).
+			Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,9 @@ package database
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"vincit.fi/image-sorter/api/apitype"
@@ -12,6 +15,8 @@ var (
 	imageStoreImageFileConverter *StubImageFileConverter
 	isCategoryStore              *CategoryStore
 	isImageCategoryStore         *ImageCategoryStore
+	isImageRatingStore           *ImageRatingStore
+	isTagStore                   *TagStore
 )
 
 func initImageStoreTest() *ImageStore {
@@ -19,6 +24,8 @@ func initImageStoreTest() *ImageStore {
 	imageStoreImageFileConverter = &StubImageFileConverter{}
 	isCategoryStore = NewCategoryStore(database)
 	isImageCategoryStore = NewImageCategoryStore(database)
+	isImageRatingStore = NewImageRatingStore(database)
+	isTagStore = NewTagStore(database)
 	return NewImageStore(database, imageStoreImageFileConverter)
 }
 
@@ -730,7 +737,7 @@ func TestImageStore_FindByDirAndFile(t *testing.T) {
 	a.Nil(err)
 
 	t.Run("Image found", func(t *testing.T) {
-		image, err := sut.FindByFileName(apitype.NewImageFile("images", "image3"))
+		image, err := sut.FindByRelativePath(apitype.NewImageFile("images", "image3"))
 
 		a.Nil(err)
 
@@ -740,7 +747,7 @@ func TestImageStore_FindByDirAndFile(t *testing.T) {
 	})
 
 	t.Run("Image not found", func(t *testing.T) {
-		image, err := sut.FindByFileName(apitype.NewImageFile("images", "foo"))
+		image, err := sut.FindByRelativePath(apitype.NewImageFile("images", "foo"))
 
 		a.Nil(err)
 
@@ -748,6 +755,85 @@ func TestImageStore_FindByDirAndFile(t *testing.T) {
 	})
 }
 
+func TestImageStore_AddImages_SameFileNameInSubDirectories(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+
+	err := sut.AddImages([]*apitype.ImageFile{
+		apitype.NewImageFile("images", "image1"),
+		apitype.NewImageFile("images", filepath.Join("sub1", "image1")),
+		apitype.NewImageFile("images", filepath.Join("sub2", "image1")),
+	})
+	a.Nil(err)
+
+	images, err := sut.GetAllImages()
+	a.Nil(err)
+	a.Equal(3, len(images))
+
+	t.Run("Image found from sub directory", func(t *testing.T) {
+		image, err := sut.FindByRelativePath(apitype.NewImageFile("images", filepath.Join("sub2", "image1")))
+
+		a.Nil(err)
+		a.NotNil(image)
+		a.Equal("image1", image.FileName())
+		a.Equal(filepath.Join("sub2", "image1"), image.RelativePath())
+		a.Equal("sub2", image.SubDirectory())
+	})
+
+	t.Run("Image in root directory is separate", func(t *testing.T) {
+		image, err := sut.FindByRelativePath(apitype.NewImageFile("images", "image1"))
+
+		a.Nil(err)
+		a.NotNil(image)
+		a.Equal("image1", image.RelativePath())
+		a.Equal("", image.SubDirectory())
+	})
+}
+
+func TestImageStore_AddImages_CompanionFiles(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+
+	imageFile := apitype.NewImageFile("images", "image1.jpg")
+	a.Nil(sut.AddImages([]*apitype.ImageFile{imageFile}))
+
+	image, err := sut.FindByRelativePath(imageFile)
+	a.Nil(err)
+	a.Nil(image.CompanionFiles())
+
+	t.Run("Companion files are updated", func(t *testing.T) {
+		imageFile.SetCompanionFiles([]string{"image1.cr2", "image1.dng"})
+		a.Nil(sut.AddImages([]*apitype.ImageFile{imageFile}))
+
+		image, err := sut.FindByRelativePath(imageFile)
+		a.Nil(err)
+		a.Equal([]string{"image1.cr2", "image1.dng"}, image.CompanionFiles())
+	})
+
+	t.Run("Sidecar files are updated", func(t *testing.T) {
+		imageFile.SetSidecarFiles([]string{"image1.xmp"})
+		a.Nil(sut.AddImages([]*apitype.ImageFile{imageFile}))
+
+		image, err := sut.FindByRelativePath(imageFile)
+		a.Nil(err)
+		a.Equal([]string{"image1.cr2", "image1.dng"}, image.CompanionFiles())
+		a.Equal([]string{"image1.xmp"}, image.SidecarFiles())
+	})
+
+	t.Run("Companion and sidecar files are removed", func(t *testing.T) {
+		imageFile.SetCompanionFiles(nil)
+		imageFile.SetSidecarFiles(nil)
+		a.Nil(sut.AddImages([]*apitype.ImageFile{imageFile}))
+
+		image, err := sut.FindByRelativePath(imageFile)
+		a.Nil(err)
+		a.Nil(image.CompanionFiles())
+		a.Nil(image.SidecarFiles())
+	})
+}
+
 func TestImageStore_Exists(t *testing.T) {
 	a := require.New(t)
 
@@ -951,3 +1037,437 @@ func TestImageStore_GetAllImagesModifiedAfter(t *testing.T) {
 	})
 
 }
+
+func TestImageStore_GetImagesInCategory_SubCategories(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initImageStoreTest()
+	image0, _ := sut.AddImage(apitype.NewImageFile("images", "image0"))
+	image1, _ := sut.AddImage(apitype.NewImageFile("images", "image1"))
+	image2, _ := sut.AddImage(apitype.NewImageFile("images", "image2"))
+	_, _ = sut.AddImage(apitype.NewImageFile("images", "image3"))
+
+	kyoto, _ := isCategoryStore.AddCategory(apitype.NewCategory("Travel/Japan/Kyoto", "Kyoto", "K"))
+	japan := kyoto.Parent()
+	travel := japan.Parent()
+	work, _ := isCategoryStore.AddCategory(apitype.NewCategory("Work", "Work", "W"))
+
+	_ = isImageCategoryStore.CategorizeImage(image0.Id(), kyoto.Id(), apitype.CATEGORIZE)
+	_ = isImageCategoryStore.CategorizeImage(image1.Id(), japan.Id(), apitype.CATEGORIZE)
+	// Image in both the parent and the sub category is listed once
+	_ = isImageCategoryStore.CategorizeImage(image1.Id(), kyoto.Id(), apitype.CATEGORIZE)
+	_ = isImageCategoryStore.CategorizeImage(image2.Id(), work.Id(), apitype.CATEGORIZE)
+
+	t.Run("Parent includes the sub categories", func(t *testing.T) {
+		images, err := sut.GetImagesInCategory(-1, 0, travel.Id())
+		a.Nil(err)
+		if a.Equal(2, len(images)) {
+			a.Equal("image0", images[0].FileName())
+			a.Equal("image1", images[1].FileName())
+		}
+		a.Equal(2, sut.GetImageCount(travel.Id()))
+	})
+
+	t.Run("Sub category doesn't include the parent", func(t *testing.T) {
+		images, err := sut.GetImagesInCategory(-1, 0, kyoto.Id())
+		a.Nil(err)
+		a.Equal(2, len(images))
+
+		a.Equal(1, sut.GetImageCount(work.Id()))
+	})
+}
+
+func TestImageStore_GetImagesInCategory_Rating(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initImageStoreTest()
+	image0, _ := sut.AddImage(apitype.NewImageFile("images", "image0"))
+	image1, _ := sut.AddImage(apitype.NewImageFile("images", "image1"))
+	image2, _ := sut.AddImage(apitype.NewImageFile("images", "image2"))
+	_, _ = sut.AddImage(apitype.NewImageFile("images", "image3"))
+
+	category, _ := isCategoryStore.AddCategory(apitype.NewCategory("Cat", "Cat", "C"))
+
+	_ = isImageRatingStore.SetRating(image0.Id(), 5)
+	_ = isImageRatingStore.SetColorLabel(image0.Id(), apitype.ColorRed)
+	_ = isImageRatingStore.SetRating(image1.Id(), 3)
+	_ = isImageRatingStore.SetColorLabel(image2.Id(), apitype.ColorRed)
+	_ = isImageCategoryStore.CategorizeImage(image1.Id(), category.Id(), apitype.CATEGORIZE)
+	_ = isImageCategoryStore.CategorizeImage(image2.Id(), category.Id(), apitype.CATEGORIZE)
+
+	t.Run("Minimum rating", func(t *testing.T) {
+		filter := &apitype.ImageFilter{CategoryId: apitype.NoCategory, MinRating: 3}
+		images, err := sut.GetFilteredImages(-1, 0, filter)
+		a.Nil(err)
+		if a.Equal(2, len(images)) {
+			a.Equal("image0", images[0].FileName())
+			a.Equal("image1", images[1].FileName())
+		}
+		a.Equal(2, sut.GetFilteredImageCount(filter))
+
+		filter.MinRating = 4
+		a.Equal(1, sut.GetFilteredImageCount(filter))
+	})
+
+	t.Run("Color label", func(t *testing.T) {
+		filter := &apitype.ImageFilter{CategoryId: apitype.NoCategory, ColorLabel: apitype.ColorRed}
+		images, err := sut.GetFilteredImages(-1, 0, filter)
+		a.Nil(err)
+		if a.Equal(2, len(images)) {
+			a.Equal("image0", images[0].FileName())
+			a.Equal("image2", images[1].FileName())
+		}
+	})
+
+	t.Run("All conditions must match", func(t *testing.T) {
+		filter := &apitype.ImageFilter{CategoryId: category.Id(), MinRating: 1}
+		images, err := sut.GetFilteredImages(-1, 0, filter)
+		a.Nil(err)
+		if a.Equal(1, len(images)) {
+			a.Equal("image1", images[0].FileName())
+		}
+
+		filter = &apitype.ImageFilter{CategoryId: category.Id(), ColorLabel: apitype.ColorRed}
+		a.Equal(1, sut.GetFilteredImageCount(filter))
+
+		filter = &apitype.ImageFilter{CategoryId: apitype.NoCategory, MinRating: 5, ColorLabel: apitype.ColorBlue}
+		a.Equal(0, sut.GetFilteredImageCount(filter))
+	})
+}
+
+func TestImageStore_GetImagesInCategory_Tag(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initImageStoreTest()
+	image0, _ := sut.AddImage(apitype.NewImageFile("images", "image0"))
+	image1, _ := sut.AddImage(apitype.NewImageFile("images", "image1"))
+	image2, _ := sut.AddImage(apitype.NewImageFile("images", "image2"))
+
+	_ = isTagStore.AddTag([]apitype.ImageId{image0.Id(), image2.Id()}, "Beach")
+	_ = isTagStore.AddTag([]apitype.ImageId{image1.Id()}, "sunset")
+	_ = isImageRatingStore.SetRating(image2.Id(), 4)
+
+	filter := &apitype.ImageFilter{CategoryId: apitype.NoCategory, Tag: "beach"}
+	images, err := sut.GetFilteredImages(-1, 0, filter)
+	a.Nil(err)
+	if a.Equal(2, len(images)) {
+		a.Equal("image0", images[0].FileName())
+		a.Equal("image2", images[1].FileName())
+	}
+
+	filter.MinRating = 3
+	a.Equal(1, sut.GetFilteredImageCount(filter))
+}
+
+func TestImageStore_GetImagesInCategory_Query(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initImageStoreTest()
+	metaDataStore := NewImageMetaDataStore(sut.database)
+	imageStoreImageFileConverter.SetNamedStubs(true)
+	imageStoreImageFileConverter.AddStubFile("IMG_0.jpg", time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC))
+	imageStoreImageFileConverter.AddStubFile("IMG_1.jpg", time.Date(2023, 7, 31, 23, 59, 0, 0, time.UTC))
+	imageStoreImageFileConverter.AddStubFile("image_2.jpg", time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC))
+	imageStoreImageFileConverter.AddStubFile("image_3.jpg", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	image0, _ := sut.AddImage(apitype.NewImageFile("images", "IMG_0.jpg"))
+	image1, _ := sut.AddImage(apitype.NewImageFile("images", "IMG_1.jpg"))
+	image2, _ := sut.AddImage(apitype.NewImageFile("images", "image_2.jpg"))
+	_, _ = sut.AddImage(apitype.NewImageFile("images", "image_3.jpg"))
+
+	kyoto, _ := isCategoryStore.AddCategory(apitype.NewCategory("Travel/Japan/Kyoto", "Kyoto", "K"))
+	bad, _ := isCategoryStore.AddCategory(apitype.NewCategory("Bad", "Bad", "B"))
+	_ = isImageCategoryStore.CategorizeImage(image0.Id(), kyoto.Id(), apitype.CATEGORIZE)
+	_ = isImageCategoryStore.CategorizeImage(image1.Id(), kyoto.Id(), apitype.CATEGORIZE)
+	_ = isImageCategoryStore.CategorizeImage(image1.Id(), bad.Id(), apitype.CATEGORIZE)
+	_ = isImageCategoryStore.CategorizeImage(image2.Id(), bad.Id(), apitype.CATEGORIZE)
+
+	_ = metaDataStore.AddMetaData(image0.Id(), apitype.NewImageMetaData(map[string]string{"Model": "Canon EOS R5", "ISOSpeedRatings": "1600"}))
+	_ = metaDataStore.AddMetaData(image2.Id(), apitype.NewImageMetaData(map[string]string{"Model": "XZ-1", "ISOSpeedRatings": "100"}))
+	_ = isTagStore.AddTag([]apitype.ImageId{image2.Id()}, "beach_2023")
+	_ = isImageRatingStore.SetRating(image1.Id(), 4)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`category:travel`, []string{"IMG_0.jpg", "IMG_1.jpg"}},
+		{`category:"Travel/Japan" AND NOT category:Bad`, []string{"IMG_0.jpg"}},
+		{`category:Japan`, nil},
+		{`uncategorized`, []string{"image_3.jpg"}},
+		{`NOT uncategorized AND NOT category:Travel`, []string{"image_2.jpg"}},
+		{`exif.model:"canon eos r5"`, []string{"IMG_0.jpg"}},
+		{`exif.Model:Canon*`, []string{"IMG_0.jpg"}},
+		{`exif.ISOSpeedRatings>=800 OR rating>3`, []string{"IMG_0.jpg", "IMG_1.jpg"}},
+		{`rating<1`, []string{"image_2.jpg", "image_3.jpg", "IMG_0.jpg"}},
+		{`date:2023-06..2023-07`, []string{"IMG_0.jpg", "IMG_1.jpg"}},
+		{`date>2023-07`, []string{"image_2.jpg"}},
+		{`date<2023`, []string{"image_3.jpg"}},
+		{`name:IMG_*`, []string{"IMG_0.jpg", "IMG_1.jpg"}},
+		{`name:image?2.jpg`, nil},
+		{`tag:beach_2023 width>1000 height<=2048 size>1KB`, []string{"image_2.jpg"}},
+		{`tag:beach?2023`, nil},
+		{`width>3000 OR (category:Bad tag:*)`, []string{"image_2.jpg"}},
+	}
+
+	for _, tt := range tests {
+		query, err := apitype.ParseSearchQuery(tt.query)
+		require.Nil(t, err, tt.query)
+
+		filter := &apitype.ImageFilter{CategoryId: apitype.NoCategory, Query: query}
+		images, err := sut.GetFilteredImages(-1, 0, filter)
+		a.Nil(err, tt.query)
+		var names []string
+		for _, image := range images {
+			names = append(names, image.FileName())
+		}
+		a.Equal(tt.expected, names, tt.query)
+		a.Equal(len(tt.expected), sut.GetFilteredImageCount(filter), tt.query)
+	}
+}
+
+func TestImageStore_GetImagesInCategory_SortOrder(t *testing.T) {
+	a := assert.New(t)
+
+	sut := initImageStoreTest()
+	imageStoreImageFileConverter.SetNamedStubs(true)
+	imageStoreImageFileConverter.AddStubFile("b.jpg", time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC))
+	imageStoreImageFileConverter.AddStubFile("IMG_10.jpg", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	imageStoreImageFileConverter.AddStubFile("IMG_2.jpg", time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC))
+	imageStoreImageFileConverter.AddStubFile("img_1.jpg", time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	b, _ := sut.AddImage(apitype.NewImageFile("images", "b.jpg"))
+	img10, _ := sut.AddImage(apitype.NewImageFile("images", "IMG_10.jpg"))
+	img2, _ := sut.AddImage(apitype.NewImageFile("images", "IMG_2.jpg"))
+	img1, _ := sut.AddImage(apitype.NewImageFile("images", "img_1.jpg"))
+
+	setImage := func(image *apitype.ImageFile, byteSize int, width int, height int, modified time.Time) {
+		_, err := sut.database.Session().SQL().
+			Update("image").
+			Set("byte_size", byteSize, "width", width, "height", height, "modified_timestamp", modified).
+			Where("id", image.Id()).
+			Exec()
+		require.Nil(t, err)
+	}
+	setImage(b, 300, 10, 10, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	setImage(img10, 100, 20, 20, time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC))
+	setImage(img2, 200, 5, 40, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	setImage(img1, 200, 40, 5, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	similarityIndex := NewSimilarityIndex(sut.database)
+	require.Nil(t, similarityIndex.DoInTransaction(func(session db.Session) error {
+		_ = similarityIndex.StartRecreateSimilarImageIndex(session)
+		_ = similarityIndex.AddSimilarImage(b.Id(), img2.Id(), 0, 1)
+		_ = similarityIndex.AddSimilarImage(img2.Id(), b.Id(), 0, 1)
+		_ = similarityIndex.AddSimilarImage(img10.Id(), img1.Id(), 0, 1)
+		_ = similarityIndex.AddSimilarImage(img1.Id(), img10.Id(), 0, 1)
+		return similarityIndex.EndRecreateSimilarImageIndex()
+	}))
+
+	getNames := func(images []*apitype.ImageFile) []string {
+		var names []string
+		for _, image := range images {
+			names = append(names, image.FileName())
+		}
+		return names
+	}
+
+	tests := []struct {
+		order    string
+		expected []string
+	}{
+		{"", []string{"b.jpg", "img_1.jpg", "IMG_2.jpg", "IMG_10.jpg"}},
+		{"name desc", []string{"IMG_10.jpg", "IMG_2.jpg", "img_1.jpg", "b.jpg"}},
+		{"date", []string{"IMG_10.jpg", "img_1.jpg", "b.jpg", "IMG_2.jpg"}},
+		{"date desc", []string{"IMG_2.jpg", "b.jpg", "img_1.jpg", "IMG_10.jpg"}},
+		{"modified", []string{"b.jpg", "IMG_2.jpg", "img_1.jpg", "IMG_10.jpg"}},
+		{"size", []string{"IMG_10.jpg", "img_1.jpg", "IMG_2.jpg", "b.jpg"}},
+		{"size desc", []string{"b.jpg", "IMG_2.jpg", "img_1.jpg", "IMG_10.jpg"}},
+		{"dimensions", []string{"b.jpg", "IMG_2.jpg", "img_1.jpg", "IMG_10.jpg"}},
+		{"similarity", []string{"b.jpg", "IMG_2.jpg", "img_1.jpg", "IMG_10.jpg"}},
+		{"similarity desc", []string{"IMG_10.jpg", "img_1.jpg", "IMG_2.jpg", "b.jpg"}},
+		{"random:42", nil},
+		{"random:-7 desc", nil},
+	}
+
+	for _, tt := range tests {
+		order, err := apitype.ParseSortOrder(tt.order)
+		require.Nil(t, err, tt.order)
+		filter := &apitype.ImageFilter{CategoryId: apitype.NoCategory, Order: order}
+
+		images, err := sut.GetFilteredImages(-1, 0, filter)
+		a.Nil(err, tt.order)
+		names := getNames(images)
+		if tt.expected != nil {
+			a.Equal(tt.expected, names, tt.order)
+		} else {
+			a.ElementsMatch([]string{"b.jpg", "img_1.jpg", "IMG_2.jpg", "IMG_10.jpg"}, names, tt.order)
+			again, _ := sut.GetFilteredImages(-1, 0, filter)
+			a.Equal(names, getNames(again), tt.order)
+		}
+
+		for i := range names {
+			next, err := sut.GetNextFilteredImages(2, i-1, filter)
+			a.Nil(err, tt.order)
+			end := i + 2
+			if end > len(names) {
+				end = len(names)
+			}
+			a.Equal(names[i:end], getNames(next), tt.order)
+
+			previous, err := sut.GetPreviousFilteredImages(2, i, filter)
+			a.Nil(err, tt.order)
+			var expectedPrevious []string
+			for j := i - 1; j >= 0 && j >= i-2; j-- {
+				expectedPrevious = append(expectedPrevious, names[j])
+			}
+			a.Equal(expectedPrevious, getNames(previous), tt.order)
+		}
+	}
+}
+
+func TestImageStore_GetImageFileStates(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+	modifiedTime := time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+	imageStoreImageFileConverter.currentTime = modifiedTime
+	imageFile := apitype.NewImageFile("images", filepath.Join("sub1", "image2.jpg"))
+	imageFile.SetSidecarFiles([]string{"image2.jpg.xmp"})
+	a.Nil(sut.AddImages([]*apitype.ImageFile{
+		apitype.NewImageFile("images", "image1.jpg"),
+		imageFile,
+		apitype.NewImageFile("images", filepath.Join("sub1", "sub2", "image3.jpg")),
+	}))
+
+	t.Run("Directories", func(t *testing.T) {
+		directories, err := sut.GetImageDirectories()
+
+		a.Nil(err)
+		a.ElementsMatch([]string{"", "sub1", filepath.Join("sub1", "sub2")}, directories)
+	})
+
+	t.Run("Images directly in the directory", func(t *testing.T) {
+		states, err := sut.GetImageFileStates("sub1")
+
+		a.Nil(err)
+		a.Equal(1, len(states))
+		a.Equal(filepath.Join("sub1", "image2.jpg"), states[0].RelativePath)
+		a.Equal(int64(1234), states[0].ByteSize)
+		a.True(modifiedTime.Equal(states[0].ModifiedTime))
+		a.Equal("image2.jpg.xmp", states[0].SidecarFiles)
+		a.True(states[0].HasSameAssociatedFiles(imageFile))
+		a.False(states[0].HasSameAssociatedFiles(apitype.NewImageFile("images", filepath.Join("sub1", "image2.jpg"))))
+	})
+
+	t.Run("Empty directory", func(t *testing.T) {
+		states, err := sut.GetImageFileStates("sub3")
+
+		a.Nil(err)
+		a.Empty(states)
+	})
+}
+
+func TestImageStore_RenameImage(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+	image, err := sut.AddImage(apitype.NewImageFile("images", "image1.jpg"))
+	a.Nil(err)
+
+	renamedImageFile := apitype.NewImageFile("images", filepath.Join("sub", "IMG_10.jpg"))
+	renamedImageFile.SetCompanionFiles([]string{"IMG_10.CR2"})
+	a.Nil(sut.RenameImage(image.Id(), renamedImageFile, "fingerprint"))
+
+	renamedImage := sut.GetImageById(image.Id())
+	a.Equal(filepath.Join("sub", "IMG_10.jpg"), renamedImage.RelativePath())
+	a.Equal("IMG_10.jpg", renamedImage.FileName())
+	a.Equal([]string{"IMG_10.CR2"}, renamedImage.CompanionFiles())
+	states, err := sut.GetImageFileStates("sub")
+	a.Nil(err)
+	a.Equal(1, len(states))
+	a.Equal(image.Id(), states[0].Id)
+	a.Equal("fingerprint", states[0].Fingerprint)
+	states, err = sut.GetImageFileStates("")
+	a.Nil(err)
+	a.Empty(states)
+}
+
+func TestImageStore_UpdateImages(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+	image, err := sut.AddImage(apitype.NewImageFile("images", "image1.jpg"))
+	a.Nil(err)
+
+	modifiedTime := time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+	imageStoreImageFileConverter.currentTime = modifiedTime
+	updated, err := sut.UpdateImages(map[apitype.ImageId]*apitype.ImageFile{
+		image.Id(): apitype.NewImageFile("images", "image1.jpg"),
+	})
+
+	a.Nil(err)
+	a.Equal(1, len(updated))
+	a.Equal(image.Id(), updated[0].Id())
+	states, err := sut.GetImageFileStates("")
+	a.Nil(err)
+	a.True(modifiedTime.Equal(states[0].ModifiedTime))
+}
+
+func TestImageStore_RemoveImages(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+	image1, _ := sut.AddImage(apitype.NewImageFile("images", "image1"))
+	_, _ = sut.AddImage(apitype.NewImageFile("images", "image2"))
+	image3, _ := sut.AddImage(apitype.NewImageFile("images", "image3"))
+
+	a.Nil(sut.RemoveImages([]apitype.ImageId{image1.Id(), image3.Id()}))
+	a.Nil(sut.RemoveImages(nil))
+
+	images, err := sut.GetAllImages()
+	a.Nil(err)
+	a.Equal(1, len(images))
+	a.Equal("image2", images[0].FileName())
+}
+
+func TestUpdateImageDirectories(t *testing.T) {
+	a := require.New(t)
+
+	sut := initImageStoreTest()
+	a.Nil(sut.AddImages([]*apitype.ImageFile{
+		apitype.NewImageFile("images", "image1"),
+		apitype.NewImageFile("images", filepath.Join("sub1", "sub2", "image2")),
+	}))
+	session := sut.getCollection().Session()
+	_, err := session.SQL().Exec("UPDATE image SET directory = NULL")
+	a.Nil(err)
+
+	a.Nil(updateImageDirectories(session))
+
+	directories, err := sut.GetImageDirectories()
+	a.Nil(err)
+	a.ElementsMatch([]string{"", filepath.Join("sub1", "sub2")}, directories)
+}
+
+func TestImageFileState_IsModified(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	a.Nil(os.WriteFile(filepath.Join(directory, "image1.jpg"), []byte("image"), 0644))
+	imageFiles, err := apitype.LoadImageFilesInSubDirectory(directory, "", nil)
+	a.Nil(err)
+	imageFile := imageFiles[0]
+
+	a.False((&ImageFileState{ByteSize: 5, ModifiedTime: imageFile.ModifiedTime()}).IsModified(imageFile))
+	a.True((&ImageFileState{ByteSize: 6, ModifiedTime: imageFile.ModifiedTime()}).IsModified(imageFile))
+	a.True((&ImageFileState{ByteSize: 5, ModifiedTime: imageFile.ModifiedTime().Add(time.Second)}).IsModified(imageFile))
+}
+
+func TestNaturalSortKey(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("img_00000000000000000002.jpg", naturalSortKey("IMG_2.jpg"))
+	a.Equal("img_00000000000000000002.jpg", naturalSortKey("img_0002.jpg"))
+	a.Less(naturalSortKey("IMG_2.jpg"), naturalSortKey("IMG_10.jpg"))
+	a.Less(naturalSortKey("2023/IMG_9.jpg"), naturalSortKey("2023/IMG_10.jpg"))
+	a.Less(naturalSortKey("a1b2"), naturalSortKey("a1b10"))
+}
@@ -1,9 +1,13 @@
 package database
 
+import "github.com/upper/db/v4"
+
 type migration struct {
 	id          MigrationId
 	description string
 	query       string
+	// Optional data migration that is run after the query
+	update func(session db.Session) error
 }
 
 var migrations = []migration{
@@ -100,4 +104,248 @@ var migrations = []migration{
 			INSERT INTO status (key, timestamp) VALUES('image_index_updated', '1970-01-01 00:00:00');
 		`,
 	},
+	{
+		id:          4,
+		description: "Image Relative Path",
+		query: `
+			CREATE TABLE image_new (
+			    id INTEGER PRIMARY KEY,
+			    name TEXT,
+			    file_name TEXT,
+			    relative_path TEXT,
+			    directory TEXT,
+			    byte_size INT,
+			    exif_orientation INT,
+			    image_angle INT,
+			    image_flip INT,
+			    width INT,
+			    height INT,
+			    created_timestamp DATETIME,
+			    modified_timestamp DATETIME,
+
+			    UNIQUE (relative_path)
+			);
+
+			INSERT INTO image_new (
+				id, name, file_name, relative_path, directory, byte_size, exif_orientation,
+				image_angle, image_flip, width, height, created_timestamp, modified_timestamp
+			)
+			SELECT
+				id, name, file_name, file_name, directory, byte_size, exif_orientation,
+				image_angle, image_flip, width, height, created_timestamp, modified_timestamp
+			FROM image;
+
+			DROP TABLE image;
+			ALTER TABLE image_new RENAME TO image;
+
+			CREATE INDEX image_created_timestamp_idx ON image (created_timestamp);
+			CREATE INDEX image_byte_size_idx ON image (byte_size);
+			CREATE INDEX image_modified_timestamp_idx ON image (modified_timestamp);
+			CREATE INDEX image_name_idx ON image (name);
+		`,
+	},
+	{
+		id:          5,
+		description: "Image Companion Files",
+		query: `
+			ALTER TABLE image ADD COLUMN companion_files TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		id:          6,
+		description: "Image Sidecar Files",
+		query: `
+			ALTER TABLE image ADD COLUMN sidecar_files TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		id:          7,
+		description: "Image Category Journal",
+		query: `
+			CREATE TABLE image_category_journal (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    image_id INTEGER NOT NULL,
+			    category_id INTEGER NOT NULL,
+			    operation INT NOT NULL,
+			    force_category INT NOT NULL,
+			    before_categories TEXT NOT NULL,
+			    after_categories TEXT NOT NULL,
+			    undone INT NOT NULL DEFAULT 0,
+			    created_timestamp DATETIME
+			);
+		`,
+	},
+	{
+		id:          8,
+		description: "Apply Jobs",
+		query: `
+			CREATE TABLE apply_job (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    status TEXT NOT NULL,
+			    options TEXT NOT NULL,
+			    created_timestamp DATETIME,
+			    finished_timestamp DATETIME
+			);
+
+			CREATE TABLE apply_job_image (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    job_id INTEGER NOT NULL,
+			    image_id INTEGER NOT NULL,
+			    status TEXT NOT NULL,
+
+			    UNIQUE (job_id, image_id)
+			);
+
+			CREATE TABLE apply_job_operation (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    job_id INTEGER NOT NULL,
+			    image_id INTEGER NOT NULL,
+			    operation TEXT NOT NULL,
+			    path TEXT NOT NULL,
+			    holding_path TEXT NOT NULL,
+			    status TEXT NOT NULL
+			);
+
+			CREATE INDEX apply_job_operation_job_id_idx ON apply_job_operation (job_id, status);
+		`,
+	},
+	{
+		id:          9,
+		description: "Apply Job Move Source",
+		query: `
+			ALTER TABLE apply_job_operation ADD COLUMN source_path TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		id:          10,
+		description: "Category Links",
+		query: `
+			CREATE TABLE category_link (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    image_id INTEGER NOT NULL,
+			    category_id INTEGER NOT NULL,
+			    path TEXT NOT NULL
+			);
+
+			CREATE INDEX category_link_image_id_idx ON category_link (image_id);
+		`,
+	},
+	{
+		id:          11,
+		description: "Nested Categories",
+		query: `
+			-- Names are unique only under the same parent, e.g. "Travel/Japan" and "Work/Japan"
+			CREATE TABLE category_nested (
+			    id INTEGER PRIMARY KEY,
+			    parent_id INTEGER NOT NULL DEFAULT -1,
+			    name TEXT,
+			    sub_path TEXT,
+			    shortcut INTEGER,
+
+			    UNIQUE (parent_id, name)
+			);
+
+			INSERT INTO category_nested (id, name, sub_path, shortcut)
+			    SELECT id, name, sub_path, shortcut FROM category;
+			DROP TABLE category;
+			ALTER TABLE category_nested RENAME TO category;
+
+			CREATE INDEX category_parent_id_idx ON category (parent_id);
+		`,
+	},
+	{
+		id:          12,
+		description: "Category Shortcut Sequences",
+		query: `
+			-- Shortcuts are key sequences such as "Ctrl+G 3" instead of a single key
+			CREATE TABLE category_shortcut (
+			    id INTEGER PRIMARY KEY,
+			    parent_id INTEGER NOT NULL DEFAULT -1,
+			    name TEXT,
+			    sub_path TEXT,
+			    shortcut TEXT NOT NULL DEFAULT '',
+
+			    UNIQUE (parent_id, name)
+			);
+
+			INSERT INTO category_shortcut (id, parent_id, name, sub_path, shortcut)
+			    SELECT id, parent_id, name, sub_path, COALESCE(CAST(shortcut AS TEXT), '') FROM category;
+			DROP TABLE category;
+			ALTER TABLE category_shortcut RENAME TO category;
+
+			CREATE INDEX category_parent_id_idx ON category (parent_id);
+		`,
+	},
+	{
+		id:          13,
+		description: "Image Ratings",
+		query: `
+			CREATE TABLE image_rating (
+			    image_id INTEGER PRIMARY KEY,
+			    rating INTEGER NOT NULL DEFAULT 0,
+			    color_label TEXT NOT NULL DEFAULT ''
+			);
+
+			CREATE INDEX image_rating_rating_idx ON image_rating (rating);
+			CREATE INDEX image_rating_color_label_idx ON image_rating (color_label);
+		`,
+	},
+	{
+		id:          14,
+		description: "Tags",
+		query: `
+			CREATE TABLE tag (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    name TEXT NOT NULL UNIQUE COLLATE NOCASE
+			);
+
+			CREATE TABLE image_tag (
+			    image_id INTEGER NOT NULL,
+			    tag_id INTEGER NOT NULL,
+			    PRIMARY KEY (image_id, tag_id)
+			);
+
+			CREATE INDEX image_tag_tag_id_idx ON image_tag (tag_id);
+		`,
+	},
+	{
+		id:          15,
+		description: "Saved searches",
+		query: `
+			CREATE TABLE saved_search (
+			    id INTEGER PRIMARY KEY AUTOINCREMENT,
+			    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			    query TEXT NOT NULL,
+			    shortcut TEXT NOT NULL DEFAULT ''
+			);
+		`,
+	},
+	{
+		id:          16,
+		description: "Sort orders",
+		query: `
+			ALTER TABLE image ADD COLUMN sort_name TEXT NOT NULL DEFAULT '';
+			CREATE INDEX image_sort_name_idx ON image (sort_name, name);
+
+			ALTER TABLE status ADD COLUMN value TEXT NOT NULL DEFAULT '';
+			INSERT INTO status (key, timestamp, value) VALUES('sort_order', '1970-01-01 00:00:00', '');
+		`,
+		update: updateImageSortNames,
+	},
+	{
+		id:          17,
+		description: "Image directories and fingerprints",
+		query: `
+			ALTER TABLE image ADD COLUMN fingerprint TEXT NOT NULL DEFAULT '';
+			CREATE INDEX image_directory_idx ON image (directory);
+		`,
+		update: updateImageDirectories,
+	},
+	{
+		id:          18,
+		description: "Category rename templates",
+		query: `
+			ALTER TABLE category ADD COLUMN rename_template TEXT NOT NULL DEFAULT '';
+		`,
+	},
 }
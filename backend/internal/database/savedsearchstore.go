@@ -0,0 +1,62 @@
+package database
+
+import (
+	"github.com/upper/db/v4"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+type SavedSearchStore struct {
+	database   *Database
+	collection db.Collection
+}
+
+func NewSavedSearchStore(database *Database) *SavedSearchStore {
+	return &SavedSearchStore{
+		database: database,
+	}
+}
+
+func (s *SavedSearchStore) getCollection() db.Collection {
+	if s.collection == nil {
+		s.collection = s.database.Session().Collection("saved_search")
+	}
+	return s.collection
+}
+
+// All the saved searches sorted by name
+func (s *SavedSearchStore) GetSavedSearches() ([]*apitype.SavedSearch, error) {
+	var savedSearches []SavedSearch
+	if err := s.getCollection().Find().OrderBy("name").All(&savedSearches); err != nil {
+		return nil, err
+	} else {
+		return toApiSavedSearches(savedSearches), nil
+	}
+}
+
+// Saves the search. Existing search with the same name is replaced.
+func (s *SavedSearchStore) SaveSearch(savedSearch *apitype.SavedSearch) (*apitype.SavedSearch, error) {
+	var id apitype.SavedSearchId
+	err := s.getCollection().Session().Tx(func(session db.Session) error {
+		if _, err := session.SQL().Exec(`
+			INSERT INTO saved_search (name, query, shortcut) VALUES (?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET name = excluded.name, query = excluded.query, shortcut = excluded.shortcut
+		`, savedSearch.Name(), savedSearch.Query().String(), savedSearch.ShortcutAsString()); err != nil {
+			return err
+		}
+
+		var saved SavedSearch
+		if err := session.Collection("saved_search").Find(db.Cond{"name": savedSearch.Name()}).One(&saved); err != nil {
+			return err
+		}
+		id = saved.Id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apitype.NewSavedSearch(id, savedSearch.Name(), savedSearch.Query(), savedSearch.ShortcutAsString()), nil
+}
+
+func (s *SavedSearchStore) RemoveSavedSearch(id apitype.SavedSearchId) error {
+	return s.getCollection().Find(db.Cond{"id": id}).Delete()
+}
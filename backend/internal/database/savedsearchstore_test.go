@@ -0,0 +1,67 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func newTestSavedSearch(t *testing.T, name string, query string, shortcut string) *apitype.SavedSearch {
+	parsed, err := apitype.ParseSearchQuery(query)
+	require.Nil(t, err)
+	return apitype.NewSavedSearch(apitype.NoSavedSearch, name, parsed, shortcut)
+}
+
+func TestSavedSearchStore_SaveSearch(t *testing.T) {
+	a := require.New(t)
+
+	sut := NewSavedSearchStore(NewInMemoryDatabase(""))
+
+	t.Run("No saved searches", func(t *testing.T) {
+		savedSearches, err := sut.GetSavedSearches()
+		a.Nil(err)
+		a.Empty(savedSearches)
+	})
+
+	var sharp *apitype.SavedSearch
+	t.Run("Save searches", func(t *testing.T) {
+		var err error
+		sharp, err = sut.SaveSearch(newTestSavedSearch(t, "sharp", "tag:sharp AND uncategorized", "Alt+S"))
+		a.Nil(err)
+		a.NotEqual(apitype.NoSavedSearch, sharp.Id())
+		_, err = sut.SaveSearch(newTestSavedSearch(t, "Last import", `date:2023-06..`, ""))
+		a.Nil(err)
+
+		savedSearches, err := sut.GetSavedSearches()
+		a.Nil(err)
+		a.Equal(2, len(savedSearches))
+		a.Equal("Last import", savedSearches[0].Name())
+		a.Equal("date:2023-06..", savedSearches[0].Query().String())
+		a.Equal("", savedSearches[0].ShortcutAsString())
+		a.Equal("sharp", savedSearches[1].Name())
+		a.Equal("tag:sharp AND uncategorized", savedSearches[1].Query().String())
+		a.Equal("Alt+S", savedSearches[1].ShortcutAsString())
+	})
+
+	t.Run("Same name replaces the search", func(t *testing.T) {
+		saved, err := sut.SaveSearch(newTestSavedSearch(t, "Sharp", "tag:sharp", ""))
+		a.Nil(err)
+		a.Equal(sharp.Id(), saved.Id())
+
+		savedSearches, err := sut.GetSavedSearches()
+		a.Nil(err)
+		a.Equal(2, len(savedSearches))
+		a.Equal("Sharp", savedSearches[1].Name())
+		a.Equal("tag:sharp", savedSearches[1].Query().String())
+		a.Equal("", savedSearches[1].ShortcutAsString())
+	})
+
+	t.Run("Remove search", func(t *testing.T) {
+		a.Nil(sut.RemoveSavedSearch(sharp.Id()))
+
+		savedSearches, err := sut.GetSavedSearches()
+		a.Nil(err)
+		a.Equal(1, len(savedSearches))
+		a.Equal("Last import", savedSearches[0].Name())
+	})
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+// Same format the timestamps are stored in so that they can be compared as text
+const searchTimestampFormat = "2006-01-02 15:04:05"
+
+var searchLikeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, apitype.SearchWildcard, `%`)
+
+// Converts the search query to an SQL condition over the image table
+func searchCondition(node apitype.SearchNode) (string, []interface{}, error) {
+	switch n := node.(type) {
+	case *apitype.SearchAnd:
+		return joinSearchConditions(n.Nodes, " AND ")
+	case *apitype.SearchOr:
+		return joinSearchConditions(n.Nodes, " OR ")
+	case *apitype.SearchNot:
+		if condition, args, err := searchCondition(n.Node); err != nil {
+			return "", nil, err
+		} else {
+			return "NOT " + condition, args, nil
+		}
+	case *apitype.SearchTerm:
+		return searchTermCondition(n)
+	default:
+		return "", nil, fmt.Errorf("unknown search node %T", node)
+	}
+}
+
+// Private API
+
+func joinSearchConditions(nodes []apitype.SearchNode, operator string) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	for _, node := range nodes {
+		condition, nodeArgs, err := searchCondition(node)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, nodeArgs...)
+	}
+	return "(" + strings.Join(conditions, operator) + ")", args, nil
+}
+
+func searchTermCondition(term *apitype.SearchTerm) (string, []interface{}, error) {
+	operator := sqlOperator(term.Operator)
+	switch term.Field {
+	case apitype.SearchFieldCategory:
+		// Category by its full name, including its sub categories
+		return `image.id IN (
+			SELECT image_category.image_id FROM image_category WHERE image_category.category_id IN (
+				WITH RECURSIVE category_path(id, path) AS (
+					SELECT category.id, category.name FROM category WHERE category.parent_id = ?
+					UNION ALL
+					SELECT category.id, category_path.path || '/' || category.name
+					FROM category JOIN category_path ON category.parent_id = category_path.id
+				), category_tree(id) AS (
+					SELECT category_path.id FROM category_path WHERE category_path.path LIKE ? ESCAPE '\'
+					UNION
+					SELECT category.id FROM category JOIN category_tree ON category.parent_id = category_tree.id
+				)
+				SELECT id FROM category_tree
+			)
+		)`, []interface{}{apitype.NoCategory, likePattern(term.Text)}, nil
+	case apitype.SearchFieldUncategorized:
+		return `image.id NOT IN (SELECT image_category.image_id FROM image_category)`, nil, nil
+	case apitype.SearchFieldTag:
+		return `image.id IN (
+			SELECT image_tag.image_id FROM image_tag JOIN tag ON tag.id = image_tag.tag_id
+			WHERE tag.name LIKE ? ESCAPE '\'
+		)`, []interface{}{likePattern(term.Text)}, nil
+	case apitype.SearchFieldLabel:
+		return `image.id IN (
+			SELECT image_rating.image_id FROM image_rating WHERE image_rating.color_label = ?
+		)`, []interface{}{term.Text}, nil
+	case apitype.SearchFieldRating:
+		// Images without a rating have zero stars
+		return `COALESCE((
+			SELECT image_rating.rating FROM image_rating WHERE image_rating.image_id = image.id
+		), 0) ` + operator + ` ?`, []interface{}{term.Number}, nil
+	case apitype.SearchFieldName:
+		return `image.file_name LIKE ? ESCAPE '\'`, []interface{}{likePattern(term.Text)}, nil
+	case apitype.SearchFieldWidth:
+		return `image.width ` + operator + ` ?`, []interface{}{term.Number}, nil
+	case apitype.SearchFieldHeight:
+		return `image.height ` + operator + ` ?`, []interface{}{term.Number}, nil
+	case apitype.SearchFieldSize:
+		return `image.byte_size ` + operator + ` ?`, []interface{}{term.Number}, nil
+	case apitype.SearchFieldDate:
+		condition, args := dateRangeCondition(term.From, term.To)
+		return condition, args, nil
+	case apitype.SearchFieldExif:
+		if term.Operator == apitype.SearchMatch {
+			return `image.id IN (
+				SELECT image_meta_data.image_id FROM image_meta_data
+				WHERE image_meta_data.key = ? COLLATE NOCASE AND image_meta_data.value LIKE ? ESCAPE '\'
+			)`, []interface{}{term.ExifField, likePattern(term.Text)}, nil
+		}
+		return `image.id IN (
+			SELECT image_meta_data.image_id FROM image_meta_data
+			WHERE image_meta_data.key = ? COLLATE NOCASE AND CAST(image_meta_data.value AS REAL) ` + operator + ` ?
+		)`, []interface{}{term.ExifField, term.Number}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown search field '%s'", term.Field)
+	}
+}
+
+func dateRangeCondition(from time.Time, to time.Time) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if !from.IsZero() {
+		conditions = append(conditions, `image.created_timestamp >= ?`)
+		args = append(args, from.Format(searchTimestampFormat))
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, `image.created_timestamp < ?`)
+		args = append(args, to.Format(searchTimestampFormat))
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", args
+}
+
+func sqlOperator(operator apitype.SearchOperator) string {
+	if operator == apitype.SearchMatch {
+		return "="
+	}
+	return string(operator)
+}
+
+// LIKE is case insensitive and the wildcards are the only special characters
+func likePattern(text string) string {
+	return searchLikeEscaper.Replace(text)
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func TestSearchCondition_Invalid(t *testing.T) {
+	t.Run("Unknown field", func(t *testing.T) {
+		a := require.New(t)
+
+		_, _, err := searchCondition(&apitype.SearchAnd{Nodes: []apitype.SearchNode{
+			&apitype.SearchTerm{Field: apitype.SearchFieldTag, Operator: apitype.SearchMatch, Text: "beach"},
+			&apitype.SearchNot{Node: &apitype.SearchTerm{Field: "unknown"}},
+		}})
+		a.NotNil(err)
+	})
+
+	t.Run("Unknown node", func(t *testing.T) {
+		a := require.New(t)
+
+		_, _, err := searchCondition(nil)
+		a.NotNil(err)
+	})
+}
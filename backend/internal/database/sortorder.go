@@ -0,0 +1,109 @@
+package database
+
+import (
+	"fmt"
+	"github.com/upper/db/v4"
+	"strings"
+	"unicode"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+// Digit runs are padded to this length so that they compare as numbers
+const naturalSortNumberLength = 20
+
+// Key that sorts the names naturally when compared as text, e.g. "IMG_2" before "IMG_10"
+func naturalSortKey(name string) string {
+	var key strings.Builder
+	runes := []rune(strings.ToLower(name))
+	for i := 0; i < len(runes); {
+		if !unicode.IsDigit(runes[i]) {
+			key.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(runes) && unicode.IsDigit(runes[i]) {
+			i++
+		}
+		number := strings.TrimLeft(string(runes[start:i]), "0")
+		if len(number) < naturalSortNumberLength {
+			key.WriteString(strings.Repeat("0", naturalSortNumberLength-len(number)))
+		}
+		key.WriteString(number)
+	}
+	return key.String()
+}
+
+// Sort names for the images that were added before the sort_name column existed
+func updateImageSortNames(session db.Session) error {
+	var images []Image
+	if err := session.SQL().Select("id", "name").From("image").All(&images); err != nil {
+		return err
+	}
+	for _, image := range images {
+		if _, err := session.SQL().
+			Update("image").
+			Set("sort_name", naturalSortKey(image.Name)).
+			Where(db.Cond{"id": image.Id}).
+			Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Order of the images. The name is always the last sort key so that every order is
+// total and the images can be paged with limit and offset.
+func orderBy(order apitype.SortOrder) *db.RawExpr {
+	dir := asc
+	if order.Descending {
+		dir = desc
+	}
+
+	var keys []string
+	var args []interface{}
+	switch order.SortField() {
+	case apitype.SortByDate:
+		keys = []string{"image.created_timestamp"}
+	case apitype.SortBySize:
+		keys = []string{"image.byte_size"}
+	case apitype.SortByModified:
+		keys = []string{"image.modified_timestamp"}
+	case apitype.SortByDimensions:
+		keys = []string{"image.width * image.height", "image.width"}
+	case apitype.SortBySimilarity:
+		// Similar images share the smallest image ID of the group
+		keys = []string{`MIN(image.id, COALESCE(
+			(SELECT MIN(image_similar.similar_image_id) FROM image_similar WHERE image_similar.image_id = image.id),
+			image.id
+		))`}
+	case apitype.SortByRandom:
+		key, seedArgs := randomSortKey(order.Seed)
+		keys = []string{key}
+		args = seedArgs
+	}
+	keys = append(keys, "image.sort_name", "image.name")
+
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("%s %s", key, dir)
+	}
+	return db.Raw(strings.Join(keys, ", "), args...)
+}
+
+// Hashes the image ID with the seed so that the same seed always gives the same order.
+// SQLite doesn't have XOR, so it is calculated as (a | b) - (a & b). The values are kept
+// small enough so that the multiplications don't overflow.
+func randomSortKey(seed int64) (string, []interface{}) {
+	xor := func(a string, b string) string {
+		return fmt.Sprintf("((%[1]s) | (%[2]s)) - ((%[1]s) & (%[2]s))", a, b)
+	}
+	mixed := fmt.Sprintf("((%s) * 2654435761) %% 4294967296", xor("image.id", "?"))
+	mixed = fmt.Sprintf("((%s) * 1597334677) %% 4294967296", xor(mixed, fmt.Sprintf("(%s) >> 15", mixed)))
+
+	args := make([]interface{}, strings.Count(mixed, "?"))
+	for i := range args {
+		args[i] = seed & 0x7fffffff
+	}
+	return mixed, args
+}
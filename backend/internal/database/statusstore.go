@@ -11,6 +11,7 @@ type StatusKey string
 const (
 	SimilarityIndexUpdated StatusKey = "similarity_index_updated"
 	ImageIndexUpdated      StatusKey = "image_index_updated"
+	SortOrder              StatusKey = "sort_order"
 )
 
 type StatusStore struct {
@@ -47,3 +48,21 @@ func (s *StatusStore) UpdateTimestamp(key StatusKey, timestamp time.Time) error
 		Timestamp: timestamp,
 	})
 }
+
+// Value of the status. Timestamp tells when the value was last changed.
+func (s *StatusStore) GetValue(key StatusKey) (string, error) {
+	if status, err := s.GetStatus(key); err != nil {
+		return "", err
+	} else {
+		return status.Value, nil
+	}
+}
+
+func (s *StatusStore) SetValue(key StatusKey, value string) error {
+	logger.Debug.Printf("Setting %s to '%s'", key, value)
+	return s.getCollection().Find(db.Cond{"key": key}).Update(&Status{
+		Key:       key,
+		Timestamp: time.Now(),
+		Value:     value,
+	})
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"github.com/upper/db/v4"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+type TagStore struct {
+	database   *Database
+	collection db.Collection
+}
+
+func NewTagStore(database *Database) *TagStore {
+	return &TagStore{
+		database: database,
+	}
+}
+
+func (s *TagStore) getCollection() db.Collection {
+	if s.collection == nil {
+		s.collection = s.database.Session().Collection("tag")
+	}
+	return s.collection
+}
+
+// All the tags in use sorted by name
+func (s *TagStore) GetTags() ([]string, error) {
+	var tags []Tag
+	if err := s.getCollection().Find().OrderBy("name").All(&tags); err != nil {
+		return nil, err
+	} else {
+		return toTagNames(tags), nil
+	}
+}
+
+func (s *TagStore) GetImageTags(imageId apitype.ImageId) ([]string, error) {
+	var tags []Tag
+	err := s.getCollection().Session().SQL().
+		Select("tag.id", "tag.name").
+		From("tag").
+		Join("image_tag").On("image_tag.tag_id = tag.id").
+		Where("image_tag.image_id", imageId).
+		OrderBy("tag.name").
+		All(&tags)
+	if err != nil {
+		return nil, err
+	} else {
+		return toTagNames(tags), nil
+	}
+}
+
+// Tags the images. The tag is created if it doesn't exist yet.
+func (s *TagStore) AddTag(imageIds []apitype.ImageId, name string) error {
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		if _, err := session.SQL().Exec(`
+			INSERT INTO tag (name) VALUES (?) ON CONFLICT(name) DO NOTHING
+		`, name); err != nil {
+			return err
+		}
+		tagId, err := s.findTagId(session, name)
+		if err != nil {
+			return err
+		}
+		for _, imageId := range imageIds {
+			if _, err := session.SQL().Exec(`
+				INSERT OR IGNORE INTO image_tag (image_id, tag_id) VALUES (?, ?)
+			`, imageId, tagId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Removes the tag from the images. The tag is removed when no image has it anymore.
+func (s *TagStore) RemoveTag(imageIds []apitype.ImageId, name string) error {
+	return s.getCollection().Session().Tx(func(session db.Session) error {
+		tagId, err := s.findTagId(session, name)
+		if err == db.ErrNoMoreRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, imageId := range imageIds {
+			if _, err := session.SQL().
+				DeleteFrom("image_tag").
+				Where("image_id", imageId).
+				And("tag_id", tagId).
+				Exec(); err != nil {
+				return err
+			}
+		}
+		_, err = session.SQL().Exec(`
+			DELETE FROM tag WHERE id = ? AND NOT EXISTS (SELECT 1 FROM image_tag WHERE tag_id = ?)
+		`, tagId, tagId)
+		return err
+	})
+}
+
+// Private API
+
+func (s *TagStore) findTagId(session db.Session, name string) (int64, error) {
+	var tag Tag
+	if err := session.Collection("tag").Find(db.Cond{"name": name}).One(&tag); err != nil {
+		return 0, err
+	} else {
+		return tag.Id, nil
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func TestTagStore_AddTag(t *testing.T) {
+	a := require.New(t)
+
+	sut := NewTagStore(NewInMemoryDatabase(""))
+
+	t.Run("No tags", func(t *testing.T) {
+		tags, err := sut.GetImageTags(1)
+		a.Nil(err)
+		a.Empty(tags)
+	})
+
+	t.Run("Tag images", func(t *testing.T) {
+		a.Nil(sut.AddTag([]apitype.ImageId{1, 2}, "sunset"))
+		a.Nil(sut.AddTag([]apitype.ImageId{1}, "Beach"))
+		// Same tag in different case
+		a.Nil(sut.AddTag([]apitype.ImageId{2}, "beach"))
+		a.Nil(sut.AddTag([]apitype.ImageId{1}, "sunset"))
+
+		tags, err := sut.GetImageTags(1)
+		a.Nil(err)
+		a.Equal([]string{"Beach", "sunset"}, tags)
+
+		tags, err = sut.GetImageTags(2)
+		a.Nil(err)
+		a.Equal([]string{"Beach", "sunset"}, tags)
+
+		tags, err = sut.GetTags()
+		a.Nil(err)
+		a.Equal([]string{"Beach", "sunset"}, tags)
+	})
+
+	t.Run("Remove tag", func(t *testing.T) {
+		a.Nil(sut.RemoveTag([]apitype.ImageId{1}, "SUNSET"))
+		a.Nil(sut.RemoveTag([]apitype.ImageId{1}, "unknown"))
+
+		tags, err := sut.GetImageTags(1)
+		a.Nil(err)
+		a.Equal([]string{"Beach"}, tags)
+
+		tags, err = sut.GetTags()
+		a.Nil(err)
+		a.Equal([]string{"Beach", "sunset"}, tags)
+	})
+
+	t.Run("Unused tag is removed", func(t *testing.T) {
+		a.Nil(sut.RemoveTag([]apitype.ImageId{2}, "sunset"))
+
+		tags, err := sut.GetTags()
+		a.Nil(err)
+		a.Equal([]string{"Beach"}, tags)
+	})
+}
@@ -14,7 +14,12 @@ type Migration struct {
 type Image struct {
 	Id              apitype.ImageId `db:"id,omitempty"`
 	Name            string          `db:"name"`
+	SortName        string          `db:"sort_name"`
 	FileName        string          `db:"file_name"`
+	RelativePath    string          `db:"relative_path"`
+	Directory       string          `db:"directory"`
+	CompanionFiles  string          `db:"companion_files"`
+	SidecarFiles    string          `db:"sidecar_files"`
 	ByteSize        int64           `db:"byte_size"`
 	ExifOrientation uint8           `db:"exif_orientation"`
 	ImageAngle      int             `db:"image_angle"`
@@ -23,6 +28,18 @@ type Image struct {
 	Width           uint32          `db:"width"`
 	Height          uint32          `db:"height"`
 	ModifiedTime    time.Time       `db:"modified_timestamp"`
+	Fingerprint     string          `db:"fingerprint"`
+}
+
+// Stored state of an image file that is compared with the file when the images are scanned
+type ImageFileState struct {
+	Id             apitype.ImageId `db:"id"`
+	RelativePath   string          `db:"relative_path"`
+	CompanionFiles string          `db:"companion_files"`
+	SidecarFiles   string          `db:"sidecar_files"`
+	ByteSize       int64           `db:"byte_size"`
+	ModifiedTime   time.Time       `db:"modified_timestamp"`
+	Fingerprint    string          `db:"fingerprint"`
 }
 
 type ImageMetaData struct {
@@ -32,10 +49,12 @@ type ImageMetaData struct {
 }
 
 type Category struct {
-	Id       apitype.CategoryId `db:"id,omitempty"`
-	Name     string             `db:"name"`
-	SubPath  string             `db:"sub_path"`
-	Shortcut string             `db:"shortcut"`
+	Id             apitype.CategoryId `db:"id,omitempty"`
+	ParentId       apitype.CategoryId `db:"parent_id"`
+	Name           string             `db:"name"`
+	SubPath        string             `db:"sub_path"`
+	Shortcut       string             `db:"shortcut"`
+	RenameTemplate string             `db:"rename_template"`
 }
 
 type ImageCategory struct {
@@ -44,6 +63,69 @@ type ImageCategory struct {
 	Operation  int64              `db:"operation"`
 }
 
+type ImageRating struct {
+	ImageId    apitype.ImageId `db:"image_id"`
+	Rating     int             `db:"rating"`
+	ColorLabel string          `db:"color_label"`
+}
+
+type Tag struct {
+	Id   int64  `db:"id,omitempty"`
+	Name string `db:"name"`
+}
+
+type SavedSearch struct {
+	Id       apitype.SavedSearchId `db:"id,omitempty"`
+	Name     string                `db:"name"`
+	Query    string                `db:"query"`
+	Shortcut string                `db:"shortcut"`
+}
+
+type CategoryLink struct {
+	Id         int64              `db:"id,omitempty"`
+	ImageId    apitype.ImageId    `db:"image_id"`
+	CategoryId apitype.CategoryId `db:"category_id"`
+	Path       string             `db:"path"`
+}
+
+type ImageCategoryJournalEntry struct {
+	Id               int64              `db:"id,omitempty"`
+	ImageId          apitype.ImageId    `db:"image_id"`
+	CategoryId       apitype.CategoryId `db:"category_id"`
+	Operation        int64              `db:"operation"`
+	ForceCategory    bool               `db:"force_category"`
+	BeforeCategories string             `db:"before_categories"`
+	AfterCategories  string             `db:"after_categories"`
+	Undone           bool               `db:"undone"`
+	CreatedTime      time.Time          `db:"created_timestamp"`
+}
+
+type ApplyJob struct {
+	Id           int64     `db:"id,omitempty"`
+	Status       string    `db:"status"`
+	Options      string    `db:"options"`
+	CreatedTime  time.Time `db:"created_timestamp"`
+	FinishedTime time.Time `db:"finished_timestamp"`
+}
+
+type ApplyJobImage struct {
+	Id      int64           `db:"id,omitempty"`
+	JobId   int64           `db:"job_id"`
+	ImageId apitype.ImageId `db:"image_id"`
+	Status  string          `db:"status"`
+}
+
+type ApplyJobOperation struct {
+	Id          int64           `db:"id,omitempty"`
+	JobId       int64           `db:"job_id"`
+	ImageId     apitype.ImageId `db:"image_id"`
+	Operation   string          `db:"operation"`
+	Path        string          `db:"path"`
+	SourcePath  string          `db:"source_path"`
+	HoldingPath string          `db:"holding_path"`
+	Status      string          `db:"status"`
+}
+
 type CategorizedImage struct {
 	ImageId    apitype.ImageId    `db:"image_id"`
 	CategoryId apitype.CategoryId `db:"category_id"`
@@ -63,6 +145,7 @@ type ImageSimilar struct {
 type Status struct {
 	Key       StatusKey `db:"key"`
 	Timestamp time.Time `db:"timestamp"`
+	Value     string    `db:"value"`
 }
 
 type Count struct {
@@ -1,10 +1,17 @@
 package database
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
+	fileUtil "vincit.fi/image-sorter/backend/internal/util"
+	"vincit.fi/image-sorter/common/imagereader"
 	"vincit.fi/image-sorter/common/logger"
 	"vincit.fi/image-sorter/common/util"
 )
@@ -14,9 +21,38 @@ func idToCategoryId(id any) apitype.CategoryId {
 }
 
 func toImageFile(image *Image, basePath string) (*apitype.ImageFile, error) {
-	return apitype.NewImageFileWithIdSizeAndOrientation(
-		image.Id, basePath, image.FileName, image.ByteSize, float64(image.ImageAngle), image.ImageFlip, int(image.Width), int(image.Height),
-	), nil
+	imageFile := apitype.NewImageFileWithIdSizeAndOrientation(
+		image.Id, basePath, filepath.FromSlash(image.RelativePath), image.ByteSize, float64(image.ImageAngle), image.ImageFlip, int(image.Width), int(image.Height),
+	)
+	imageFile.SetCompanionFiles(fromDbFileNames(image.CompanionFiles))
+	imageFile.SetSidecarFiles(fromDbFileNames(image.SidecarFiles))
+	return imageFile, nil
+}
+
+// Relative paths are always stored with forward slashes so that
+// the database can be shared between operating systems
+func toDbRelativePath(imageFile *apitype.ImageFile) string {
+	return filepath.ToSlash(imageFile.RelativePath())
+}
+
+// Directory of the image relative to the base path with forward slashes. Images
+// directly in the base path have an empty directory.
+func toDbDirectory(subDirectory string) string {
+	return filepath.ToSlash(subDirectory)
+}
+
+// File names can't contain '/' on any platform, so it is safe to use as a separator
+const fileNameSeparator = "/"
+
+func toDbFileNames(fileNames []string) string {
+	return strings.Join(fileNames, fileNameSeparator)
+}
+
+func fromDbFileNames(fileNames string) []string {
+	if fileNames == "" {
+		return nil
+	}
+	return strings.Split(fileNames, fileNameSeparator)
 }
 
 func toImageFiles(images []Image, basePath string) []*apitype.ImageFile {
@@ -27,37 +63,190 @@ func toImageFiles(images []Image, basePath string) []*apitype.ImageFile {
 	return imageFiles
 }
 
-func toApiCategorizedImages(categories []CategorizedImage) []*api.CategorizedImage {
+func toApiCategorizedImages(categories []CategorizedImage, categoriesById map[apitype.CategoryId]*apitype.Category) []*api.CategorizedImage {
 	apiTypeCategories := make([]*api.CategorizedImage, len(categories))
 	for i, category := range categories {
-		apiTypeCategories[i] = toApiCategorizedImage(&category)
+		apiTypeCategories[i] = toApiCategorizedImage(&category, categoriesById)
 	}
 	return apiTypeCategories
 }
 
-func toApiCategorizedImage(category *CategorizedImage) *api.CategorizedImage {
+func toApiCategorizedImage(category *CategorizedImage, categoriesById map[apitype.CategoryId]*apitype.Category) *api.CategorizedImage {
+	apiCategory, ok := categoriesById[category.CategoryId]
+	if !ok {
+		apiCategory = apitype.NewCategoryWithId(category.CategoryId, category.Name, category.SubPath, category.Shortcut)
+	}
 	return &api.CategorizedImage{
-		Category: apitype.NewCategoryWithId(
-			category.CategoryId, category.Name, category.SubPath, category.Shortcut),
+		Category:  apiCategory,
 		Operation: apitype.OperationFromId(category.Operation),
 	}
 }
 
+// Category state is stored as "<category ID>:<operation>" pairs ordered by the category ID
+func toDbImageCategoryState(state api.ImageCategoryState) string {
+	categoryIds := make([]int, 0, len(state))
+	for categoryId := range state {
+		categoryIds = append(categoryIds, int(categoryId))
+	}
+	sort.Ints(categoryIds)
+
+	entries := make([]string, len(categoryIds))
+	for i, categoryId := range categoryIds {
+		entries[i] = fmt.Sprintf("%d:%d", categoryId, state[apitype.CategoryId(categoryId)])
+	}
+	return strings.Join(entries, ",")
+}
+
+func fromDbImageCategoryState(value string) (api.ImageCategoryState, error) {
+	state := api.ImageCategoryState{}
+	if value == "" {
+		return state, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		var categoryId int64
+		var operation int64
+		if _, err := fmt.Sscanf(entry, "%d:%d", &categoryId, &operation); err != nil {
+			return nil, fmt.Errorf("invalid category state '%s': %w", value, err)
+		}
+		state[apitype.CategoryId(categoryId)] = apitype.OperationFromId(operation)
+	}
+	return state, nil
+}
+
+func toApiCategorizationAction(entry *ImageCategoryJournalEntry) (*api.CategorizationAction, error) {
+	if before, err := fromDbImageCategoryState(entry.BeforeCategories); err != nil {
+		return nil, err
+	} else if after, err := fromDbImageCategoryState(entry.AfterCategories); err != nil {
+		return nil, err
+	} else {
+		return &api.CategorizationAction{
+			Id:              entry.Id,
+			ImageId:         entry.ImageId,
+			CategoryId:      entry.CategoryId,
+			Operation:       apitype.OperationFromId(entry.Operation),
+			ForceToCategory: entry.ForceCategory,
+			Before:          before,
+			After:           after,
+		}, nil
+	}
+}
+
+func toApiApplyJob(job *ApplyJob, doneImages int, failedImages int) (*api.ApplyJob, error) {
+	options := &api.PersistCategorizationCommand{}
+	if err := json.Unmarshal([]byte(job.Options), options); err != nil {
+		return nil, fmt.Errorf("invalid apply job options '%s': %w", job.Options, err)
+	}
+	return &api.ApplyJob{
+		Id:           job.Id,
+		Status:       api.ApplyJobStatus(job.Status),
+		Options:      options,
+		CreatedTime:  job.CreatedTime,
+		FinishedTime: job.FinishedTime,
+		DoneImages:   doneImages,
+		FailedImages: failedImages,
+	}, nil
+}
+
+func toApiApplyJobOperations(operations []ApplyJobOperation) []*api.ApplyJobOperation {
+	apiOperations := make([]*api.ApplyJobOperation, len(operations))
+	for i, operation := range operations {
+		apiOperations[i] = &api.ApplyJobOperation{
+			Id:          operation.Id,
+			JobId:       operation.JobId,
+			ImageId:     operation.ImageId,
+			Operation:   api.FileOperation(operation.Operation),
+			Path:        operation.Path,
+			SourcePath:  operation.SourcePath,
+			HoldingPath: operation.HoldingPath,
+			Status:      api.FileOperationStatus(operation.Status),
+		}
+	}
+	return apiOperations
+}
+
+func toApiCategoryLinks(links []CategoryLink) []*api.CategoryLink {
+	apiLinks := make([]*api.CategoryLink, len(links))
+	for i, link := range links {
+		apiLinks[i] = &api.CategoryLink{
+			ImageId:    link.ImageId,
+			CategoryId: link.CategoryId,
+			Path:       link.Path,
+		}
+	}
+	return apiLinks
+}
+
+func toApiImageRating(rating *ImageRating) *apitype.ImageRating {
+	return &apitype.ImageRating{
+		Rating:     apitype.Rating(rating.Rating),
+		ColorLabel: apitype.ColorLabel(rating.ColorLabel),
+	}
+}
+
+// Links the categories to their parents. Categories are ordered so that
+// the sub categories follow their parent.
 func toApiCategories(categories []Category) []*apitype.Category {
-	apiTypeCategories := make([]*apitype.Category, len(categories))
-	for i, category := range categories {
-		apiTypeCategories[i] = toApiCategory(category)
+	categoriesById := toApiCategoriesById(categories)
+	apiTypeCategories := make([]*apitype.Category, 0, len(categoriesById))
+	for _, category := range categoriesById {
+		apiTypeCategories = append(apiTypeCategories, category)
 	}
+	sort.Slice(apiTypeCategories, func(i, j int) bool {
+		return isCategoryBefore(apiTypeCategories[i], apiTypeCategories[j])
+	})
 	return apiTypeCategories
 }
 
-func toApiCategory(category Category) *apitype.Category {
-	return apitype.NewCategoryWithId(category.Id, category.Name, category.SubPath, category.Shortcut)
+// Categories whose parent doesn't exist are handled as top level categories
+func toApiCategoriesById(categories []Category) map[apitype.CategoryId]*apitype.Category {
+	categoriesById := map[apitype.CategoryId]Category{}
+	for _, category := range categories {
+		categoriesById[category.Id] = category
+	}
+
+	apiCategoriesById := map[apitype.CategoryId]*apitype.Category{}
+	var toApi func(category Category, depth int) *apitype.Category
+	toApi = func(category Category, depth int) *apitype.Category {
+		if apiCategory, ok := apiCategoriesById[category.Id]; ok {
+			return apiCategory
+		}
+		var parent *apitype.Category
+		// Depth is limited in case the parents refer to each other
+		if parentCategory, ok := categoriesById[category.ParentId]; ok && depth < len(categories) {
+			parent = toApi(parentCategory, depth+1)
+		}
+		apiCategory := toApiCategory(category, parent)
+		apiCategoriesById[category.Id] = apiCategory
+		return apiCategory
+	}
+	for _, category := range categories {
+		toApi(category, 0)
+	}
+	return apiCategoriesById
+}
+
+func toApiCategory(category Category, parent *apitype.Category) *apitype.Category {
+	apiCategory := apitype.NewCategoryWithParent(category.Id, parent, category.Name, category.SubPath, category.Shortcut)
+	apiCategory.SetRenameTemplate(category.RenameTemplate)
+	return apiCategory
+}
+
+// Compares the names level by level so that the sub categories are right after the parent
+func isCategoryBefore(a *apitype.Category, b *apitype.Category) bool {
+	aNames := apitype.SplitCategoryName(a.FullName())
+	bNames := apitype.SplitCategoryName(b.FullName())
+	for i := 0; i < len(aNames) && i < len(bNames); i++ {
+		if aNames[i] != bNames[i] {
+			return aNames[i] < bNames[i]
+		}
+	}
+	return len(aNames) < len(bNames)
 }
 
 type ImageFileConverter interface {
 	ImageFileToDbImage(*apitype.ImageFile) (*Image, map[string]string, error)
 	GetImageFileStats(*apitype.ImageFile) (os.FileInfo, error)
+	GetImageFileFingerprint(*apitype.ImageFile) (string, error)
 }
 
 type FileSystemImageFileConverter struct {
@@ -68,12 +257,22 @@ func (s *FileSystemImageFileConverter) GetImageFileStats(imageFile *apitype.Imag
 	return os.Stat(imageFile.Path())
 }
 
+func (s *FileSystemImageFileConverter) GetImageFileFingerprint(imageFile *apitype.ImageFile) (string, error) {
+	return fileUtil.FileFingerprint(imageFile.Path())
+}
+
 func (s *FileSystemImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile) (*Image, map[string]string, error) {
 	exifLoadStart := time.Now()
 	exifData, err := util.LoadExifData(imageFile)
 	if err != nil {
-		logger.Warn.Printf("Exif data not properly loaded for '%d'", imageFile.Id())
-		return nil, nil, err
+		// Exif is optional for e.g. PNG images, but the size is still needed
+		logger.Debug.Printf("Exif data not found for '%s', resolving size from the image", imageFile.Path())
+		if config, err := imagereader.LoadImageConfig(imageFile.Path()); err != nil {
+			logger.Warn.Printf("Could not load image '%s'", imageFile.Path())
+			return nil, nil, err
+		} else {
+			exifData = apitype.NewExifDataWithSize(uint32(config.Width), uint32(config.Height))
+		}
 	}
 	exifLoadEnd := time.Now()
 	logger.Trace.Printf(" - Loaded exif data in %s", exifLoadEnd.Sub(exifLoadStart))
@@ -86,6 +285,11 @@ func (s *FileSystemImageFileConverter) ImageFileToDbImage(imageFile *apitype.Ima
 	fileStatEnd := time.Now()
 	logger.Trace.Printf(" - Loaded file info in %s", fileStatEnd.Sub(fileStatStart))
 
+	fingerprint, err := s.GetImageFileFingerprint(imageFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	width := exifData.ImageWidth()
 	height := exifData.ImageHeight()
 	rotation := int(exifData.Rotation())
@@ -96,8 +300,11 @@ func (s *FileSystemImageFileConverter) ImageFileToDbImage(imageFile *apitype.Ima
 	}
 
 	return &Image{
-		Name:            imageFile.FileName(),
+		Name:            toDbRelativePath(imageFile),
 		FileName:        imageFile.FileName(),
+		RelativePath:    toDbRelativePath(imageFile),
+		CompanionFiles:  toDbFileNames(imageFile.CompanionFiles()),
+		SidecarFiles:    toDbFileNames(imageFile.SidecarFiles()),
 		ByteSize:        fileStat.Size(),
 		ExifOrientation: exifData.ExifOrientation(),
 		ImageAngle:      rotation,
@@ -105,5 +312,27 @@ func (s *FileSystemImageFileConverter) ImageFileToDbImage(imageFile *apitype.Ima
 		Width:           width,
 		Height:          height,
 		ModifiedTime:    fileStat.ModTime(),
+		Fingerprint:     fingerprint,
 	}, exifData.Values(), nil
 }
+
+func toTagNames(tags []Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
+
+// Saved searches whose query can't be parsed any more are skipped
+func toApiSavedSearches(savedSearches []SavedSearch) []*apitype.SavedSearch {
+	result := make([]*apitype.SavedSearch, 0, len(savedSearches))
+	for _, savedSearch := range savedSearches {
+		if query, err := apitype.ParseSearchQuery(savedSearch.Query); err != nil || query == nil {
+			logger.Warn.Printf("Skipping saved search '%s' with invalid query '%s'", savedSearch.Name, savedSearch.Query)
+		} else {
+			result = append(result, apitype.NewSavedSearch(savedSearch.Id, savedSearch.Name, query, savedSearch.Shortcut))
+		}
+	}
+	return result
+}
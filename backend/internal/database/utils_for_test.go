@@ -22,6 +22,9 @@ func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile
 		Id:              0,
 		Name:            imageFile.FileName(),
 		FileName:        imageFile.FileName(),
+		RelativePath:    imageFile.RelativePath(),
+		CompanionFiles:  toDbFileNames(imageFile.CompanionFiles()),
+		SidecarFiles:    toDbFileNames(imageFile.SidecarFiles()),
 		ByteSize:        1234,
 		ExifOrientation: 1,
 		ImageAngle:      90,
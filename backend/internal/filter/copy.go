@@ -1,38 +1,56 @@
 package filter
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"image"
-	"image/jpeg"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"unsafe"
+	"strings"
 	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/backend/internal/util"
 	"vincit.fi/image-sorter/common/logger"
 )
 
+// Length of the content hash added to the file name with ConflictRenameWithHash
+const fileNameHashLength = 8
+
 type fileOperation struct {
-	dstPath string
-	dstFile string
+	dstPath        string
+	dstFile        string
+	conflictPolicy apitype.ConflictPolicy
 }
 
 type ImageCopy struct {
 	fileOperation
-	quality int
+	quality    int
+	copyMethod apitype.CopyMethod
+	// Embedded as IPTC Keywords to JPEG images
+	keywords []string
+	// Embedded as Exif Rating to JPEG images
+	rating *apitype.ImageRating
 
 	apitype.ImageOperation
 }
 
-func NewImageCopy(targetDir string, targetFile string, quality int) apitype.ImageOperation {
+// Content written to the target. Either the original file as is or the re-encoded image.
+type imageContent struct {
+	srcFilePath string
+	data        []byte
+}
+
+func NewImageCopy(targetDir string, targetFile string, quality int, conflictPolicy apitype.ConflictPolicy, copyMethod apitype.CopyMethod, keywords []string, rating *apitype.ImageRating) apitype.ImageOperation {
 	return &ImageCopy{
-		quality: quality,
+		quality:    quality,
+		copyMethod: copyMethod,
+		keywords:   keywords,
+		rating:     rating,
 		fileOperation: fileOperation{
-			dstPath: targetDir,
-			dstFile: targetFile,
+			dstPath:        targetDir,
+			dstFile:        targetFile,
+			conflictPolicy: conflictPolicy,
 		},
 	}
 }
@@ -40,73 +58,254 @@ func (s *ImageCopy) Apply(operationGroup *apitype.ImageOperationGroup) (image.Im
 	imageFile := operationGroup.ImageFile()
 	logger.Debug.Printf("Copy %s", imageFile.Path())
 
-	if operationGroup.Modified() {
-		logger.Debug.Printf("Image %s has been modifier. Re-encoding the image...", imageFile.Path())
-		imageData := operationGroup.ImageData()
-		exifData := operationGroup.ExifData()
+	if operationGroup.Modified() && imageFile.Format() == apitype.RAW {
+		logger.Warn.Printf("RAW images can't be modified, copying '%s' as is", imageFile.Path())
+	}
+
+	content, err := s.imageContent(operationGroup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dstFile, err := s.resolveTargetFileName(operationGroup, content, "copied")
+	if err != nil || dstFile == "" {
+		return nil, nil, err
+	}
 
-		encodingOptions := &jpeg.Options{
-			Quality: s.quality,
+	journal := fileJournal(operationGroup)
+	if err := prepareTarget(journal, filepath.Join(s.dstPath, dstFile)); err != nil {
+		return nil, nil, err
+	} else if err := content.writeTo(imageFile.Directory(), s.dstPath, dstFile, s.copyMethod); err != nil {
+		return nil, nil, err
+	}
+	operationGroup.AddTarget(filepath.Join(s.dstPath, dstFile))
+	for _, fileName := range imageFile.AssociatedFiles() {
+		logger.Debug.Printf("Copy associated file '%s'", fileName)
+		associatedFile := associatedFileName(imageFile, fileName, dstFile)
+		if err := prepareTarget(journal, filepath.Join(s.dstPath, associatedFile)); err != nil {
+			return nil, nil, err
+		} else if err := copyFile(s.copyMethod, imageFile.Directory(), fileName, s.dstPath, associatedFile); err != nil {
+			return nil, nil, err
 		}
+	}
+	return nil, nil, nil
+}
+
+// Resolves the paths the image and its associated files would be copied to.
+// Modified images may be saved in a different format than the original.
+func (s *fileOperation) TargetPaths(imageFile *apitype.ImageFile, modified bool) (string, []string) {
+	dstFile := s.targetFileName(imageFile, modified)
+	var associatedPaths []string
+	for _, fileName := range imageFile.AssociatedFiles() {
+		associatedPaths = append(associatedPaths, filepath.Join(s.dstPath, associatedFileName(imageFile, fileName, dstFile)))
+	}
+	return filepath.Join(s.dstPath, dstFile), associatedPaths
+}
+
+func (s *fileOperation) targetFileName(imageFile *apitype.ImageFile, modified bool) string {
+	if _, ok := encoders[imageFile.Format()]; modified && !ok && imageFile.Format() != apitype.RAW {
+		return strings.TrimSuffix(s.dstFile, filepath.Ext(s.dstFile)) + fallbackFormat.FileEnding()
+	}
+	return s.dstFile
+}
 
-		jpegBuffer := bytes.NewBuffer([]byte{})
-		dstFilePath := filepath.Join(s.dstPath, s.dstFile)
-		if err := jpeg.Encode(jpegBuffer, imageData, encodingOptions); err != nil {
+// Associated files are named after the target file so that they still pair with
+// the image. Sidecars named after the full file name (IMG_1.JPG.xmp) keep the form.
+func associatedFileName(imageFile *apitype.ImageFile, fileName string, dstFile string) string {
+	if suffix := strings.TrimPrefix(fileName, imageFile.FileName()); suffix != fileName {
+		return dstFile + suffix
+	}
+	return strings.TrimSuffix(dstFile, filepath.Ext(dstFile)) + filepath.Ext(fileName)
+}
+
+func (s *ImageCopy) imageContent(operationGroup *apitype.ImageOperationGroup) (*imageContent, error) {
+	imageFile := operationGroup.ImageFile()
+	if operationGroup.Modified() && imageFile.Format() != apitype.RAW {
+		logger.Debug.Printf("Image %s has been modifier. Re-encoding the image...", imageFile.Path())
+		format := imageFile.Format()
+		encode, ok := encoders[format]
+		if !ok {
+			logger.Warn.Printf("Can't encode %s images, saving '%s' as %s", imageFile.Format(), imageFile.Path(), fallbackFormat)
+			format = fallbackFormat
+			encode = encoders[fallbackFormat]
+		}
+
+		imageBuffer := bytes.NewBuffer([]byte{})
+		if err := encode(imageBuffer, operationGroup.ImageData(), operationGroup.ExifData(), s.quality); err != nil {
 			logger.Error.Println("Could not encode image", err)
-			return imageData, exifData, err
-		} else if err := util.MakeDirectoriesIfNotExist(imageFile.Directory(), s.dstPath); err != nil {
-			return imageData, exifData, err
-		} else if destination, err := os.Create(dstFilePath); err != nil {
-			logger.Error.Println("Could not open file for writing", err)
-			return imageData, exifData, err
+			return nil, err
+		}
+		return &imageContent{data: s.embedMetadata(imageBuffer.Bytes(), format, imageFile)}, nil
+	} else if s.hasMetadata() && imageFile.Format() == apitype.JPEG {
+		// Metadata can't be added to a link, so the content is always copied
+		if data, err := ioutil.ReadFile(imageFile.Path()); err != nil {
+			return nil, err
 		} else {
-			defer destination.Close()
-			s.writeJpegWithExifData(destination, jpegBuffer, exifData)
-			return imageData, exifData, nil
+			return &imageContent{data: s.embedMetadata(data, apitype.JPEG, imageFile)}, nil
 		}
 	} else {
 		logger.Debug.Printf("Copy '%s' as is", imageFile.Path())
-		return nil, nil, util.CopyFile(imageFile.Directory(), imageFile.FileName(), s.dstPath, s.dstFile)
+		return &imageContent{srcFilePath: imageFile.Path()}, nil
 	}
 }
 
-func (s *ImageCopy) writeJpegWithExifData(destination *os.File, buffer *bytes.Buffer, exifData *apitype.ExifData) {
-	writer := bufio.NewWriter(destination)
-	// 0xFF 0xD8: Start of JPEG
-	writer.Write(buffer.Next(2))
+func (s *ImageCopy) hasMetadata() bool {
+	return len(s.keywords) > 0 || s.hasRating()
+}
 
-	s.writeJfifBlock(writer, buffer)
-	s.writeExifBlock(exifData, writer)
+func (s *ImageCopy) hasRating() bool {
+	return s.rating != nil && s.rating.Rating != apitype.NoRating
+}
 
-	// Write rest of file
-	writer.Write(buffer.Bytes())
-	writer.Flush()
+// Image is written without the keywords or the rating if they can't be added
+func (s *ImageCopy) embedMetadata(data []byte, format apitype.ImageFormat, imageFile *apitype.ImageFile) []byte {
+	if format != apitype.JPEG {
+		return data
+	}
+	if len(s.keywords) > 0 {
+		if withKeywords, err := util.SetJpegKeywords(data, s.keywords); err != nil {
+			logger.Warn.Printf("Could not add keywords to '%s': %s", imageFile.Path(), err)
+		} else {
+			data = withKeywords
+		}
+	}
+	if s.hasRating() {
+		if withRating, err := util.SetJpegExifRating(data, int(s.rating.Rating)); err != nil {
+			logger.Warn.Printf("Could not add rating to '%s': %s", imageFile.Path(), err)
+		} else {
+			data = withRating
+		}
+	}
+	return data
 }
 
-func (s *ImageCopy) writeExifBlock(data *apitype.ExifData, writer *bufio.Writer) {
-	const lengthBytes = 2
-	const exifHeader = "Exif\x00\x00"
-	headerLength := len(exifHeader)
-	// Length includes the length bytes, so we need to add them when writing
-	dataLength := data.RawExifDataLength() + uint16(headerLength) + lengthBytes
-	dataLengthBytes := (*[2]byte)(unsafe.Pointer(&dataLength))[:]
-	writer.Write([]byte{0xFF, 0xE1})
-	writer.WriteByte(dataLengthBytes[1])
-	writer.WriteByte(dataLengthBytes[0])
-	writer.WriteString(exifHeader)
-	writer.Write(data.RawExifData())
+// Resolves the file name the image is written to based on the conflict policy.
+// Returns an empty file name if the image should not be written at all.
+func (s *fileOperation) resolveTargetFileName(operationGroup *apitype.ImageOperationGroup, content *imageContent, action string) (string, error) {
+	dstFile := s.targetFileName(operationGroup.ImageFile(), content.data != nil)
+	dstFilePath := filepath.Join(s.dstPath, dstFile)
+	if !util.DoesFileExist(dstFilePath) {
+		operationGroup.AddResult(fmt.Sprintf("%s to '%s'", action, dstFilePath))
+		return dstFile, nil
+	}
+
+	switch s.conflictPolicy {
+	case apitype.ConflictSkip:
+		// The existing file may be a different image, so the original must be kept
+		operationGroup.SetKeepOriginal()
+		operationGroup.AddResult(fmt.Sprintf("skipped, '%s' already exists", dstFilePath))
+		return "", nil
+	case apitype.ConflictRenameWithNumber:
+		return s.renamed(operationGroup, util.NumberedFileName(s.dstPath, dstFile)), nil
+	case apitype.ConflictRenameWithHash:
+		if hash, err := content.hash(); err != nil {
+			return "", err
+		} else if hashedFile := util.FileNameWithSuffix(dstFile, hash[:fileNameHashLength]); util.DoesFileExist(filepath.Join(s.dstPath, hashedFile)) {
+			operationGroup.AddResult(fmt.Sprintf("skipped, identical '%s' already exists", filepath.Join(s.dstPath, hashedFile)))
+			return "", nil
+		} else {
+			return s.renamed(operationGroup, hashedFile), nil
+		}
+	case apitype.ConflictSkipIdentical:
+		if identical, err := content.isIdenticalTo(dstFilePath); err != nil {
+			return "", err
+		} else if identical {
+			operationGroup.AddResult(fmt.Sprintf("skipped, identical '%s' already exists", dstFilePath))
+			return "", nil
+		} else {
+			return s.renamed(operationGroup, util.NumberedFileName(s.dstPath, dstFile)), nil
+		}
+	default:
+		operationGroup.AddResult(fmt.Sprintf("overwrote '%s'", dstFilePath))
+		return dstFile, nil
+	}
 }
 
-func (s *ImageCopy) writeJfifBlock(writer *bufio.Writer, bw *bytes.Buffer) {
-	// 0xFF 0xE0 length (2 bytes): APP0 block of 0 length
-	const lengthBytes = 2
-	writer.Write(bw.Next(2))
-	e0LengthBytes := bw.Next(2)
-	// Length includes the length bytes, so we need to subtract when reading
-	e0Length := int(binary.BigEndian.Uint16(e0LengthBytes)) - lengthBytes
-	writer.Write(e0LengthBytes)
-	writer.Write(bw.Next(e0Length))
+func (s *fileOperation) renamed(operationGroup *apitype.ImageOperationGroup, dstFile string) string {
+	operationGroup.AddResult(fmt.Sprintf("renamed to '%s'", filepath.Join(s.dstPath, dstFile)))
+	return dstFile
 }
+
 func (s *ImageCopy) String() string {
 	return fmt.Sprintf("Copy file '%s' to '%s'", s.dstFile, s.dstPath)
 }
+
+func (s *imageContent) open() (io.ReadCloser, error) {
+	if s.data != nil {
+		return ioutil.NopCloser(bytes.NewReader(s.data)), nil
+	}
+	return os.Open(s.srcFilePath)
+}
+
+func (s *imageContent) hash() (string, error) {
+	if reader, err := s.open(); err != nil {
+		return "", err
+	} else {
+		defer reader.Close()
+		return util.ContentHash(reader)
+	}
+}
+
+func (s *imageContent) size() (int64, error) {
+	if s.data != nil {
+		return int64(len(s.data)), nil
+	} else if stat, err := os.Stat(s.srcFilePath); err != nil {
+		return 0, err
+	} else {
+		return stat.Size(), nil
+	}
+}
+
+func (s *imageContent) isIdenticalTo(path string) (bool, error) {
+	if stat, err := os.Stat(path); err != nil {
+		return false, err
+	} else if size, err := s.size(); err != nil {
+		return false, err
+	} else if size != stat.Size() {
+		return false, nil
+	} else if hash, err := s.hash(); err != nil {
+		return false, err
+	} else if otherHash, err := util.FileContentHash(path); err != nil {
+		return false, err
+	} else {
+		return hash == otherHash, nil
+	}
+}
+
+func (s *imageContent) writeTo(srcPath string, dstPath string, dstFile string, copyMethod apitype.CopyMethod) error {
+	if s.data == nil {
+		return copyFile(copyMethod, filepath.Dir(s.srcFilePath), filepath.Base(s.srcFilePath), dstPath, dstFile)
+	} else if err := util.MakeDirectoriesIfNotExist(srcPath, dstPath); err != nil {
+		return err
+	} else if destination, err := os.Create(filepath.Join(dstPath, dstFile)); err != nil {
+		logger.Error.Println("Could not open file for writing", err)
+		return err
+	} else {
+		defer destination.Close()
+		_, err := destination.Write(s.data)
+		return err
+	}
+}
+
+// Links the file if requested and possible, otherwise copies the file
+func copyFile(copyMethod apitype.CopyMethod, srcPath string, srcFile string, dstPath string, dstFile string) error {
+	srcFilePath := filepath.Join(srcPath, srcFile)
+	dstFilePath := filepath.Join(dstPath, dstFile)
+	if copyMethod == apitype.CopyMethodHardlink || copyMethod == apitype.CopyMethodReflink {
+		if err := util.MakeDirectoriesIfNotExist(srcPath, dstPath); err != nil {
+			return err
+		}
+
+		var err error
+		if copyMethod == apitype.CopyMethodHardlink {
+			err = os.Link(srcFilePath, dstFilePath)
+		} else {
+			err = util.ReflinkFile(srcFilePath, dstFilePath)
+		}
+		if err == nil {
+			return nil
+		}
+		logger.Debug.Printf("Could not %s '%s', copying instead: %s", copyMethod, srcFilePath, err)
+	}
+	return util.CopyFile(srcPath, srcFile, dstPath, dstFile)
+}
@@ -0,0 +1,94 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/image/tiff"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"unsafe"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+type encoder func(writer io.Writer, imageData image.Image, exifData *apitype.ExifData, quality int) error
+
+// Encoders for the formats that can be written. Exif data is only preserved for JPEG images.
+// Formats without an encoder (e.g. WebP) are written using fallbackFormat.
+var encoders = map[apitype.ImageFormat]encoder{
+	apitype.JPEG: encodeJpeg,
+	apitype.PNG:  encodePng,
+	apitype.GIF:  encodeGif,
+	apitype.TIFF: encodeTiff,
+}
+
+const fallbackFormat = apitype.PNG
+
+func encodeJpeg(writer io.Writer, imageData image.Image, exifData *apitype.ExifData, quality int) error {
+	encodingOptions := &jpeg.Options{
+		Quality: quality,
+	}
+
+	jpegBuffer := bytes.NewBuffer([]byte{})
+	if err := jpeg.Encode(jpegBuffer, imageData, encodingOptions); err != nil {
+		return err
+	} else if exifData.HasRawExifData() {
+		return writeJpegWithExifData(writer, jpegBuffer, exifData)
+	} else {
+		_, err := jpegBuffer.WriteTo(writer)
+		return err
+	}
+}
+
+func encodePng(writer io.Writer, imageData image.Image, _ *apitype.ExifData, _ int) error {
+	return png.Encode(writer, imageData)
+}
+
+func encodeGif(writer io.Writer, imageData image.Image, _ *apitype.ExifData, _ int) error {
+	return gif.Encode(writer, imageData, nil)
+}
+
+func encodeTiff(writer io.Writer, imageData image.Image, _ *apitype.ExifData, _ int) error {
+	return tiff.Encode(writer, imageData, &tiff.Options{Compression: tiff.Deflate})
+}
+
+func writeJpegWithExifData(destination io.Writer, buffer *bytes.Buffer, exifData *apitype.ExifData) error {
+	writer := bufio.NewWriter(destination)
+	// 0xFF 0xD8: Start of JPEG
+	writer.Write(buffer.Next(2))
+
+	writeJfifBlock(writer, buffer)
+	writeExifBlock(exifData, writer)
+
+	// Write rest of file
+	writer.Write(buffer.Bytes())
+	return writer.Flush()
+}
+
+func writeExifBlock(data *apitype.ExifData, writer *bufio.Writer) {
+	const lengthBytes = 2
+	const exifHeader = "Exif\x00\x00"
+	headerLength := len(exifHeader)
+	// Length includes the length bytes, so we need to add them when writing
+	dataLength := data.RawExifDataLength() + uint16(headerLength) + lengthBytes
+	dataLengthBytes := (*[2]byte)(unsafe.Pointer(&dataLength))[:]
+	writer.Write([]byte{0xFF, 0xE1})
+	writer.WriteByte(dataLengthBytes[1])
+	writer.WriteByte(dataLengthBytes[0])
+	writer.WriteString(exifHeader)
+	writer.Write(data.RawExifData())
+}
+
+func writeJfifBlock(writer *bufio.Writer, bw *bytes.Buffer) {
+	// 0xFF 0xE0 length (2 bytes): APP0 block of 0 length
+	const lengthBytes = 2
+	writer.Write(bw.Next(2))
+	e0LengthBytes := bw.Next(2)
+	// Length includes the length bytes, so we need to subtract when reading
+	e0Length := int(binary.BigEndian.Uint16(e0LengthBytes)) - lengthBytes
+	writer.Write(e0LengthBytes)
+	writer.Write(bw.Next(e0Length))
+}
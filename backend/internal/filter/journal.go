@@ -0,0 +1,59 @@
+package filter
+
+import (
+	"path/filepath"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/util"
+)
+
+// Used when the file changes are not recorded. Files are removed directly.
+type directFileJournal struct {
+	apitype.FileJournal
+}
+
+func (s *directFileJournal) Creating(string) error {
+	return nil
+}
+
+func (s *directFileJournal) Hold(path string) error {
+	return util.RemoveFile(path)
+}
+
+func (s *directFileJournal) Moving(string, string) error {
+	return nil
+}
+
+func fileJournal(operationGroup *apitype.ImageOperationGroup) apitype.FileJournal {
+	if journal := operationGroup.FileJournal(); journal != nil {
+		return journal
+	}
+	return &directFileJournal{}
+}
+
+// Records that the file is going to be created. Existing file is
+// held so that it can be restored if the changes are rolled back.
+func prepareTarget(journal apitype.FileJournal, path string) error {
+	if util.DoesPathExist(path) {
+		if err := journal.Hold(path); err != nil {
+			return err
+		}
+	}
+	return journal.Creating(path)
+}
+
+// Moves the file. Existing target is held so that it can be restored
+// if the changes are rolled back.
+func moveFile(journal apitype.FileJournal, src string, dst string) error {
+	if util.DoesPathExist(dst) {
+		if err := journal.Hold(dst); err != nil {
+			return err
+		}
+	}
+	if err := journal.Moving(src, dst); err != nil {
+		return err
+	} else if err := util.MakeDirectoriesIfNotExist(filepath.Dir(src), filepath.Dir(dst)); err != nil {
+		return err
+	} else {
+		return util.MoveFile(src, dst)
+	}
+}
@@ -0,0 +1,187 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/util"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// ImageLink links the original image and its associated files to the category
+// instead of copying them. Existing links to the image are kept as is.
+type ImageLink struct {
+	fileOperation
+	categoryId apitype.CategoryId
+	outputMode apitype.OutputMode
+	linkPaths  []string
+
+	apitype.ImageOperation
+}
+
+func NewImageLink(categoryId apitype.CategoryId, targetDir string, targetFile string, outputMode apitype.OutputMode, conflictPolicy apitype.ConflictPolicy) apitype.ImageOperation {
+	return &ImageLink{
+		categoryId: categoryId,
+		outputMode: outputMode,
+		fileOperation: fileOperation{
+			dstPath:        targetDir,
+			dstFile:        targetFile,
+			conflictPolicy: conflictPolicy,
+		},
+	}
+}
+
+func (s *ImageLink) Apply(operationGroup *apitype.ImageOperationGroup) (image.Image, *apitype.ExifData, error) {
+	imageFile := operationGroup.ImageFile()
+	logger.Debug.Printf("Link %s", imageFile.Path())
+	s.linkPaths = nil
+
+	dstFile := s.dstFile
+	if dstFilePath := filepath.Join(s.dstPath, dstFile); s.isLinkTo(dstFilePath, imageFile.Path()) {
+		operationGroup.AddResult(fmt.Sprintf("already linked to '%s'", dstFilePath))
+	} else if resolvedFile, err := s.resolveTargetFileName(operationGroup, &imageContent{srcFilePath: imageFile.Path()}, "linked"); err != nil || resolvedFile == "" {
+		return nil, nil, err
+	} else {
+		dstFile = resolvedFile
+	}
+
+	journal := fileJournal(operationGroup)
+	if err := s.link(journal, imageFile.Path(), filepath.Join(s.dstPath, dstFile)); err != nil {
+		return nil, nil, err
+	}
+	for _, fileName := range imageFile.AssociatedFiles() {
+		logger.Debug.Printf("Link associated file '%s'", fileName)
+		srcFilePath := filepath.Join(imageFile.Directory(), fileName)
+		dstFilePath := filepath.Join(s.dstPath, associatedFileName(imageFile, fileName, dstFile))
+		if err := s.link(journal, srcFilePath, dstFilePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, nil
+}
+
+func (s *ImageLink) CategoryId() apitype.CategoryId {
+	return s.categoryId
+}
+
+// Paths of the links to the image and its associated files after the operation has been applied
+func (s *ImageLink) LinkPaths() []string {
+	return s.linkPaths
+}
+
+// Returns true if any of the targets exists and is not already a link to the image
+func (s *ImageLink) HasConflict(imageFile *apitype.ImageFile) bool {
+	target, associatedTargets := s.TargetPaths(imageFile, false)
+	if util.DoesPathExist(target) && !s.isLinkTo(target, imageFile.Path()) {
+		return true
+	}
+	for i, fileName := range imageFile.AssociatedFiles() {
+		srcFilePath := filepath.Join(imageFile.Directory(), fileName)
+		if util.DoesPathExist(associatedTargets[i]) && !s.isLinkTo(associatedTargets[i], srcFilePath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ImageLink) String() string {
+	return fmt.Sprintf("Link file '%s' to '%s'", s.dstFile, s.dstPath)
+}
+
+// Links the file unless the link already exists
+func (s *ImageLink) link(journal apitype.FileJournal, src string, dst string) error {
+	s.linkPaths = append(s.linkPaths, dst)
+	if s.isLinkTo(dst, src) {
+		return nil
+	} else if err := prepareTarget(journal, dst); err != nil {
+		return err
+	} else if err := util.MakeDirectoriesIfNotExist(filepath.Dir(src), filepath.Dir(dst)); err != nil {
+		return err
+	} else if s.outputMode == apitype.OutputHardlinks {
+		return os.Link(src, dst)
+	} else {
+		return os.Symlink(symlinkTarget(src, dst), dst)
+	}
+}
+
+func (s *ImageLink) isLinkTo(path string, src string) bool {
+	if s.outputMode == apitype.OutputHardlinks {
+		return isHardlinkTo(path, src)
+	}
+	return isSymlinkTo(path, src)
+}
+
+// LinkRemove removes a link that was created to a category the image no longer belongs to
+type LinkRemove struct {
+	path string
+
+	apitype.ImageOperation
+}
+
+func NewLinkRemove(path string) apitype.ImageOperation {
+	return &LinkRemove{
+		path: path,
+	}
+}
+
+func (s *LinkRemove) Apply(operationGroup *apitype.ImageOperationGroup) (image.Image, *apitype.ExifData, error) {
+	imageFile := operationGroup.ImageFile()
+	if !util.DoesPathExist(s.path) {
+		logger.Debug.Printf("Link '%s' has already been removed", s.path)
+		return nil, nil, nil
+	}
+
+	// The user may have replaced the link with a file of their own
+	srcFilePaths := []string{imageFile.Path()}
+	for _, fileName := range imageFile.AssociatedFiles() {
+		srcFilePaths = append(srcFilePaths, filepath.Join(imageFile.Directory(), fileName))
+	}
+	for _, srcFilePath := range srcFilePaths {
+		if isSymlinkTo(s.path, srcFilePath) || isHardlinkTo(s.path, srcFilePath) {
+			logger.Debug.Printf("Remove link '%s'", s.path)
+			operationGroup.AddResult(fmt.Sprintf("removed link '%s'", s.path))
+			return nil, nil, fileJournal(operationGroup).Hold(s.path)
+		}
+	}
+	operationGroup.AddResult(fmt.Sprintf("kept '%s', not a link to the image", s.path))
+	return nil, nil, nil
+}
+
+func (s *LinkRemove) Path() string {
+	return s.path
+}
+
+func (s *LinkRemove) String() string {
+	return fmt.Sprintf("Remove link '%s'", s.path)
+}
+
+// Links are relative so that the directory can be moved or shared as a whole
+func symlinkTarget(src string, dst string) string {
+	if target, err := filepath.Rel(filepath.Dir(dst), src); err == nil {
+		return target
+	}
+	return src
+}
+
+// Compares the link target instead of the files, so broken links are recognized too
+func isSymlinkTo(path string, src string) bool {
+	if target, err := os.Readlink(path); err != nil {
+		return false
+	} else if filepath.IsAbs(target) {
+		return filepath.Clean(target) == filepath.Clean(src)
+	} else {
+		return filepath.Join(filepath.Dir(path), target) == filepath.Clean(src)
+	}
+}
+
+func isHardlinkTo(path string, src string) bool {
+	if stat, err := os.Lstat(path); err != nil || stat.Mode()&os.ModeSymlink != 0 {
+		return false
+	} else if srcStat, err := os.Stat(src); err != nil {
+		return false
+	} else {
+		return os.SameFile(stat, srcStat)
+	}
+}
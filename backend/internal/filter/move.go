@@ -0,0 +1,59 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// ImageMove moves the image to the category instead of copying and removing it.
+// Used when the image is not modified and belongs to only one category.
+type ImageMove struct {
+	fileOperation
+
+	apitype.ImageOperation
+}
+
+func NewImageMove(targetDir string, targetFile string, conflictPolicy apitype.ConflictPolicy) apitype.ImageOperation {
+	return &ImageMove{
+		fileOperation: fileOperation{
+			dstPath:        targetDir,
+			dstFile:        targetFile,
+			conflictPolicy: conflictPolicy,
+		},
+	}
+}
+
+func (s *ImageMove) Apply(operationGroup *apitype.ImageOperationGroup) (image.Image, *apitype.ExifData, error) {
+	imageFile := operationGroup.ImageFile()
+	logger.Debug.Printf("Move %s", imageFile.Path())
+
+	dstFile, err := s.resolveTargetFileName(operationGroup, &imageContent{srcFilePath: imageFile.Path()}, "moved")
+	if err != nil {
+		return nil, nil, err
+	} else if dstFile == "" {
+		// Image was not moved, so the original is handled the same way as when copying
+		return NewImageRemove().Apply(operationGroup)
+	}
+
+	journal := fileJournal(operationGroup)
+	if err := moveFile(journal, imageFile.Path(), filepath.Join(s.dstPath, dstFile)); err != nil {
+		return nil, nil, err
+	}
+	operationGroup.AddTarget(filepath.Join(s.dstPath, dstFile))
+	for _, fileName := range imageFile.AssociatedFiles() {
+		logger.Debug.Printf("Move associated file '%s'", fileName)
+		srcFilePath := filepath.Join(imageFile.Directory(), fileName)
+		dstFilePath := filepath.Join(s.dstPath, associatedFileName(imageFile, fileName, dstFile))
+		if err := moveFile(journal, srcFilePath, dstFilePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, nil
+}
+
+func (s *ImageMove) String() string {
+	return fmt.Sprintf("Move file '%s' to '%s'", s.dstFile, s.dstPath)
+}
@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/util"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// RatingWrite writes the rating, the color label and the keywords to the XMP sidecar
+// of each copy of the image. Existing sidecar is updated, otherwise a new one is created.
+type RatingWrite struct {
+	// Nil if the rating is not written
+	rating *apitype.ImageRating
+	// Nil if the keywords are not written
+	keywords []string
+
+	apitype.ImageOperation
+}
+
+func NewRatingWrite(rating *apitype.ImageRating, keywords []string) apitype.ImageOperation {
+	return &RatingWrite{
+		rating:   rating,
+		keywords: keywords,
+	}
+}
+
+func (s *RatingWrite) Apply(operationGroup *apitype.ImageOperationGroup) (image.Image, *apitype.ExifData, error) {
+	imageFile := operationGroup.ImageFile()
+	var properties []util.XmpProperty
+	if s.rating != nil {
+		properties = []util.XmpProperty{
+			{Name: "Rating", Value: strconv.Itoa(int(s.rating.Rating))},
+			{Name: "Label", Value: string(s.rating.ColorLabel)},
+		}
+	}
+
+	journal := fileJournal(operationGroup)
+	for _, target := range operationGroup.Targets() {
+		sidecarPath := xmpSidecarPath(imageFile, target)
+		logger.Debug.Printf("Write metadata to '%s'", sidecarPath)
+
+		var content []byte
+		var err error
+		if !util.DoesFileExist(sidecarPath) {
+			content, err = s.updateXmp(util.NewXmp(properties), nil)
+		} else if existing, readErr := ioutil.ReadFile(sidecarPath); readErr != nil {
+			return nil, nil, readErr
+		} else {
+			content, err = s.updateXmp(existing, properties)
+		}
+		if err != nil {
+			// Unknown sidecar is kept as is instead of failing the whole image
+			logger.Warn.Printf("Could not write metadata to '%s': %s", sidecarPath, err)
+			operationGroup.AddResult(fmt.Sprintf("metadata not written to '%s'", sidecarPath))
+			continue
+		}
+
+		if err := prepareTarget(journal, sidecarPath); err != nil {
+			return nil, nil, err
+		} else if err := ioutil.WriteFile(sidecarPath, content, 0666); err != nil {
+			return nil, nil, err
+		}
+		operationGroup.AddResult(fmt.Sprintf("wrote metadata to '%s'", sidecarPath))
+	}
+	return nil, nil, nil
+}
+
+func (s *RatingWrite) String() string {
+	var values []string
+	if s.rating != nil {
+		values = append(values, fmt.Sprintf("rating (%s, %s)", s.rating.Rating, s.rating.ColorLabel))
+	}
+	if s.keywords != nil {
+		values = append(values, fmt.Sprintf("keywords (%s)", strings.Join(s.keywords, ", ")))
+	}
+	return fmt.Sprintf("Write %s to XMP", strings.Join(values, " and "))
+}
+
+func (s *RatingWrite) updateXmp(content []byte, properties []util.XmpProperty) ([]byte, error) {
+	if len(properties) > 0 {
+		var err error
+		if content, err = util.SetXmpProperties(content, properties); err != nil {
+			return nil, err
+		}
+	}
+	if s.keywords != nil {
+		return util.SetXmpSubject(content, s.keywords)
+	}
+	return content, nil
+}
+
+// XMP sidecar copied with the image is used if there is one
+func xmpSidecarPath(imageFile *apitype.ImageFile, target string) string {
+	dstPath, dstFile := filepath.Split(target)
+	for _, fileName := range imageFile.SidecarFiles() {
+		if strings.EqualFold(filepath.Ext(fileName), ".xmp") {
+			return filepath.Join(dstPath, associatedFileName(imageFile, fileName, dstFile))
+		}
+	}
+	return strings.TrimSuffix(target, filepath.Ext(target)) + ".xmp"
+}
@@ -2,8 +2,8 @@ package filter
 
 import (
 	"image"
+	"path/filepath"
 	"vincit.fi/image-sorter/api/apitype"
-	"vincit.fi/image-sorter/backend/internal/util"
 	"vincit.fi/image-sorter/common/logger"
 )
 
@@ -16,8 +16,24 @@ func NewImageRemove() apitype.ImageOperation {
 }
 func (s *ImageRemove) Apply(operationGroup *apitype.ImageOperationGroup) (image.Image, *apitype.ExifData, error) {
 	imageFile := operationGroup.ImageFile()
+	if operationGroup.KeepOriginal() {
+		logger.Debug.Printf("Keep %s", imageFile.Path())
+		operationGroup.AddResult("original kept")
+		return nil, nil, nil
+	}
 	logger.Debug.Printf("Remove %s", imageFile.Path())
-	return nil, nil, util.RemoveFile(imageFile.Path())
+	journal := fileJournal(operationGroup)
+	if err := journal.Hold(imageFile.Path()); err != nil {
+		return nil, nil, err
+	}
+	for _, fileName := range imageFile.AssociatedFiles() {
+		logger.Debug.Printf("Remove associated file %s", fileName)
+		if err := journal.Hold(filepath.Join(imageFile.Directory(), fileName)); err != nil {
+			return nil, nil, err
+		}
+	}
+	operationGroup.AddResult("original removed")
+	return nil, nil, nil
 }
 func (s *ImageRemove) String() string {
 	return "Remove"
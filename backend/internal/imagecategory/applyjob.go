@@ -0,0 +1,318 @@
+package imagecategory
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/backend/internal/util"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// Files removed or overwritten by a job are moved under this directory
+// in the setting directory so that they can be restored
+const holdingDirName = "holding"
+
+// Number of the latest completed jobs that can be rolled back. Files held by
+// the older jobs are removed when a job completes.
+const rollbackJobsKept = 5
+
+// Records the file changes of a single image to the apply job
+type jobFileJournal struct {
+	store      *database.ApplyJobStore
+	jobId      int64
+	imageId    apitype.ImageId
+	holdingDir string
+	heldFiles  int
+
+	apitype.FileJournal
+}
+
+func (s *jobFileJournal) Creating(path string) error {
+	return s.store.AddOperation(&api.ApplyJobOperation{
+		JobId:     s.jobId,
+		ImageId:   s.imageId,
+		Operation: api.FileCreate,
+		Path:      path,
+	})
+}
+
+func (s *jobFileJournal) Hold(path string) error {
+	// The same file name may be held many times, e.g. the original and the overwritten target
+	s.heldFiles++
+	holdingPath := filepath.Join(s.holdingDir, fmt.Sprintf("%d_%d_%s", s.imageId, s.heldFiles, filepath.Base(path)))
+	if err := s.store.AddOperation(&api.ApplyJobOperation{
+		JobId:       s.jobId,
+		ImageId:     s.imageId,
+		Operation:   api.FileHold,
+		Path:        path,
+		HoldingPath: holdingPath,
+	}); err != nil {
+		return err
+	}
+	return transferFile(path, holdingPath)
+}
+
+func (s *jobFileJournal) Moving(src string, dst string) error {
+	return s.store.AddOperation(&api.ApplyJobOperation{
+		JobId:      s.jobId,
+		ImageId:    s.imageId,
+		Operation:  api.FileMove,
+		Path:       dst,
+		SourcePath: src,
+	})
+}
+
+func (s *Service) GetApplyJobs() []*api.ApplyJob {
+	if jobs, err := s.applyJobStore.GetJobs(); err != nil {
+		s.sender.SendError("Error while loading apply jobs", err)
+		return []*api.ApplyJob{}
+	} else {
+		return jobs
+	}
+}
+
+// Rolls back the latest completed job by removing the created files and
+// restoring the removed and overwritten files from the holding directory
+func (s *Service) RollbackApplyJob() {
+	if job, err := s.applyJobStore.GetLatestCompletedJob(); err != nil {
+		s.sender.SendError("Error while loading apply jobs", err)
+	} else if job == nil {
+		logger.Info.Printf("No apply job to roll back")
+	} else {
+		logger.Info.Printf("Rolling back apply job %d", job.Id)
+		if err := s.rollbackOperations(job.Id, api.FileOperationDone, "Rolling back..."); err != nil {
+			s.sender.SendError("Error while rolling back changes", err)
+		} else if err := s.applyJobStore.SetJobStatus(job.Id, api.ApplyJobRolledBack); err != nil {
+			s.sender.SendError("Error while storing apply job", err)
+		} else {
+			// Only removed if all the files have been restored
+			if holdingDir, err := s.getHoldingDir(job.Id); err == nil {
+				_ = os.Remove(holdingDir)
+			}
+		}
+		s.sender.SendCommandToTopic(api.DirectoryChanged, &api.DirectoryChangedCommand{Directory: s.rootDir})
+	}
+}
+
+func (s *Service) DiscardApplyJob(command *api.DiscardApplyJobCommand) {
+	if command.JobId == 0 {
+		if jobs, err := s.applyJobStore.GetCompletedJobs(); err != nil {
+			s.sender.SendError("Error while loading apply jobs", err)
+		} else if err := s.discardApplyJobs(jobs); err != nil {
+			s.sender.SendError("Error while discarding apply jobs", err)
+		}
+	} else if job, err := s.applyJobStore.GetJob(command.JobId); err != nil {
+		s.sender.SendError("Error while loading apply job", err)
+	} else if job.Status != api.ApplyJobCompleted {
+		s.sender.SendError("Error while discarding apply job",
+			fmt.Errorf("apply job %d is %s, only completed jobs can be discarded", job.Id, job.Status))
+	} else if err := s.discardApplyJobs([]*api.ApplyJob{job}); err != nil {
+		s.sender.SendError("Error while discarding apply job", err)
+	}
+}
+
+// Private API
+
+// Job is marked discarded before removing the held files so that
+// it is never rolled back without them
+func (s *Service) discardApplyJobs(jobs []*api.ApplyJob) error {
+	for _, job := range jobs {
+		logger.Info.Printf("Discarding rollback data of apply job %d", job.Id)
+		if holdingDir, err := s.getHoldingDir(job.Id); err != nil {
+			return err
+		} else if err := s.applyJobStore.SetJobStatus(job.Id, api.ApplyJobDiscarded); err != nil {
+			return err
+		} else if err := os.RemoveAll(holdingDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Only the latest completed jobs are kept for rolling back
+func (s *Service) discardOldApplyJobs() {
+	if jobs, err := s.applyJobStore.GetCompletedJobs(); err != nil {
+		s.sender.SendError("Error while loading apply jobs", err)
+	} else if len(jobs) > rollbackJobsKept {
+		if err := s.discardApplyJobs(jobs[rollbackJobsKept:]); err != nil {
+			s.sender.SendError("Error while discarding old apply jobs", err)
+		}
+	}
+}
+
+// Continues the jobs that were interrupted e.g. because the application crashed.
+// Image that was being applied is first rolled back and then applied again.
+func (s *Service) resumeApplyJobs() {
+	if jobs, err := s.applyJobStore.GetRunningJobs(); err != nil {
+		s.sender.SendError("Error while loading apply jobs", err)
+	} else {
+		for _, job := range jobs {
+			logger.Info.Printf("Resuming interrupted apply job %d", job.Id)
+			if err := s.rollbackOperations(job.Id, api.FileOperationPending, "Rolling back interrupted changes..."); err != nil {
+				s.sender.SendError("Error while rolling back interrupted changes", err)
+			} else {
+				s.runApplyJob(job)
+			}
+		}
+	}
+}
+
+func (s *Service) runApplyJob(job *api.ApplyJob) {
+	processedImages, err := s.applyJobStore.GetProcessedImages(job.Id)
+	if err != nil {
+		s.sender.SendError("Error while loading apply job", err)
+		return
+	}
+
+	// Job is left running so that it is resumed
+	imageCategory, err := s.imageCategoryStore.GetCategorizedImages()
+	if err != nil {
+		s.sender.SendError("Error while loading categorized images", err)
+		return
+	}
+	operationsByImage := s.ResolveFileOperations(imageCategory, job.Options, func(current int, total int) {
+		s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
+			Name:      "Resolving operations...",
+			Current:   current,
+			Total:     total,
+			CanCancel: false,
+		})
+	})
+
+	total := len(operationsByImage)
+	s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
+		Name:      "Categorizing...",
+		Current:   0,
+		Total:     total,
+		CanCancel: false,
+	})
+	for i, operationGroup := range operationsByImage {
+		details := ""
+		if imageId := operationGroup.ImageFile().Id(); processedImages[imageId] {
+			logger.Debug.Printf("Image %d already applied in job %d", imageId, job.Id)
+		} else {
+			if err := s.applyImage(job, operationGroup); err != nil {
+				s.sender.SendError("Error while applying changes", err)
+			}
+			details = describeResults(operationGroup)
+		}
+		s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
+			Name:      "Categorizing...",
+			Current:   i + 1,
+			Total:     total,
+			CanCancel: false,
+			Details:   details,
+		})
+	}
+
+	if err := s.applyJobStore.SetJobStatus(job.Id, api.ApplyJobCompleted); err != nil {
+		s.sender.SendError("Error while storing apply job", err)
+	} else {
+		s.discardOldApplyJobs()
+	}
+}
+
+// Applies all the operations of the image or none of them
+func (s *Service) applyImage(job *api.ApplyJob, operationGroup *apitype.ImageOperationGroup) error {
+	imageId := operationGroup.ImageFile().Id()
+	holdingDir, err := s.getHoldingDir(job.Id)
+	if err != nil {
+		return err
+	}
+	operationGroup.SetFileJournal(&jobFileJournal{
+		store:      s.applyJobStore,
+		jobId:      job.Id,
+		imageId:    imageId,
+		holdingDir: holdingDir,
+	})
+
+	if err := operationGroup.Apply(); err != nil {
+		if rollbackErr := s.rollbackOperations(job.Id, api.FileOperationPending, ""); rollbackErr != nil {
+			logger.Error.Printf("Could not roll back changes of image %d: %s", imageId, rollbackErr)
+		}
+		if statusErr := s.applyJobStore.SetImageStatus(job.Id, imageId, api.ApplyJobImageFailed); statusErr != nil {
+			logger.Error.Printf("Could not store status of image %d: %s", imageId, statusErr)
+		}
+		return err
+	}
+	if job.Options.OutputMode.IsLink() {
+		if err := s.storeImageLinks(operationGroup); err != nil {
+			logger.Error.Printf("Could not store links of image %d: %s", imageId, err)
+		}
+	}
+	return s.applyJobStore.SetImageStatus(job.Id, imageId, api.ApplyJobImageDone)
+}
+
+// Rolls back the operations of the job with the given status in the reverse order.
+// Progress is reported if the name is given.
+func (s *Service) rollbackOperations(jobId int64, status api.FileOperationStatus, progressName string) error {
+	operations, err := s.applyJobStore.GetOperationsToRollback(jobId, status)
+	if err != nil {
+		return err
+	}
+
+	for i, operation := range operations {
+		if details, err := rollbackOperation(operation); err != nil {
+			return err
+		} else if err := s.applyJobStore.SetOperationStatus(operation.Id, api.FileOperationRolledBack); err != nil {
+			return err
+		} else if progressName != "" {
+			s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
+				Name:      progressName,
+				Current:   i + 1,
+				Total:     len(operations),
+				CanCancel: false,
+				Details:   details,
+			})
+		}
+	}
+	return nil
+}
+
+// Operation may have been interrupted, so the files are checked before touching them
+func rollbackOperation(operation *api.ApplyJobOperation) (string, error) {
+	switch operation.Operation {
+	case api.FileCreate:
+		// Created links may be broken, so the links are not followed
+		if util.DoesPathExist(operation.Path) {
+			logger.Debug.Printf("Remove created file '%s'", operation.Path)
+			return fmt.Sprintf("removed '%s'", operation.Path), util.RemoveFile(operation.Path)
+		}
+	case api.FileHold:
+		if util.DoesPathExist(operation.HoldingPath) {
+			logger.Debug.Printf("Restore '%s' from '%s'", operation.Path, operation.HoldingPath)
+			return fmt.Sprintf("restored '%s'", operation.Path), transferFile(operation.HoldingPath, operation.Path)
+		}
+	case api.FileMove:
+		// Files are only moved within a device, so the move is never partial
+		if util.DoesFileExist(operation.Path) {
+			logger.Debug.Printf("Move '%s' back to '%s'", operation.Path, operation.SourcePath)
+			return fmt.Sprintf("moved back '%s'", operation.SourcePath), util.MoveFile(operation.Path, operation.SourcePath)
+		}
+	}
+	return "", nil
+}
+
+// Holding directory may be on another device than the held file, so the file is copied and
+// removed if it can't be renamed
+func transferFile(src string, dst string) error {
+	if sameDevice, err := util.IsSameDevice(filepath.Dir(src), filepath.Dir(dst)); err == nil && sameDevice {
+		return util.MoveFile(src, dst)
+	} else if err := util.CopyFile(filepath.Dir(src), filepath.Base(src), filepath.Dir(dst), filepath.Base(dst)); err != nil {
+		return err
+	} else {
+		return util.RemoveFile(src)
+	}
+}
+
+// Files are never held relative to the working directory
+func (s *Service) getHoldingDir(jobId int64) (string, error) {
+	if s.settingDir == "" {
+		return "", errors.New("directory has not been initialized")
+	}
+	return filepath.Join(s.settingDir, holdingDirName, fmt.Sprint(jobId)), nil
+}
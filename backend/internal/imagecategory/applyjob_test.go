@@ -0,0 +1,506 @@
+package imagecategory
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/backend/internal/filter"
+	"vincit.fi/image-sorter/backend/internal/library"
+	"vincit.fi/image-sorter/common/constants"
+)
+
+// Creates images image1.jpg and image2.jpg categorized to cat_1
+func initApplyJobTest(t *testing.T) (string, api.ImageCategoryService, *database.ApplyJobStore, []*apitype.ImageFile) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore, database.NewImageCategoryJournalStore(memoryDatabase), applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+	var images []*apitype.ImageFile
+	for _, fileName := range []string{"image1.jpg", "image2.jpg"} {
+		a.Nil(os.WriteFile(filepath.Join(dir, fileName), []byte(fileName), 0644))
+		image, _ := imageStore.AddImage(apitype.NewImageFile(dir, fileName))
+		a.Nil(imageCategoryStore.CategorizeImage(image.Id(), cat1.Id(), apitype.CATEGORIZE))
+		images = append(images, image)
+	}
+	lib.AddImageFiles(images)
+	return dir, sut, applyJobStore, images
+}
+
+func requireFileContent(t *testing.T, expected string, path string) {
+	content, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, expected, string(content))
+}
+
+func TestApplyJob_Rollback(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, applyJobStore, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+
+	// Overwritten by image1
+	a.Nil(os.MkdirAll(filepath.Join(dir, "cat_1"), 0755))
+	a.Nil(os.WriteFile(filepath.Join(dir, "cat_1", "image1.jpg"), []byte("existing"), 0644))
+
+	sut.PersistImageCategories(&api.PersistCategorizationCommand{
+		KeepOriginals:  false,
+		Quality:        100,
+		ConflictPolicy: apitype.ConflictOverwrite,
+	})
+
+	t.Run("Originals are held", func(t *testing.T) {
+		requireFileContent(t, "image1.jpg", filepath.Join(dir, "cat_1", "image1.jpg"))
+		requireFileContent(t, "image2.jpg", filepath.Join(dir, "cat_1", "image2.jpg"))
+		a.NoFileExists(filepath.Join(dir, "image1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "image2.jpg"))
+
+		jobs := sut.GetApplyJobs()
+		a.Equal(1, len(jobs))
+		a.Equal(api.ApplyJobCompleted, jobs[0].Status)
+		a.Equal(2, jobs[0].DoneImages)
+	})
+
+	t.Run("Rollback restores the files", func(t *testing.T) {
+		sut.RollbackApplyJob()
+
+		requireFileContent(t, "image1.jpg", filepath.Join(dir, "image1.jpg"))
+		requireFileContent(t, "image2.jpg", filepath.Join(dir, "image2.jpg"))
+		requireFileContent(t, "existing", filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image2.jpg"))
+		a.NoDirExists(filepath.Join(dir, constants.ImageSorterDir, holdingDirName, "1"))
+
+		job, err := applyJobStore.GetLatestCompletedJob()
+		a.Nil(err)
+		a.Nil(job)
+	})
+}
+
+func TestApplyJob_ResumeInterrupted(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, applyJobStore, images := initApplyJobTest(t)
+
+	// Job was interrupted after image1 was applied and
+	// while image2 was being moved to the holding directory
+	job, err := applyJobStore.AddJob(&api.PersistCategorizationCommand{Quality: 100})
+	a.Nil(err)
+	a.Nil(applyJobStore.SetImageStatus(job.Id, images[0].Id(), api.ApplyJobImageDone))
+	holdingPath := filepath.Join(dir, "holding", "image2.jpg")
+	a.Nil(applyJobStore.AddOperation(&api.ApplyJobOperation{
+		JobId:       job.Id,
+		ImageId:     images[1].Id(),
+		Operation:   api.FileHold,
+		Path:        filepath.Join(dir, "image2.jpg"),
+		HoldingPath: holdingPath,
+	}))
+	a.Nil(os.MkdirAll(filepath.Dir(holdingPath), 0755))
+	a.Nil(os.Rename(filepath.Join(dir, "image2.jpg"), holdingPath))
+
+	sut.InitializeForDirectory(dir)
+
+	a.NoFileExists(holdingPath)
+	a.NoFileExists(filepath.Join(dir, "cat_1", "image1.jpg"))
+	requireFileContent(t, "image2.jpg", filepath.Join(dir, "cat_1", "image2.jpg"))
+
+	resumedJob, err := applyJobStore.GetJob(job.Id)
+	a.Nil(err)
+	a.Equal(api.ApplyJobCompleted, resumedJob.Status)
+	a.Equal(2, resumedJob.DoneImages)
+}
+
+func TestApplyJob_Move(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+	original, err := os.Stat(filepath.Join(dir, "image1.jpg"))
+	a.Nil(err)
+
+	sut.PersistImageCategories(&api.PersistCategorizationCommand{
+		KeepOriginals: false,
+		Quality:       100,
+	})
+
+	t.Run("Image is renamed", func(t *testing.T) {
+		moved, err := os.Stat(filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.Nil(err)
+		a.True(os.SameFile(original, moved))
+		a.NoFileExists(filepath.Join(dir, "image1.jpg"))
+		a.NoDirExists(filepath.Join(dir, constants.ImageSorterDir, holdingDirName, "1"))
+	})
+
+	t.Run("Rollback moves the image back", func(t *testing.T) {
+		sut.RollbackApplyJob()
+
+		restored, err := os.Stat(filepath.Join(dir, "image1.jpg"))
+		a.Nil(err)
+		a.True(os.SameFile(original, restored))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1.jpg"))
+	})
+}
+
+func TestApplyJob_Hardlink(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+
+	sut.PersistImageCategories(&api.PersistCategorizationCommand{
+		KeepOriginals: true,
+		Quality:       100,
+		CopyMethod:    apitype.CopyMethodHardlink,
+	})
+
+	original, err := os.Stat(filepath.Join(dir, "image1.jpg"))
+	a.Nil(err)
+	linked, err := os.Stat(filepath.Join(dir, "cat_1", "image1.jpg"))
+	a.Nil(err)
+	a.True(os.SameFile(original, linked))
+}
+
+func TestApplyJob_Reflink(t *testing.T) {
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+
+	sut.PersistImageCategories(&api.PersistCategorizationCommand{
+		KeepOriginals: true,
+		Quality:       100,
+		CopyMethod:    apitype.CopyMethodReflink,
+	})
+
+	// Copied if the file system doesn't support reflinks
+	requireFileContent(t, "image1.jpg", filepath.Join(dir, "cat_1", "image1.jpg"))
+	requireFileContent(t, "image1.jpg", filepath.Join(dir, "image1.jpg"))
+}
+
+func TestApplyJob_WriteRatings(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	imageRatingStore := database.NewImageRatingStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), imageRatingStore, database.NewTagStore(memoryDatabase))
+	sut.InitializeForDirectory(dir)
+
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 2", "cat_2", "D"))
+	var images []*apitype.ImageFile
+	for _, fileName := range []string{"image1.jpg", "image2.jpg"} {
+		a.Nil(os.WriteFile(filepath.Join(dir, fileName), []byte(fileName), 0644))
+		image, _ := imageStore.AddImage(apitype.NewImageFile(dir, fileName))
+		a.Nil(imageCategoryStore.CategorizeImage(image.Id(), cat1.Id(), apitype.CATEGORIZE))
+		images = append(images, image)
+	}
+	a.Nil(imageCategoryStore.CategorizeImage(images[0].Id(), cat2.Id(), apitype.CATEGORIZE))
+	lib.AddImageFiles(images)
+	a.Nil(imageRatingStore.SetRating(images[0].Id(), 4))
+	a.Nil(imageRatingStore.SetColorLabel(images[0].Id(), apitype.ColorGreen))
+
+	options := &api.PersistCategorizationCommand{
+		KeepOriginals: true,
+		Quality:       100,
+		WriteRatings:  true,
+	}
+
+	t.Run("Plan lists the rating", func(t *testing.T) {
+		plan := sut.PlanImageCategories(options)
+		a.Equal(2, len(plan.Images))
+		a.Equal([]string{"Write rating (4 stars, Green) to XMP"}, plan.Images[0].Filters)
+		a.Equal(0, len(plan.Images[1].Filters))
+	})
+
+	t.Run("Rating is written next to each copy", func(t *testing.T) {
+		sut.PersistImageCategories(options)
+
+		for _, categoryDir := range []string{"cat_1", "cat_2"} {
+			content, err := os.ReadFile(filepath.Join(dir, categoryDir, "image1.xmp"))
+			a.Nil(err)
+			a.Contains(string(content), `xmp:Rating="4"`)
+			a.Contains(string(content), `xmp:Label="Green"`)
+		}
+		a.NoFileExists(filepath.Join(dir, "image1.xmp"))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image2.xmp"))
+	})
+
+	t.Run("Rollback removes the sidecars", func(t *testing.T) {
+		sut.RollbackApplyJob()
+
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1.xmp"))
+		a.NoFileExists(filepath.Join(dir, "cat_2", "image1.xmp"))
+	})
+}
+
+func TestApplyJob_WriteRatingsToJpeg(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	imageRatingStore := database.NewImageRatingStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), imageRatingStore, database.NewTagStore(memoryDatabase))
+	sut.InitializeForDirectory(dir)
+
+	var original bytes.Buffer
+	a.Nil(jpeg.Encode(&original, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	a.Nil(os.WriteFile(filepath.Join(dir, "image1.jpg"), original.Bytes(), 0644))
+
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat1.Id(), apitype.CATEGORIZE))
+	lib.AddImageFiles([]*apitype.ImageFile{image1})
+	a.Nil(imageRatingStore.SetRating(image1.Id(), 3))
+
+	options := &api.PersistCategorizationCommand{
+		Quality:      100,
+		WriteRatings: true,
+	}
+
+	t.Run("Rated image is copied instead of moving", func(t *testing.T) {
+		plan := sut.PlanImageCategories(options)
+		a.Equal(1, len(plan.Images))
+		a.False(plan.Images[0].Copies[0].Move)
+		a.True(plan.Images[0].RemoveOriginal)
+	})
+
+	t.Run("Rating is written to the Exif of the copy", func(t *testing.T) {
+		sut.PersistImageCategories(options)
+
+		content, err := os.ReadFile(filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.Nil(err)
+		x, err := exif.Decode(bytes.NewReader(content))
+		a.Nil(err)
+		x.LoadTags(x.Tiff.Dirs[0], map[uint16]exif.FieldName{0x4746: "Rating"}, false)
+		tag, err := x.Get("Rating")
+		a.Nil(err)
+		rating, err := tag.Int(0)
+		a.Nil(err)
+		a.Equal(3, rating)
+		_, err = jpeg.Decode(bytes.NewReader(content))
+		a.Nil(err)
+	})
+}
+
+func TestApplyJob_WriteTags(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	tagStore := database.NewTagStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), tagStore)
+	sut.InitializeForDirectory(dir)
+
+	var original bytes.Buffer
+	a.Nil(jpeg.Encode(&original, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	a.Nil(os.WriteFile(filepath.Join(dir, "image1.jpg"), original.Bytes(), 0644))
+
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat1.Id(), apitype.CATEGORIZE))
+	lib.AddImageFiles([]*apitype.ImageFile{image1})
+	a.Nil(tagStore.AddTag([]apitype.ImageId{image1.Id()}, "beach"))
+	a.Nil(tagStore.AddTag([]apitype.ImageId{image1.Id()}, "sunset"))
+
+	options := &api.PersistCategorizationCommand{
+		Quality:   100,
+		WriteTags: true,
+	}
+
+	t.Run("Image with keywords is copied instead of moving", func(t *testing.T) {
+		plan := sut.PlanImageCategories(options)
+		a.Equal(1, len(plan.Images))
+		a.Equal([]string{"Write keywords (beach, sunset) to XMP"}, plan.Images[0].Filters)
+		a.False(plan.Images[0].Copies[0].Move)
+		a.True(plan.Images[0].RemoveOriginal)
+	})
+
+	t.Run("Keywords are written to the copy and the sidecar", func(t *testing.T) {
+		sut.PersistImageCategories(options)
+
+		content, err := os.ReadFile(filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.Nil(err)
+		a.Contains(string(content), "Photoshop 3.0")
+		a.Contains(string(content), "sunset")
+		_, err = jpeg.Decode(bytes.NewReader(content))
+		a.Nil(err)
+
+		content, err = os.ReadFile(filepath.Join(dir, "cat_1", "image1.xmp"))
+		a.Nil(err)
+		a.Contains(string(content), "<rdf:li>beach</rdf:li>")
+		a.NoFileExists(filepath.Join(dir, "image1.jpg"))
+	})
+
+	t.Run("Rollback restores the original as is", func(t *testing.T) {
+		sut.RollbackApplyJob()
+
+		content, err := os.ReadFile(filepath.Join(dir, "image1.jpg"))
+		a.Nil(err)
+		a.Equal(original.Bytes(), content)
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1.xmp"))
+	})
+}
+
+func TestApplyJob_SequenceReapply(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+
+	command := &api.PersistCategorizationCommand{
+		KeepOriginals:  true,
+		Quality:        100,
+		RenameTemplate: "photo_{seq}",
+		ConflictPolicy: apitype.ConflictSkipIdentical,
+	}
+	sut.PersistImageCategories(command)
+	sut.PersistImageCategories(command)
+
+	// Applying again does not create new numbers
+	entries, err := os.ReadDir(filepath.Join(dir, "cat_1"))
+	a.Nil(err)
+	var fileNames []string
+	for _, entry := range entries {
+		fileNames = append(fileNames, entry.Name())
+	}
+	a.Equal([]string{"photo_1.jpg", "photo_2.jpg"}, fileNames)
+	requireFileContent(t, "image1.jpg", filepath.Join(dir, "cat_1", "photo_1.jpg"))
+	requireFileContent(t, "image2.jpg", filepath.Join(dir, "cat_1", "photo_2.jpg"))
+}
+
+func TestApplyJob_DiscardOldJobs(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+
+	// Jobs after the first one hold the overwritten copies
+	command := &api.PersistCategorizationCommand{
+		KeepOriginals:  true,
+		Quality:        100,
+		ConflictPolicy: apitype.ConflictOverwrite,
+	}
+	for i := 0; i < rollbackJobsKept+2; i++ {
+		sut.PersistImageCategories(command)
+	}
+
+	jobs := sut.GetApplyJobs()
+	a.Equal(rollbackJobsKept+2, len(jobs))
+	for i, job := range jobs {
+		holdingDir := filepath.Join(dir, constants.ImageSorterDir, holdingDirName, fmt.Sprint(job.Id))
+		if i < rollbackJobsKept {
+			a.Equal(api.ApplyJobCompleted, job.Status)
+			a.DirExists(holdingDir)
+		} else {
+			a.Equal(api.ApplyJobDiscarded, job.Status)
+			a.NoDirExists(holdingDir)
+		}
+	}
+}
+
+func TestApplyJob_Discard(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+
+	command := &api.PersistCategorizationCommand{
+		KeepOriginals:  true,
+		Quality:        100,
+		ConflictPolicy: apitype.ConflictOverwrite,
+	}
+	sut.PersistImageCategories(command)
+	sut.PersistImageCategories(command)
+	jobs := sut.GetApplyJobs()
+	a.Equal(2, len(jobs))
+	holdingDir := filepath.Join(dir, constants.ImageSorterDir, holdingDirName, fmt.Sprint(jobs[0].Id))
+	a.DirExists(holdingDir)
+
+	t.Run("Discard job", func(t *testing.T) {
+		sut.DiscardApplyJob(&api.DiscardApplyJobCommand{JobId: jobs[0].Id})
+
+		a.NoDirExists(holdingDir)
+		jobs := sut.GetApplyJobs()
+		a.Equal(api.ApplyJobDiscarded, jobs[0].Status)
+		a.Equal(api.ApplyJobCompleted, jobs[1].Status)
+	})
+
+	t.Run("Discarded jobs are not rolled back", func(t *testing.T) {
+		sut.DiscardApplyJob(&api.DiscardApplyJobCommand{})
+		sut.RollbackApplyJob()
+
+		// Copies of the first job are kept
+		requireFileContent(t, "image1.jpg", filepath.Join(dir, "cat_1", "image1.jpg"))
+		requireFileContent(t, "image2.jpg", filepath.Join(dir, "cat_1", "image2.jpg"))
+		for _, job := range sut.GetApplyJobs() {
+			a.Equal(api.ApplyJobDiscarded, job.Status)
+		}
+	})
+}
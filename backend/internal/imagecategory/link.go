@@ -0,0 +1,106 @@
+package imagecategory
+
+import (
+	"path/filepath"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/filter"
+	"vincit.fi/image-sorter/backend/internal/util"
+)
+
+// Private API
+
+// Adds the images that have links but no categories anymore
+// so that their links are removed too
+func (s *Service) withLinkedImages(imageCategory map[apitype.ImageId]map[apitype.CategoryId]*api.CategorizedImage) map[apitype.ImageId]map[apitype.CategoryId]*api.CategorizedImage {
+	imageIds, err := s.imageCategoryStore.GetLinkedImageIds()
+	if err != nil {
+		s.sender.SendError("Error while fetching category links", err)
+		return imageCategory
+	}
+
+	result := map[apitype.ImageId]map[apitype.CategoryId]*api.CategorizedImage{}
+	for imageId, categoryEntries := range imageCategory {
+		result[imageId] = categoryEntries
+	}
+	for _, imageId := range imageIds {
+		if _, ok := result[imageId]; !ok {
+			result[imageId] = map[apitype.CategoryId]*api.CategorizedImage{}
+		}
+	}
+	return result
+}
+
+// Links the original image to each category. Links to the categories the image
+// no longer belongs to are removed so that the links stay in sync with the categories.
+func (s *Service) resolveLinkOperationsForGroup(
+	imageFile *apitype.ImageFile,
+	targets map[apitype.CategoryId]*categoryTarget,
+	options *api.PersistCategorizationCommand,
+) (*apitype.ImageOperationGroup, error) {
+	var linkOperations []apitype.ImageOperation
+	// Links of the image and its associated files in each category
+	linkPaths := map[apitype.CategoryId]map[string]bool{}
+	for categoryId, target := range targets {
+		linkOperation := filter.NewImageLink(categoryId, target.dir, target.file, options.OutputMode, options.ConflictPolicy)
+		linkOperations = append(linkOperations, linkOperation)
+
+		targetPath, associatedPaths := linkOperation.(*filter.ImageLink).TargetPaths(imageFile, false)
+		linkPaths[categoryId] = map[string]bool{targetPath: true}
+		for _, associatedPath := range associatedPaths {
+			linkPaths[categoryId][associatedPath] = true
+		}
+	}
+
+	links, err := s.imageCategoryStore.GetImageLinks(imageFile.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	// Stale links are removed first so that they don't conflict with the new ones
+	var imageOperations []apitype.ImageOperation
+	for _, link := range links {
+		path := absoluteLinkPath(imageFile.RootDirectory(), link.Path)
+		// Links are also stale when the file is renamed, e.g. with another rename template
+		if !linkPaths[link.CategoryId][path] {
+			imageOperations = append(imageOperations, filter.NewLinkRemove(path))
+		}
+	}
+	imageOperations = append(imageOperations, linkOperations...)
+
+	return apitype.NewImageOperationGroup(imageFile, s.imageLoader.LoadImage, s.imageLoader.LoadExifData, imageOperations), nil
+}
+
+// Stores the links of the applied image so that they can be kept in sync when
+// the categories are applied again
+func (s *Service) storeImageLinks(operationGroup *apitype.ImageOperationGroup) error {
+	imageFile := operationGroup.ImageFile()
+	var links []*api.CategoryLink
+	for _, operation := range operationGroup.Operations() {
+		if linkOperation, ok := operation.(*filter.ImageLink); ok {
+			for _, path := range linkOperation.LinkPaths() {
+				links = append(links, &api.CategoryLink{
+					CategoryId: linkOperation.CategoryId(),
+					Path:       relativeLinkPath(imageFile.RootDirectory(), path),
+				})
+			}
+		}
+	}
+	return s.imageCategoryStore.SetImageLinks(imageFile.Id(), links)
+}
+
+// Links under the root directory are stored relative to it so that the directory
+// can be moved. Links in the categories outside of it are stored as absolute paths.
+func relativeLinkPath(rootDir string, path string) string {
+	if relativePath, ok := util.RelativePathInside(rootDir, path); ok {
+		return filepath.ToSlash(relativePath)
+	}
+	return path
+}
+
+func absoluteLinkPath(rootDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(rootDir, filepath.FromSlash(path))
+}
@@ -0,0 +1,208 @@
+package imagecategory
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/backend/internal/filter"
+	"vincit.fi/image-sorter/backend/internal/library"
+	"vincit.fi/image-sorter/common/constants"
+)
+
+func requireSymlink(t *testing.T, expectedTarget string, path string) {
+	target, err := os.Readlink(path)
+	require.Nil(t, err)
+	require.Equal(t, expectedTarget, target)
+}
+
+func TestApplyJob_Symlinks(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, images := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+	options := &api.PersistCategorizationCommand{
+		KeepOriginals: false,
+		Quality:       100,
+		OutputMode:    apitype.OutputSymlinks,
+	}
+
+	t.Run("Links are created and originals kept", func(t *testing.T) {
+		sut.PersistImageCategories(options)
+
+		requireSymlink(t, filepath.Join("..", "image1.jpg"), filepath.Join(dir, "cat_1", "image1.jpg"))
+		requireSymlink(t, filepath.Join("..", "image2.jpg"), filepath.Join(dir, "cat_1", "image2.jpg"))
+		requireFileContent(t, "image1.jpg", filepath.Join(dir, "cat_1", "image1.jpg"))
+		requireFileContent(t, "image1.jpg", filepath.Join(dir, "image1.jpg"))
+		requireFileContent(t, "image2.jpg", filepath.Join(dir, "image2.jpg"))
+	})
+
+	t.Run("Plan shows existing links without conflicts", func(t *testing.T) {
+		plan := sut.PlanImageCategories(options)
+		a.Equal(2, len(plan.Images))
+		a.Equal(0, plan.Conflicts)
+		a.True(plan.Images[0].Copies[0].Link)
+		a.False(plan.Images[0].RemoveOriginal)
+	})
+
+	t.Run("Renamed link replaces the old link", func(t *testing.T) {
+		renamed := *options
+		renamed.RenameTemplate = "renamed_{name}"
+
+		plan := sut.PlanImageCategories(&renamed)
+		a.Equal([]string{filepath.Join(dir, "cat_1", "image1.jpg")}, plan.Images[0].RemovedLinks)
+
+		sut.PersistImageCategories(&renamed)
+		requireSymlink(t, filepath.Join("..", "image1.jpg"), filepath.Join(dir, "cat_1", "renamed_image1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1.jpg"))
+
+		sut.PersistImageCategories(options)
+		requireSymlink(t, filepath.Join("..", "image1.jpg"), filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "renamed_image1.jpg"))
+	})
+
+	t.Run("Stale link is removed on re-apply", func(t *testing.T) {
+		for categoryId := range sut.GetCategories(&api.ImageCategoryQuery{ImageId: images[1].Id()}) {
+			sut.SetCategory(&api.CategorizeCommand{
+				ImageId:         images[1].Id(),
+				CategoryId:      categoryId,
+				Operation:       apitype.UNCATEGORIZE,
+				StayOnSameImage: true,
+			})
+		}
+
+		plan := sut.PlanImageCategories(options)
+		a.Equal(2, len(plan.Images))
+		a.Equal([]string{filepath.Join(dir, "cat_1", "image2.jpg")}, plan.Images[1].RemovedLinks)
+
+		sut.PersistImageCategories(options)
+
+		requireSymlink(t, filepath.Join("..", "image1.jpg"), filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image2.jpg"))
+		requireFileContent(t, "image2.jpg", filepath.Join(dir, "image2.jpg"))
+
+		// Nothing left to sync
+		a.Equal(1, len(sut.PlanImageCategories(options).Images))
+	})
+
+	t.Run("Rollback restores the removed link", func(t *testing.T) {
+		sut.RollbackApplyJob()
+
+		requireSymlink(t, filepath.Join("..", "image1.jpg"), filepath.Join(dir, "cat_1", "image1.jpg"))
+		requireSymlink(t, filepath.Join("..", "image2.jpg"), filepath.Join(dir, "cat_1", "image2.jpg"))
+	})
+}
+
+func TestApplyJob_SymlinkReplacedByFile(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, images := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+	options := &api.PersistCategorizationCommand{OutputMode: apitype.OutputSymlinks}
+	sut.PersistImageCategories(options)
+
+	linkPath := filepath.Join(dir, "cat_1", "image1.jpg")
+	a.Nil(os.Remove(linkPath))
+	a.Nil(os.WriteFile(linkPath, []byte("own file"), 0644))
+	for categoryId := range sut.GetCategories(&api.ImageCategoryQuery{ImageId: images[0].Id()}) {
+		sut.SetCategory(&api.CategorizeCommand{
+			ImageId:         images[0].Id(),
+			CategoryId:      categoryId,
+			Operation:       apitype.UNCATEGORIZE,
+			StayOnSameImage: true,
+		})
+	}
+
+	sut.PersistImageCategories(options)
+
+	requireFileContent(t, "own file", linkPath)
+}
+
+func TestApplyJob_HardlinkOutput(t *testing.T) {
+	a := require.New(t)
+
+	dir, sut, _, _ := initApplyJobTest(t)
+	sut.InitializeForDirectory(dir)
+	options := &api.PersistCategorizationCommand{
+		KeepOriginals:  false,
+		FixOrientation: true,
+		OutputMode:     apitype.OutputHardlinks,
+	}
+
+	sut.PersistImageCategories(options)
+	sut.PersistImageCategories(options)
+
+	original, err := os.Stat(filepath.Join(dir, "image1.jpg"))
+	a.Nil(err)
+	link, err := os.Lstat(filepath.Join(dir, "cat_1", "image1.jpg"))
+	a.Nil(err)
+	a.True(os.SameFile(original, link))
+	a.Zero(link.Mode() & os.ModeSymlink)
+	a.NoFileExists(filepath.Join(dir, "cat_1", "image1_1.jpg"))
+}
+
+func TestApplyJob_SymlinksWithSidecar(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+	sut.InitializeForDirectory(dir)
+
+	a.Nil(os.WriteFile(filepath.Join(dir, "image1.jpg"), []byte("image1.jpg"), 0644))
+	a.Nil(os.WriteFile(filepath.Join(dir, "image1.xmp"), []byte("image1.xmp"), 0644))
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat1.Id(), apitype.CATEGORIZE))
+	lib.AddImageFiles([]*apitype.ImageFile{image1})
+	image1.SetSidecarFiles([]string{"image1.xmp"})
+	a.Nil(imageStore.UpdateAssociatedFiles([]*apitype.ImageFile{image1}))
+
+	options := &api.PersistCategorizationCommand{OutputMode: apitype.OutputSymlinks}
+	sut.PersistImageCategories(options)
+	requireSymlink(t, filepath.Join("..", "image1.jpg"), filepath.Join(dir, "cat_1", "image1.jpg"))
+	requireSymlink(t, filepath.Join("..", "image1.xmp"), filepath.Join(dir, "cat_1", "image1.xmp"))
+
+	t.Run("Unchanged re-apply removes no links", func(t *testing.T) {
+		plan := sut.PlanImageCategories(options)
+		a.Equal(1, len(plan.Images))
+		a.Empty(plan.Images[0].RemovedLinks)
+
+		operations, err := sut.ResolveOperationsForGroup(image1, map[apitype.CategoryId]*api.CategorizedImage{
+			cat1.Id(): {Category: cat1, Operation: apitype.CATEGORIZE},
+		}, options)
+		a.Nil(err)
+		for _, operation := range operations.Operations() {
+			a.IsType(&filter.ImageLink{}, operation)
+		}
+	})
+
+	t.Run("Unchanged re-apply holds no files", func(t *testing.T) {
+		sut.PersistImageCategories(options)
+
+		requireSymlink(t, filepath.Join("..", "image1.xmp"), filepath.Join(dir, "cat_1", "image1.xmp"))
+		holding, _ := os.ReadDir(filepath.Join(dir, constants.ImageSorterDir, holdingDirName))
+		for _, jobDir := range holding {
+			files, _ := os.ReadDir(filepath.Join(dir, constants.ImageSorterDir, holdingDirName, jobDir.Name()))
+			a.Empty(files)
+		}
+	})
+}
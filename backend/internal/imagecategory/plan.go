@@ -0,0 +1,109 @@
+package imagecategory
+
+import (
+	"sort"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/filter"
+	"vincit.fi/image-sorter/backend/internal/util"
+)
+
+// Resolves the file operations the same way as when persisting the categories
+// but only describes them instead of applying
+func (s *Service) PlanImageCategories(options *api.PersistCategorizationCommand) *api.ApplyPlan {
+	plan := &api.ApplyPlan{
+		Images: []*api.ImageApplyPlan{},
+	}
+
+	imageCategory, err := s.imageCategoryStore.GetCategorizedImages()
+	if err != nil {
+		s.sender.SendError("Error while fetching categorized images", err)
+		return plan
+	}
+
+	operationGroups := s.ResolveFileOperations(imageCategory, options, func(int, int) {})
+	for _, operationGroup := range operationGroups {
+		imagePlan := planOperationGroup(operationGroup)
+		for _, plannedCopy := range imagePlan.Copies {
+			if plannedCopy.Conflict {
+				plan.Conflicts++
+			}
+		}
+		plan.Images = append(plan.Images, imagePlan)
+	}
+	sort.Slice(plan.Images, func(i, j int) bool {
+		return plan.Images[i].Source < plan.Images[j].Source
+	})
+	return plan
+}
+
+func (s *Service) RequestApplyPlan(options *api.PersistCategorizationCommand) {
+	s.sender.SendCommandToTopic(api.CategoryPlanUpdated, &api.ApplyPlanCommand{
+		Plan:    s.PlanImageCategories(options),
+		Options: options,
+	})
+}
+
+// Private API
+
+func planOperationGroup(operationGroup *apitype.ImageOperationGroup) *api.ImageApplyPlan {
+	imageFile := operationGroup.ImageFile()
+	imagePlan := &api.ImageApplyPlan{
+		Source:  imageFile.Path(),
+		Filters: []string{},
+		Copies:  []*api.PlannedCopy{},
+	}
+
+	// Same filters are applied before each copy, so they are only listed once
+	filters := map[string]bool{}
+	for _, operation := range operationGroup.Operations() {
+		switch op := operation.(type) {
+		case *filter.ImageCopy:
+			target, associatedTargets := op.TargetPaths(imageFile, len(filters) > 0)
+			imagePlan.Copies = append(imagePlan.Copies, &api.PlannedCopy{
+				Target:            target,
+				AssociatedTargets: associatedTargets,
+				Conflict:          anyFileExists(append([]string{target}, associatedTargets...)),
+			})
+		case *filter.ImageMove:
+			target, associatedTargets := op.TargetPaths(imageFile, false)
+			imagePlan.Copies = append(imagePlan.Copies, &api.PlannedCopy{
+				Target:            target,
+				Move:              true,
+				AssociatedTargets: associatedTargets,
+				Conflict:          anyFileExists(append([]string{target}, associatedTargets...)),
+			})
+			imagePlan.RemoveOriginal = true
+		case *filter.ImageLink:
+			target, associatedTargets := op.TargetPaths(imageFile, false)
+			imagePlan.Copies = append(imagePlan.Copies, &api.PlannedCopy{
+				Target:            target,
+				Link:              true,
+				AssociatedTargets: associatedTargets,
+				Conflict:          op.HasConflict(imageFile),
+			})
+		case *filter.LinkRemove:
+			imagePlan.RemovedLinks = append(imagePlan.RemovedLinks, op.Path())
+		case *filter.ImageRemove:
+			imagePlan.RemoveOriginal = true
+		default:
+			if name := operation.String(); !filters[name] {
+				filters[name] = true
+				imagePlan.Filters = append(imagePlan.Filters, name)
+			}
+		}
+	}
+	sort.Slice(imagePlan.Copies, func(i, j int) bool {
+		return imagePlan.Copies[i].Target < imagePlan.Copies[j].Target
+	})
+	return imagePlan
+}
+
+func anyFileExists(paths []string) bool {
+	for _, path := range paths {
+		if util.DoesFileExist(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,396 @@
+package imagecategory
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/backend/internal/filter"
+	"vincit.fi/image-sorter/backend/internal/library"
+)
+
+func TestPlanImageCategories(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+	statusStore := database.NewStatusStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		statusStore,
+	)
+	filterService := filter.NewFilterService()
+
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+	image2, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image2.jpg"))
+	lib.AddImageFiles([]*apitype.ImageFile{image1, image2})
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 2", "cat_2", "D"))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat1.Id(), apitype.CATEGORIZE))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat2.Id(), apitype.CATEGORIZE))
+	a.Nil(imageCategoryStore.CategorizeImage(image2.Id(), cat1.Id(), apitype.CATEGORIZE))
+
+	// Target of image2 already exists
+	a.Nil(os.MkdirAll(filepath.Join(dir, "cat_1"), 0755))
+	a.Nil(os.WriteFile(filepath.Join(dir, "cat_1", "image2.jpg"), []byte{}, 0644))
+
+	plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{
+		KeepOriginals:  false,
+		FixOrientation: true,
+		Quality:        100,
+	})
+
+	a.Equal(1, plan.Conflicts)
+	a.Equal(2, len(plan.Images))
+
+	t.Run("Image to many categories", func(t *testing.T) {
+		imagePlan := plan.Images[0]
+		a.Equal(filepath.Join(dir, "image1.jpg"), imagePlan.Source)
+		a.Equal([]string{"Exif Rotate"}, imagePlan.Filters)
+		a.True(imagePlan.RemoveOriginal)
+		a.Equal(2, len(imagePlan.Copies))
+		a.Equal(filepath.Join(dir, "cat_1", "image1.jpg"), imagePlan.Copies[0].Target)
+		a.False(imagePlan.Copies[0].Move)
+		a.False(imagePlan.Copies[0].Conflict)
+		a.Equal(filepath.Join(dir, "cat_2", "image1.jpg"), imagePlan.Copies[1].Target)
+		a.False(imagePlan.Copies[1].Conflict)
+	})
+
+	t.Run("Existing target is a conflict", func(t *testing.T) {
+		imagePlan := plan.Images[1]
+		a.Equal(filepath.Join(dir, "image2.jpg"), imagePlan.Source)
+		a.Equal(1, len(imagePlan.Copies))
+		a.Equal(filepath.Join(dir, "cat_1", "image2.jpg"), imagePlan.Copies[0].Target)
+		a.True(imagePlan.Copies[0].Conflict)
+	})
+
+	t.Run("Nothing is written", func(t *testing.T) {
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1.jpg"))
+		a.NoDirExists(filepath.Join(dir, "cat_2"))
+	})
+}
+
+func TestPersistImageCategories_ConflictPolicy(t *testing.T) {
+	// Copies image1.jpg to cat_1 that already has an image with the given content
+	persist := func(t *testing.T, policy apitype.ConflictPolicy, existingContent string) (string, string) {
+		a := require.New(t)
+
+		dir := t.TempDir()
+		sender := new(MockSender)
+		imageCache := new(MockImageCache)
+		imageLoader := new(MockImageLoader)
+		sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+		memoryDatabase := database.NewInMemoryDatabase(dir)
+		imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+		categoryStore := database.NewCategoryStore(memoryDatabase)
+		imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+		lib := library.NewImageService(
+			sender,
+			library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+			database.NewStatusStore(memoryDatabase),
+		)
+		sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore, database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+		sut.InitializeForDirectory(dir)
+
+		a.Nil(os.WriteFile(filepath.Join(dir, "image1.jpg"), []byte("image"), 0644))
+		a.Nil(os.MkdirAll(filepath.Join(dir, "cat_1"), 0755))
+		a.Nil(os.WriteFile(filepath.Join(dir, "cat_1", "image1.jpg"), []byte(existingContent), 0644))
+
+		image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+		lib.AddImageFiles([]*apitype.ImageFile{image1})
+		cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "cat_1", "C"))
+		a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat1.Id(), apitype.CATEGORIZE))
+
+		sut.PersistImageCategories(&api.PersistCategorizationCommand{
+			KeepOriginals:  false,
+			Quality:        100,
+			ConflictPolicy: policy,
+		})
+
+		details := ""
+		for _, call := range sender.Calls {
+			if command, ok := call.Arguments.Get(1).(*api.UpdateProgressCommand); ok && command.Details != "" {
+				details = command.Details
+			}
+		}
+		return dir, details
+	}
+	readFile := func(t *testing.T, path string) string {
+		content, err := os.ReadFile(path)
+		require.Nil(t, err)
+		return string(content)
+	}
+
+	t.Run("Overwrite", func(t *testing.T) {
+		a := require.New(t)
+		dir, details := persist(t, apitype.ConflictOverwrite, "other")
+
+		a.Equal("image", readFile(t, filepath.Join(dir, "cat_1", "image1.jpg")))
+		a.NoFileExists(filepath.Join(dir, "image1.jpg"))
+		a.Contains(details, "image1.jpg: overwrote")
+	})
+
+	t.Run("Skip keeps the original", func(t *testing.T) {
+		a := require.New(t)
+		dir, details := persist(t, apitype.ConflictSkip, "other")
+
+		a.Equal("other", readFile(t, filepath.Join(dir, "cat_1", "image1.jpg")))
+		a.FileExists(filepath.Join(dir, "image1.jpg"))
+		a.Contains(details, "skipped")
+		a.Contains(details, "original kept")
+	})
+
+	t.Run("Rename with number", func(t *testing.T) {
+		a := require.New(t)
+		dir, details := persist(t, apitype.ConflictRenameWithNumber, "other")
+
+		a.Equal("other", readFile(t, filepath.Join(dir, "cat_1", "image1.jpg")))
+		a.Equal("image", readFile(t, filepath.Join(dir, "cat_1", "image1_1.jpg")))
+		a.Contains(details, "renamed to")
+	})
+
+	t.Run("Rename with hash", func(t *testing.T) {
+		a := require.New(t)
+		dir, _ := persist(t, apitype.ConflictRenameWithHash, "other")
+
+		// SHA-256 of "image"
+		a.Equal("image", readFile(t, filepath.Join(dir, "cat_1", "image1_6105d6cc.jpg")))
+	})
+
+	t.Run("Skip identical", func(t *testing.T) {
+		a := require.New(t)
+		dir, details := persist(t, apitype.ConflictSkipIdentical, "image")
+
+		a.NoFileExists(filepath.Join(dir, "cat_1", "image1_1.jpg"))
+		a.NoFileExists(filepath.Join(dir, "image1.jpg"))
+		a.Contains(details, "skipped, identical")
+	})
+
+	t.Run("Skip identical renames different", func(t *testing.T) {
+		a := require.New(t)
+		dir, _ := persist(t, apitype.ConflictSkipIdentical, "other")
+
+		a.Equal("other", readFile(t, filepath.Join(dir, "cat_1", "image1.jpg")))
+		a.Equal("image", readFile(t, filepath.Join(dir, "cat_1", "image1_1.jpg")))
+	})
+}
+
+func TestPlanImageCategories_TemplatedPath(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	archiveDir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "sub/image1.jpg"))
+	image2, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image2.jpg"))
+	lib.AddImageFiles([]*apitype.ImageFile{image1, image2})
+	a.Nil(imageMetaDataStore.AddMetaData(image1.Id(), apitype.NewImageMetaData(map[string]string{
+		"DateTimeOriginal": "2021:03:14 15:09:26",
+		"Model":            "X100",
+	})))
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Good", filepath.Join(archiveDir, "{category}", "{exif:DateTimeOriginal:2006}", "{exif:Model}_{filename}"), "G"))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), cat1.Id(), apitype.CATEGORIZE))
+	a.Nil(imageCategoryStore.CategorizeImage(image2.Id(), cat1.Id(), apitype.CATEGORIZE))
+
+	plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{KeepOriginals: true})
+
+	a.Equal(2, len(plan.Images))
+	// Sub directory is not preserved with templates
+	a.Equal(filepath.Join(archiveDir, "Good", "unknown", "unknown_image2.jpg"), plan.Images[0].Copies[0].Target)
+	a.Equal(filepath.Join(archiveDir, "Good", "2021", "X100_image1.jpg"), plan.Images[1].Copies[0].Target)
+}
+
+func TestPlanImageCategories_RenameTemplate(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	var images []*apitype.ImageFile
+	for _, fileName := range []string{"image1.jpg", "image2.jpg"} {
+		image, _ := imageStore.AddImage(apitype.NewImageFile(dir, fileName))
+		images = append(images, image)
+	}
+	lib.AddImageFiles(images)
+	for _, image := range images {
+		a.Nil(imageMetaDataStore.AddMetaData(image.Id(), apitype.NewImageMetaData(map[string]string{
+			"DateTimeOriginal": "2021:03:14 15:09:26",
+			"Model":            "X100",
+		})))
+	}
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Good", "good", "G"))
+	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Named", "named/{name}", "N"))
+	for _, image := range images {
+		a.Nil(imageCategoryStore.CategorizeImage(image.Id(), cat1.Id(), apitype.CATEGORIZE))
+		a.Nil(imageCategoryStore.CategorizeImage(image.Id(), cat2.Id(), apitype.CATEGORIZE))
+	}
+
+	t.Run("Duplicate names are numbered", func(t *testing.T) {
+		plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{
+			KeepOriginals:  true,
+			RenameTemplate: "{exif:DateTimeOriginal:2006-01-02}_{exif:Model}",
+		})
+
+		a.Equal(2, len(plan.Images))
+		a.Equal(0, plan.Conflicts)
+		a.Equal(filepath.Join(dir, "good", "2021-03-14_X100.jpg"), plan.Images[0].Copies[0].Target)
+		a.Equal(filepath.Join(dir, "good", "2021-03-14_X100_1.jpg"), plan.Images[1].Copies[0].Target)
+		// Category path that names the file is not renamed
+		a.Equal(filepath.Join(dir, "named", "image1.jpg"), plan.Images[0].Copies[1].Target)
+		a.Equal(filepath.Join(dir, "named", "image2.jpg"), plan.Images[1].Copies[1].Target)
+	})
+
+	t.Run("Sequence leaves existing files to the conflict policy", func(t *testing.T) {
+		a.Nil(os.MkdirAll(filepath.Join(dir, "good"), 0755))
+		a.Nil(os.WriteFile(filepath.Join(dir, "good", "X100_01.jpg"), []byte{}, 0644))
+
+		plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{
+			KeepOriginals:  true,
+			RenameTemplate: "{exif:Model}_{seq:2}",
+		})
+
+		a.Equal(1, plan.Conflicts)
+		a.Equal(filepath.Join(dir, "good", "X100_01.jpg"), plan.Images[0].Copies[0].Target)
+		a.Equal(filepath.Join(dir, "good", "X100_02.jpg"), plan.Images[1].Copies[0].Target)
+	})
+
+	t.Run("Invalid template", func(t *testing.T) {
+		plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{RenameTemplate: "{foo}"})
+		a.Equal(0, len(plan.Images))
+	})
+}
+
+func TestPlanImageCategories_CategoryRenameTemplate(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+	lib.AddImageFiles([]*apitype.ImageFile{image1})
+	a.Nil(imageMetaDataStore.AddMetaData(image1.Id(), apitype.NewImageMetaData(map[string]string{
+		"Model": "X100",
+	})))
+	good, _ := categoryStore.AddCategory(apitype.NewCategory("Good", "good", "G"))
+	category := apitype.NewCategory("Camera", "camera", "C")
+	category.SetRenameTemplate("{exif:Model}_{name}")
+	camera, _ := categoryStore.AddCategory(category)
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), good.Id(), apitype.CATEGORIZE))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), camera.Id(), apitype.CATEGORIZE))
+
+	t.Run("Category template is used instead of the default", func(t *testing.T) {
+		plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{
+			KeepOriginals:  true,
+			RenameTemplate: "renamed_{name}",
+		})
+
+		a.Equal(1, len(plan.Images))
+		a.Equal(filepath.Join(dir, "camera", "X100_image1.jpg"), plan.Images[0].Copies[0].Target)
+		a.Equal(filepath.Join(dir, "good", "renamed_image1.jpg"), plan.Images[0].Copies[1].Target)
+	})
+
+	t.Run("Category template without a default", func(t *testing.T) {
+		plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{KeepOriginals: true})
+
+		a.Equal(filepath.Join(dir, "camera", "X100_image1.jpg"), plan.Images[0].Copies[0].Target)
+		a.Equal(filepath.Join(dir, "good", "image1.jpg"), plan.Images[0].Copies[1].Target)
+	})
+}
+
+func TestPlanImageCategories_NestedCategory(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, database.NewImageMetaDataStore(memoryDatabase), StubProgressReporter{}),
+		database.NewStatusStore(memoryDatabase),
+	)
+	sut := NewImageCategoryService(sender, lib, filter.NewFilterService(), imageLoader, imageCategoryStore,
+		database.NewImageCategoryJournalStore(memoryDatabase), database.NewApplyJobStore(memoryDatabase), database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(dir, "image1.jpg"))
+	lib.AddImageFiles([]*apitype.ImageFile{image1})
+	travel, _ := categoryStore.AddCategory(apitype.NewCategory("Travel", "trips", "T"))
+	kyoto, _ := categoryStore.AddCategory(apitype.NewCategory("Travel/Japan/Kyoto", "Kyoto", "K"))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), travel.Id(), apitype.CATEGORIZE))
+	a.Nil(imageCategoryStore.CategorizeImage(image1.Id(), kyoto.Id(), apitype.CATEGORIZE))
+
+	plan := sut.PlanImageCategories(&api.PersistCategorizationCommand{KeepOriginals: true})
+
+	a.Equal(1, len(plan.Images))
+	a.Equal(2, len(plan.Images[0].Copies))
+	a.Equal(filepath.Join(dir, "trips", "Japan", "Kyoto", "image1.jpg"), plan.Images[0].Copies[0].Target)
+	a.Equal(filepath.Join(dir, "trips", "image1.jpg"), plan.Images[0].Copies[1].Target)
+}
@@ -1,12 +1,16 @@
 package imagecategory
 
 import (
+	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/backend/internal/database"
 	"vincit.fi/image-sorter/backend/internal/filter"
+	"vincit.fi/image-sorter/backend/internal/util"
 	"vincit.fi/image-sorter/common/constants"
 	"vincit.fi/image-sorter/common/logger"
 )
@@ -19,23 +23,32 @@ type Service struct {
 	filterService      *filter.FilterService
 	imageLoader        api.ImageLoader
 	imageCategoryStore *database.ImageCategoryStore
+	journalStore       *database.ImageCategoryJournalStore
+	applyJobStore      *database.ApplyJobStore
+	imageRatingStore   *database.ImageRatingStore
+	tagStore           *database.TagStore
 
 	api.ImageCategoryService
 }
 
-func NewImageCategoryService(sender api.Sender, lib api.ImageService, filterService *filter.FilterService, imageLoader api.ImageLoader, imageCategoryStore *database.ImageCategoryStore) api.ImageCategoryService {
+func NewImageCategoryService(sender api.Sender, lib api.ImageService, filterService *filter.FilterService, imageLoader api.ImageLoader, imageCategoryStore *database.ImageCategoryStore, journalStore *database.ImageCategoryJournalStore, applyJobStore *database.ApplyJobStore, imageRatingStore *database.ImageRatingStore, tagStore *database.TagStore) api.ImageCategoryService {
 	return &Service{
 		sender:             sender,
 		library:            lib,
 		filterService:      filterService,
 		imageLoader:        imageLoader,
 		imageCategoryStore: imageCategoryStore,
+		journalStore:       journalStore,
+		applyJobStore:      applyJobStore,
+		imageRatingStore:   imageRatingStore,
+		tagStore:           tagStore,
 	}
 }
 
 func (s *Service) InitializeForDirectory(directory string) {
 	s.rootDir = directory
 	s.settingDir = filepath.Join(directory, constants.ImageSorterDir)
+	s.resumeApplyJobs()
 }
 
 func (s *Service) RequestCategory(query *api.ImageCategoryQuery) {
@@ -68,6 +81,12 @@ func (s *Service) SetCategory(command *api.CategorizeCommand) {
 		return
 	}
 
+	before, err := s.getCategoryState(imageId)
+	if err != nil {
+		s.sender.SendError("Error while fetching image's category", err)
+		return
+	}
+
 	if command.ForceToCategory {
 		logger.Debug.Printf("Force to category for '%d'", imageId)
 		if err := s.imageCategoryStore.RemoveImageCategories(imageId); err != nil {
@@ -79,6 +98,22 @@ func (s *Service) SetCategory(command *api.CategorizeCommand) {
 		s.sender.SendError("Error while setting category", err)
 	}
 
+	if after, err := s.getCategoryState(imageId); err != nil {
+		s.sender.SendError("Error while fetching image's category", err)
+	} else if !isSameCategoryState(before, after) {
+		if err := s.journalStore.AddAction(&api.CategorizationAction{
+			ImageId:         imageId,
+			CategoryId:      categoryId,
+			Operation:       operation,
+			ForceToCategory: command.ForceToCategory,
+			Before:          before,
+			After:           after,
+		}); err != nil {
+			s.sender.SendError("Error while storing categorization history", err)
+		}
+		s.sender.SendToTopic(api.ImageAnnotationsUpdated)
+	}
+
 	if command.StayOnSameImage {
 		s.sendCategories(command.ImageId)
 	} else {
@@ -88,37 +123,39 @@ func (s *Service) SetCategory(command *api.CategorizeCommand) {
 	}
 }
 
+// Restores the categories of the latest categorized image to the state before the categorization
+func (s *Service) UndoCategorization() {
+	if action, err := s.journalStore.GetUndoAction(); err != nil {
+		s.sender.SendError("Error while loading categorization history", err)
+	} else if action == nil {
+		logger.Debug.Printf("Nothing to undo")
+	} else {
+		logger.Debug.Printf("Undo categorization of image %d", action.ImageId)
+		s.applyCategorizationAction(action, action.Before, true)
+	}
+}
+
+// Re-applies the latest undone categorization
+func (s *Service) RedoCategorization() {
+	if action, err := s.journalStore.GetRedoAction(); err != nil {
+		s.sender.SendError("Error while loading categorization history", err)
+	} else if action == nil {
+		logger.Debug.Printf("Nothing to redo")
+	} else {
+		logger.Debug.Printf("Redo categorization of image %d", action.ImageId)
+		s.applyCategorizationAction(action, action.After, false)
+	}
+}
+
+// Applies the categories to the files as a job. Changes are recorded
+// so that the job can be resumed if it is interrupted and rolled back later.
 func (s *Service) PersistImageCategories(options *api.PersistCategorizationCommand) {
 	logger.Debug.Printf("Persisting files to categories")
 
-	imageCategory, _ := s.imageCategoryStore.GetCategorizedImages()
-	operationsByImage := s.ResolveFileOperations(imageCategory, options, func(current int, total int) {
-		s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
-			Name:      "Resolving operations...",
-			Current:   current,
-			Total:     total,
-			CanCancel: false,
-		})
-	})
-
-	total := len(operationsByImage)
-	s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
-		Name:      "Categorizing...",
-		Current:   0,
-		Total:     total,
-		CanCancel: false,
-	})
-	for i, operationGroup := range operationsByImage {
-		err := operationGroup.Apply()
-		if err != nil {
-			s.sender.SendError("Error while applying changes", err)
-		}
-		s.sender.SendCommandToTopic(api.ProcessStatusUpdated, &api.UpdateProgressCommand{
-			Name:      "Categorizing...",
-			Current:   i + 1,
-			Total:     total,
-			CanCancel: false,
-		})
+	if job, err := s.applyJobStore.AddJob(options); err != nil {
+		s.sender.SendError("Error while starting to apply changes", err)
+	} else {
+		s.runApplyJob(job)
 	}
 
 	s.sender.SendCommandToTopic(api.DirectoryChanged, &api.DirectoryChangedCommand{Directory: s.rootDir})
@@ -131,16 +168,30 @@ func (s *Service) ResolveFileOperations(
 ) []*apitype.ImageOperationGroup {
 	var operationGroups []*apitype.ImageOperationGroup
 
-	i := 0
-	for imageId, categoryEntries := range imageCategory {
-		imageFile := s.library.GetImageFileById(imageId)
-		if imageFile.IsValid() {
-			if newOperationGroup, err := s.ResolveOperationsForGroup(imageFile, categoryEntries, options); err == nil {
-				operationGroups = append(operationGroups, newOperationGroup)
-			}
+	if options.OutputMode.IsLink() {
+		imageCategory = s.withLinkedImages(imageCategory)
+	}
+
+	// Images are resolved in the same order every time so that
+	// the sequence numbers and the renamed duplicates are stable
+	var imageFiles []*apitype.ImageFile
+	for imageId := range imageCategory {
+		if imageFile := s.library.GetImageFileById(imageId); imageFile.IsValid() {
+			imageFiles = append(imageFiles, imageFile)
+		}
+	}
+	sort.Slice(imageFiles, func(i, j int) bool {
+		return imageFiles[i].Path() < imageFiles[j].Path()
+	})
+
+	claims := newTargetClaims()
+	for i, imageFile := range imageFiles {
+		if newOperationGroup, err := s.resolveOperationsForGroup(imageFile, imageCategory[imageFile.Id()], options, claims); err != nil {
+			logger.Warn.Printf("Could not resolve operations for '%s': %s", imageFile.Path(), err)
+		} else {
+			operationGroups = append(operationGroups, newOperationGroup)
 		}
-		progressCallback(i, len(imageCategory))
-		i++
+		progressCallback(i, len(imageFiles))
 	}
 
 	return operationGroups
@@ -151,33 +202,160 @@ func (s *Service) ResolveOperationsForGroup(
 	categoryEntries map[apitype.CategoryId]*api.CategorizedImage,
 	options *api.PersistCategorizationCommand,
 ) (*apitype.ImageOperationGroup, error) {
-	dir, file := imageFile.Directory(), imageFile.FileName()
+	return s.resolveOperationsForGroup(imageFile, categoryEntries, options, newTargetClaims())
+}
+
+func (s *Service) Close() {
+	logger.Info.Print("Shutting down image category service")
+}
+
+func (s *Service) ShowOnlyCategoryImages(command *api.SelectCategoryCommand) {
+	s.sender.SendCommandToTopic(api.ImageShowOnly, command)
+}
+
+// Private API
+
+// Targets already claimed by the other images are not used again
+func (s *Service) resolveOperationsForGroup(
+	imageFile *apitype.ImageFile,
+	categoryEntries map[apitype.CategoryId]*api.CategorizedImage,
+	options *api.PersistCategorizationCommand,
+	claims *targetClaims,
+) (*apitype.ImageOperationGroup, error) {
+	subDir := imageFile.SubDirectory()
+	if options.FlattenSubDirectories {
+		subDir = ""
+	}
+
+	targets, err := s.resolveCategoryTargets(imageFile, categoryEntries, options, subDir, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.OutputMode.IsLink() {
+		return s.resolveLinkOperationsForGroup(imageFile, targets, options)
+	}
 
 	filters := s.filterService.GetFilters(imageFile.Id(), options)
+	keywords := s.getKeywords(imageFile, options)
+	rating := s.getRating(imageFile, options)
+	// Metadata is embedded to JPEG copies, so they can't be moved
+	embedsMetadata := imageFile.Format() == apitype.JPEG && (len(keywords) > 0 || (rating != nil && rating.Rating != apitype.NoRating))
 
 	var imageOperations []apitype.ImageOperation
-	for _, categorizedImage := range categoryEntries {
-		targetDirName := categorizedImage.Category.SubPath()
-		targetDir := filepath.Join(dir, targetDirName)
+	if !options.KeepOriginals && len(targets) == 1 && len(filters) == 0 && !embedsMetadata && isOnSameDevice(imageFile, targets) {
+		// Image is not modified, so it can be moved instead of copying and removing
+		for _, target := range targets {
+			imageOperations = append(imageOperations, filter.NewImageMove(target.dir, target.file, options.ConflictPolicy))
+		}
+		imageOperations = appendRatingWrite(imageOperations, rating, keywords)
+	} else {
+		for _, target := range targets {
+			for _, f := range filters {
+				imageOperations = append(imageOperations, f.Operation())
+			}
+			imageOperations = append(imageOperations, filter.NewImageCopy(target.dir, target.file, options.Quality, options.ConflictPolicy, options.CopyMethod, keywords, rating))
+		}
+		imageOperations = appendRatingWrite(imageOperations, rating, keywords)
+		if !options.KeepOriginals {
+			imageOperations = append(imageOperations, filter.NewImageRemove())
+		}
+	}
 
-		for _, f := range filters {
-			imageOperations = append(imageOperations, f.Operation())
+	return apitype.NewImageOperationGroup(imageFile, s.imageLoader.LoadImage, s.imageLoader.LoadExifData, imageOperations), nil
+}
+
+// Files can only be moved within a device. Between devices they are copied and removed.
+func isOnSameDevice(imageFile *apitype.ImageFile, targets map[apitype.CategoryId]*categoryTarget) bool {
+	for _, target := range targets {
+		if sameDevice, err := util.IsSameDevice(imageFile.Directory(), target.dir); err != nil {
+			logger.Warn.Printf("Could not compare the devices of '%s' and '%s': %s", imageFile.Directory(), target.dir, err)
+			return false
+		} else if !sameDevice {
+			return false
 		}
-		imageOperations = append(imageOperations, filter.NewImageCopy(targetDir, file, options.Quality))
 	}
-	if !options.KeepOriginals {
-		imageOperations = append(imageOperations, filter.NewImageRemove())
+	return true
+}
+
+// Rating and keywords are written after the image has been copied to all the categories
+func appendRatingWrite(imageOperations []apitype.ImageOperation, rating *apitype.ImageRating, keywords []string) []apitype.ImageOperation {
+	if len(imageOperations) == 0 {
+		return imageOperations
+	} else if rating != nil || keywords != nil {
+		return append(imageOperations, filter.NewRatingWrite(rating, keywords))
 	}
+	return imageOperations
+}
 
-	return apitype.NewImageOperationGroup(imageFile, s.imageLoader.LoadImage, s.imageLoader.LoadExifData, imageOperations), nil
+// Nil if the rating is not written or the image hasn't been rated
+func (s *Service) getRating(imageFile *apitype.ImageFile, options *api.PersistCategorizationCommand) *apitype.ImageRating {
+	if !options.WriteRatings {
+		return nil
+	} else if rating, err := s.imageRatingStore.GetRating(imageFile.Id()); err != nil {
+		logger.Warn.Printf("Could not read rating of '%s': %s", imageFile.Path(), err)
+		return nil
+	} else if rating.IsSet() {
+		return rating
+	} else {
+		return nil
+	}
 }
 
-func (s *Service) Close() {
-	logger.Info.Print("Shutting down image category service")
+// Nil if the tags are not written or the image doesn't have any
+func (s *Service) getKeywords(imageFile *apitype.ImageFile, options *api.PersistCategorizationCommand) []string {
+	if !options.WriteTags {
+		return nil
+	} else if tags, err := s.tagStore.GetImageTags(imageFile.Id()); err != nil {
+		logger.Warn.Printf("Could not read tags of '%s': %s", imageFile.Path(), err)
+		return nil
+	} else if len(tags) > 0 {
+		return tags
+	} else {
+		return nil
+	}
 }
 
-func (s *Service) ShowOnlyCategoryImages(command *api.SelectCategoryCommand) {
-	s.sender.SendCommandToTopic(api.ImageShowOnly, command)
+func (s *Service) applyCategorizationAction(action *api.CategorizationAction, state api.ImageCategoryState, undone bool) {
+	if err := s.imageCategoryStore.SetImageCategories(action.ImageId, state); err != nil {
+		s.sender.SendError("Error while setting category", err)
+	} else if err := s.journalStore.SetUndone(action.Id, undone); err != nil {
+		s.sender.SendError("Error while storing categorization history", err)
+	} else {
+		// Show the image so that the user can see what was changed
+		s.sender.SendCommandToTopic(api.ImageRequest, &api.ImageQuery{Id: action.ImageId})
+		s.sendCategories(action.ImageId)
+		s.sender.SendToTopic(api.ImageAnnotationsUpdated)
+	}
+}
+
+func (s *Service) getCategoryState(imageId apitype.ImageId) (api.ImageCategoryState, error) {
+	if categories, err := s.imageCategoryStore.GetImagesCategories(imageId); err != nil {
+		return nil, err
+	} else {
+		state := api.ImageCategoryState{}
+		for _, categorizedImage := range categories {
+			state[categorizedImage.Category.Id()] = categorizedImage.Operation
+		}
+		return state, nil
+	}
+}
+
+// Describes what was done to the image, e.g. "IMG_1.jpg: copied to '/photos/Good/IMG_1.jpg', original removed"
+func describeResults(operationGroup *apitype.ImageOperationGroup) string {
+	return fmt.Sprintf("%s: %s", operationGroup.ImageFile().FileName(), strings.Join(operationGroup.Results(), ", "))
+}
+
+func isSameCategoryState(a api.ImageCategoryState, b api.ImageCategoryState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for categoryId, operation := range a {
+		if other, ok := b[categoryId]; !ok || other != operation {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *Service) getCategories(imageId apitype.ImageId) []*api.CategorizedImage {
@@ -47,6 +47,7 @@ func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile
 		Id:              0,
 		Name:            imageFile.FileName(),
 		FileName:        imageFile.FileName(),
+		RelativePath:    imageFile.RelativePath(),
 		ByteSize:        1234,
 		ExifOrientation: 1,
 		ImageAngle:      90,
@@ -108,6 +109,7 @@ func TestCategorizeOne(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -116,8 +118,10 @@ func TestCategorizeOne(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -140,6 +144,7 @@ func TestCategorizeOne_InvalidImageId(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -148,8 +153,10 @@ func TestCategorizeOne_InvalidImageId(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	_, _ = imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -170,6 +177,7 @@ func TestCategorizeOne_InvalidCategoryId(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -178,8 +186,10 @@ func TestCategorizeOne_InvalidCategoryId(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	_, _ = categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -200,6 +210,7 @@ func TestCategorizeOneToTwoCategories(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -208,8 +219,10 @@ func TestCategorizeOneToTwoCategories(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -232,6 +245,7 @@ func TestCategorizeOneRemoveCategory(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -240,8 +254,10 @@ func TestCategorizeOneRemoveCategory(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
 	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 2", "c2", "D"))
@@ -262,6 +278,7 @@ func TestCategorizeOneRemoveAll(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -270,8 +287,10 @@ func TestCategorizeOneRemoveAll(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
 	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 2", "c2", "D"))
@@ -293,6 +312,7 @@ func TestCategorizeForceToCategory(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -301,8 +321,10 @@ func TestCategorizeForceToCategory(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -331,6 +353,7 @@ func TestCategorizeForceToExistingCategory(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -339,8 +362,10 @@ func TestCategorizeForceToExistingCategory(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -361,6 +386,7 @@ func TestCategorizeForceToCategory_None(t *testing.T) {
 
 	sender := new(MockSender)
 	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
 	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
 	lib := new(MockLibrary)
 	filterService := filter.NewFilterService()
@@ -369,8 +395,10 @@ func TestCategorizeForceToCategory_None(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
 	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
@@ -386,6 +414,101 @@ func TestCategorizeForceToCategory_None(t *testing.T) {
 	a.Equal(0, len(result))
 }
 
+//// Undo and redo
+
+func TestUndoRedoForceToCategory(t *testing.T) {
+	a := assert.New(t)
+
+	sender := new(MockSender)
+	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
+	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
+	sender.On("SendCommandToTopic", api.ImageRequest, mock.Anything).Return()
+	lib := new(MockLibrary)
+	filterService := filter.NewFilterService()
+	imageLoader := new(MockImageLoader)
+	memoryDatabase := database.NewInMemoryDatabase("")
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
+	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 2", "c2", "D"))
+	cat3, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 3", "c3", "E"))
+	sut.SetCategory(&api.CategorizeCommand{ImageId: imageFile.Id(), CategoryId: cat1.Id(), Operation: apitype.CATEGORIZE})
+	sut.SetCategory(&api.CategorizeCommand{ImageId: imageFile.Id(), CategoryId: cat2.Id(), Operation: apitype.CATEGORIZE})
+	sut.SetCategory(&api.CategorizeCommand{ImageId: imageFile.Id(), CategoryId: cat3.Id(), Operation: apitype.CATEGORIZE, ForceToCategory: true})
+
+	query := &api.ImageCategoryQuery{ImageId: imageFile.Id()}
+	a.Equal(1, len(sut.GetCategories(query)))
+
+	sut.UndoCategorization()
+	result := sut.GetCategories(query)
+	if a.Equal(2, len(result)) {
+		a.Equal("Cat 1", result[cat1.Id()].Category.Name())
+		a.Equal("Cat 2", result[cat2.Id()].Category.Name())
+	}
+	sender.AssertCalled(t, "SendCommandToTopic", api.ImageRequest, &api.ImageQuery{Id: imageFile.Id()})
+
+	sut.UndoCategorization()
+	sut.UndoCategorization()
+	a.Equal(0, len(sut.GetCategories(query)))
+
+	// Nothing more to undo
+	sut.UndoCategorization()
+	a.Equal(0, len(sut.GetCategories(query)))
+
+	sut.RedoCategorization()
+	sut.RedoCategorization()
+	a.Equal(2, len(sut.GetCategories(query)))
+
+	sut.RedoCategorization()
+	result = sut.GetCategories(query)
+	if a.Equal(1, len(result)) {
+		a.Equal("Cat 3", result[cat3.Id()].Category.Name())
+	}
+}
+
+func TestUndo_NewCategorizationClearsRedo(t *testing.T) {
+	a := assert.New(t)
+
+	sender := new(MockSender)
+	sender.On("SendToTopic", api.ImageRequestNext).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
+	sender.On("SendCommandToTopic", api.CategoryImageUpdate, mock.Anything).Return()
+	sender.On("SendCommandToTopic", api.ImageRequest, mock.Anything).Return()
+	lib := new(MockLibrary)
+	filterService := filter.NewFilterService()
+	imageLoader := new(MockImageLoader)
+	memoryDatabase := database.NewInMemoryDatabase("")
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("/tmp", "foo"))
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 1", "c1", "C"))
+	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("Cat 2", "c2", "D"))
+	sut.SetCategory(&api.CategorizeCommand{ImageId: imageFile.Id(), CategoryId: cat1.Id(), Operation: apitype.CATEGORIZE})
+	sut.UndoCategorization()
+	sut.SetCategory(&api.CategorizeCommand{ImageId: imageFile.Id(), CategoryId: cat2.Id(), Operation: apitype.CATEGORIZE})
+
+	sut.RedoCategorization()
+
+	result := sut.GetCategories(&api.ImageCategoryQuery{ImageId: imageFile.Id()})
+	if a.Equal(1, len(result)) {
+		a.Equal("Cat 2", result[cat2.Id()].Category.Name())
+	}
+}
+
 func TestResolveFileOperations(t *testing.T) {
 	a := require.New(t)
 
@@ -398,6 +521,8 @@ func TestResolveFileOperations(t *testing.T) {
 	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
 	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 	statusStore := database.NewStatusStore(memoryDatabase)
 	lib := library.NewImageService(
 		sender,
@@ -406,7 +531,7 @@ func TestResolveFileOperations(t *testing.T) {
 	)
 	filterService := filter.NewFilterService()
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", "filename"))
 	lib.AddImageFiles([]*apitype.ImageFile{imageFile})
 
@@ -443,6 +568,8 @@ func TestResolveOperationsForGroup_KeepOld(t *testing.T) {
 	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 	statusStore := database.NewStatusStore(memoryDatabase)
 	lib := library.NewImageService(
 		sender,
@@ -451,7 +578,7 @@ func TestResolveOperationsForGroup_KeepOld(t *testing.T) {
 	)
 	filterService := filter.NewFilterService()
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", "filename"))
 	cat, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "cat_1", ""))
@@ -471,6 +598,65 @@ func TestResolveOperationsForGroup_KeepOld(t *testing.T) {
 	a.Equal(fmt.Sprintf("Copy file 'filename' to '%s'", filepath.Join("filepath", "cat_1")), ops[0].String())
 }
 
+func TestResolveOperationsForGroup_SubDirectory(t *testing.T) {
+	a := require.New(t)
+
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	imageLoader.On("LoadImage", api.ImageRequestNext).Return(nil, nil)
+	memoryDatabase := database.NewInMemoryDatabase("filepath")
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+	statusStore := database.NewStatusStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		statusStore,
+	)
+	filterService := filter.NewFilterService()
+
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", filepath.Join("sub", "filename")))
+	cat, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "cat_1", ""))
+	_ = imageCategoryStore.CategorizeImage(imageFile.Id(), cat.Id(), apitype.CATEGORIZE)
+	imageCategories, _ := imageCategoryStore.GetCategorizedImages()
+
+	t.Run("Preserve sub directory", func(t *testing.T) {
+		command := &api.PersistCategorizationCommand{
+			KeepOriginals:  true,
+			FixOrientation: false,
+			Quality:        100,
+		}
+		operations, err := sut.ResolveOperationsForGroup(imageFile, imageCategories[imageFile.Id()], command)
+
+		a.Nil(err)
+		ops := operations.Operations()
+		a.Equal(1, len(ops))
+		a.Equal(fmt.Sprintf("Copy file 'filename' to '%s'", filepath.Join("filepath", "cat_1", "sub")), ops[0].String())
+	})
+
+	t.Run("Flatten sub directory", func(t *testing.T) {
+		command := &api.PersistCategorizationCommand{
+			KeepOriginals:         true,
+			FixOrientation:        false,
+			Quality:               100,
+			FlattenSubDirectories: true,
+		}
+		operations, err := sut.ResolveOperationsForGroup(imageFile, imageCategories[imageFile.Id()], command)
+
+		a.Nil(err)
+		ops := operations.Operations()
+		a.Equal(1, len(ops))
+		a.Equal(fmt.Sprintf("Copy file 'filename' to '%s'", filepath.Join("filepath", "cat_1")), ops[0].String())
+	})
+}
+
 func TestResolveOperationsForGroup_RemoveOld(t *testing.T) {
 	a := require.New(t)
 
@@ -483,6 +669,8 @@ func TestResolveOperationsForGroup_RemoveOld(t *testing.T) {
 	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 	statusStore := database.NewStatusStore(memoryDatabase)
 	lib := library.NewImageService(
 		sender,
@@ -491,7 +679,7 @@ func TestResolveOperationsForGroup_RemoveOld(t *testing.T) {
 	)
 	filterService := filter.NewFilterService()
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", "filename"))
 	cat, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "cat_1", ""))
@@ -505,13 +693,102 @@ func TestResolveOperationsForGroup_RemoveOld(t *testing.T) {
 	}
 	operations, err := sut.ResolveOperationsForGroup(imageFile, imageCategories[imageFile.Id()], command)
 
+	a.Nil(err)
+	ops := operations.Operations()
+	a.Equal(1, len(ops))
+	a.Equal(fmt.Sprintf("Move file 'filename' to '%s'", filepath.Join("filepath", "cat_1")), ops[0].String())
+}
+
+func TestResolveOperationsForGroup_RemoveOld_OtherDevice(t *testing.T) {
+	a := require.New(t)
+
+	dir := t.TempDir()
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	imageLoader.On("LoadImage", api.ImageRequestNext).Return(nil, nil)
+	memoryDatabase := database.NewInMemoryDatabase(dir)
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+	statusStore := database.NewStatusStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		statusStore,
+	)
+	filterService := filter.NewFilterService()
+
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	imageFile, _ := imageStore.AddImage(apitype.NewImageFile(dir, "filename"))
+	// Only planned, so nothing is written to /proc
+	cat, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "/proc/cat_1", ""))
+	_ = imageCategoryStore.CategorizeImage(imageFile.Id(), cat.Id(), apitype.CATEGORIZE)
+	imageCategories, _ := imageCategoryStore.GetCategorizedImages()
+
+	command := &api.PersistCategorizationCommand{
+		KeepOriginals: false,
+		Quality:       100,
+	}
+	operations, err := sut.ResolveOperationsForGroup(imageFile, imageCategories[imageFile.Id()], command)
+
 	a.Nil(err)
 	ops := operations.Operations()
 	a.Equal(2, len(ops))
-	a.Equal(fmt.Sprintf("Copy file 'filename' to '%s'", filepath.Join("filepath", "cat_1")), ops[0].String())
+	a.Equal("Copy file 'filename' to '/proc/cat_1'", ops[0].String())
 	a.Equal("Remove", ops[1].String())
 }
 
+func TestResolveOperationsForGroup_RemoveOld_ManyCategories(t *testing.T) {
+	a := require.New(t)
+
+	sender := new(MockSender)
+	imageCache := new(MockImageCache)
+	imageLoader := new(MockImageLoader)
+	imageLoader.On("LoadImage", api.ImageRequestNext).Return(nil, nil)
+	memoryDatabase := database.NewInMemoryDatabase("filepath")
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
+	statusStore := database.NewStatusStore(memoryDatabase)
+	lib := library.NewImageService(
+		sender,
+		library.NewImageLibrary(imageCache, imageLoader, nil, imageStore, imageMetaDataStore, StubProgressReporter{}),
+		statusStore,
+	)
+	filterService := filter.NewFilterService()
+
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
+
+	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", "filename"))
+	cat1, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "cat_1", ""))
+	cat2, _ := categoryStore.AddCategory(apitype.NewCategory("cat2", "cat_2", ""))
+	_ = imageCategoryStore.CategorizeImage(imageFile.Id(), cat1.Id(), apitype.CATEGORIZE)
+	_ = imageCategoryStore.CategorizeImage(imageFile.Id(), cat2.Id(), apitype.CATEGORIZE)
+	imageCategories, _ := imageCategoryStore.GetCategorizedImages()
+
+	command := &api.PersistCategorizationCommand{
+		KeepOriginals:  false,
+		FixOrientation: false,
+		Quality:        100,
+	}
+	operations, err := sut.ResolveOperationsForGroup(imageFile, imageCategories[imageFile.Id()], command)
+
+	a.Nil(err)
+	ops := operations.Operations()
+	a.Equal(3, len(ops))
+	a.Contains(ops[0].String(), "Copy file 'filename'")
+	a.Contains(ops[1].String(), "Copy file 'filename'")
+	a.Equal("Remove", ops[2].String())
+}
+
 func TestResolveOperationsForGroup_FixExifRotation(t *testing.T) {
 	a := require.New(t)
 
@@ -524,6 +801,8 @@ func TestResolveOperationsForGroup_FixExifRotation(t *testing.T) {
 	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 	statusStore := database.NewStatusStore(memoryDatabase)
 	lib := library.NewImageService(
 		sender,
@@ -532,7 +811,7 @@ func TestResolveOperationsForGroup_FixExifRotation(t *testing.T) {
 	)
 	filterService := filter.NewFilterService()
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", "filename"))
 	cat, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "cat_1", ""))
@@ -565,6 +844,8 @@ func TestResolveOperationsForGroup_FixExifRotation_RemoveOld(t *testing.T) {
 	imageMetaDataStore := database.NewImageMetaDataStore(memoryDatabase)
 	categoryStore := database.NewCategoryStore(memoryDatabase)
 	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	journalStore := database.NewImageCategoryJournalStore(memoryDatabase)
+	applyJobStore := database.NewApplyJobStore(memoryDatabase)
 	statusStore := database.NewStatusStore(memoryDatabase)
 	lib := library.NewImageService(
 		sender,
@@ -573,7 +854,7 @@ func TestResolveOperationsForGroup_FixExifRotation_RemoveOld(t *testing.T) {
 	)
 	filterService := filter.NewFilterService()
 
-	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore)
+	sut := NewImageCategoryService(sender, lib, filterService, imageLoader, imageCategoryStore, journalStore, applyJobStore, database.NewImageRatingStore(memoryDatabase), database.NewTagStore(memoryDatabase))
 
 	imageFile, _ := imageStore.AddImage(apitype.NewImageFile("filepath", "filename"))
 	cat, _ := categoryStore.AddCategory(apitype.NewCategory("cat1", "cat_1", ""))
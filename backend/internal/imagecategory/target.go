@@ -0,0 +1,165 @@
+package imagecategory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/util"
+)
+
+// Where the image is written in a category
+type categoryTarget struct {
+	dir  string
+	file string
+}
+
+func (s *categoryTarget) path() string {
+	return filepath.Join(s.dir, s.file)
+}
+
+// Targets claimed during one apply and the next sequence number of each
+// target directory. Sequence numbers are counted per run instead of probing
+// the existing files so that the same images get the same numbers every time.
+type targetClaims struct {
+	paths     map[string]bool
+	sequences map[string]int
+}
+
+func newTargetClaims() *targetClaims {
+	return &targetClaims{
+		paths:     map[string]bool{},
+		sequences: map[string]int{},
+	}
+}
+
+func (s *targetClaims) nextSequence(dir string) int {
+	if sequence, ok := s.sequences[dir]; ok {
+		return sequence
+	}
+	return 1
+}
+
+// Private API
+
+// Resolves the target of the image in each category. Rename template of the category is
+// used instead of the one in the options. Meta data and the content hash are only loaded
+// if the paths use them. Targets are claimed so that two images
+// are never written to the same file during the same apply. Existing files
+// are left for the conflict policy of the operations.
+func (s *Service) resolveCategoryTargets(
+	imageFile *apitype.ImageFile,
+	categoryEntries map[apitype.CategoryId]*api.CategorizedImage,
+	options *api.PersistCategorizationCommand,
+	subDir string,
+	claims *targetClaims,
+) (map[apitype.CategoryId]*categoryTarget, error) {
+	defaultRenameTemplate, err := apitype.ParseFileNameTemplate(options.RenameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var categoryIds []apitype.CategoryId
+	for categoryId := range categoryEntries {
+		categoryIds = append(categoryIds, categoryId)
+	}
+	sort.Slice(categoryIds, func(i, j int) bool {
+		return categoryIds[i] < categoryIds[j]
+	})
+
+	values := &apitype.PathTemplateValues{ImageFile: imageFile}
+	targets := map[apitype.CategoryId]*categoryTarget{}
+	for _, categoryId := range categoryIds {
+		category := categoryEntries[categoryId].Category
+		template, err := apitype.ParsePathTemplate(category.Path())
+		if err != nil {
+			return nil, fmt.Errorf("invalid path for category '%s': %s", category.FullName(), err)
+		}
+		renameTemplate := defaultRenameTemplate
+		if category.RenameTemplate() != "" {
+			if renameTemplate, err = apitype.ParseFileNameTemplate(category.RenameTemplate()); err != nil {
+				return nil, fmt.Errorf("invalid rename template for category '%s': %s", category.FullName(), err)
+			}
+		}
+		if err := s.loadTemplateValues(values, template, renameTemplate); err != nil {
+			return nil, err
+		}
+
+		values.Category = category
+		values.Sequence = 1
+		target, err := resolveCategoryTarget(values, renameTemplate, subDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if template.UsesSequence() || (renameTemplate != nil && renameTemplate.UsesSequence() && !template.NamesFile()) {
+			// Next number in the directory during this apply. The directory is
+			// identified by the target of the first number in case it uses the sequence too.
+			sequenceDir := target.dir
+			values.Sequence = claims.nextSequence(sequenceDir)
+			if target, err = resolveCategoryTarget(values, renameTemplate, subDir); err != nil {
+				return nil, err
+			}
+			for claims.paths[target.path()] {
+				values.Sequence++
+				if target, err = resolveCategoryTarget(values, renameTemplate, subDir); err != nil {
+					return nil, err
+				}
+			}
+			claims.sequences[sequenceDir] = values.Sequence + 1
+		} else if claims.paths[target.path()] {
+			// Another image was renamed to the same name
+			fileName := target.file
+			for i := 1; isTargetTaken(target.path(), imageFile, claims.paths); i++ {
+				target.file = util.FileNameWithSuffix(fileName, fmt.Sprint(i))
+			}
+		}
+
+		claims.paths[target.path()] = true
+		targets[categoryId] = target
+	}
+	return targets, nil
+}
+
+func (s *Service) loadTemplateValues(values *apitype.PathTemplateValues, templates ...*apitype.PathTemplate) error {
+	for _, template := range templates {
+		if template == nil {
+			continue
+		}
+		if values.MetaData == nil && template.UsesMetaData() {
+			values.MetaData = s.library.GetImageMetaData(values.ImageFile.Id())
+		}
+		if values.Hash == "" && template.UsesHash() {
+			if hash, err := util.FileContentHash(values.ImageFile.Path()); err != nil {
+				return err
+			} else {
+				values.Hash = hash
+			}
+		}
+	}
+	return nil
+}
+
+func resolveCategoryTarget(values *apitype.PathTemplateValues, renameTemplate *apitype.PathTemplate, subDir string) (*categoryTarget, error) {
+	if dir, file, err := apitype.ResolveCategoryTarget(values, renameTemplate, subDir); err != nil {
+		return nil, err
+	} else {
+		return &categoryTarget{dir: dir, file: file}, nil
+	}
+}
+
+// Target is taken if another image has claimed it or a file other than
+// the image itself (e.g. a link to it) already exists there
+func isTargetTaken(path string, imageFile *apitype.ImageFile, claimedTargets map[string]bool) bool {
+	if claimedTargets[path] {
+		return true
+	} else if stat, err := os.Stat(path); err != nil {
+		return util.DoesPathExist(path)
+	} else if imageStat, err := os.Stat(imageFile.Path()); err != nil {
+		return true
+	} else {
+		return !os.SameFile(stat, imageStat)
+	}
+}
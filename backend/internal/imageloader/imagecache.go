@@ -1,6 +1,7 @@
 package imageloader
 
 import (
+	"container/list"
 	"image"
 	"runtime"
 	"sync"
@@ -10,12 +11,26 @@ import (
 	"vincit.fi/image-sorter/common/logger"
 )
 
+// Full and scaled images are kept in memory until they don't fit in the max byte size.
+// Then the least recently used ones are purged. Thumbnails are always kept.
 type DefaultImageStore struct {
-	imageCache    map[apitype.ImageId]*Instance
-	mux           sync.Mutex
-	imageLoader   api.ImageLoader
-	stopChannel   chan bool
-	outputChannel chan *Instance
+	imageCache     map[apitype.ImageId]*Instance
+	mux            sync.Mutex
+	imageLoader    api.ImageLoader
+	thumbnailCache api.ThumbnailCache
+	stopChannel    chan bool
+	outputChannel  chan *Instance
+
+	maxByteSize uint64
+	// IDs of the images that have full or scaled image loaded, most recently used first
+	loadedImages   *list.List
+	loadedElements map[apitype.ImageId]*list.Element
+	loadedSizes    map[apitype.ImageId]uint64
+	// Image that was requested last is never purged to make room for the other images
+	currentImageId apitype.ImageId
+	initialized    bool
+	// Incremented on every prefetch so that the older prefetches stop
+	prefetchGeneration int
 
 	api.ImageStore
 }
@@ -74,6 +89,8 @@ func (s *DefaultImageStore) Initialize(imageFiles []*apitype.ImageFile, reporter
 		avg := totalTime / time.Duration(numOfImages)
 		logger.Info.Printf("All %d instances loaded in cache in %s (avg. %s)", numOfImages, totalTime.String(), avg.String())
 
+		s.pruneThumbnails(imageFiles)
+
 		runtime.GC()
 	}()
 }
@@ -82,12 +99,19 @@ func (s *DefaultImageStore) initializeCache() {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	s.imageCache = map[apitype.ImageId]*Instance{}
+	s.loadedImages.Init()
+	s.loadedElements = map[apitype.ImageId]*list.Element{}
+	s.loadedSizes = map[apitype.ImageId]uint64{}
+	s.initialized = true
 }
 
+// Images that have been requested while the cache is initialized are kept
 func (s *DefaultImageStore) addImageToCache(instance *Instance) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.imageCache[instance.imageId] = instance
+	if _, ok := s.imageCache[instance.imageId]; !ok {
+		s.imageCache[instance.imageId] = instance
+	}
 }
 
 func (s *DefaultImageStore) loadImageInstance(inputChannel chan *apitype.ImageFile, outputChannel chan *Instance, quitChannel chan bool) {
@@ -97,28 +121,88 @@ func (s *DefaultImageStore) loadImageInstance(inputChannel chan *apitype.ImageFi
 			return
 		case imageFile := <-inputChannel:
 			{
-				outputChannel <- NewInstance(imageFile.Id(), s.imageLoader)
+				outputChannel <- s.getImage(imageFile.Id())
 			}
 		}
 	}
 }
 
-func NewImageCache(imageLoader api.ImageLoader) api.ImageStore {
+// Thumbnail cache may be nil in which case the thumbnails are always decoded from the images.
+// Max byte size limits the memory used by the full and scaled images.
+func NewImageCache(imageLoader api.ImageLoader, thumbnailCache api.ThumbnailCache, maxByteSize uint64) api.ImageStore {
 	logger.Debug.Printf("Initialize image cache...")
 	imageCache := &DefaultImageStore{
-		imageCache:  map[apitype.ImageId]*Instance{},
-		mux:         sync.Mutex{},
-		imageLoader: imageLoader,
+		imageCache:     map[apitype.ImageId]*Instance{},
+		mux:            sync.Mutex{},
+		imageLoader:    imageLoader,
+		thumbnailCache: thumbnailCache,
+		maxByteSize:    maxByteSize,
+		loadedImages:   list.New(),
+		loadedElements: map[apitype.ImageId]*list.Element{},
+		loadedSizes:    map[apitype.ImageId]uint64{},
+		currentImageId: apitype.NoImage,
 	}
 	logger.Debug.Printf("Image cache initialized")
 	return imageCache
 }
 
+// Thumbnails of the removed and modified images are removed once all the thumbnails are in the cache
+func (s *DefaultImageStore) pruneThumbnails(imageFiles []*apitype.ImageFile) {
+	if s.thumbnailCache == nil || len(imageFiles) == 0 {
+		return
+	}
+	if _, _, err := s.thumbnailCache.Prune(imageFiles[0].RootDirectory(), imageFiles); err != nil {
+		logger.Warn.Printf("Could not prune thumbnail cache: %s", err)
+	}
+}
+
 func (s *DefaultImageStore) GetFull(imageId apitype.ImageId) (image.Image, error) {
-	return s.getImage(imageId).GetFull()
+	full, err := s.getImage(imageId).GetFull()
+	s.imageLoaded(imageId)
+	return full, err
 }
 func (s *DefaultImageStore) GetScaled(imageId apitype.ImageId, size apitype.Size) (image.Image, error) {
-	return s.getImage(imageId).GetScaled(size)
+	scaled, err := s.getImage(imageId).GetScaled(size)
+	s.imageLoaded(imageId)
+	return scaled, err
+}
+
+// Loads the full images in the background in the given order so that they are ready when they are
+// shown. Stops when the cache is full or when prefetch is called again. Does nothing until the cache
+// has been initialized since the images are only needed when they are shown.
+func (s *DefaultImageStore) Prefetch(imageIds []apitype.ImageId) {
+	s.mux.Lock()
+	s.prefetchGeneration++
+	generation := s.prefetchGeneration
+	initialized := s.initialized
+	s.mux.Unlock()
+
+	if !initialized || len(imageIds) == 0 {
+		return
+	}
+
+	go func() {
+		keep := map[apitype.ImageId]bool{}
+		for _, imageId := range imageIds {
+			keep[imageId] = true
+		}
+
+		for _, imageId := range imageIds {
+			if s.isPrefetchCancelled(generation) {
+				return
+			}
+			instance := s.getImage(imageId)
+			if _, err := instance.GetFull(); err != nil {
+				logger.Warn.Printf("Could not prefetch image %d: %s", imageId, err)
+				continue
+			}
+			if !s.addLoadedImage(instance, keep) {
+				logger.Debug.Printf("Image cache is full, stop prefetching at image %d", imageId)
+				return
+			}
+			logger.Trace.Printf("Prefetched image %d", imageId)
+		}
+	}()
 }
 func (s *DefaultImageStore) GetThumbnail(imageId apitype.ImageId) (image.Image, error) {
 	return s.getImage(imageId).GetThumbnail()
@@ -127,20 +211,28 @@ func (s *DefaultImageStore) GetExifData(imageId apitype.ImageId) *apitype.ExifDa
 	return nil
 }
 
+// New instance is created without locking the cache, because it loads the thumbnail and
+// the thumbnails should be shown while the cache is still being initialized
 func (s *DefaultImageStore) getImage(imageId apitype.ImageId) *Instance {
+	if imageId == apitype.NoImage {
+		return &emptyInstance
+	}
+
+	s.mux.Lock()
+	existingInstance, ok := s.imageCache[imageId]
+	s.mux.Unlock()
+	if ok {
+		return existingInstance
+	}
+
+	instance := NewInstance(imageId, s.imageLoader, s.thumbnailCache)
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	if imageId != apitype.NoImage {
-		if existingInstance, ok := s.imageCache[imageId]; !ok {
-			instance := NewInstance(imageId, s.imageLoader)
-			s.imageCache[imageId] = instance
-			return instance
-		} else {
-			return existingInstance
-		}
-	} else {
-		return &emptyInstance
+	if existingInstance, ok := s.imageCache[imageId]; ok {
+		return existingInstance
 	}
+	s.imageCache[imageId] = instance
+	return instance
 }
 
 func (s *DefaultImageStore) Purge() {
@@ -149,9 +241,23 @@ func (s *DefaultImageStore) Purge() {
 	for _, instance := range s.imageCache {
 		instance.Purge()
 	}
+	s.loadedImages.Init()
+	s.loadedElements = map[apitype.ImageId]*list.Element{}
+	s.loadedSizes = map[apitype.ImageId]uint64{}
 	runtime.GC()
 }
 
+func (s *DefaultImageStore) Invalidate(imageIds []apitype.ImageId) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, imageId := range imageIds {
+		if _, ok := s.loadedElements[imageId]; ok {
+			s.removeLoadedImage(imageId)
+		}
+		delete(s.imageCache, imageId)
+	}
+}
+
 func (s *DefaultImageStore) GetByteSize() (byteSize uint64) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
@@ -164,3 +270,76 @@ func (s *DefaultImageStore) GetByteSize() (byteSize uint64) {
 func (s *DefaultImageStore) GetSizeInMB() (mbSize float64) {
 	return float64(s.GetByteSize()) / (1024 * 1024)
 }
+
+// Private API
+
+// Requested image becomes the current image
+func (s *DefaultImageStore) imageLoaded(imageId apitype.ImageId) {
+	if imageId == apitype.NoImage {
+		return
+	}
+	s.mux.Lock()
+	s.currentImageId = imageId
+	s.mux.Unlock()
+	s.addLoadedImage(s.getImage(imageId), map[apitype.ImageId]bool{imageId: true})
+}
+
+// Marks the image as the most recently used and purges the least recently used images that
+// are not kept until the images fit in the max byte size. If they still don't fit, the added
+// image is purged too (unless it is the current image) and false is returned.
+// Sizes are stored when the images are added so that the instances don't have to be locked
+// while the cache is locked.
+func (s *DefaultImageStore) addLoadedImage(instance *Instance, keep map[apitype.ImageId]bool) bool {
+	imageId := instance.imageId
+	loadedSize := uint64(instance.GetLoadedByteLength())
+
+	s.mux.Lock()
+	if element, ok := s.loadedElements[imageId]; ok {
+		s.loadedImages.MoveToFront(element)
+	} else {
+		s.loadedElements[imageId] = s.loadedImages.PushFront(imageId)
+	}
+	s.loadedSizes[imageId] = loadedSize
+
+	var byteSize uint64
+	for _, size := range s.loadedSizes {
+		byteSize += size
+	}
+
+	var purged []*Instance
+	for element := s.loadedImages.Back(); element != nil && byteSize > s.maxByteSize; {
+		previous := element.Prev()
+		loadedImageId := element.Value.(apitype.ImageId)
+		if !keep[loadedImageId] && loadedImageId != s.currentImageId {
+			byteSize -= s.loadedSizes[loadedImageId]
+			purged = append(purged, s.imageCache[loadedImageId])
+			s.removeLoadedImage(loadedImageId)
+		}
+		element = previous
+	}
+
+	fits := byteSize <= s.maxByteSize
+	if !fits && imageId != s.currentImageId {
+		purged = append(purged, instance)
+		s.removeLoadedImage(imageId)
+	}
+	s.mux.Unlock()
+
+	for _, purgedInstance := range purged {
+		logger.Trace.Printf("Purge image %d from cache", purgedInstance.imageId)
+		purgedInstance.Purge()
+	}
+	return fits
+}
+
+func (s *DefaultImageStore) removeLoadedImage(imageId apitype.ImageId) {
+	s.loadedImages.Remove(s.loadedElements[imageId])
+	delete(s.loadedElements, imageId)
+	delete(s.loadedSizes, imageId)
+}
+
+func (s *DefaultImageStore) isPrefetchCancelled(generation int) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.prefetchGeneration != generation
+}
@@ -1,7 +1,9 @@
 package imageloader
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"os"
 	"testing"
 	"time"
@@ -10,6 +12,8 @@ import (
 	"vincit.fi/image-sorter/backend/internal/database"
 )
 
+const testImageCacheSize = 1024 * 1024 * 1024
+
 type StubImageFileConverter struct {
 	database.ImageFileConverter
 }
@@ -20,6 +24,7 @@ func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile
 		Id:              0,
 		Name:            imageFile.FileName(),
 		FileName:        imageFile.FileName(),
+		RelativePath:    imageFile.RelativePath(),
 		ByteSize:        1234,
 		ExifOrientation: 1,
 		ImageAngle:      90,
@@ -66,7 +71,7 @@ func TestDefaultImageStore_Initialize(t *testing.T) {
 	imageStore := database.NewImageStore(db, &StubImageFileConverter{})
 
 	loader := NewImageLoader(imageStore)
-	cache := NewImageCache(loader)
+	cache := NewImageCache(loader, nil, testImageCacheSize)
 
 	a.Equal(0, int(cache.GetByteSize()))
 
@@ -107,7 +112,7 @@ func TestDefaultImageStore_Purge(t *testing.T) {
 	imageStore := database.NewImageStore(db, &StubImageFileConverter{})
 
 	loader := NewImageLoader(imageStore)
-	cache := NewImageCache(loader)
+	cache := NewImageCache(loader, nil, testImageCacheSize)
 
 	a.Equal(uint64(0), cache.GetByteSize())
 
@@ -151,7 +156,7 @@ func TestDefaultImageStore_GetFull(t *testing.T) {
 	imageStore := database.NewImageStore(db, &StubImageFileConverter{})
 
 	loader := NewImageLoader(imageStore)
-	cache := NewImageCache(loader)
+	cache := NewImageCache(loader, nil, testImageCacheSize)
 
 	t.Run("Valid", func(t *testing.T) {
 		imageFile, _ := imageStore.AddImage(apitype.NewImageFile(testAssetsDir, "horizontal.jpg"))
@@ -183,7 +188,7 @@ func TestDefaultImageStore_GetScaled(t *testing.T) {
 	imageStore := database.NewImageStore(db, &StubImageFileConverter{})
 
 	loader := NewImageLoader(imageStore)
-	cache := NewImageCache(loader)
+	cache := NewImageCache(loader, nil, testImageCacheSize)
 
 	t.Run("Valid", func(t *testing.T) {
 		imageFile, _ := imageStore.AddImage(apitype.NewImageFile(testAssetsDir, "horizontal.jpg"))
@@ -218,7 +223,7 @@ func TestDefaultImageStore_GetThumbnail(t *testing.T) {
 	imageStore := database.NewImageStore(db, &StubImageFileConverter{})
 
 	loader := NewImageLoader(imageStore)
-	cache := NewImageCache(loader)
+	cache := NewImageCache(loader, nil, testImageCacheSize)
 
 	t.Run("Valid", func(t *testing.T) {
 		imageFile, _ := imageStore.AddImage(apitype.NewImageFile(testAssetsDir, "horizontal.jpg"))
@@ -242,3 +247,109 @@ func TestDefaultImageStore_GetThumbnail(t *testing.T) {
 		a.Nil(img)
 	})
 }
+
+func TestDefaultImageStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	a := assert.New(t)
+
+	imageStore, storedImages := addCopiesOfTestImage(t, 3)
+	loader := NewImageLoader(imageStore)
+	imageSize := getFullByteLength(t, loader, storedImages[0])
+	cache := NewImageCache(loader, nil, uint64(2*imageSize))
+	sut := cache.(*DefaultImageStore)
+
+	_, _ = cache.GetFull(storedImages[0].Id())
+	_, _ = cache.GetFull(storedImages[1].Id())
+	_, _ = cache.GetFull(storedImages[2].Id())
+
+	a.Equal([]bool{false, true, true}, getLoadedImages(sut, storedImages))
+
+	_, _ = cache.GetFull(storedImages[0].Id())
+
+	a.Equal([]bool{true, false, true}, getLoadedImages(sut, storedImages))
+}
+
+func TestDefaultImageStore_KeepsCurrentImageEvenIfTooBig(t *testing.T) {
+	a := assert.New(t)
+
+	imageStore, storedImages := addCopiesOfTestImage(t, 2)
+	cache := NewImageCache(NewImageLoader(imageStore), nil, 1)
+	sut := cache.(*DefaultImageStore)
+
+	_, _ = cache.GetFull(storedImages[0].Id())
+	_, _ = cache.GetFull(storedImages[1].Id())
+
+	a.Equal([]bool{false, true}, getLoadedImages(sut, storedImages))
+}
+
+func TestDefaultImageStore_Prefetch(t *testing.T) {
+	a := assert.New(t)
+
+	imageStore, storedImages := addCopiesOfTestImage(t, 4)
+	loader := NewImageLoader(imageStore)
+	imageSize := getFullByteLength(t, loader, storedImages[0])
+	cache := NewImageCache(loader, nil, uint64(3*imageSize))
+	sut := cache.(*DefaultImageStore)
+
+	t.Run("Not initialized", func(t *testing.T) {
+		cache.Prefetch([]apitype.ImageId{storedImages[1].Id()})
+
+		time.Sleep(100 * time.Millisecond)
+		a.Equal([]bool{false, false, false, false}, getLoadedImages(sut, storedImages))
+	})
+
+	reporter := &StubProgressReporter{}
+	cache.Initialize(storedImages, reporter)
+	waitForCacheToFill(reporter)
+
+	t.Run("Stops when cache is full", func(t *testing.T) {
+		_, _ = cache.GetFull(storedImages[0].Id())
+		cache.Prefetch([]apitype.ImageId{storedImages[1].Id(), storedImages[2].Id(), storedImages[3].Id()})
+
+		a.Eventually(func() bool {
+			return getLoadedImages(sut, storedImages)[2]
+		}, time.Minute, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		a.Equal([]bool{true, true, true, false}, getLoadedImages(sut, storedImages))
+	})
+
+	t.Run("Purges images that are no longer prefetched", func(t *testing.T) {
+		_, _ = cache.GetFull(storedImages[1].Id())
+		cache.Prefetch([]apitype.ImageId{storedImages[2].Id(), storedImages[3].Id()})
+
+		a.Eventually(func() bool {
+			return getLoadedImages(sut, storedImages)[3]
+		}, time.Minute, 10*time.Millisecond)
+		a.Equal([]bool{false, true, true, true}, getLoadedImages(sut, storedImages))
+	})
+}
+
+func addCopiesOfTestImage(t *testing.T, count int) (*database.ImageStore, []*apitype.ImageFile) {
+	directory := t.TempDir()
+	imageStore := database.NewImageStore(database.NewInMemoryDatabase(directory), &StubImageFileConverter{})
+	var imageFiles []*apitype.ImageFile
+	for i := 0; i < count; i++ {
+		fileName := fmt.Sprintf("image%d.jpg", i)
+		copyTestAsset(t, directory, "vertical.jpg", fileName)
+		imageFile, err := imageStore.AddImage(apitype.NewImageFile(directory, fileName))
+		require.Nil(t, err)
+		imageFiles = append(imageFiles, imageFile)
+	}
+	return imageStore, imageFiles
+}
+
+func getFullByteLength(t *testing.T, loader api.ImageLoader, imageFile *apitype.ImageFile) int {
+	full, err := NewInstance(imageFile.Id(), loader, nil).GetFull()
+	require.Nil(t, err)
+	return GetByteLength(full)
+}
+
+func getLoadedImages(sut *DefaultImageStore, imageFiles []*apitype.ImageFile) []bool {
+	loaded := make([]bool, len(imageFiles))
+	for i, imageFile := range imageFiles {
+		sut.mux.Lock()
+		instance, ok := sut.imageCache[imageFile.Id()]
+		sut.mux.Unlock()
+		loaded[i] = ok && instance.GetLoadedByteLength() > 0
+	}
+	return loaded
+}
@@ -52,6 +52,23 @@ func (s *LibJPEGImageLoader) LoadImageScaled(imageId apitype.ImageId, size apity
 	}
 }
 
+func (s *LibJPEGImageLoader) LoadExifThumbnail(imageId apitype.ImageId) (image.Image, error) {
+	if imageId != apitype.NoImage {
+		if storedImageFile := s.imageStore.GetImageById(imageId); storedImageFile == nil {
+			return nil, errors.New("image not found in DB")
+		} else if exifData, err := util.LoadExifData(storedImageFile); err != nil {
+			return nil, err
+		} else if thumbnail, err := exifData.Thumbnail(); err != nil {
+			return nil, err
+		} else {
+			rotation, flipped := storedImageFile.Rotation()
+			return apitype.ExifRotateImage(thumbnail, rotation, flipped)
+		}
+	} else {
+		return nil, errors.New("invalid image ID")
+	}
+}
+
 func (s *LibJPEGImageLoader) LoadExifData(imageFile *apitype.ImageFile) (*apitype.ExifData, error) {
 	return util.LoadExifData(imageFile)
 }
@@ -72,3 +72,36 @@ func TestLibJPEGImageLoader_LoadImageScaled(t *testing.T) {
 	})
 
 }
+
+func TestLibJPEGImageLoader_LoadExifThumbnail(t *testing.T) {
+	a := assert.New(t)
+
+	db := database.NewInMemoryDatabase(testAssetsDir)
+	imageStore := database.NewImageStore(db, &database.FileSystemImageFileConverter{})
+
+	vertical, _ := imageStore.AddImage(apitype.NewImageFile(testAssetsDir, "vertical.jpg"))
+	noExif, _ := imageStore.AddImage(apitype.NewImageFile(testAssetsDir, "no-exif.jpg"))
+
+	loader := NewImageLoader(imageStore)
+	t.Run("Rotated and cropped to the image's aspect ratio", func(t *testing.T) {
+		img, err := loader.LoadExifThumbnail(vertical.Id())
+
+		a.Nil(err)
+		if a.NotNil(img) {
+			a.Less(img.Bounds().Dx(), img.Bounds().Dy())
+			a.InDelta(2736.0/3648.0, float64(img.Bounds().Dx())/float64(img.Bounds().Dy()), 0.02)
+		}
+	})
+	t.Run("No Exif data", func(t *testing.T) {
+		img, err := loader.LoadExifThumbnail(noExif.Id())
+
+		a.NotNil(err)
+		a.Nil(img)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		img, err := loader.LoadExifThumbnail(apitype.NoImage)
+
+		a.NotNil(err)
+		a.Nil(img)
+	})
+}
@@ -23,20 +23,23 @@ var (
 )
 
 type Instance struct {
-	imageId     apitype.ImageId
-	full        image.Image
-	thumbnail   image.Image
-	scaled      image.Image
-	imageLoader api.ImageLoader
-	mux         sync.Mutex
+	imageId        apitype.ImageId
+	full           image.Image
+	thumbnail      image.Image
+	scaled         image.Image
+	imageLoader    api.ImageLoader
+	thumbnailCache api.ThumbnailCache
+	mux            sync.Mutex
 }
 
-func NewInstance(imageId apitype.ImageId, imageLoader api.ImageLoader) *Instance {
+// Thumbnail is read from the thumbnail cache if possible. Cache may be nil.
+func NewInstance(imageId apitype.ImageId, imageLoader api.ImageLoader, thumbnailCache api.ThumbnailCache) *Instance {
 	var instance *Instance
 
 	instance = &Instance{
-		imageId:     imageId,
-		imageLoader: imageLoader,
+		imageId:        imageId,
+		imageLoader:    imageLoader,
+		thumbnailCache: thumbnailCache,
 	}
 
 	instance.thumbnail, _ = instance.GetThumbnail()
@@ -83,21 +86,26 @@ func (s *Instance) GetScaled(size apitype.Size) (image.Image, error) {
 	fullSize := full.Bounds()
 	newSize := apitype.RectangleOfScaledToFit(fullSize, size)
 
-	if s.scaled == nil {
-		s.scaled = imagereader.ConvertNrgbaToRgba(imaging.Resize(full, newSize.Width(), newSize.Height(), imaging.Linear))
+	// Image may be purged while it is being scaled, so the scaled image is returned from a local variable
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	scaled := s.scaled
+	if scaled == nil {
+		scaled = imagereader.ConvertNrgbaToRgba(imaging.Resize(full, newSize.Width(), newSize.Height(), imaging.Linear))
 	} else {
-		size := s.scaled.Bounds()
+		size := scaled.Bounds()
 		if newSize.Width() != size.Dx() && newSize.Height() != size.Dy() {
-			s.scaled = imagereader.ConvertNrgbaToRgba(imaging.Resize(full, newSize.Width(), newSize.Height(), imaging.Linear))
+			scaled = imagereader.ConvertNrgbaToRgba(imaging.Resize(full, newSize.Width(), newSize.Height(), imaging.Linear))
 		} else {
 			logger.Trace.Print("Use cached scaled image")
 			// Use cached
 		}
 	}
+	s.scaled = scaled
 	endTime := time.Now()
 	logger.Trace.Printf("%d: Scaled loaded in %s", s.imageId, endTime.Sub(startTime).String())
 
-	return s.scaled, err
+	return scaled, err
 }
 
 func (s *Instance) GetThumbnail() (image.Image, error) {
@@ -108,7 +116,12 @@ func (s *Instance) GetThumbnail() (image.Image, error) {
 	startTime := time.Now()
 	if s.thumbnail == nil {
 
-		if full, err := s.loadThumbnailFromCache(); err != nil {
+		if cached := s.loadThumbnailFromDisk(); cached != nil {
+			s.thumbnail = cached
+		} else if embedded := s.loadExifThumbnail(); embedded != nil {
+			// Embedded thumbnails are quick to read, so they are not stored in the thumbnail cache
+			s.thumbnail = embedded
+		} else if full, err := s.loadThumbnailFromCache(); err != nil {
 			return nil, err
 		} else if full == nil {
 			return nil, nil
@@ -117,6 +130,9 @@ func (s *Instance) GetThumbnail() (image.Image, error) {
 			newSize := apitype.RectangleOfScaledToFit(fullSize, thumbnailSize)
 
 			s.thumbnail = imagereader.ConvertNrgbaToRgba(imaging.Resize(full, newSize.Width(), newSize.Height(), imaging.Linear))
+			if s.thumbnailCache != nil {
+				s.thumbnailCache.Put(s.imageId, s.thumbnail)
+			}
 		}
 	} else {
 		logger.Trace.Print("Use cached thumbnail")
@@ -127,6 +143,8 @@ func (s *Instance) GetThumbnail() (image.Image, error) {
 }
 
 func (s *Instance) Purge() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	s.full = nil
 	s.scaled = nil
 }
@@ -138,6 +156,14 @@ func (s *Instance) GetByteLength() int {
 	byteLength += GetByteLength(s.thumbnail)
 	return byteLength
 }
+
+// Byte length of the full and scaled images that are purged when the cache is full
+func (s *Instance) GetLoadedByteLength() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return GetByteLength(s.full) + GetByteLength(s.scaled)
+}
+
 func GetByteLength(pixbuf image.Image) int {
 	if pixbuf != nil {
 		// Approximation using the image size
@@ -174,6 +200,48 @@ func (s *Instance) loadImageWithExifCorrection(size *apitype.Size) (image.Image,
 	return loadedImage, nil
 }
 
+func (s *Instance) loadThumbnailFromDisk() image.Image {
+	if s.thumbnailCache == nil {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	// Opaque thumbnails are decoded as RGBA and the transparent ones as NRGBA
+	switch thumbnail := s.thumbnailCache.Get(s.imageId).(type) {
+	case *image.RGBA:
+		logger.Trace.Printf("%d: Thumbnail read from disk", s.imageId)
+		return thumbnail
+	case *image.NRGBA:
+		logger.Trace.Printf("%d: Thumbnail read from disk", s.imageId)
+		return imagereader.ConvertNrgbaToRgba(thumbnail)
+	default:
+		return nil
+	}
+}
+
+// Returns nil if the image has no embedded thumbnail or if it is smaller than the thumbnail size
+func (s *Instance) loadExifThumbnail() image.Image {
+	if s.imageLoader == nil {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	embedded, err := s.imageLoader.LoadExifThumbnail(s.imageId)
+	if err != nil {
+		logger.Trace.Printf("%d: No embedded thumbnail: %s", s.imageId, err)
+		return nil
+	}
+
+	embeddedSize := embedded.Bounds()
+	newSize := apitype.RectangleOfScaledToFit(embeddedSize, thumbnailSize)
+	if embeddedSize.Dx() < newSize.Width() || embeddedSize.Dy() < newSize.Height() {
+		logger.Trace.Printf("%d: Embedded thumbnail is too small (%dx%d)", s.imageId, embeddedSize.Dx(), embeddedSize.Dy())
+		return nil
+	}
+	logger.Trace.Printf("%d: Thumbnail read from Exif data", s.imageId)
+	return imagereader.ConvertNrgbaToRgba(imaging.Resize(embedded, newSize.Width(), newSize.Height(), imaging.Linear))
+}
+
 func (s *Instance) loadThumbnailFromCache() (image.Image, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
@@ -1,8 +1,11 @@
 package imageloader
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"image"
 	"testing"
+	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/backend/internal/database"
 )
@@ -19,7 +22,7 @@ func TestInstance_GetFull(t *testing.T) {
 	loader := NewImageLoader(imageStore)
 
 	t.Run("Horizontal", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		scaled, err := instance.GetFull()
 
@@ -31,7 +34,7 @@ func TestInstance_GetFull(t *testing.T) {
 		a.Equal(39953712, instance.GetByteLength())
 	})
 	t.Run("Vertical", func(t *testing.T) {
-		instance := NewInstance(vertical.Id(), loader)
+		instance := NewInstance(vertical.Id(), loader, nil)
 
 		scaled, err := instance.GetFull()
 
@@ -43,7 +46,7 @@ func TestInstance_GetFull(t *testing.T) {
 		a.Equal(39953712, instance.GetByteLength())
 	})
 	t.Run("Cached", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		scaled, err := instance.GetFull()
 		scaled, err = instance.GetFull()
@@ -57,14 +60,14 @@ func TestInstance_GetFull(t *testing.T) {
 		a.Equal(39953712, instance.GetByteLength())
 	})
 	t.Run("No image", func(t *testing.T) {
-		instance := NewInstance(3, loader)
+		instance := NewInstance(3, loader, nil)
 
 		scaled, err := instance.GetFull()
 		a.NotNil(err)
 		a.Nil(scaled)
 	})
 	t.Run("Invalid", func(t *testing.T) {
-		instance := NewInstance(apitype.NoImage, loader)
+		instance := NewInstance(apitype.NoImage, loader, nil)
 
 		scaled, err := instance.GetFull()
 		a.NotNil(err)
@@ -84,7 +87,7 @@ func TestInstance_GetScaled(t *testing.T) {
 	loader := NewImageLoader(imageStore)
 
 	t.Run("Horizontal", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		size := apitype.SizeOf(400, 400)
 		scaled, err := instance.GetScaled(size)
@@ -97,7 +100,7 @@ func TestInstance_GetScaled(t *testing.T) {
 		a.Equal(40433712, instance.GetByteLength())
 	})
 	t.Run("Vertical", func(t *testing.T) {
-		instance := NewInstance(vertical.Id(), loader)
+		instance := NewInstance(vertical.Id(), loader, nil)
 
 		size := apitype.SizeOf(400, 400)
 		scaled, err := instance.GetScaled(size)
@@ -110,7 +113,7 @@ func TestInstance_GetScaled(t *testing.T) {
 		a.Equal(40433712, instance.GetByteLength())
 	})
 	t.Run("Cached", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		size := apitype.SizeOf(400, 400)
 		scaled, err := instance.GetScaled(size)
@@ -124,7 +127,7 @@ func TestInstance_GetScaled(t *testing.T) {
 		a.Equal(40433712, instance.GetByteLength())
 	})
 	t.Run("Rescaled", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		size1 := apitype.SizeOf(400, 400)
 		scaled, err := instance.GetScaled(size1)
@@ -147,7 +150,7 @@ func TestInstance_GetScaled(t *testing.T) {
 		a.Equal(41873712, instance.GetByteLength())
 	})
 	t.Run("Not found", func(t *testing.T) {
-		instance := NewInstance(3, loader)
+		instance := NewInstance(3, loader, nil)
 
 		size := apitype.SizeOf(400, 400)
 		scaled, err := instance.GetScaled(size)
@@ -156,7 +159,7 @@ func TestInstance_GetScaled(t *testing.T) {
 		a.Nil(scaled)
 	})
 	t.Run("Invalid", func(t *testing.T) {
-		instance := NewInstance(apitype.NoImage, loader)
+		instance := NewInstance(apitype.NoImage, loader, nil)
 
 		size := apitype.SizeOf(400, 400)
 		scaled, err := instance.GetScaled(size)
@@ -178,7 +181,7 @@ func TestInstance_GetThumbnail(t *testing.T) {
 	loader := NewImageLoader(imageStore)
 
 	t.Run("Horizontal", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		scaled, err := instance.GetThumbnail()
 
@@ -190,7 +193,7 @@ func TestInstance_GetThumbnail(t *testing.T) {
 		a.Equal(30000, instance.GetByteLength())
 	})
 	t.Run("Vertical", func(t *testing.T) {
-		instance := NewInstance(vertical.Id(), loader)
+		instance := NewInstance(vertical.Id(), loader, nil)
 
 		scaled, err := instance.GetThumbnail()
 
@@ -202,7 +205,7 @@ func TestInstance_GetThumbnail(t *testing.T) {
 		a.Equal(30000, instance.GetByteLength())
 	})
 	t.Run("Cached", func(t *testing.T) {
-		instance := NewInstance(horizontal.Id(), loader)
+		instance := NewInstance(horizontal.Id(), loader, nil)
 
 		scaled, err := instance.GetThumbnail()
 		scaled, err = instance.GetThumbnail()
@@ -215,7 +218,7 @@ func TestInstance_GetThumbnail(t *testing.T) {
 		a.Equal(30000, instance.GetByteLength())
 	})
 	t.Run("Not found", func(t *testing.T) {
-		instance := NewInstance(3, loader)
+		instance := NewInstance(3, loader, nil)
 
 		scaled, err := instance.GetThumbnail()
 
@@ -223,7 +226,7 @@ func TestInstance_GetThumbnail(t *testing.T) {
 		a.Nil(scaled)
 	})
 	t.Run("Invalid", func(t *testing.T) {
-		instance := NewInstance(apitype.NoImage, loader)
+		instance := NewInstance(apitype.NoImage, loader, nil)
 
 		scaled, err := instance.GetThumbnail()
 
@@ -232,6 +235,61 @@ func TestInstance_GetThumbnail(t *testing.T) {
 	})
 }
 
+type StubThumbnailLoader struct {
+	embedded     image.Image
+	scaledLoaded bool
+
+	api.ImageLoader
+}
+
+func (s *StubThumbnailLoader) LoadExifThumbnail(apitype.ImageId) (image.Image, error) {
+	if s.embedded == nil {
+		return nil, errors.New("no thumbnail")
+	}
+	return s.embedded, nil
+}
+
+func (s *StubThumbnailLoader) LoadImageScaled(apitype.ImageId, apitype.Size) (image.Image, error) {
+	s.scaledLoaded = true
+	return image.NewNRGBA(image.Rect(0, 0, 400, 300)), nil
+}
+
+func TestInstance_GetThumbnail_Embedded(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("Embedded thumbnail is used", func(t *testing.T) {
+		loader := &StubThumbnailLoader{embedded: image.NewNRGBA(image.Rect(0, 0, 160, 120))}
+		instance := NewInstance(1, loader, nil)
+
+		thumbnail, err := instance.GetThumbnail()
+
+		a.Nil(err)
+		a.Equal(100, thumbnail.Bounds().Dx())
+		a.Equal(75, thumbnail.Bounds().Dy())
+		a.False(loader.scaledLoaded)
+	})
+	t.Run("Image is decoded if embedded thumbnail is too small", func(t *testing.T) {
+		loader := &StubThumbnailLoader{embedded: image.NewNRGBA(image.Rect(0, 0, 80, 60))}
+		instance := NewInstance(1, loader, nil)
+
+		thumbnail, err := instance.GetThumbnail()
+
+		a.Nil(err)
+		a.Equal(100, thumbnail.Bounds().Dx())
+		a.True(loader.scaledLoaded)
+	})
+	t.Run("Image is decoded if there is no embedded thumbnail", func(t *testing.T) {
+		loader := &StubThumbnailLoader{}
+		instance := NewInstance(1, loader, nil)
+
+		thumbnail, err := instance.GetThumbnail()
+
+		a.Nil(err)
+		a.Equal(100, thumbnail.Bounds().Dx())
+		a.True(loader.scaledLoaded)
+	})
+}
+
 func TestInstance_Purge(t *testing.T) {
 	a := assert.New(t)
 
@@ -243,7 +301,7 @@ func TestInstance_Purge(t *testing.T) {
 
 	loader := NewImageLoader(imageStore)
 
-	instance := NewInstance(horizontal.Id(), loader)
+	instance := NewInstance(horizontal.Id(), loader, nil)
 
 	scaled, err := instance.GetFull()
 
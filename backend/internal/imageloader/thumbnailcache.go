@@ -0,0 +1,259 @@
+package imageloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/common/constants"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+const thumbnailDir = "thumbnails"
+const thumbnailExtension = ".png"
+const thumbnailTempExtension = ".tmp"
+
+// The cache is pruned to the max size when this fraction of it has been written after the last prune
+const thumbnailPruneFraction = 10
+
+// Younger temporary files may still be written by Put
+const thumbnailTempMaxAge = 10 * time.Second
+
+// Thumbnails are stored as PNG files under the image directory's .image-sorter directory.
+// The file name is a hash of the image's path, size, modification time and rotation
+// so a modified image never gets an old thumbnail. Stale thumbnails are removed when
+// the cache is pruned. Files are touched when they are read so that the least recently
+// used thumbnails are removed first when the cache grows too big. Put prunes the cache
+// every now and then, so it never grows much bigger than the max size.
+type ThumbnailCache struct {
+	imageStore  *database.ImageStore
+	maxByteSize int64
+	mux         sync.Mutex
+	// Bytes written by Put after the last prune
+	writtenByteSize int64
+	writtenMux      sync.Mutex
+
+	api.ThumbnailCache
+}
+
+// Max byte size 0 disables the cache
+func NewThumbnailCache(imageStore *database.ImageStore, maxByteSize int64) api.ThumbnailCache {
+	return &ThumbnailCache{
+		imageStore:  imageStore,
+		maxByteSize: maxByteSize,
+	}
+}
+
+// Returns nil if the thumbnail has not been cached or the image has been modified after that
+func (s *ThumbnailCache) Get(imageId apitype.ImageId) image.Image {
+	if !s.isEnabled() {
+		return nil
+	}
+	path, err := s.getThumbnailPath(imageId)
+	if err != nil {
+		logger.Trace.Printf("%d: No cached thumbnail: %s", imageId, err)
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	thumbnail, err := png.Decode(file)
+	if err != nil {
+		logger.Warn.Printf("Invalid cached thumbnail '%s': %s", path, err)
+		return nil
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return thumbnail
+}
+
+func (s *ThumbnailCache) Put(imageId apitype.ImageId, thumbnail image.Image) {
+	if !s.isEnabled() || thumbnail == nil {
+		return
+	}
+	imageFile := s.imageStore.GetImageById(imageId)
+	if imageFile == nil {
+		logger.Warn.Printf("Could not cache thumbnail for %d: image not found", imageId)
+	} else if path, err := getThumbnailPath(imageFile); err != nil {
+		logger.Warn.Printf("Could not cache thumbnail for %d: %s", imageId, err)
+	} else if byteSize, err := writeThumbnail(path, thumbnail); err != nil {
+		logger.Warn.Printf("Could not cache thumbnail '%s': %s", path, err)
+	} else if s.addWrittenByteSize(byteSize) {
+		if _, _, err := s.Prune(imageFile.RootDirectory(), nil); err != nil {
+			logger.Warn.Printf("Could not prune thumbnails: %s", err)
+		}
+	}
+}
+
+// Removes the thumbnails of the images that are not in the given images (if given) and
+// then the least recently used thumbnails until the cache fits in the max size.
+// Temporary files are removed only when they are old enough not to be written anymore.
+// Returns the number of removed thumbnails and the byte size of the remaining ones.
+func (s *ThumbnailCache) Prune(directory string, imageFiles []*apitype.ImageFile) (int, int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.writtenMux.Lock()
+	s.writtenByteSize = 0
+	s.writtenMux.Unlock()
+
+	cacheDir := filepath.Join(directory, constants.ImageSorterDir, thumbnailDir)
+	var validFileNames map[string]bool
+	if imageFiles != nil {
+		validFileNames = map[string]bool{}
+		for _, imageFile := range imageFiles {
+			if fileName, err := getThumbnailFileName(imageFile); err == nil {
+				validFileNames[fileName] = true
+			}
+		}
+	}
+
+	type cachedThumbnail struct {
+		path     string
+		byteSize int64
+		used     time.Time
+	}
+	var thumbnails []cachedThumbnail
+	var byteSize int64
+	removed := 0
+	err := filepath.WalkDir(cacheDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		} else if entry.IsDir() {
+			return nil
+		}
+
+		name := entry.Name()
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// Temporary file renamed by Put
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(name, thumbnailTempExtension) {
+			if time.Since(info.ModTime()) < thumbnailTempMaxAge {
+				return nil
+			} else if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		} else if validFileNames != nil && !validFileNames[name] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		} else {
+			thumbnails = append(thumbnails, cachedThumbnail{path: path, byteSize: info.Size(), used: info.ModTime()})
+			byteSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, byteSize, err
+	}
+
+	sort.Slice(thumbnails, func(i, j int) bool {
+		return thumbnails[i].used.Before(thumbnails[j].used)
+	})
+	for _, thumbnail := range thumbnails {
+		if byteSize <= s.maxByteSize {
+			break
+		}
+		if err := os.Remove(thumbnail.path); err != nil {
+			return removed, byteSize, err
+		}
+		removed++
+		byteSize -= thumbnail.byteSize
+	}
+
+	logger.Info.Printf("Pruned %d thumbnails from '%s', %d bytes left", removed, cacheDir, byteSize)
+	return removed, byteSize, nil
+}
+
+// Private API
+
+func (s *ThumbnailCache) isEnabled() bool {
+	return s.maxByteSize > 0
+}
+
+// Returns true when the cache should be pruned
+func (s *ThumbnailCache) addWrittenByteSize(byteSize int64) bool {
+	s.writtenMux.Lock()
+	defer s.writtenMux.Unlock()
+	s.writtenByteSize += byteSize
+	return s.writtenByteSize >= s.maxByteSize/thumbnailPruneFraction
+}
+
+func (s *ThumbnailCache) getThumbnailPath(imageId apitype.ImageId) (string, error) {
+	imageFile := s.imageStore.GetImageById(imageId)
+	if imageFile == nil {
+		return "", fmt.Errorf("image %d not found", imageId)
+	}
+	return getThumbnailPath(imageFile)
+}
+
+func getThumbnailPath(imageFile *apitype.ImageFile) (string, error) {
+	if fileName, err := getThumbnailFileName(imageFile); err != nil {
+		return "", err
+	} else {
+		return filepath.Join(imageFile.RootDirectory(), constants.ImageSorterDir, thumbnailDir, fileName[:2], fileName), nil
+	}
+}
+
+func getThumbnailFileName(imageFile *apitype.ImageFile) (string, error) {
+	info, err := os.Stat(imageFile.Path())
+	if err != nil {
+		return "", err
+	}
+	rotation, flipped := imageFile.Rotation()
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%g\x00%t\x00%dx%d",
+		filepath.ToSlash(imageFile.RelativePath()), info.Size(), info.ModTime().UnixNano(),
+		rotation, flipped, thumbnailWidth, thumbnailHeight)
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:]) + thumbnailExtension, nil
+}
+
+// Written to a temporary file first so that the readers never see a partial thumbnail.
+// Returns the byte size of the written file.
+func writeThumbnail(path string, thumbnail image.Image) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "*"+thumbnailTempExtension)
+	if err != nil {
+		return 0, err
+	}
+	if err := png.Encode(file, thumbnail); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return 0, err
+	}
+	info, err := file.Stat()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return 0, err
+	}
+	return info.Size(), os.Rename(file.Name(), path)
+}
@@ -0,0 +1,163 @@
+package imageloader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/common/constants"
+)
+
+func copyTestAsset(t *testing.T, directory string, fileName string, newName string) {
+	content, err := os.ReadFile(filepath.Join(testAssetsDir, fileName))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(directory, newName), content, 0644))
+}
+
+func countThumbnails(t *testing.T, directory string) int {
+	count := 0
+	_ = filepath.Walk(filepath.Join(directory, constants.ImageSorterDir, thumbnailDir), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+func TestThumbnailCache(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	copyTestAsset(t, directory, "vertical.jpg", "image1.jpg")
+	copyTestAsset(t, directory, "no-exif.jpg", "image2.jpg")
+	imageStore := database.NewImageStore(database.NewInMemoryDatabase(directory), &StubImageFileConverter{})
+	image1, _ := imageStore.AddImage(apitype.NewImageFile(directory, "image1.jpg"))
+	image2, _ := imageStore.AddImage(apitype.NewImageFile(directory, "image2.jpg"))
+
+	sut := NewThumbnailCache(imageStore, 1024*1024)
+	thumbnail := image.NewRGBA(image.Rect(0, 0, 10, 5))
+
+	t.Run("Not cached", func(t *testing.T) {
+		a.Nil(sut.Get(image1.Id()))
+	})
+
+	t.Run("Put and get", func(t *testing.T) {
+		sut.Put(image1.Id(), thumbnail)
+
+		cached := sut.Get(image1.Id())
+		if a.NotNil(cached) {
+			a.Equal(thumbnail.Bounds(), cached.Bounds())
+		}
+		a.Equal(1, countThumbnails(t, directory))
+	})
+
+	t.Run("Instance reads the thumbnail from the cache", func(t *testing.T) {
+		instance := NewInstance(image1.Id(), NewImageLoader(imageStore), sut)
+
+		cached, err := instance.GetThumbnail()
+		a.Nil(err)
+		if a.NotNil(cached) {
+			a.Equal(thumbnail.Bounds(), cached.Bounds())
+		}
+	})
+
+	t.Run("Instance stores the decoded thumbnail", func(t *testing.T) {
+		instance := NewInstance(image2.Id(), NewImageLoader(imageStore), sut)
+
+		decoded, err := instance.GetThumbnail()
+		a.Nil(err)
+		cached := sut.Get(image2.Id())
+		if a.NotNil(decoded) && a.NotNil(cached) {
+			a.Equal(decoded.Bounds(), cached.Bounds())
+		}
+	})
+
+	t.Run("Modified image is not found", func(t *testing.T) {
+		modified := time.Now().Add(time.Hour)
+		require.Nil(t, os.Chtimes(filepath.Join(directory, "image1.jpg"), modified, modified))
+
+		a.Nil(sut.Get(image1.Id()))
+	})
+
+	t.Run("Prune stale thumbnails", func(t *testing.T) {
+		sut.Put(image1.Id(), thumbnail)
+		sut.Put(image2.Id(), thumbnail)
+		a.Equal(3, countThumbnails(t, directory))
+
+		images, _ := imageStore.GetAllImages()
+		removed, byteSize, err := sut.Prune(directory, images)
+		a.Nil(err)
+		a.Equal(1, removed)
+		a.Greater(byteSize, int64(0))
+		a.Equal(2, countThumbnails(t, directory))
+		a.NotNil(sut.Get(image1.Id()))
+		a.NotNil(sut.Get(image2.Id()))
+	})
+
+	t.Run("Prune least recently used thumbnails to max size", func(t *testing.T) {
+		images, _ := imageStore.GetAllImages()
+		_, byteSize, _ := sut.Prune(directory, images)
+
+		used := time.Now().Add(-time.Hour)
+		path, _ := sut.(*ThumbnailCache).getThumbnailPath(image2.Id())
+		require.Nil(t, os.Chtimes(path, used, used))
+
+		limited := NewThumbnailCache(imageStore, byteSize-1)
+		removed, _, err := limited.Prune(directory, nil)
+		a.Nil(err)
+		a.Equal(1, removed)
+		a.NotNil(limited.Get(image1.Id()))
+		a.Nil(limited.Get(image2.Id()))
+	})
+
+	t.Run("Prune keeps temporary files that may still be written", func(t *testing.T) {
+		cacheDir := filepath.Join(directory, constants.ImageSorterDir, thumbnailDir)
+		writing := filepath.Join(cacheDir, "writing"+thumbnailTempExtension)
+		abandoned := filepath.Join(cacheDir, "abandoned"+thumbnailTempExtension)
+		require.Nil(t, os.WriteFile(writing, []byte("partial"), 0644))
+		require.Nil(t, os.WriteFile(abandoned, []byte("partial"), 0644))
+		old := time.Now().Add(-time.Minute)
+		require.Nil(t, os.Chtimes(abandoned, old, old))
+
+		removed, _, err := sut.Prune(directory, nil)
+		a.Nil(err)
+		a.Equal(1, removed)
+		a.FileExists(writing)
+		a.NoFileExists(abandoned)
+		require.Nil(t, os.Remove(writing))
+	})
+
+	t.Run("Put prunes least recently used thumbnails to max size", func(t *testing.T) {
+		sut.Put(image2.Id(), thumbnail)
+		_, byteSize, _ := sut.Prune(directory, nil)
+		a.Equal(2, countThumbnails(t, directory))
+
+		used := time.Now().Add(-time.Hour)
+		path, _ := sut.(*ThumbnailCache).getThumbnailPath(image2.Id())
+		require.Nil(t, os.Chtimes(path, used, used))
+
+		limited := NewThumbnailCache(imageStore, byteSize-1)
+		limited.Put(image1.Id(), thumbnail)
+		a.Equal(1, countThumbnails(t, directory))
+		a.NotNil(limited.Get(image1.Id()))
+		a.Nil(limited.Get(image2.Id()))
+	})
+
+	t.Run("Disabled cache", func(t *testing.T) {
+		disabled := NewThumbnailCache(imageStore, 0)
+		disabled.Put(image2.Id(), thumbnail)
+		a.Nil(disabled.Get(image1.Id()))
+
+		removed, byteSize, err := disabled.Prune(directory, nil)
+		a.Nil(err)
+		a.Equal(1, removed)
+		a.Equal(int64(0), byteSize)
+		a.Equal(0, countThumbnails(t, directory))
+	})
+}
@@ -0,0 +1,72 @@
+package imagerating
+
+import (
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+type Service struct {
+	sender           api.Sender
+	imageRatingStore *database.ImageRatingStore
+
+	api.ImageRatingService
+}
+
+func NewImageRatingService(sender api.Sender, imageRatingStore *database.ImageRatingStore) api.ImageRatingService {
+	return &Service{
+		sender:           sender,
+		imageRatingStore: imageRatingStore,
+	}
+}
+
+func (s *Service) RequestRating(query *api.ImageCategoryQuery) {
+	s.sendRating(query.ImageId)
+}
+
+func (s *Service) GetRating(imageId apitype.ImageId) *apitype.ImageRating {
+	if rating, err := s.imageRatingStore.GetRating(imageId); err != nil {
+		s.sender.SendError("Error while fetching image's rating", err)
+		return &apitype.ImageRating{}
+	} else {
+		return rating
+	}
+}
+
+func (s *Service) SetRating(command *api.RateImageCommand) {
+	if command.ImageId <= 0 {
+		logger.Warn.Printf("Trying to rate invalid imageId=%d", command.ImageId)
+	} else if !command.Rating.IsValid() {
+		logger.Warn.Printf("Trying to set invalid rating %d", command.Rating)
+	} else if err := s.imageRatingStore.SetRating(command.ImageId, command.Rating); err != nil {
+		s.sender.SendError("Error while setting rating", err)
+	} else {
+		s.sendRating(command.ImageId)
+		s.sender.SendToTopic(api.ImageAnnotationsUpdated)
+	}
+}
+
+func (s *Service) SetColorLabel(command *api.ColorLabelCommand) {
+	if command.ImageId <= 0 {
+		logger.Warn.Printf("Trying to label invalid imageId=%d", command.ImageId)
+	} else if err := s.imageRatingStore.SetColorLabel(command.ImageId, command.ColorLabel); err != nil {
+		s.sender.SendError("Error while setting color label", err)
+	} else {
+		s.sendRating(command.ImageId)
+		s.sender.SendToTopic(api.ImageAnnotationsUpdated)
+	}
+}
+
+func (s *Service) Close() {
+	logger.Info.Print("Shutting down image rating service")
+}
+
+// Private API
+
+func (s *Service) sendRating(imageId apitype.ImageId) {
+	s.sender.SendCommandToTopic(api.ImageRatingUpdated, &api.ImageRatingCommand{
+		ImageId: imageId,
+		Rating:  s.GetRating(imageId),
+	})
+}
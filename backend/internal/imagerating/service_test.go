@@ -0,0 +1,61 @@
+package imagerating
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+)
+
+type MockSender struct {
+	api.Sender
+	mock.Mock
+}
+
+func (s *MockSender) SendToTopic(topic api.Topic) {
+	s.Called(topic)
+}
+
+func (s *MockSender) SendCommandToTopic(topic api.Topic, command apitype.Command) {
+	s.Called(topic, command)
+}
+
+func (s *MockSender) SendError(message string, err error) {
+}
+
+func TestSetRating(t *testing.T) {
+	a := assert.New(t)
+
+	sender := new(MockSender)
+	sender.On("SendCommandToTopic", api.ImageRatingUpdated, mock.Anything).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
+	sut := NewImageRatingService(sender, database.NewImageRatingStore(database.NewInMemoryDatabase("")))
+
+	t.Run("Rating and color label", func(t *testing.T) {
+		sut.SetRating(&api.RateImageCommand{ImageId: 1, Rating: 4})
+		sut.SetColorLabel(&api.ColorLabelCommand{ImageId: 1, ColorLabel: apitype.ColorGreen})
+
+		rating := sut.GetRating(1)
+		a.Equal(apitype.Rating(4), rating.Rating)
+		a.Equal(apitype.ColorGreen, rating.ColorLabel)
+
+		sender.AssertCalled(t, "SendCommandToTopic", api.ImageRatingUpdated, &api.ImageRatingCommand{
+			ImageId: 1,
+			Rating:  &apitype.ImageRating{Rating: 4, ColorLabel: apitype.ColorGreen},
+		})
+	})
+
+	t.Run("Invalid rating is ignored", func(t *testing.T) {
+		sut.SetRating(&api.RateImageCommand{ImageId: 1, Rating: 6})
+		sut.SetRating(&api.RateImageCommand{ImageId: 0, Rating: 2})
+
+		a.Equal(apitype.Rating(4), sut.GetRating(1).Rating)
+		a.Equal(apitype.NoRating, sut.GetRating(0).Rating)
+	})
+
+	t.Run("Not rated", func(t *testing.T) {
+		a.False(sut.GetRating(2).IsSet())
+	})
+}
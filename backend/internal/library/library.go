@@ -1,13 +1,16 @@
 package library
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/backend/internal/database"
 	"vincit.fi/image-sorter/common/logger"
-	"vincit.fi/image-sorter/common/util"
 )
 
 var (
@@ -26,6 +29,7 @@ type ImageLibrary struct {
 	hashCalculator     *HashCalculator
 	progressReporter   api.ProgressReporter
 	directory          string
+	options            *api.ScanOptions
 
 	api.ImageLibrary
 }
@@ -45,9 +49,10 @@ func NewImageLibrary(imageCache api.ImageStore, imageLoader api.ImageLoader,
 	return &service
 }
 
-func (s *ImageLibrary) InitializeFromDirectory(directory string) (time.Time, error) {
+func (s *ImageLibrary) InitializeFromDirectory(directory string, options *api.ScanOptions) (time.Time, error) {
 	s.directory = directory
-	return s.updateImages(directory)
+	s.options = options
+	return s.updateImages(directory, options)
 }
 
 func (s *ImageLibrary) GetImages() []*apitype.ImageFile {
@@ -98,7 +103,71 @@ func (s *ImageLibrary) StopHashes() {
 }
 
 func (s *ImageLibrary) GetImagesInCategory(number int, offset int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
-	return s.imageStore.GetImagesInCategory(number, offset, categoryId)
+	return s.GetFilteredImages(number, offset, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageLibrary) GetFilteredImages(number int, offset int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
+	return s.imageStore.GetFilteredImages(number, offset, filter)
+}
+
+// Rescans the directories that contain the changed files or directories. Images of the
+// removed directories are removed and the new directories are scanned if the scan is recursive.
+// Files moved between the rescanned directories keep their images. Paths are relative to the
+// directory. Progress is not reported since the changes are small.
+func (s *ImageLibrary) UpdateImageFiles(paths []string) error {
+	if s.options == nil {
+		return errors.New("library has not been initialized")
+	}
+
+	directories := map[string]bool{}
+	var removedPaths []string
+	var changedImageIds []apitype.ImageId
+	newDirectories := false
+	for _, path := range paths {
+		directories[subDirectoryOf(path)] = true
+		if info, err := os.Stat(filepath.Join(s.directory, path)); err == nil && info.IsDir() {
+			newDirectories = true
+		} else if os.IsNotExist(err) {
+			removedPaths = append(removedPaths, path)
+		}
+		if imageFile, err := s.imageStore.FindByRelativePath(apitype.NewImageFile(s.directory, path)); err != nil {
+			return err
+		} else if imageFile != nil {
+			changedImageIds = append(changedImageIds, imageFile.Id())
+		}
+	}
+	// Removed images and the old versions of the modified images
+	defer s.imageCache.Invalidate(changedImageIds)
+
+	if newDirectories && s.options.Recursive {
+		// Files may have been added to the new directories before they were watched
+		logger.Debug.Printf("New directories in '%s', scanning all images", s.directory)
+		_, err := s.updateImages(s.directory, s.options)
+		return err
+	}
+
+	walk := func(walkFn apitype.WalkImageFilesFunc) error {
+		for directory := range directories {
+			// Removed directory is handled as an empty directory
+			imageFiles, err := apitype.LoadImageFilesInSubDirectory(s.directory, directory, s.options.SidecarExtensions)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			} else if err := walkFn(directory, imageFiles); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Removed paths may be directories, so the images in them are removed too
+	inScope := func(subDirectory string) bool {
+		for _, removedPath := range removedPaths {
+			if subDirectory == removedPath || strings.HasPrefix(subDirectory, removedPath+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+	return s.scanImages(walk, inScope, false)
 }
 
 func (s *ImageLibrary) AddImageFiles(imageList []*apitype.ImageFile) error {
@@ -153,12 +222,20 @@ func (s *ImageLibrary) GetImageFileById(imageId apitype.ImageId) *apitype.ImageF
 	return s.imageStore.GetImageById(imageId)
 }
 
+func (s *ImageLibrary) GetImageMetaData(imageId apitype.ImageId) (*apitype.ImageMetaData, error) {
+	return s.imageMetaDataStore.GetMetaDataByImageId(imageId)
+}
+
 // Private API
 
 func (s *ImageLibrary) GetImageAtIndex(index int, categoryId apitype.CategoryId) (*apitype.ImageFile, *apitype.ImageMetaData, int, error) {
-	imageCount := s.imageStore.GetImageCount(categoryId)
+	return s.GetFilteredImageAtIndex(index, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageLibrary) GetFilteredImageAtIndex(index int, filter *apitype.ImageFilter) (*apitype.ImageFile, *apitype.ImageMetaData, int, error) {
+	imageCount := s.imageStore.GetFilteredImageCount(filter)
 	if index >= 0 && index < imageCount {
-		images, _ := s.imageStore.GetImagesInCategory(1, index, categoryId)
+		images, _ := s.imageStore.GetFilteredImages(1, index, filter)
 		imageFile := images[0]
 		if metaData, err := s.imageMetaDataStore.GetMetaDataByImageId(imageFile.Id()); err != nil {
 			return apitype.GetEmptyImageFile(), apitype.NewInvalidImageMetaData(), 0, nil
@@ -170,11 +247,19 @@ func (s *ImageLibrary) GetImageAtIndex(index int, categoryId apitype.CategoryId)
 }
 
 func (s *ImageLibrary) GetTotalImages(categoryId apitype.CategoryId) int {
-	return s.imageStore.GetImageCount(categoryId)
+	return s.GetTotalFilteredImages(apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageLibrary) GetTotalFilteredImages(filter *apitype.ImageFilter) int {
+	return s.imageStore.GetFilteredImageCount(filter)
 }
 
 func (s *ImageLibrary) GetNextImages(index int, count int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
-	if images, err := s.imageStore.GetNextImagesInCategory(count, index, categoryId); err != nil {
+	return s.GetNextFilteredImages(index, count, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageLibrary) GetNextFilteredImages(index int, count int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
+	if images, err := s.imageStore.GetNextFilteredImages(count, index, filter); err != nil {
 		return emptyImageFiles, err
 	} else {
 		return s.toImageContainers(images)
@@ -182,7 +267,11 @@ func (s *ImageLibrary) GetNextImages(index int, count int, categoryId apitype.Ca
 }
 
 func (s *ImageLibrary) GetPreviousImages(index int, count int, categoryId apitype.CategoryId) ([]*apitype.ImageFile, error) {
-	if slice, err := s.imageStore.GetPreviousImagesInCategory(count, index, categoryId); err != nil {
+	return s.GetPreviousFilteredImages(index, count, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *ImageLibrary) GetPreviousFilteredImages(index int, count int, filter *apitype.ImageFilter) ([]*apitype.ImageFile, error) {
+	if slice, err := s.imageStore.GetPreviousFilteredImages(count, index, filter); err != nil {
 		return emptyImageFiles, err
 	} else if images, err := s.toImageContainers(slice); err != nil {
 		return emptyImageFiles, err
@@ -191,6 +280,15 @@ func (s *ImageLibrary) GetPreviousImages(index int, count int, categoryId apityp
 	}
 }
 
+// Images are loaded in the given order, so the images that are most likely shown next should be first
+func (s *ImageLibrary) PrefetchImages(imageFiles []*apitype.ImageFile) {
+	imageIds := make([]apitype.ImageId, len(imageFiles))
+	for i, imageFile := range imageFiles {
+		imageIds[i] = imageFile.Id()
+	}
+	s.imageCache.Prefetch(imageIds)
+}
+
 func (s *ImageLibrary) toImageContainers(nextImageFiles []*apitype.ImageFile) ([]*apitype.ImageFile, error) {
 	images := make([]*apitype.ImageFile, len(nextImageFiles))
 	for i, imageFile := range nextImageFiles {
@@ -200,13 +298,16 @@ func (s *ImageLibrary) toImageContainers(nextImageFiles []*apitype.ImageFile) ([
 	return images, nil
 }
 
-func (s *ImageLibrary) updateImages(rootDir string) (time.Time, error) {
-	imageFiles := apitype.LoadImageFiles(rootDir)
-	if err := s.AddImageFiles(imageFiles); err != nil {
-		logger.Error.Println("Error while adding images:", err)
-		return time.Unix(0, 0), err
-	} else if err := s.removeMissingImages(imageFiles); err != nil {
-		logger.Error.Println("Error while removing missing images:", err)
+func (s *ImageLibrary) updateImages(rootDir string, options *api.ScanOptions) (time.Time, error) {
+	walk := func(walkFn apitype.WalkImageFilesFunc) error {
+		return apitype.WalkImageFiles(rootDir, options.Recursive, options.ExcludedDirectories, options.SidecarExtensions, walkFn)
+	}
+	// If the scan is not recursive, images in sub directories are kept so that their categories are not lost
+	inScope := func(subDirectory string) bool {
+		return options.Recursive || subDirectory == ""
+	}
+	if err := s.scanImages(walk, inScope, true); err != nil {
+		logger.Error.Println("Error while scanning directory:", err)
 		return time.Unix(0, 0), err
 	} else {
 		return s.imageStore.GetLatestModifiedImage(), nil
@@ -234,39 +335,11 @@ func (s *ImageLibrary) GetSimilarImages(imageId apitype.ImageId) ([]*apitype.Ima
 	}
 }
 
-func (s *ImageLibrary) removeMissingImages(imageFiles []*apitype.ImageFile) error {
-	if images, err := s.imageStore.GetAllImages(); err != nil {
-		logger.Error.Print("Error while loading images", err)
-		return err
-	} else {
-		var existing = util.NewSet[string]()
-		var toRemove = map[apitype.ImageId]*apitype.ImageFile{}
-
-		for _, imageFile := range imageFiles {
-			existing.Add(imageFile.FileName())
-		}
-
-		for _, image := range images {
-			if !existing.Contains(image.FileName()) {
-				toRemove[image.Id()] = image
-			}
-		}
-		if len(toRemove) > 0 {
-			logger.Debug.Printf("Found %d images that don't exist anymore", len(toRemove))
-
-			for imageId, image := range toRemove {
-				logger.Trace.Printf("Removing image %s because it doesn't exist", image.String())
-				if err := s.imageStore.RemoveImage(imageId); err != nil {
-					logger.Error.Print("Can't remove", err)
-					return err
-				}
-			}
-			return nil
-		} else {
-			logger.Trace.Print("No missing images to remove")
-			return nil
-		}
+func subDirectoryOf(path string) string {
+	if directory := filepath.Dir(path); directory != "." {
+		return directory
 	}
+	return ""
 }
 
 func (s *ImageLibrary) addImagesToDb(imageList []*apitype.ImageFile) error {
@@ -279,14 +352,16 @@ func (s *ImageLibrary) addImagesToDb(imageList []*apitype.ImageFile) error {
 
 	imageCount := len(imageList)
 	duration := end.Sub(start)
-	avg := duration / time.Duration(imageCount)
-	logger.Debug.Printf("Added %d images in %s (avg. %s/image)", imageCount, duration, avg)
+	if imageCount > 0 {
+		avg := duration / time.Duration(imageCount)
+		logger.Debug.Printf("Added %d images in %s (avg. %s/image)", imageCount, duration, avg)
+	}
 	return nil
 }
 
 func (s *ImageLibrary) addImageMetaDataToDb(images []*apitype.ImageFile) error {
 	start := time.Now()
-	if err := s.imageMetaDataStore.AddMetaDataForImages(images, s.imageLoader.LoadExifData); err != nil {
+	if err := s.imageMetaDataStore.AddMetaDataForImages(images, s.loadExifData); err != nil {
 		return err
 	}
 
@@ -301,3 +376,14 @@ func (s *ImageLibrary) addImageMetaDataToDb(images []*apitype.ImageFile) error {
 	logger.Debug.Printf("Added meta data for %d images in %s (avg. %s/image)", imageCount, duration, avg)
 	return nil
 }
+
+// Images without Exif data (e.g. PNG images or JPEGs without orientation)
+// are stored without meta data instead of failing the whole scan
+func (s *ImageLibrary) loadExifData(imageFile *apitype.ImageFile) (*apitype.ExifData, error) {
+	if exifData, err := s.imageLoader.LoadExifData(imageFile); err != nil {
+		logger.Debug.Printf("No meta data for '%s': %s", imageFile.RelativePath(), err)
+		return nil, nil
+	} else {
+		return exifData, nil
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/upper/db/v4"
 	"image"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"vincit.fi/image-sorter/api"
@@ -27,6 +28,9 @@ func (s *MockSender) SendCommandToTopic(topic api.Topic, command apitype.Command
 type MockImageStore struct {
 	api.ImageStore
 	mock.Mock
+
+	prefetched  []apitype.ImageId
+	invalidated []apitype.ImageId
 }
 
 type MockImage struct {
@@ -42,6 +46,14 @@ func (s *MockImageStore) GetThumbnail(apitype.ImageId) (image.Image, error) {
 	return nil, nil
 }
 
+func (s *MockImageStore) Prefetch(imageIds []apitype.ImageId) {
+	s.prefetched = imageIds
+}
+
+func (s *MockImageStore) Invalidate(imageIds []apitype.ImageId) {
+	s.invalidated = append(s.invalidated, imageIds...)
+}
+
 type MockImageLoader struct {
 	api.ImageLoader
 	mock.Mock
@@ -55,8 +67,16 @@ type StubImageFileConverter struct {
 	database.ImageFileConverter
 }
 
+// Size, modification time and fingerprint are read from the file if it exists
 func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile) (*database.Image, map[string]string, error) {
 	metaData := map[string]string{}
+	byteSize := int64(1234)
+	modifiedTime := time.Now()
+	if info, err := os.Stat(imageFile.Path()); err == nil {
+		byteSize = info.Size()
+		modifiedTime = info.ModTime()
+	}
+	fingerprint, _ := s.GetImageFileFingerprint(imageFile)
 	if _, err := json.Marshal(metaData); err != nil {
 		return nil, nil, err
 	} else {
@@ -64,18 +84,28 @@ func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile
 			Id:              0,
 			Name:            imageFile.FileName(),
 			FileName:        imageFile.FileName(),
-			ByteSize:        1234,
+			RelativePath:    imageFile.RelativePath(),
+			ByteSize:        byteSize,
 			ExifOrientation: 1,
 			ImageAngle:      90,
 			ImageFlip:       true,
 			CreatedTime:     time.Now(),
 			Width:           1024,
 			Height:          2048,
-			ModifiedTime:    time.Now(),
+			ModifiedTime:    modifiedTime,
+			Fingerprint:     fingerprint,
 		}, metaData, nil
 	}
 }
 
+func (s *StubImageFileConverter) GetImageFileFingerprint(imageFile *apitype.ImageFile) (string, error) {
+	if content, err := os.ReadFile(imageFile.Path()); err != nil {
+		return "", err
+	} else {
+		return string(content), nil
+	}
+}
+
 type FakeFile struct {
 	name     string
 	contents string
@@ -716,36 +746,67 @@ func TestShowOnlyImages_ShowAllAgain(t *testing.T) {
 func TestShowOnlyImages_removeMissingImages(t *testing.T) {
 	a := assert.New(t)
 
+	directory := t.TempDir()
+	createImageFiles(t, directory, "foo0.jpg", "foo1.jpg", "foo2.jpg", "foo3.jpg", "foo4.jpg", "foo5.jpg")
 	sut := initializeSut()
 
 	// Add initial images to DB
-	err := sut.AddImageFiles([]*apitype.ImageFile{
-		apitype.NewImageFile("/tmp", "foo0"),
-		apitype.NewImageFile("/tmp", "foo1"),
-		apitype.NewImageFile("/tmp", "foo2"),
-		apitype.NewImageFile("/tmp", "foo3"),
-		apitype.NewImageFile("/tmp", "foo4"),
-		apitype.NewImageFile("/tmp", "foo5"),
-	})
+	_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{})
 
 	a.Nil(err)
 	a.Equal(6, len(sut.GetImages()))
 
-	// Simulate "removing" image by giving it an incomplete list
-	err = sut.removeMissingImages([]*apitype.ImageFile{
-		apitype.NewImageFile("/tmp", "foo0"),
-		apitype.NewImageFile("/tmp", "foo1"),
-		apitype.NewImageFile("/tmp", "foo3"),
-		apitype.NewImageFile("/tmp", "foo4"),
-	})
+	a.Nil(os.Remove(filepath.Join(directory, "foo2.jpg")))
+	a.Nil(os.Remove(filepath.Join(directory, "foo5.jpg")))
+	_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{})
 	a.Nil(err)
 
 	allImagesAfterRemove := sut.GetImages()
 	a.Equal(4, len(allImagesAfterRemove))
-	a.Equal("foo0", allImagesAfterRemove[0].FileName())
-	a.Equal("foo1", allImagesAfterRemove[1].FileName())
-	a.Equal("foo3", allImagesAfterRemove[2].FileName())
-	a.Equal("foo4", allImagesAfterRemove[3].FileName())
+	a.Equal("foo0.jpg", allImagesAfterRemove[0].FileName())
+	a.Equal("foo1.jpg", allImagesAfterRemove[1].FileName())
+	a.Equal("foo3.jpg", allImagesAfterRemove[2].FileName())
+	a.Equal("foo4.jpg", allImagesAfterRemove[3].FileName())
+}
+
+func TestShowOnlyImages_removeMissingImages_SubDirectories(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("Non-recursive keeps images in sub directories", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "foo0.jpg", "foo1.jpg", filepath.Join("sub", "foo0.jpg"))
+		sut := initializeSut()
+
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+
+		a.Nil(os.Remove(filepath.Join(directory, "foo1.jpg")))
+		_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+		a.Nil(err)
+
+		allImagesAfterRemove := sut.GetImages()
+		a.Equal(2, len(allImagesAfterRemove))
+		a.Equal("foo0.jpg", allImagesAfterRemove[0].RelativePath())
+		a.Equal(filepath.Join("sub", "foo0.jpg"), allImagesAfterRemove[1].RelativePath())
+	})
+
+	t.Run("Recursive removes images in sub directories", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "foo0.jpg", filepath.Join("sub", "foo0.jpg"), filepath.Join("sub", "foo1.jpg"))
+		sut := initializeSut()
+
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+
+		a.Nil(os.Remove(filepath.Join(directory, "sub", "foo0.jpg")))
+		_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+
+		allImagesAfterRemove := sut.GetImages()
+		a.Equal(2, len(allImagesAfterRemove))
+		a.Equal("foo0.jpg", allImagesAfterRemove[0].RelativePath())
+		a.Equal(filepath.Join("sub", "foo1.jpg"), allImagesAfterRemove[1].RelativePath())
+	})
 }
 
 func TestShowOnlyImages_GetSimilarImages(t *testing.T) {
@@ -798,3 +859,224 @@ func TestShowOnlyImages_GetSimilarImages(t *testing.T) {
 	similar3, _, _ := sut.GetSimilarImages(images[2].Id())
 	a.Equal(0, len(similar3))
 }
+
+// Files have their names as content so that each of them has a different fingerprint
+func createImageFiles(t *testing.T, directory string, fileNames ...string) {
+	for _, fileName := range fileNames {
+		path := filepath.Join(directory, fileName)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(fileName), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getRelativePaths(imageFiles []*apitype.ImageFile) []string {
+	var paths []string
+	for _, imageFile := range imageFiles {
+		paths = append(paths, imageFile.RelativePath())
+	}
+	return paths
+}
+
+func TestImageLibrary_UpdateImageFiles(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("Not initialized", func(t *testing.T) {
+		sut := initializeSut()
+
+		a.NotNil(sut.UpdateImageFiles([]string{"foo.jpg"}))
+	})
+
+	t.Run("Added, modified and removed files", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg", "b.jpg", "c.jpg", filepath.Join("sub", "d.jpg"))
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+		imageB, _ := imageStore.FindByRelativePath(apitype.NewImageFile(directory, "b.jpg"))
+		imageC, _ := imageStore.FindByRelativePath(apitype.NewImageFile(directory, "c.jpg"))
+		store.invalidated = nil
+
+		a.Nil(os.Remove(filepath.Join(directory, "b.jpg")))
+		createImageFiles(t, directory, "c.jpg", "e.jpg", filepath.Join("sub", "f.jpg"))
+		err = sut.UpdateImageFiles([]string{"b.jpg", "c.jpg", "e.jpg", filepath.Join("sub", "f.jpg")})
+
+		a.Nil(err)
+		a.ElementsMatch([]string{"a.jpg", "c.jpg", "e.jpg", filepath.Join("sub", "d.jpg"), filepath.Join("sub", "f.jpg")},
+			getRelativePaths(sut.GetImages()))
+		a.Equal([]apitype.ImageId{imageB.Id(), imageC.Id()}, store.invalidated)
+	})
+
+	t.Run("Moved file keeps its image", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg", filepath.Join("sub", "b.jpg"))
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+		imageA, _ := imageStore.FindByRelativePath(apitype.NewImageFile(directory, "a.jpg"))
+
+		a.Nil(os.Rename(filepath.Join(directory, "a.jpg"), filepath.Join(directory, "sub", "c.jpg")))
+		err = sut.UpdateImageFiles([]string{"a.jpg", filepath.Join("sub", "c.jpg")})
+
+		a.Nil(err)
+		imageC, _ := imageStore.FindByRelativePath(apitype.NewImageFile(directory, filepath.Join("sub", "c.jpg")))
+		a.Equal(imageA.Id(), imageC.Id())
+		a.Equal(2, len(sut.GetImages()))
+	})
+
+	t.Run("Removed directory", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg", filepath.Join("sub", "b.jpg"), filepath.Join("sub", "sub2", "c.jpg"))
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+
+		a.Nil(os.RemoveAll(filepath.Join(directory, "sub")))
+		err = sut.UpdateImageFiles([]string{"sub"})
+
+		a.Nil(err)
+		a.Equal([]string{"a.jpg"}, getRelativePaths(sut.GetImages()))
+	})
+
+	t.Run("New directory is scanned if the scan is recursive", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg")
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+
+		createImageFiles(t, directory, filepath.Join("new", "b.jpg"), filepath.Join("new", "sub", "c.jpg"))
+		err = sut.UpdateImageFiles([]string{"new"})
+
+		a.Nil(err)
+		a.ElementsMatch([]string{"a.jpg", filepath.Join("new", "b.jpg"), filepath.Join("new", "sub", "c.jpg")},
+			getRelativePaths(sut.GetImages()))
+	})
+}
+
+func TestImageLibrary_InitializeFromDirectory_Rescan(t *testing.T) {
+	a := assert.New(t)
+
+	findImage := func(directory string, relativePath string) *apitype.ImageFile {
+		imageFile, err := imageStore.FindByRelativePath(apitype.NewImageFile(directory, relativePath))
+		a.Nil(err)
+		return imageFile
+	}
+
+	t.Run("Modified file is updated", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg", "b.jpg")
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+		a.Nil(err)
+		imageA := findImage(directory, "a.jpg")
+
+		a.Nil(os.WriteFile(filepath.Join(directory, "a.jpg"), []byte("modified image"), 0644))
+		_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+
+		a.Nil(err)
+		a.Equal(imageA.Id(), findImage(directory, "a.jpg").Id())
+		a.Equal(int64(14), sut.GetImageFileById(imageA.Id()).ByteSize())
+		a.Equal(2, len(sut.GetImages()))
+	})
+
+	t.Run("Renamed file keeps its image", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg", "b.jpg")
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+		a.Nil(err)
+		imageA := findImage(directory, "a.jpg")
+
+		a.Nil(os.Rename(filepath.Join(directory, "a.jpg"), filepath.Join(directory, "c.jpg")))
+		_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+
+		a.Nil(err)
+		a.Equal([]string{"b.jpg", "c.jpg"}, getRelativePaths(sut.GetImages()))
+		a.Equal(imageA.Id(), findImage(directory, "c.jpg").Id())
+	})
+
+	t.Run("Moved file keeps its image", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg", filepath.Join("sub", "b.jpg"))
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+		a.Nil(err)
+		imageA := findImage(directory, "a.jpg")
+
+		a.Nil(os.Rename(filepath.Join(directory, "a.jpg"), filepath.Join(directory, "sub", "a.jpg")))
+		_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{Recursive: true})
+
+		a.Nil(err)
+		a.ElementsMatch([]string{filepath.Join("sub", "a.jpg"), filepath.Join("sub", "b.jpg")}, getRelativePaths(sut.GetImages()))
+		a.Equal(imageA.Id(), findImage(directory, filepath.Join("sub", "a.jpg")).Id())
+	})
+
+	t.Run("Copied file is a new image", func(t *testing.T) {
+		directory := t.TempDir()
+		createImageFiles(t, directory, "a.jpg")
+		sut := initializeSut()
+		_, err := sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+		a.Nil(err)
+		imageA := findImage(directory, "a.jpg")
+
+		a.Nil(os.WriteFile(filepath.Join(directory, "b.jpg"), []byte("a.jpg"), 0644))
+		_, err = sut.InitializeFromDirectory(directory, &api.ScanOptions{})
+
+		a.Nil(err)
+		a.Equal([]string{"a.jpg", "b.jpg"}, getRelativePaths(sut.GetImages()))
+		a.Equal(imageA.Id(), findImage(directory, "a.jpg").Id())
+		a.NotEqual(imageA.Id(), findImage(directory, "b.jpg").Id())
+	})
+}
+
+func TestImageLibrary_findRenamedImage(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	createImageFiles(t, directory, "a.jpg")
+	imageFiles, err := apitype.LoadImageFilesInSubDirectory(directory, "", nil)
+	a.Nil(err)
+	imageFile := imageFiles[0]
+	sut := initializeSut()
+
+	t.Run("Matching fingerprint", func(t *testing.T) {
+		state, fingerprint := sut.findRenamedImage(imageFile, []*database.ImageFileState{
+			{Id: 1, Fingerprint: "b.jpg"},
+			{Id: 2, Fingerprint: "a.jpg"},
+		}, map[apitype.ImageId]bool{})
+
+		a.Equal(apitype.ImageId(2), state.Id)
+		a.Equal("a.jpg", fingerprint)
+	})
+
+	t.Run("Already renamed", func(t *testing.T) {
+		state, _ := sut.findRenamedImage(imageFile, []*database.ImageFileState{
+			{Id: 2, Fingerprint: "a.jpg"},
+		}, map[apitype.ImageId]bool{2: true})
+
+		a.Nil(state)
+	})
+
+	t.Run("Without fingerprint by modification time", func(t *testing.T) {
+		state, fingerprint := sut.findRenamedImage(imageFile, []*database.ImageFileState{
+			{Id: 1, ModifiedTime: imageFile.ModifiedTime().Add(time.Second)},
+			{Id: 2, ModifiedTime: imageFile.ModifiedTime()},
+		}, map[apitype.ImageId]bool{})
+
+		a.Equal(apitype.ImageId(2), state.Id)
+		a.Equal("a.jpg", fingerprint)
+	})
+
+	t.Run("No match", func(t *testing.T) {
+		state, _ := sut.findRenamedImage(imageFile, []*database.ImageFileState{
+			{Id: 1, Fingerprint: "b.jpg"},
+			{Id: 2, ModifiedTime: imageFile.ModifiedTime().Add(time.Second)},
+		}, map[apitype.ImageId]bool{})
+
+		a.Nil(state)
+	})
+}
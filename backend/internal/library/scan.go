@@ -0,0 +1,217 @@
+package library
+
+import (
+	"time"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// Changes found while the directories are scanned. New and missing images are resolved
+// only after all the directories have been scanned so that the images moved from one
+// directory to another are detected as renames.
+type scanResult struct {
+	scannedDirectories map[string]bool
+	newImages          []*apitype.ImageFile
+	missingImages      []*database.ImageFileState
+	// Added and modified images whose meta data has to be loaded
+	loadedImages   []*apitype.ImageFile
+	modifiedCount  int
+	renamedCount   int
+	unchangedCount int
+}
+
+// Compares the image files of the walked directories with the stored images one directory at
+// a time and only adds, updates and removes the images that have changed. Stored images in
+// the directories that are in scope but were not walked (e.g. removed directories) are missing.
+// Missing images whose files are found with another name are renamed, so their categories
+// are kept.
+func (s *ImageLibrary) scanImages(walk func(apitype.WalkImageFilesFunc) error, inScope func(subDirectory string) bool, reportProgress bool) error {
+	start := time.Now()
+	if reportProgress {
+		s.progressReporter.Update("Loading images...", 0, 2, false, true)
+	}
+
+	result := &scanResult{scannedDirectories: map[string]bool{}}
+	if err := walk(func(subDirectory string, imageFiles []*apitype.ImageFile) error {
+		return s.scanDirectory(result, subDirectory, imageFiles)
+	}); err != nil {
+		return err
+	} else if err := s.findMissingDirectories(result, inScope); err != nil {
+		return err
+	} else if err := s.renameMovedImages(result); err != nil {
+		return err
+	}
+
+	if addedImages, err := s.imageStore.InsertImages(result.newImages); err != nil {
+		logger.Error.Print("cannot add images", err)
+		return err
+	} else {
+		result.loadedImages = append(result.loadedImages, addedImages...)
+	}
+
+	missingImageIds := make([]apitype.ImageId, len(result.missingImages))
+	for i, state := range result.missingImages {
+		logger.Trace.Printf("Removing image '%s' because it doesn't exist", state.RelativePath)
+		missingImageIds[i] = state.Id
+	}
+	if err := s.imageStore.RemoveImages(missingImageIds); err != nil {
+		logger.Error.Print("Can't remove", err)
+		return err
+	}
+
+	if reportProgress {
+		s.progressReporter.Update("Loading Meta Data...", 1, 2, false, true)
+	}
+	if imagesWithoutMetaData, err := s.imageMetaDataStore.GetAllImagesWithoutMetaData(s.directory); err != nil {
+		logger.Error.Print("cannot read images", err)
+		return err
+	} else if filesToAddMetaData := mergeLists(result.loadedImages, imagesWithoutMetaData); len(filesToAddMetaData) > 0 {
+		if err := s.addImageMetaDataToDb(filesToAddMetaData); err != nil {
+			return err
+		}
+	}
+
+	logger.Debug.Printf("Scanned %d directories in %s: %d new, %d modified, %d renamed, %d removed and %d unchanged images",
+		len(result.scannedDirectories), time.Since(start), len(result.newImages), result.modifiedCount,
+		result.renamedCount, len(result.missingImages), result.unchangedCount)
+	if reportProgress {
+		s.progressReporter.Update("Done", 2, 2, false, true)
+	}
+	return nil
+}
+
+// Images are compared by their size and modification time, so the files are not read.
+// Modified images are updated right away.
+func (s *ImageLibrary) scanDirectory(result *scanResult, subDirectory string, imageFiles []*apitype.ImageFile) error {
+	result.scannedDirectories[subDirectory] = true
+	states, err := s.imageStore.GetImageFileStates(subDirectory)
+	if err != nil {
+		return err
+	}
+
+	imageFilesByPath := map[string]*apitype.ImageFile{}
+	for _, imageFile := range imageFiles {
+		imageFilesByPath[imageFile.RelativePath()] = imageFile
+	}
+
+	modifiedImages := map[apitype.ImageId]*apitype.ImageFile{}
+	var associatedFilesChanged []*apitype.ImageFile
+	for _, state := range states {
+		if imageFile, ok := imageFilesByPath[state.RelativePath]; !ok {
+			result.missingImages = append(result.missingImages, state)
+		} else {
+			delete(imageFilesByPath, state.RelativePath)
+			if state.IsModified(imageFile) {
+				modifiedImages[state.Id] = imageFile
+			} else if !state.HasSameAssociatedFiles(imageFile) {
+				associatedFilesChanged = append(associatedFilesChanged, imageFile)
+			} else {
+				result.unchangedCount++
+			}
+		}
+	}
+	for _, imageFile := range imageFiles {
+		if _, ok := imageFilesByPath[imageFile.RelativePath()]; ok {
+			result.newImages = append(result.newImages, imageFile)
+		}
+	}
+
+	if len(modifiedImages) > 0 {
+		if updatedImages, err := s.imageStore.UpdateImages(modifiedImages); err != nil {
+			return err
+		} else {
+			result.loadedImages = append(result.loadedImages, updatedImages...)
+			result.modifiedCount += len(modifiedImages)
+		}
+	}
+	return s.imageStore.UpdateAssociatedFiles(associatedFilesChanged)
+}
+
+func (s *ImageLibrary) findMissingDirectories(result *scanResult, inScope func(subDirectory string) bool) error {
+	directories, err := s.imageStore.GetImageDirectories()
+	if err != nil {
+		return err
+	}
+	for _, directory := range directories {
+		if result.scannedDirectories[directory] || !inScope(directory) {
+			continue
+		}
+		if states, err := s.imageStore.GetImageFileStates(directory); err != nil {
+			return err
+		} else {
+			result.missingImages = append(result.missingImages, states...)
+		}
+	}
+	return nil
+}
+
+// New images are matched to the missing images with the same size by the fingerprint of their
+// content. Images that were stored without a fingerprint are matched by their modification time
+// instead, since renaming or moving the file doesn't change it.
+func (s *ImageLibrary) renameMovedImages(result *scanResult) error {
+	if len(result.newImages) == 0 || len(result.missingImages) == 0 {
+		return nil
+	}
+
+	missingBySize := map[int64][]*database.ImageFileState{}
+	for _, state := range result.missingImages {
+		missingBySize[state.ByteSize] = append(missingBySize[state.ByteSize], state)
+	}
+
+	renamed := map[apitype.ImageId]bool{}
+	var newImages []*apitype.ImageFile
+	for _, imageFile := range result.newImages {
+		state, fingerprint := s.findRenamedImage(imageFile, missingBySize[imageFile.ByteSize()], renamed)
+		if state == nil {
+			newImages = append(newImages, imageFile)
+			continue
+		}
+
+		logger.Debug.Printf("Image '%s' has been renamed to '%s'", state.RelativePath, imageFile.RelativePath())
+		if err := s.imageStore.RenameImage(state.Id, imageFile, fingerprint); err != nil {
+			return err
+		}
+		renamed[state.Id] = true
+	}
+
+	var missingImages []*database.ImageFileState
+	for _, state := range result.missingImages {
+		if !renamed[state.Id] {
+			missingImages = append(missingImages, state)
+		}
+	}
+	result.newImages = newImages
+	result.missingImages = missingImages
+	result.renamedCount = len(renamed)
+	return nil
+}
+
+// Returns the missing image that has the same content as the image file and the fingerprint
+// of the image file. The file is only read if there are candidates with a fingerprint.
+func (s *ImageLibrary) findRenamedImage(imageFile *apitype.ImageFile, candidates []*database.ImageFileState, renamed map[apitype.ImageId]bool) (*database.ImageFileState, string) {
+	fingerprint := ""
+	for _, candidate := range candidates {
+		if renamed[candidate.Id] {
+			continue
+		} else if candidate.Fingerprint == "" {
+			if candidate.ModifiedTime.Equal(imageFile.ModifiedTime()) {
+				fingerprint, _ = s.imageStore.GetImageFileFingerprint(imageFile)
+				return candidate, fingerprint
+			}
+			continue
+		}
+
+		if fingerprint == "" {
+			var err error
+			if fingerprint, err = s.imageStore.GetImageFileFingerprint(imageFile); err != nil {
+				logger.Warn.Printf("Could not read '%s': %s", imageFile.RelativePath(), err)
+				return nil, ""
+			}
+		}
+		if candidate.Fingerprint == fingerprint {
+			return candidate, fingerprint
+		}
+	}
+	return nil, ""
+}
@@ -12,37 +12,44 @@ import (
 var nextImage = &api.ImageAtQuery{Index: 1}
 var previousImage = &api.ImageAtQuery{Index: -1}
 
+// Number of images prefetched in the direction the user is browsing and in the opposite direction
+const prefetchAhead = 3
+const prefetchBehind = 1
+
 type Service struct {
-	sender             api.Sender
-	library            api.ImageLibrary
-	statusStore        *database.StatusStore
-	selectedCategoryId apitype.CategoryId
-	index              int
-	imageListSize      int
-	shouldSendSimilar  bool
-	imageLoadMux       sync.Mutex
+	sender      api.Sender
+	library     api.ImageLibrary
+	statusStore *database.StatusStore
+	filter      *apitype.ImageFilter
+	index       int
+	// 1 when moving forward and -1 when moving backward
+	travelDirection   int
+	imageListSize     int
+	shouldSendSimilar bool
+	imageLoadMux      sync.Mutex
 
 	api.ImageService
 }
 
 func NewImageService(sender api.Sender, library api.ImageLibrary, statusStore *database.StatusStore) *Service {
 	return &Service{
-		sender:             sender,
-		library:            library,
-		statusStore:        statusStore,
-		index:              0,
-		imageListSize:      5,
-		shouldSendSimilar:  false,
-		selectedCategoryId: apitype.NoCategory,
-		imageLoadMux:       sync.Mutex{},
+		sender:            sender,
+		library:           library,
+		statusStore:       statusStore,
+		index:             0,
+		travelDirection:   1,
+		imageListSize:     5,
+		shouldSendSimilar: false,
+		filter:            apitype.NoImageFilter(),
+		imageLoadMux:      sync.Mutex{},
 	}
 }
 
-func (s *Service) InitializeFromDirectory(directory string) {
+func (s *Service) InitializeFromDirectory(directory string, options *api.ScanOptions) {
 	s.imageLoadMux.Lock()
 	defer s.imageLoadMux.Unlock()
 	s.index = 0
-	latestUpdate, err := s.library.InitializeFromDirectory(directory)
+	latestUpdate, err := s.library.InitializeFromDirectory(directory, options)
 	if err != nil {
 		s.sender.SendError("Error while initializing images", err)
 	}
@@ -59,6 +66,8 @@ func (s *Service) InitializeFromDirectory(directory string) {
 			s.statusStore.UpdateTimestamp(database.ImageIndexUpdated, time.Now())
 		}
 	}
+
+	s.filter.Order = s.loadSortOrder()
 }
 
 func (s *Service) GetImageFiles() []*apitype.ImageFile {
@@ -69,15 +78,62 @@ func (s *Service) GetImageFiles() []*apitype.ImageFile {
 
 func (s *Service) ShowOnlyImages(command *api.SelectCategoryCommand) {
 	s.index = 0
-	s.selectedCategoryId = command.CategoryId
+	filter := *s.filter
+	filter.CategoryId = command.CategoryId
+	s.filter = &filter
+	s.RequestImages()
+}
+
+// Rating filter is combined with the selected category
+func (s *Service) ShowOnlyRatedImages(command *api.RatingFilterCommand) {
+	s.index = 0
+	filter := *s.filter
+	filter.MinRating = command.MinRating
+	filter.ColorLabel = command.ColorLabel
+	s.filter = &filter
+	s.RequestImages()
+}
+
+// Query is combined with the selected category and the rating filter
+func (s *Service) ShowOnlyMatchingImages(command *api.SearchCommand) {
+	s.index = 0
+	filter := *s.filter
+	filter.Query = command.Query
+	s.filter = &filter
 	s.RequestImages()
 }
 
+// Sort order is kept when the filters are removed
 func (s *Service) ShowAllImages() {
-	s.selectedCategoryId = apitype.NoCategory
+	order := s.filter.Order
+	s.filter = apitype.NoImageFilter()
+	s.filter.Order = order
 	s.RequestImages()
 }
 
+// Current image stays selected when the order changes
+func (s *Service) SetSortOrder(command *api.SortOrderCommand) {
+	if err := s.statusStore.SetValue(database.SortOrder, command.Order.String()); err != nil {
+		s.sender.SendError("Error while saving sort order", err)
+	}
+
+	currentImage, _, _, err := s.getCurrentImage()
+	filter := *s.filter
+	filter.Order = command.Order
+	s.filter = &filter
+	if err == nil && currentImage != nil {
+		s.moveToImage(currentImage.Id())
+	} else {
+		s.index = 0
+	}
+	logger.Info.Printf("Sorting images by %s", command.Order)
+	s.RequestImages()
+}
+
+func (s *Service) GetSortOrder() apitype.SortOrder {
+	return s.filter.Order
+}
+
 func (s *Service) RequestGenerateHashes() {
 	s.shouldSendSimilar = true
 	shouldGenerateHashes := s.checkHashStatus()
@@ -111,7 +167,7 @@ func (s *Service) checkHashStatus() bool {
 }
 
 func (s *Service) getCurrentImage() (*apitype.ImageFile, *apitype.ImageMetaData, int, error) {
-	return s.library.GetImageAtIndex(s.index, s.selectedCategoryId)
+	return s.library.GetFilteredImageAtIndex(s.index, s.filter)
 }
 
 func (s *Service) SetSendSimilarImages(command *api.SimilarImagesCommand) {
@@ -147,24 +203,42 @@ func (s *Service) RequestImage(query *api.ImageQuery) {
 }
 
 func (s *Service) moveToImage(imageId apitype.ImageId) {
-	s.index = s.findImageIndex(imageId, s.selectedCategoryId)
+	s.setIndex(s.findFilteredImageIndex(imageId, s.filter))
 }
 
 func (s *Service) findImageIndex(imageId apitype.ImageId, categoryId apitype.CategoryId) int {
-	images, _ := s.library.GetImagesInCategory(-1, 0, categoryId)
+	return s.findFilteredImageIndex(imageId, apitype.NewCategoryFilter(categoryId))
+}
+
+func (s *Service) findFilteredImageIndex(imageId apitype.ImageId, filter *apitype.ImageFilter) int {
+	index, _ := s.indexOfImage(imageId, filter)
+	return index
+}
+
+func (s *Service) indexOfImage(imageId apitype.ImageId, filter *apitype.ImageFilter) (int, bool) {
+	images, _ := s.library.GetFilteredImages(-1, 0, filter)
 	for imageIndex, image := range images {
 		if imageId == image.Id() {
-			return imageIndex
+			return imageIndex, true
 		}
 	}
-	return 0
+	return 0, false
 }
 
 func (s *Service) moveToImageAt(index int) {
-	count := s.library.GetTotalImages(s.selectedCategoryId)
+	count := s.library.GetTotalFilteredImages(s.filter)
 	newIndex := s.calculateNewIndexAndWrapNegative(index, count)
 
-	s.index = newIndex
+	s.setIndex(newIndex)
+}
+
+func (s *Service) setIndex(index int) {
+	if index > s.index {
+		s.travelDirection = 1
+	} else if index < s.index {
+		s.travelDirection = -1
+	}
+	s.index = index
 }
 
 func (s *Service) calculateNewIndexAndWrapNegative(index int, count int) int {
@@ -203,7 +277,12 @@ func (s *Service) moveToPreviousImageWithOffset(offset int) {
 }
 
 func (s *Service) requestImageWithOffset(offset int) {
-	count := s.library.GetTotalImages(s.selectedCategoryId)
+	count := s.library.GetTotalFilteredImages(s.filter)
+	if offset > 0 {
+		s.travelDirection = 1
+	} else if offset < 0 {
+		s.travelDirection = -1
+	}
 	s.index = s.calculateIndexOffsetAndClamp(s.index, offset, count)
 }
 
@@ -241,10 +320,39 @@ func (s *Service) AddImageFiles(imageList []*apitype.ImageFile) {
 	}
 }
 
+// Current image is kept if it still exists. Otherwise, the image at the same index is shown.
+func (s *Service) UpdateImageFiles(command *api.ImageFilesChangedCommand) {
+	currentImage, _, _, err := s.getCurrentImage()
+	if err != nil {
+		s.sender.SendError("Error while fetching images", err)
+		return
+	}
+
+	if err := s.library.UpdateImageFiles(command.Paths); err != nil {
+		s.sender.SendError("Error while updating images", err)
+	}
+
+	if index, ok := s.indexOfImage(currentImage.Id(), s.filter); ok {
+		s.index = index
+	} else {
+		s.index = s.calculateIndexOffsetAndClamp(s.index, 0, s.library.GetTotalFilteredImages(s.filter))
+	}
+	s.RequestImages()
+}
+
 func (s *Service) GetImageFileById(imageId apitype.ImageId) *apitype.ImageFile {
 	return s.library.GetImageFileById(imageId)
 }
 
+func (s *Service) GetImageMetaData(imageId apitype.ImageId) *apitype.ImageMetaData {
+	if metaData, err := s.library.GetImageMetaData(imageId); err != nil {
+		s.sender.SendError("Error while fetching image meta data", err)
+		return apitype.NewInvalidImageMetaData()
+	} else {
+		return metaData
+	}
+}
+
 // Private API
 
 func (s *Service) sendImages(sendCurrentImage bool) {
@@ -254,33 +362,40 @@ func (s *Service) sendImages(sendCurrentImage bool) {
 	if currentImage, metaData, currentIndex, err := s.getCurrentImage(); err != nil {
 		s.sender.SendError("Error while fetching images", err)
 	} else {
-		totalImages := s.library.GetTotalImages(s.selectedCategoryId)
+		totalImages := s.library.GetTotalFilteredImages(s.filter)
 		if totalImages == 0 {
 			currentIndex = 0
 		}
 
 		if sendCurrentImage {
 			s.sender.SendCommandToTopic(api.ImageCurrentUpdated, &api.UpdateImageCommand{
-				Image:      currentImage,
-				MetaData:   metaData,
-				Index:      currentIndex,
-				Total:      totalImages,
-				CategoryId: s.selectedCategoryId,
+				Image:    currentImage,
+				MetaData: metaData,
+				Index:    currentIndex,
+				Total:    totalImages,
+				Filter:   s.filter,
 			})
 		}
 
-		if nextImages, err := s.library.GetNextImages(s.index, s.imageListSize, s.selectedCategoryId); err != nil {
+		listSize := s.imageListSize
+		if sendCurrentImage && listSize < prefetchAhead {
+			listSize = prefetchAhead
+		}
+		if nextImages, err := s.library.GetNextFilteredImages(s.index, listSize, s.filter); err != nil {
 			s.sender.SendError("Error while fetching next images", err)
-		} else if previousImages, err := s.library.GetPreviousImages(s.index, s.imageListSize, s.selectedCategoryId); err != nil {
+		} else if previousImages, err := s.library.GetPreviousFilteredImages(s.index, listSize, s.filter); err != nil {
 			s.sender.SendError("Error while fetching previous images", err)
 		} else {
+			if sendCurrentImage {
+				s.prefetchImages(nextImages, previousImages)
+			}
 			s.sender.SendCommandToTopic(api.ImageListUpdated, &api.SetImagesCommand{
 				Topic:  api.ImageRequestPrevious,
-				Images: previousImages,
+				Images: firstImages(previousImages, s.imageListSize),
 			})
 			s.sender.SendCommandToTopic(api.ImageListUpdated, &api.SetImagesCommand{
 				Topic:  api.ImageRequestNext,
-				Images: nextImages,
+				Images: firstImages(nextImages, s.imageListSize),
 			})
 		}
 
@@ -290,6 +405,36 @@ func (s *Service) sendImages(sendCurrentImage bool) {
 	}
 }
 
+// Next and previous images are ordered so that the closest image is first
+func (s *Service) prefetchImages(nextImages []*apitype.ImageFile, previousImages []*apitype.ImageFile) {
+	ahead, behind := nextImages, previousImages
+	if s.travelDirection < 0 {
+		ahead, behind = previousImages, nextImages
+	}
+	var imageFiles []*apitype.ImageFile
+	imageFiles = append(imageFiles, firstImages(ahead, prefetchAhead)...)
+	imageFiles = append(imageFiles, firstImages(behind, prefetchBehind)...)
+	s.library.PrefetchImages(imageFiles)
+}
+
+func firstImages(imageFiles []*apitype.ImageFile, count int) []*apitype.ImageFile {
+	if len(imageFiles) > count {
+		return imageFiles[:count]
+	}
+	return imageFiles
+}
+
+func (s *Service) loadSortOrder() apitype.SortOrder {
+	if value, err := s.statusStore.GetValue(database.SortOrder); err != nil {
+		s.sender.SendError("Error while loading sort order", err)
+	} else if order, err := apitype.ParseSortOrder(value); err != nil {
+		s.sender.SendError("Invalid sort order", err)
+	} else {
+		return order
+	}
+	return apitype.DefaultSortOrder()
+}
+
 func (s *Service) sendSimilarImages(imageId apitype.ImageId) {
 	if images, shouldSend, err := s.library.GetSimilarImages(imageId); err != nil {
 		s.sender.SendError("Error while fetching similar images", err)
@@ -2,7 +2,10 @@ package library
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
+	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
 )
 
@@ -141,3 +144,70 @@ func TestService_moveToImage(t *testing.T) {
 	})
 
 }
+
+func TestService_prefetchImages(t *testing.T) {
+	a := assert.New(t)
+
+	sutService = initializeSutService()
+	next := []*apitype.ImageFile{
+		apitype.NewImageFileWithId(4, "/tmp", "foo4", 400, 300),
+		apitype.NewImageFileWithId(5, "/tmp", "foo5", 400, 300),
+		apitype.NewImageFileWithId(6, "/tmp", "foo6", 400, 300),
+		apitype.NewImageFileWithId(7, "/tmp", "foo7", 400, 300),
+	}
+	previous := []*apitype.ImageFile{
+		apitype.NewImageFileWithId(2, "/tmp", "foo2", 400, 300),
+		apitype.NewImageFileWithId(1, "/tmp", "foo1", 400, 300),
+	}
+
+	t.Run("Moving forward", func(t *testing.T) {
+		sutService.travelDirection = 1
+		sutService.prefetchImages(next, previous)
+		a.Equal([]apitype.ImageId{4, 5, 6, 2}, store.prefetched)
+	})
+
+	t.Run("Moving backward", func(t *testing.T) {
+		sutService.travelDirection = -1
+		sutService.prefetchImages(next, previous)
+		a.Equal([]apitype.ImageId{2, 1, 4}, store.prefetched)
+	})
+
+	t.Run("Direction follows the index", func(t *testing.T) {
+		sutService.index = 3
+		sutService.setIndex(5)
+		a.Equal(1, sutService.travelDirection)
+		sutService.setIndex(2)
+		a.Equal(-1, sutService.travelDirection)
+		sutService.setIndex(2)
+		a.Equal(-1, sutService.travelDirection)
+	})
+}
+
+func TestService_UpdateImageFiles(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	createImageFiles(t, directory, "a.jpg", "b.jpg", "c.jpg")
+	sutService = NewImageService(sender, initializeSut(), statusStore)
+	sutService.InitializeFromDirectory(directory, &api.ScanOptions{})
+
+	t.Run("Current image is kept", func(t *testing.T) {
+		sutService.RequestImageAt(&api.ImageAtQuery{Index: 1})
+
+		a.Nil(os.Remove(filepath.Join(directory, "a.jpg")))
+		sutService.UpdateImageFiles(&api.ImageFilesChangedCommand{Paths: []string{"a.jpg"}})
+
+		currentImage, _, index, _ := sutService.getCurrentImage()
+		a.Equal("b.jpg", currentImage.FileName())
+		a.Equal(0, index)
+	})
+
+	t.Run("Image at the same index is shown if the current image is removed", func(t *testing.T) {
+		a.Nil(os.Remove(filepath.Join(directory, "b.jpg")))
+		sutService.UpdateImageFiles(&api.ImageFilesChangedCommand{Paths: []string{"b.jpg"}})
+
+		currentImage, _, index, _ := sutService.getCurrentImage()
+		a.Equal("c.jpg", currentImage.FileName())
+		a.Equal(0, index)
+	})
+}
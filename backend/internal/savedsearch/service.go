@@ -0,0 +1,119 @@
+package savedsearch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/common"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+type Service struct {
+	sender           api.Sender
+	imageStore       *database.ImageStore
+	categoryStore    *database.CategoryStore
+	savedSearchStore *database.SavedSearchStore
+
+	api.SavedSearchService
+}
+
+func NewSavedSearchService(sender api.Sender, imageStore *database.ImageStore, categoryStore *database.CategoryStore, savedSearchStore *database.SavedSearchStore) api.SavedSearchService {
+	return &Service{
+		sender:           sender,
+		imageStore:       imageStore,
+		categoryStore:    categoryStore,
+		savedSearchStore: savedSearchStore,
+	}
+}
+
+// Sends the saved searches with the current image counts
+func (s *Service) RequestSavedSearches() {
+	s.sender.SendCommandToTopic(api.SavedSearchesUpdated, &api.SavedSearchesCommand{
+		SavedSearches: s.GetSavedSearches(),
+	})
+}
+
+func (s *Service) GetSavedSearches() []*api.SavedSearchCount {
+	savedSearches, err := s.savedSearchStore.GetSavedSearches()
+	if err != nil {
+		s.sender.SendError("Error while fetching saved searches", err)
+		return []*api.SavedSearchCount{}
+	}
+
+	counts := make([]*api.SavedSearchCount, len(savedSearches))
+	for i, savedSearch := range savedSearches {
+		counts[i] = &api.SavedSearchCount{
+			SavedSearch: savedSearch,
+			ImageCount:  s.imageStore.GetFilteredImageCount(&apitype.ImageFilter{CategoryId: apitype.NoCategory, Query: savedSearch.Query()}),
+		}
+	}
+	return counts
+}
+
+func (s *Service) SaveSearch(command *api.SaveSearchCommand) {
+	if savedSearch, err := s.toSavedSearch(command); err != nil {
+		s.sender.SendError("Invalid saved search", err)
+	} else if _, err := s.savedSearchStore.SaveSearch(savedSearch); err != nil {
+		s.sender.SendError("Error while saving search", err)
+	} else {
+		logger.Info.Printf("Saved search '%s': %s", savedSearch.Name(), savedSearch.Query())
+		s.RequestSavedSearches()
+	}
+}
+
+func (s *Service) RemoveSavedSearch(command *api.RemoveSavedSearchCommand) {
+	if err := s.savedSearchStore.RemoveSavedSearch(command.Id); err != nil {
+		s.sender.SendError("Error while removing saved search", err)
+	} else {
+		s.RequestSavedSearches()
+	}
+}
+
+func (s *Service) Close() {
+	logger.Info.Print("Shutting down saved search service")
+}
+
+// Private API
+
+// Shortcut may not conflict with the category shortcuts or with the other saved searches
+func (s *Service) toSavedSearch(command *api.SaveSearchCommand) (*apitype.SavedSearch, error) {
+	name := strings.TrimSpace(command.Name)
+	if name == "" {
+		return nil, errors.New("name is empty")
+	}
+	query, err := apitype.ParseSearchQuery(command.Query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err)
+	} else if query == nil {
+		return nil, errors.New("query is empty")
+	}
+	shortcut, err := common.ParseKeySequence(command.Shortcut)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shortcut) > 0 {
+		categories, err := s.categoryStore.GetCategories()
+		if err != nil {
+			return nil, err
+		}
+		for _, category := range categories {
+			if category.Shortcut().ConflictsWith(shortcut) {
+				return nil, fmt.Errorf("shortcut '%s' conflicts with category '%s'", shortcut, category.FullName())
+			}
+		}
+		savedSearches, err := s.savedSearchStore.GetSavedSearches()
+		if err != nil {
+			return nil, err
+		}
+		for _, savedSearch := range savedSearches {
+			if !strings.EqualFold(savedSearch.Name(), name) && savedSearch.Shortcut().ConflictsWith(shortcut) {
+				return nil, fmt.Errorf("shortcut '%s' conflicts with saved search '%s'", shortcut, savedSearch.Name())
+			}
+		}
+	}
+	return apitype.NewSavedSearch(apitype.NoSavedSearch, name, query, shortcut.String()), nil
+}
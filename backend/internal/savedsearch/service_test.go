@@ -0,0 +1,104 @@
+package savedsearch
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"testing"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+)
+
+type MockSender struct {
+	api.Sender
+	mock.Mock
+}
+
+func (s *MockSender) SendCommandToTopic(topic api.Topic, command apitype.Command) {
+	s.Called(topic, command)
+}
+
+func (s *MockSender) SendError(message string, err error) {
+	s.Called(message, err)
+}
+
+type StubImageFileConverter struct {
+	database.ImageFileConverter
+}
+
+func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile) (*database.Image, map[string]string, error) {
+	return &database.Image{
+		Name:         imageFile.FileName(),
+		FileName:     imageFile.FileName(),
+		RelativePath: imageFile.RelativePath(),
+		ModifiedTime: time.Now(),
+	}, map[string]string{}, nil
+}
+
+func (s *StubImageFileConverter) GetImageFileStats(*apitype.ImageFile) (os.FileInfo, error) {
+	return nil, nil
+}
+
+func TestSaveSearch(t *testing.T) {
+	a := assert.New(t)
+
+	sender := new(MockSender)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything).Return()
+	sender.On("SendError", mock.Anything, mock.Anything).Return()
+	memoryDatabase := database.NewInMemoryDatabase("")
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	categoryStore := database.NewCategoryStore(memoryDatabase)
+	imageCategoryStore := database.NewImageCategoryStore(memoryDatabase)
+	sut := NewSavedSearchService(sender, imageStore, categoryStore, database.NewSavedSearchStore(memoryDatabase))
+
+	image1, _ := imageStore.AddImage(apitype.NewImageFile("images", "image1"))
+	_, _ = imageStore.AddImage(apitype.NewImageFile("images", "image2"))
+	good, _ := categoryStore.AddCategory(apitype.NewCategory("Good", "good", "G"))
+
+	t.Run("Save and count", func(t *testing.T) {
+		sut.SaveSearch(&api.SaveSearchCommand{Name: " Uncategorized ", Query: "uncategorized", Shortcut: "alt+u"})
+
+		savedSearches := sut.GetSavedSearches()
+		if a.Equal(1, len(savedSearches)) {
+			a.Equal("Uncategorized", savedSearches[0].SavedSearch.Name())
+			a.Equal("Alt+U", savedSearches[0].SavedSearch.ShortcutAsString())
+			a.Equal(2, savedSearches[0].ImageCount)
+		}
+		sender.AssertCalled(t, "SendCommandToTopic", api.SavedSearchesUpdated, &api.SavedSearchesCommand{SavedSearches: savedSearches})
+	})
+
+	t.Run("Count follows the categorization", func(t *testing.T) {
+		_ = imageCategoryStore.CategorizeImage(image1.Id(), good.Id(), apitype.CATEGORIZE)
+
+		savedSearches := sut.GetSavedSearches()
+		if a.Equal(1, len(savedSearches)) {
+			a.Equal(1, savedSearches[0].ImageCount)
+		}
+	})
+
+	t.Run("Invalid searches", func(t *testing.T) {
+		sut.SaveSearch(&api.SaveSearchCommand{Name: "", Query: "uncategorized"})
+		sut.SaveSearch(&api.SaveSearchCommand{Name: "Empty", Query: " "})
+		sut.SaveSearch(&api.SaveSearchCommand{Name: "Invalid", Query: "width>wide"})
+		sut.SaveSearch(&api.SaveSearchCommand{Name: "Category shortcut", Query: "category:Good", Shortcut: "G"})
+		sut.SaveSearch(&api.SaveSearchCommand{Name: "Search shortcut", Query: "category:Good", Shortcut: "Alt+U"})
+
+		a.Equal(1, len(sut.GetSavedSearches()))
+		sender.AssertNumberOfCalls(t, "SendError", 5)
+	})
+
+	t.Run("Replace and remove", func(t *testing.T) {
+		sut.SaveSearch(&api.SaveSearchCommand{Name: "uncategorized", Query: "NOT uncategorized", Shortcut: "Alt+U"})
+
+		savedSearches := sut.GetSavedSearches()
+		if a.Equal(1, len(savedSearches)) {
+			a.Equal("NOT uncategorized", savedSearches[0].SavedSearch.Query().String())
+			a.Equal(1, savedSearches[0].ImageCount)
+
+			sut.RemoveSavedSearch(&api.RemoveSavedSearchCommand{Id: savedSearches[0].SavedSearch.Id()})
+			a.Empty(sut.GetSavedSearches())
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package tag
+
+import (
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+type Service struct {
+	sender         api.Sender
+	imageStore     *database.ImageStore
+	tagStore       *database.TagStore
+	currentImageId apitype.ImageId
+
+	api.TagService
+}
+
+func NewTagService(sender api.Sender, imageStore *database.ImageStore, tagStore *database.TagStore) api.TagService {
+	return &Service{
+		sender:         sender,
+		imageStore:     imageStore,
+		tagStore:       tagStore,
+		currentImageId: apitype.NoImage,
+	}
+}
+
+func (s *Service) RequestTags(query *api.ImageCategoryQuery) {
+	s.currentImageId = query.ImageId
+	s.sendImageTags(query.ImageId)
+	s.sendTags()
+}
+
+func (s *Service) GetImageTags(imageId apitype.ImageId) []string {
+	if tags, err := s.tagStore.GetImageTags(imageId); err != nil {
+		s.sender.SendError("Error while fetching image's tags", err)
+		return []string{}
+	} else {
+		return tags
+	}
+}
+
+func (s *Service) GetTags() []string {
+	if tags, err := s.tagStore.GetTags(); err != nil {
+		s.sender.SendError("Error while fetching tags", err)
+		return []string{}
+	} else {
+		return tags
+	}
+}
+
+func (s *Service) TagImage(command *api.TagImageCommand) {
+	if command.ImageId <= 0 {
+		logger.Warn.Printf("Trying to tag invalid imageId=%d", command.ImageId)
+	} else if s.setTag([]apitype.ImageId{command.ImageId}, command.Tag, command.Remove) {
+		s.sendImageTags(command.ImageId)
+		s.sendTags()
+		s.sender.SendToTopic(api.ImageAnnotationsUpdated)
+	}
+}
+
+func (s *Service) TagFilteredImages(command *api.TagFilteredImagesCommand) {
+	images, err := s.imageStore.GetFilteredImages(-1, 0, command.Filter)
+	if err != nil {
+		s.sender.SendError("Error while fetching images to tag", err)
+		return
+	}
+
+	imageIds := make([]apitype.ImageId, len(images))
+	for i, image := range images {
+		imageIds[i] = image.Id()
+	}
+	if s.setTag(imageIds, command.Tag, command.Remove) {
+		logger.Info.Printf("Tagged %d images with '%s' (remove=%t)", len(imageIds), command.Tag, command.Remove)
+		if s.currentImageId != apitype.NoImage {
+			s.sendImageTags(s.currentImageId)
+		}
+		s.sendTags()
+		s.sender.SendToTopic(api.ImageAnnotationsUpdated)
+	}
+}
+
+func (s *Service) Close() {
+	logger.Info.Print("Shutting down tag service")
+}
+
+// Private API
+
+// Returns true if the tags were changed
+func (s *Service) setTag(imageIds []apitype.ImageId, name string, remove bool) bool {
+	tag := apitype.NormalizeTagName(name)
+	if tag == "" {
+		logger.Warn.Printf("Invalid tag '%s'", name)
+		return false
+	}
+
+	var err error
+	if remove {
+		err = s.tagStore.RemoveTag(imageIds, tag)
+	} else {
+		err = s.tagStore.AddTag(imageIds, tag)
+	}
+	if err != nil {
+		s.sender.SendError("Error while setting tag", err)
+		return false
+	}
+	return true
+}
+
+func (s *Service) sendImageTags(imageId apitype.ImageId) {
+	s.sender.SendCommandToTopic(api.ImageTagsUpdated, &api.ImageTagsCommand{
+		ImageId: imageId,
+		Tags:    s.GetImageTags(imageId),
+	})
+}
+
+func (s *Service) sendTags() {
+	s.sender.SendCommandToTopic(api.TagsUpdated, &api.TagsCommand{
+		Tags: s.GetTags(),
+	})
+}
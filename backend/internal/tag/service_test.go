@@ -0,0 +1,108 @@
+package tag
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"testing"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend/internal/database"
+)
+
+type MockSender struct {
+	api.Sender
+	mock.Mock
+}
+
+func (s *MockSender) SendToTopic(topic api.Topic) {
+	s.Called(topic)
+}
+
+func (s *MockSender) SendCommandToTopic(topic api.Topic, command apitype.Command) {
+	s.Called(topic, command)
+}
+
+func (s *MockSender) SendError(message string, err error) {
+}
+
+type StubImageFileConverter struct {
+	database.ImageFileConverter
+}
+
+func (s *StubImageFileConverter) ImageFileToDbImage(imageFile *apitype.ImageFile) (*database.Image, map[string]string, error) {
+	return &database.Image{
+		Name:         imageFile.FileName(),
+		FileName:     imageFile.FileName(),
+		RelativePath: imageFile.RelativePath(),
+		ModifiedTime: time.Now(),
+	}, map[string]string{}, nil
+}
+
+func (s *StubImageFileConverter) GetImageFileStats(*apitype.ImageFile) (os.FileInfo, error) {
+	return nil, nil
+}
+
+func TestTagImage(t *testing.T) {
+	a := assert.New(t)
+
+	sender := new(MockSender)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
+	memoryDatabase := database.NewInMemoryDatabase("")
+	sut := NewTagService(sender, database.NewImageStore(memoryDatabase, &StubImageFileConverter{}), database.NewTagStore(memoryDatabase))
+
+	sut.TagImage(&api.TagImageCommand{ImageId: 1, Tag: " Beach "})
+	sut.TagImage(&api.TagImageCommand{ImageId: 1, Tag: "sunset"})
+	sut.TagImage(&api.TagImageCommand{ImageId: 1, Tag: "sunset", Remove: true})
+	sut.TagImage(&api.TagImageCommand{ImageId: 1, Tag: "a,b"})
+
+	a.Equal([]string{"Beach"}, sut.GetImageTags(1))
+	a.Equal([]string{"Beach"}, sut.GetTags())
+	sender.AssertCalled(t, "SendCommandToTopic", api.ImageTagsUpdated, &api.ImageTagsCommand{
+		ImageId: 1,
+		Tags:    []string{"Beach"},
+	})
+	sender.AssertCalled(t, "SendCommandToTopic", api.TagsUpdated, &api.TagsCommand{Tags: []string{"Beach"}})
+}
+
+func TestTagFilteredImages(t *testing.T) {
+	a := assert.New(t)
+
+	sender := new(MockSender)
+	sender.On("SendCommandToTopic", mock.Anything, mock.Anything).Return()
+	sender.On("SendToTopic", api.ImageAnnotationsUpdated).Return()
+	memoryDatabase := database.NewInMemoryDatabase("")
+	imageStore := database.NewImageStore(memoryDatabase, &StubImageFileConverter{})
+	ratingStore := database.NewImageRatingStore(memoryDatabase)
+	sut := NewTagService(sender, imageStore, database.NewTagStore(memoryDatabase))
+
+	image1, _ := imageStore.AddImage(apitype.NewImageFile("images", "image1"))
+	image2, _ := imageStore.AddImage(apitype.NewImageFile("images", "image2"))
+	image3, _ := imageStore.AddImage(apitype.NewImageFile("images", "image3"))
+	_ = ratingStore.SetRating(image1.Id(), 4)
+	_ = ratingStore.SetRating(image3.Id(), 5)
+
+	sut.RequestTags(&api.ImageCategoryQuery{ImageId: image1.Id()})
+	sut.TagFilteredImages(&api.TagFilteredImagesCommand{
+		Filter: &apitype.ImageFilter{CategoryId: apitype.NoCategory, MinRating: 4},
+		Tag:    "best",
+	})
+
+	a.Equal([]string{"best"}, sut.GetImageTags(image1.Id()))
+	a.Empty(sut.GetImageTags(image2.Id()))
+	a.Equal([]string{"best"}, sut.GetImageTags(image3.Id()))
+	sender.AssertCalled(t, "SendCommandToTopic", api.ImageTagsUpdated, &api.ImageTagsCommand{
+		ImageId: image1.Id(),
+		Tags:    []string{"best"},
+	})
+
+	sut.TagFilteredImages(&api.TagFilteredImagesCommand{
+		Filter: apitype.NoImageFilter(),
+		Tag:    "best",
+		Remove: true,
+	})
+	a.Empty(sut.GetImageTags(image1.Id()))
+	a.Empty(sut.GetTags())
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Returns true if the paths are on the same device, so the files can be renamed between them.
+// Paths that don't exist yet are resolved to the closest parent directory that exists.
+func IsSameDevice(path1 string, path2 string) (bool, error) {
+	device1, err := getDevice(path1)
+	if err != nil {
+		return false, err
+	}
+	device2, err := getDevice(path2)
+	if err != nil {
+		return false, err
+	}
+	return device1 == device2, nil
+}
+
+func getDevice(path string) (uint64, error) {
+	for !DoesPathExist(path) && filepath.Dir(path) != path {
+		path = filepath.Dir(path)
+	}
+	if info, err := os.Stat(path); err != nil {
+		return 0, err
+	} else {
+		return uint64(info.Sys().(*syscall.Stat_t).Dev), nil
+	}
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsSameDevice(t *testing.T) {
+	a := require.New(t)
+	dir := t.TempDir()
+
+	sameDevice, err := IsSameDevice(dir, filepath.Join(dir, "missing", "dir"))
+	a.Nil(err)
+	a.True(sameDevice)
+
+	sameDevice, err = IsSameDevice(dir, "/proc")
+	a.Nil(err)
+	a.False(sameDevice)
+}
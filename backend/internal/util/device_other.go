@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "errors"
+
+// Returns true if the paths are on the same device. Only supported on Linux.
+func IsSameDevice(path1 string, path2 string) (bool, error) {
+	return false, errors.New("comparing devices is not supported on this platform")
+}
@@ -0,0 +1,200 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const jpegApp1 = 0xE1
+
+// Exif data is stored as TIFF in an APP1 segment
+const exifHeader = "Exif\x00\x00"
+
+const (
+	tiffHeaderSize   = 8
+	tiffIfdEntrySize = 12
+	tiffTypeShort    = 3
+	exifRatingTag    = 0x4746
+)
+
+// Exif APP1 segment of a JPEG image. Start and end are -1 if the image
+// doesn't have one, and then a new segment is inserted at insertAt.
+type jpegExifSegment struct {
+	start    int
+	end      int
+	insertAt int
+}
+
+// Sets the Exif Rating of a JPEG image. Existing rating is replaced where it is. Otherwise
+// the first IFD is copied with the rating to the end of the Exif data, so that the offsets
+// of the other values stay the same. Rest of the image is kept as it is.
+func SetJpegExifRating(content []byte, rating int) ([]byte, error) {
+	segment, err := findJpegExifSegment(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var tiff []byte
+	if segment.start < 0 {
+		tiff = newTiffWithRating(rating)
+	} else if tiff, err = setTiffRating(content[segment.start+4+len(exifHeader):segment.end], rating); err != nil {
+		return nil, err
+	}
+
+	length := 2 + len(exifHeader) + len(tiff)
+	if length > 0xFFFF {
+		return nil, errors.New("Exif data is too large")
+	}
+	var app1 bytes.Buffer
+	app1.Write([]byte{0xFF, jpegApp1})
+	_ = binary.Write(&app1, binary.BigEndian, uint16(length))
+	app1.WriteString(exifHeader)
+	app1.Write(tiff)
+
+	var result bytes.Buffer
+	if segment.start >= 0 {
+		result.Write(content[:segment.start])
+		result.Write(app1.Bytes())
+		result.Write(content[segment.end:])
+	} else {
+		result.Write(content[:segment.insertAt])
+		result.Write(app1.Bytes())
+		result.Write(content[segment.insertAt:])
+	}
+	return result.Bytes(), nil
+}
+
+// Private API
+
+// The new segment is inserted after JFIF, which must be the first segment
+func findJpegExifSegment(content []byte) (*jpegExifSegment, error) {
+	segment := &jpegExifSegment{start: -1, end: -1, insertAt: 2}
+	err := walkJpegSegments(content, func(marker byte, start int, end int) bool {
+		if marker == jpegApp1 && bytes.HasPrefix(content[start+4:end], []byte(exifHeader)) {
+			segment.start = start
+			segment.end = end
+			return false
+		} else if marker == jpegApp0 && start == 2 {
+			segment.insertAt = end
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return segment, nil
+}
+
+// Calls visit for each segment before the image data until it returns false.
+// Start is the index of the marker and end the index after the segment.
+func walkJpegSegments(content []byte, visit func(marker byte, start int, end int) bool) error {
+	if len(content) < 4 || content[0] != 0xFF || content[1] != jpegStartOfImage {
+		return errors.New("not a JPEG image")
+	}
+
+	position := 2
+	for position+4 <= len(content) {
+		if content[position] != 0xFF {
+			return fmt.Errorf("invalid JPEG marker at %d", position)
+		}
+		marker := content[position+1]
+		if marker == 0xFF {
+			// Fill byte
+			position++
+			continue
+		} else if marker == jpegStartOfScan || marker == jpegEndOfImage {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(content[position+2:]))
+		end := position + 2 + length
+		if length < 2 || end > len(content) {
+			return fmt.Errorf("invalid JPEG segment at %d", position)
+		}
+		if !visit(marker, position, end) {
+			break
+		}
+		position = end
+	}
+	return nil
+}
+
+func newTiffWithRating(rating int) []byte {
+	tiff := make([]byte, tiffHeaderSize+2+tiffIfdEntrySize+4)
+	copy(tiff, "II")
+	binary.LittleEndian.PutUint16(tiff[2:], 42)
+	binary.LittleEndian.PutUint32(tiff[4:], tiffHeaderSize)
+	binary.LittleEndian.PutUint16(tiff[tiffHeaderSize:], 1)
+	setRatingEntry(tiff[tiffHeaderSize+2:], binary.LittleEndian, rating)
+	return tiff
+}
+
+func setTiffRating(data []byte, rating int) ([]byte, error) {
+	if len(data) < tiffHeaderSize {
+		return nil, errors.New("invalid Exif data")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid Exif byte order")
+	}
+
+	ifdStart := int(order.Uint32(data[4:]))
+	if ifdStart < tiffHeaderSize || ifdStart+2 > len(data) {
+		return nil, errors.New("invalid Exif IFD offset")
+	}
+	entryCount := int(order.Uint16(data[ifdStart:]))
+	entriesEnd := ifdStart + 2 + entryCount*tiffIfdEntrySize
+	if entriesEnd+4 > len(data) {
+		return nil, errors.New("invalid Exif IFD")
+	}
+
+	tiff := append([]byte{}, data...)
+	var entries [][]byte
+	for i := 0; i < entryCount; i++ {
+		entry := tiff[ifdStart+2+i*tiffIfdEntrySize : ifdStart+2+(i+1)*tiffIfdEntrySize]
+		if order.Uint16(entry) == exifRatingTag {
+			setRatingEntry(entry, order, rating)
+			return tiff, nil
+		}
+		entries = append(entries, entry)
+	}
+
+	// Entries must be in the order of the tags
+	ratingEntry := make([]byte, tiffIfdEntrySize)
+	setRatingEntry(ratingEntry, order, rating)
+	index := sort.Search(len(entries), func(i int) bool {
+		return order.Uint16(entries[i]) > exifRatingTag
+	})
+	entries = append(entries[:index], append([][]byte{ratingEntry}, entries[index:]...)...)
+
+	var ifd bytes.Buffer
+	_ = binary.Write(&ifd, order, uint16(len(entries)))
+	for _, entry := range entries {
+		ifd.Write(entry)
+	}
+	// Offset of the next IFD, e.g. the thumbnail
+	ifd.Write(data[entriesEnd : entriesEnd+4])
+
+	// IFDs start at a word boundary
+	if len(tiff)%2 != 0 {
+		tiff = append(tiff, 0)
+	}
+	order.PutUint32(tiff[4:], uint32(len(tiff)))
+	return append(tiff, ifd.Bytes()...), nil
+}
+
+func setRatingEntry(entry []byte, order binary.ByteOrder, rating int) {
+	order.PutUint16(entry, exifRatingTag)
+	order.PutUint16(entry[2:], tiffTypeShort)
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], uint16(rating))
+	order.PutUint16(entry[10:], 0)
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const exifRating exif.FieldName = "Rating"
+
+// JPEG with big endian Exif that has only the camera model. The value is stored after the IFD.
+func newTestJpegWithModel(t *testing.T, model string) []byte {
+	value := append([]byte(model), 0)
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	for _, field := range []interface{}{
+		uint16(42), uint32(tiffHeaderSize),
+		uint16(1), uint16(0x0110), uint16(2), uint32(len(value)), uint32(tiffHeaderSize + 2 + tiffIfdEntrySize + 4),
+		uint32(0),
+	} {
+		_ = binary.Write(&tiff, binary.BigEndian, field)
+	}
+	tiff.Write(value)
+
+	var app1 bytes.Buffer
+	app1.Write([]byte{0xFF, jpegApp1})
+	_ = binary.Write(&app1, binary.BigEndian, uint16(2+len(exifHeader)+tiff.Len()))
+	app1.WriteString(exifHeader)
+	app1.Write(tiff.Bytes())
+
+	content := newTestJpeg(t)
+	return append(append(append([]byte{}, content[:2]...), app1.Bytes()...), content[2:]...)
+}
+
+func readExif(t *testing.T, content []byte) *exif.Exif {
+	a := require.New(t)
+
+	x, err := exif.Decode(bytes.NewReader(content))
+	a.Nil(err)
+	x.LoadTags(x.Tiff.Dirs[0], map[uint16]exif.FieldName{exifRatingTag: exifRating}, false)
+	return x
+}
+
+func requireExifRating(t *testing.T, expected int, content []byte) {
+	tag, err := readExif(t, content).Get(exifRating)
+	require.Nil(t, err)
+	rating, err := tag.Int(0)
+	require.Nil(t, err)
+	require.Equal(t, expected, rating)
+}
+
+func TestSetJpegExifRating(t *testing.T) {
+	original := newTestJpeg(t)
+
+	t.Run("Adds Exif to image without it", func(t *testing.T) {
+		a := require.New(t)
+		content, err := SetJpegExifRating(original, 3)
+		a.Nil(err)
+		requireExifRating(t, 3, content)
+
+		// Rest of the image is kept as it is
+		a.Equal(original[2:], content[len(content)-len(original)+2:])
+	})
+
+	t.Run("Replaces the existing rating", func(t *testing.T) {
+		a := require.New(t)
+		content, err := SetJpegExifRating(original, 3)
+		a.Nil(err)
+		replaced, err := SetJpegExifRating(content, 5)
+		a.Nil(err)
+
+		requireExifRating(t, 5, replaced)
+		a.Equal(len(content), len(replaced))
+	})
+
+	t.Run("Keeps the other tags", func(t *testing.T) {
+		a := require.New(t)
+		content, err := SetJpegExifRating(newTestJpegWithModel(t, "X100 camera"), 4)
+		a.Nil(err)
+
+		requireExifRating(t, 4, content)
+		model, err := readExif(t, content).Get(exif.Model)
+		a.Nil(err)
+		value, err := model.StringVal()
+		a.Nil(err)
+		a.Equal("X100 camera", value)
+	})
+
+	t.Run("Not a JPEG image", func(t *testing.T) {
+		a := require.New(t)
+		_, err := SetJpegExifRating([]byte("not an image"), 3)
+		a.NotNil(err)
+	})
+}
@@ -1,10 +1,13 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"vincit.fi/image-sorter/common/logger"
 )
 
@@ -55,6 +58,15 @@ func copyInternal(src string, dst string) error {
 	return err
 }
 
+// Moves file. Creates destination directory if it doesn't exist. Files can't be
+// moved between devices, see IsSameDevice.
+func MoveFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
 // Removes file
 func RemoveFile(src string) error {
 	return os.Remove(src)
@@ -71,6 +83,24 @@ func DoesFileExist(path string) bool {
 	}
 }
 
+// Returns true if anything exists at the path. Unlike DoesFileExist,
+// symbolic links are not followed, so broken links exist too.
+func DoesPathExist(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// Returns the path relative to the base directory if the path is inside it
+func RelativePathInside(baseDir string, path string) (string, bool) {
+	if relativePath, err := filepath.Rel(baseDir, path); err != nil {
+		return "", false
+	} else if relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", false
+	} else {
+		return relativePath, true
+	}
+}
+
 func GetFirstExistingFilePath(filePaths []string) string {
 	var filePath string
 	for _, path := range filePaths {
@@ -81,3 +111,72 @@ func GetFirstExistingFilePath(filePaths []string) string {
 	}
 	return filePath
 }
+
+// Returns SHA-256 hash of the content as a hex string
+func ContentHash(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Returns SHA-256 hash of the file content as a hex string
+func FileContentHash(path string) (string, error) {
+	if file, err := os.Open(path); err != nil {
+		return "", err
+	} else {
+		defer file.Close()
+		return ContentHash(file)
+	}
+}
+
+// Bytes read from both ends of the file for the fingerprint
+const fingerprintChunkSize = 64 * 1024
+
+// Returns SHA-256 hash of the file size and the first and the last 64 kB of the file
+// as a hex string. Identifies the file cheaply even on slow disks, since the images
+// that differ almost always differ in their size, Exif header or end of the data.
+func FileFingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%d:", size)
+	if size <= 2*fingerprintChunkSize {
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	} else if _, err := io.CopyN(hash, file, fingerprintChunkSize); err != nil {
+		return "", err
+	} else if _, err := io.Copy(hash, io.NewSectionReader(file, size-fingerprintChunkSize, fingerprintChunkSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Adds suffix to the file name before the extension, e.g. IMG_1.jpg -> IMG_1_suffix.jpg
+func FileNameWithSuffix(fileName string, suffix string) string {
+	extension := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, extension) + "_" + suffix + extension
+}
+
+// Returns the first file name with a number suffix, e.g. IMG_1_1.jpg,
+// that doesn't exist in the directory
+func NumberedFileName(dir string, fileName string) string {
+	for i := 1; ; i++ {
+		numberedFileName := FileNameWithSuffix(fileName, fmt.Sprint(i))
+		if !DoesFileExist(filepath.Join(dir, numberedFileName)) {
+			return numberedFileName
+		}
+	}
+}
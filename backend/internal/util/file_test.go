@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +20,37 @@ func TestDoesFileExist_DoesntExist(t *testing.T) {
 	require.False(t, DoesFileExist("foobarfile"))
 }
 
+func TestDoesPathExist_BrokenLink(t *testing.T) {
+	a := require.New(t)
+
+	dir, err := ioutil.TempDir("", "test_dir")
+	a.Nil(err)
+	defer os.RemoveAll(dir)
+
+	link := filepath.Join(dir, "link")
+	a.Nil(os.Symlink(filepath.Join(dir, "missing"), link))
+	a.True(DoesPathExist(link))
+	a.False(DoesFileExist(link))
+	a.False(DoesPathExist(filepath.Join(dir, "missing")))
+}
+
+func TestRelativePathInside(t *testing.T) {
+	a := assert.New(t)
+
+	path, ok := RelativePathInside("/photos", "/photos/good/image.jpg")
+	a.True(ok)
+	a.Equal(filepath.Join("good", "image.jpg"), path)
+
+	_, ok = RelativePathInside("/photos", "/archive/good")
+	a.False(ok)
+	_, ok = RelativePathInside("/photos/good", "/photos")
+	a.False(ok)
+
+	path, ok = RelativePathInside("/photos", "/photos/..good")
+	a.True(ok)
+	a.Equal("..good", path)
+}
+
 func TestMakeDirectoriesIfNotExist(t *testing.T) {
 	a := assert.New(t)
 
@@ -121,3 +153,94 @@ func TestRemoveFile_FileNotExists(t *testing.T) {
 	err = os.Remove(dir)
 	a.Nil(err)
 }
+
+func TestContentHash(t *testing.T) {
+	a := assert.New(t)
+
+	hash1, err := ContentHash(strings.NewReader("Test string"))
+	a.Nil(err)
+	hash2, err := ContentHash(strings.NewReader("Test string"))
+	a.Nil(err)
+	hash3, err := ContentHash(strings.NewReader("Other string"))
+	a.Nil(err)
+
+	a.Len(hash1, 64)
+	a.Equal(hash1, hash2)
+	a.NotEqual(hash1, hash3)
+}
+
+func TestFileFingerprint(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir := t.TempDir()
+	large := []byte(strings.Repeat("a", 3*fingerprintChunkSize))
+	largeWithChangedMiddle := []byte(strings.Repeat("a", 3*fingerprintChunkSize))
+	largeWithChangedMiddle[fingerprintChunkSize+1] = 'b'
+	largeWithChangedEnd := []byte(strings.Repeat("a", 3*fingerprintChunkSize))
+	largeWithChangedEnd[len(largeWithChangedEnd)-1] = 'b'
+	for name, content := range map[string][]byte{
+		"small1":        []byte("Test string"),
+		"small2":        []byte("Test string"),
+		"small3":        []byte("Other string"),
+		"large":         large,
+		"changedMiddle": largeWithChangedMiddle,
+		"changedEnd":    largeWithChangedEnd,
+	} {
+		r.Nil(ioutil.WriteFile(filepath.Join(dir, name), content, 0644))
+	}
+	fingerprint := func(name string) string {
+		fingerprint, err := FileFingerprint(filepath.Join(dir, name))
+		r.Nil(err)
+		return fingerprint
+	}
+
+	a.Len(fingerprint("small1"), 64)
+	a.Equal(fingerprint("small1"), fingerprint("small2"))
+	a.NotEqual(fingerprint("small1"), fingerprint("small3"))
+	// Only the beginning and the end of the large files are read
+	a.Equal(fingerprint("large"), fingerprint("changedMiddle"))
+	a.NotEqual(fingerprint("large"), fingerprint("changedEnd"))
+
+	_, err := FileFingerprint(filepath.Join(dir, "missing"))
+	a.NotNil(err)
+}
+
+func TestFileNameWithSuffix(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("IMG_1_2.jpg", FileNameWithSuffix("IMG_1.jpg", "2"))
+	a.Equal("README_1", FileNameWithSuffix("README", "1"))
+}
+
+func TestNumberedFileName(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "test_dir")
+	r.Nil(err)
+	defer os.RemoveAll(dir)
+
+	a.Equal("IMG_1_1.jpg", NumberedFileName(dir, "IMG_1.jpg"))
+
+	r.Nil(ioutil.WriteFile(filepath.Join(dir, "IMG_1_1.jpg"), []byte("1"), 0644))
+	r.Nil(ioutil.WriteFile(filepath.Join(dir, "IMG_1_2.jpg"), []byte("2"), 0644))
+	a.Equal("IMG_1_3.jpg", NumberedFileName(dir, "IMG_1.jpg"))
+}
+
+func TestMoveFile(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "file1")
+	dst := filepath.Join(dir, "sub", "file2")
+	r.Nil(ioutil.WriteFile(src, []byte("Test string"), 0644))
+
+	r.Nil(MoveFile(src, dst))
+
+	a.False(DoesFileExist(src))
+	content, err := ioutil.ReadFile(dst)
+	r.Nil(err)
+	a.Equal("Test string", string(content))
+}
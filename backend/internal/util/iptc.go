@@ -0,0 +1,233 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	jpegStartOfImage = 0xD8
+	jpegEndOfImage   = 0xD9
+	jpegStartOfScan  = 0xDA
+	jpegApp0         = 0xE0
+	jpegApp13        = 0xED
+	jpegAppLast      = 0xEF
+)
+
+// IPTC data is stored as a resource in a Photoshop APP13 segment
+const photoshopHeader = "Photoshop 3.0\x00"
+const photoshopSignature = "8BIM"
+const iptcResourceId = 0x0404
+
+const (
+	iptcTagMarker      = 0x1C
+	iptcEnvelopeRecord = 1
+	iptcCharset        = 90
+	iptcAppRecord      = 2
+	iptcRecordVersion  = 0
+	iptcKeywords       = 25
+)
+
+// ESC % G, UTF-8
+var iptcCharsetUtf8 = []byte{0x1B, 0x25, 0x47}
+
+type photoshopResource struct {
+	id uint16
+	// Pascal string including the padding
+	name []byte
+	data []byte
+}
+
+type iptcDataset struct {
+	record  byte
+	dataset byte
+	data    []byte
+}
+
+// Photoshop APP13 segment of a JPEG image. Start and end are -1 if the image
+// doesn't have one, and then a new segment is inserted at insertAt.
+type jpegIptcSegment struct {
+	start     int
+	end       int
+	insertAt  int
+	resources []photoshopResource
+}
+
+// Sets the IPTC Keywords of a JPEG image. Existing keywords are replaced. Rest of
+// the IPTC data and the other Photoshop resources are kept as they are.
+func SetJpegKeywords(content []byte, keywords []string) ([]byte, error) {
+	segment, err := findJpegIptcSegment(content)
+	if err != nil {
+		return nil, err
+	}
+
+	iptcIndex := -1
+	for i, resource := range segment.resources {
+		if resource.id == iptcResourceId {
+			iptcIndex = i
+		}
+	}
+	if iptcIndex < 0 && len(keywords) == 0 {
+		return content, nil
+	}
+
+	var datasets []iptcDataset
+	if iptcIndex >= 0 {
+		if datasets, err = parseIptcDatasets(segment.resources[iptcIndex].data); err != nil {
+			return nil, err
+		}
+	} else {
+		datasets = []iptcDataset{
+			{record: iptcEnvelopeRecord, dataset: iptcCharset, data: iptcCharsetUtf8},
+			{record: iptcAppRecord, dataset: iptcRecordVersion, data: []byte{0x00, 0x04}},
+		}
+	}
+
+	var iptc bytes.Buffer
+	for _, dataset := range datasets {
+		if dataset.record != iptcAppRecord || dataset.dataset != iptcKeywords {
+			writeIptcDataset(&iptc, dataset)
+		}
+	}
+	for _, keyword := range keywords {
+		writeIptcDataset(&iptc, iptcDataset{record: iptcAppRecord, dataset: iptcKeywords, data: []byte(keyword)})
+	}
+
+	iptcResource := photoshopResource{id: iptcResourceId, name: []byte{0, 0}, data: iptc.Bytes()}
+	if iptcIndex >= 0 {
+		iptcResource.name = segment.resources[iptcIndex].name
+		segment.resources[iptcIndex] = iptcResource
+	} else {
+		segment.resources = append(segment.resources, iptcResource)
+	}
+
+	app13, err := buildApp13Segment(segment.resources)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	if segment.start >= 0 {
+		result.Write(content[:segment.start])
+		result.Write(app13)
+		result.Write(content[segment.end:])
+	} else {
+		result.Write(content[:segment.insertAt])
+		result.Write(app13)
+		result.Write(content[segment.insertAt:])
+	}
+	return result.Bytes(), nil
+}
+
+// Private API
+
+// The new segment is inserted after the APPn segments at the start of the image,
+// so that it comes after JFIF and Exif
+func findJpegIptcSegment(content []byte) (*jpegIptcSegment, error) {
+	segment := &jpegIptcSegment{start: -1, end: -1, insertAt: 2}
+	inAppSegments := true
+	var resourcesErr error
+	err := walkJpegSegments(content, func(marker byte, start int, end int) bool {
+		payload := content[start+4 : end]
+		if marker == jpegApp13 && bytes.HasPrefix(payload, []byte(photoshopHeader)) {
+			segment.start = start
+			segment.end = end
+			segment.resources, resourcesErr = parsePhotoshopResources(payload[len(photoshopHeader):])
+			return false
+		}
+
+		inAppSegments = inAppSegments && marker >= jpegApp0 && marker <= jpegAppLast
+		if inAppSegments {
+			segment.insertAt = end
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	} else if resourcesErr != nil {
+		return nil, resourcesErr
+	}
+	return segment, nil
+}
+
+func parsePhotoshopResources(data []byte) ([]photoshopResource, error) {
+	var resources []photoshopResource
+	for len(data) > 0 {
+		if len(data) < 7 || string(data[:4]) != photoshopSignature {
+			return nil, errors.New("invalid Photoshop resource")
+		}
+		nameLength := 1 + int(data[6])
+		nameLength += nameLength % 2
+		if len(data) < 6+nameLength+4 {
+			return nil, errors.New("truncated Photoshop resource")
+		}
+		dataStart := 6 + nameLength + 4
+		dataLength := int(binary.BigEndian.Uint32(data[6+nameLength:]))
+		if dataLength > len(data)-dataStart {
+			return nil, errors.New("truncated Photoshop resource")
+		}
+
+		resources = append(resources, photoshopResource{
+			id:   binary.BigEndian.Uint16(data[4:]),
+			name: data[6 : 6+nameLength],
+			data: data[dataStart : dataStart+dataLength],
+		})
+		data = data[dataStart+dataLength+dataLength%2:]
+	}
+	return resources, nil
+}
+
+func buildApp13Segment(resources []photoshopResource) ([]byte, error) {
+	var payload bytes.Buffer
+	payload.WriteString(photoshopHeader)
+	for _, resource := range resources {
+		payload.WriteString(photoshopSignature)
+		_ = binary.Write(&payload, binary.BigEndian, resource.id)
+		payload.Write(resource.name)
+		_ = binary.Write(&payload, binary.BigEndian, uint32(len(resource.data)))
+		payload.Write(resource.data)
+		if len(resource.data)%2 == 1 {
+			payload.WriteByte(0)
+		}
+	}
+
+	// Segment length includes the length bytes
+	length := payload.Len() + 2
+	if length > 0xFFFF {
+		return nil, errors.New("IPTC data doesn't fit in a JPEG segment")
+	}
+	segment := []byte{0xFF, jpegApp13, byte(length >> 8), byte(length)}
+	return append(segment, payload.Bytes()...), nil
+}
+
+func parseIptcDatasets(data []byte) ([]iptcDataset, error) {
+	var datasets []iptcDataset
+	for len(data) > 0 {
+		if data[0] != iptcTagMarker {
+			// Rest is padding
+			break
+		} else if len(data) < 5 {
+			return nil, errors.New("truncated IPTC dataset")
+		}
+		length := int(binary.BigEndian.Uint16(data[3:]))
+		if length&0x8000 != 0 {
+			return nil, errors.New("extended IPTC datasets are not supported")
+		} else if len(data) < 5+length {
+			return nil, errors.New("truncated IPTC dataset")
+		}
+		datasets = append(datasets, iptcDataset{
+			record:  data[1],
+			dataset: data[2],
+			data:    data[5 : 5+length],
+		})
+		data = data[5+length:]
+	}
+	return datasets, nil
+}
+
+func writeIptcDataset(buffer *bytes.Buffer, dataset iptcDataset) {
+	buffer.Write([]byte{iptcTagMarker, dataset.record, dataset.dataset})
+	_ = binary.Write(buffer, binary.BigEndian, uint16(len(dataset.data)))
+	buffer.Write(dataset.data)
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func newTestJpeg(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	require.Nil(t, jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	return buffer.Bytes()
+}
+
+func readKeywords(t *testing.T, content []byte) []string {
+	a := require.New(t)
+
+	segment, err := findJpegIptcSegment(content)
+	a.Nil(err)
+	var keywords []string
+	for _, resource := range segment.resources {
+		if resource.id == iptcResourceId {
+			datasets, err := parseIptcDatasets(resource.data)
+			a.Nil(err)
+			for _, dataset := range datasets {
+				if dataset.record == iptcAppRecord && dataset.dataset == iptcKeywords {
+					keywords = append(keywords, string(dataset.data))
+				}
+			}
+		}
+	}
+	return keywords
+}
+
+func TestSetJpegKeywords(t *testing.T) {
+	a := require.New(t)
+	original := newTestJpeg(t)
+
+	t.Run("Add keywords", func(t *testing.T) {
+		content, err := SetJpegKeywords(original, []string{"beach", "sunset", "Ä"})
+		a.Nil(err)
+		a.Equal([]string{"beach", "sunset", "Ä"}, readKeywords(t, content))
+
+		_, err = jpeg.Decode(bytes.NewReader(content))
+		a.Nil(err)
+	})
+
+	t.Run("Replace keywords and keep other data", func(t *testing.T) {
+		content, err := SetJpegKeywords(original, []string{"beach", "sunset"})
+		a.Nil(err)
+		segment, err := findJpegIptcSegment(content)
+		a.Nil(err)
+		// Unknown resource with an odd length
+		segment.resources = append(segment.resources, photoshopResource{id: 0x0425, name: []byte{0, 0}, data: []byte{1, 2, 3}})
+		app13, err := buildApp13Segment(segment.resources)
+		a.Nil(err)
+		content = append(append(append([]byte{}, content[:segment.start]...), app13...), content[segment.end:]...)
+
+		content, err = SetJpegKeywords(content, []string{"family"})
+		a.Nil(err)
+		a.Equal([]string{"family"}, readKeywords(t, content))
+
+		segment, err = findJpegIptcSegment(content)
+		a.Nil(err)
+		a.Equal(2, len(segment.resources))
+		a.Equal([]byte{1, 2, 3}, segment.resources[1].data)
+
+		_, err = jpeg.Decode(bytes.NewReader(content))
+		a.Nil(err)
+	})
+
+	t.Run("Remove keywords", func(t *testing.T) {
+		content, err := SetJpegKeywords(original, []string{"beach"})
+		a.Nil(err)
+		content, err = SetJpegKeywords(content, nil)
+		a.Nil(err)
+		a.Nil(readKeywords(t, content))
+	})
+
+	t.Run("No keywords", func(t *testing.T) {
+		content, err := SetJpegKeywords(original, nil)
+		a.Nil(err)
+		a.Equal(original, content)
+	})
+
+	t.Run("Not a JPEG", func(t *testing.T) {
+		_, err := SetJpegKeywords([]byte("GIF89a"), []string{"beach"})
+		a.NotNil(err)
+	})
+}
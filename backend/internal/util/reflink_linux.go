@@ -0,0 +1,30 @@
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE ioctl request from linux/fs.h
+const ficlone = 0x40049409
+
+// Creates a copy-on-write clone of the file. Fails if the file
+// system doesn't support it or the files are on different file systems.
+func ReflinkFile(src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, destination.Fd(), ficlone, source.Fd()); errno != 0 {
+		destination.Close()
+		_ = os.Remove(dst)
+		return errno
+	}
+	return destination.Close()
+}
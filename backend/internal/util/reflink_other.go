@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "errors"
+
+// Creates a copy-on-write clone of the file. Only supported on Linux.
+func ReflinkFile(src string, dst string) error {
+	return errors.New("reflink is not supported on this platform")
+}
@@ -0,0 +1,157 @@
+package util
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/"
+const dublinCoreNamespace = "http://purl.org/dc/elements/1.1/"
+
+var xmpDescriptionStart = regexp.MustCompile(`<rdf:Description\b`)
+var xmpSubject = regexp.MustCompile(`(?s)\s*<dc:subject\s*/>|\s*<dc:subject\b.*?</dc:subject>`)
+
+// Property of the basic XMP schema, e.g. Rating. Empty value removes the property.
+type XmpProperty struct {
+	Name  string
+	Value string
+}
+
+// Creates an XMP sidecar that contains only the given properties
+func NewXmp(properties []XmpProperty) []byte {
+	var content bytes.Buffer
+	content.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	content.WriteString(` <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	content.WriteString(`  <rdf:Description rdf:about=""` + "\n")
+	content.WriteString(`    xmlns:xmp="` + xmpNamespace + `"`)
+	for _, property := range properties {
+		if property.Value != "" {
+			content.WriteString("\n    " + xmpAttribute(property))
+		}
+	}
+	content.WriteString("/>\n")
+	content.WriteString(" </rdf:RDF>\n")
+	content.WriteString("</x:xmpmeta>\n")
+	return content.Bytes()
+}
+
+// Sets the properties of an existing XMP sidecar. Properties are replaced where
+// they are, either as attributes or as elements. New properties are added as
+// attributes to the first rdf:Description. Rest of the content is kept as is.
+func SetXmpProperties(content []byte, properties []XmpProperty) ([]byte, error) {
+	text := string(content)
+	var added []XmpProperty
+	for _, property := range properties {
+		attribute := regexp.MustCompile(`\s+xmp:` + regexp.QuoteMeta(property.Name) + `\s*=\s*("[^"]*"|'[^']*')`)
+		element := regexp.MustCompile(`\s*<xmp:` + regexp.QuoteMeta(property.Name) + `>[^<]*</xmp:` + regexp.QuoteMeta(property.Name) + `>`)
+		if property.Value == "" {
+			text = attribute.ReplaceAllLiteralString(text, "")
+			text = element.ReplaceAllLiteralString(text, "")
+		} else if attribute.MatchString(text) {
+			text = attribute.ReplaceAllLiteralString(text, " "+xmpAttribute(property))
+		} else if location := element.FindStringIndex(text); location != nil {
+			indent := text[location[0] : strings.Index(text[location[0]:], "<")+location[0]]
+			text = text[:location[0]] + indent + xmpElement(property) + text[location[1]:]
+		} else {
+			added = append(added, property)
+		}
+	}
+
+	if len(added) == 0 {
+		return []byte(text), nil
+	}
+
+	start := xmpDescriptionStart.FindStringIndex(text)
+	if start == nil {
+		return nil, errors.New("XMP has no rdf:Description")
+	}
+	end, err := findTagEnd(text, start[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes strings.Builder
+	if !strings.Contains(text[:end], "xmlns:xmp=") {
+		attributes.WriteString(` xmlns:xmp="` + xmpNamespace + `"`)
+	}
+	for _, property := range added {
+		attributes.WriteString(" " + xmpAttribute(property))
+	}
+	return []byte(text[:end] + attributes.String() + text[end:]), nil
+}
+
+// Sets the keywords (dc:subject) of an XMP sidecar. Existing keywords are replaced
+// and empty keywords remove the property. Rest of the content is kept as is.
+func SetXmpSubject(content []byte, keywords []string) ([]byte, error) {
+	text := xmpSubject.ReplaceAllLiteralString(string(content), "")
+	if len(keywords) == 0 {
+		return []byte(text), nil
+	}
+
+	start := xmpDescriptionStart.FindStringIndex(text)
+	if start == nil {
+		return nil, errors.New("XMP has no rdf:Description")
+	}
+	end, err := findTagEnd(text, start[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var subject strings.Builder
+	subject.WriteString("\n   <dc:subject>\n    <rdf:Bag>")
+	for _, keyword := range keywords {
+		subject.WriteString("\n     <rdf:li>" + escapeXml(keyword) + "</rdf:li>")
+	}
+	subject.WriteString("\n    </rdf:Bag>\n   </dc:subject>")
+
+	var namespace string
+	if !strings.Contains(text[:end], "xmlns:dc=") {
+		namespace = ` xmlns:dc="` + dublinCoreNamespace + `"`
+	}
+	if text[end] == '/' {
+		// Self-closing description needs an end tag for the element
+		return []byte(text[:end] + namespace + ">" + subject.String() + "\n  </rdf:Description>" + text[end+2:]), nil
+	}
+	return []byte(text[:end] + namespace + ">" + subject.String() + text[end+1:]), nil
+}
+
+// Private API
+
+// Returns the index of the "/>" or ">" that closes the tag. Quoted attribute values are skipped.
+func findTagEnd(text string, start int) (int, error) {
+	var quote rune
+	for i, c := range text[start:] {
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if c == '"' || c == '\'' {
+			quote = c
+		} else if c == '>' {
+			end := start + i
+			if text[end-1] == '/' {
+				end--
+			}
+			return end, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated tag at %d", start)
+}
+
+func xmpAttribute(property XmpProperty) string {
+	return fmt.Sprintf(`xmp:%s="%s"`, property.Name, escapeXml(property.Value))
+}
+
+func xmpElement(property XmpProperty) string {
+	return fmt.Sprintf(`<xmp:%s>%s</xmp:%s>`, property.Name, escapeXml(property.Value), property.Name)
+}
+
+func escapeXml(value string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var ratingProperties = []XmpProperty{
+	{Name: "Rating", Value: "4"},
+	{Name: "Label", Value: "Red"},
+}
+
+func TestNewXmp(t *testing.T) {
+	a := assert.New(t)
+
+	content := string(NewXmp([]XmpProperty{{Name: "Rating", Value: "4"}, {Name: "Label", Value: ""}}))
+	a.Contains(content, `xmlns:xmp="http://ns.adobe.com/xap/1.0/"`)
+	a.Contains(content, `xmp:Rating="4"`)
+	a.NotContains(content, `xmp:Label`)
+}
+
+func TestSetXmpProperties(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("Replace attributes", func(t *testing.T) {
+		content, err := SetXmpProperties([]byte(
+			`<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="1" xmp:Label='Blue'/>`,
+		), ratingProperties)
+		a.Nil(err)
+		a.Equal(`<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="4" xmp:Label="Red"/>`, string(content))
+	})
+
+	t.Run("Replace elements", func(t *testing.T) {
+		content, err := SetXmpProperties([]byte(
+			"<rdf:Description rdf:about=\"\" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\">\n"+
+				"  <xmp:Rating>1</xmp:Rating>\n"+
+				"  <xmp:Label>Blue</xmp:Label>\n"+
+				"</rdf:Description>",
+		), ratingProperties)
+		a.Nil(err)
+		a.Equal("<rdf:Description rdf:about=\"\" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\">\n"+
+			"  <xmp:Rating>4</xmp:Rating>\n"+
+			"  <xmp:Label>Red</xmp:Label>\n"+
+			"</rdf:Description>", string(content))
+	})
+
+	t.Run("Add to description with the namespace", func(t *testing.T) {
+		content, err := SetXmpProperties([]byte(
+			`<rdf:RDF><rdf:Description rdf:about="" xmlns:tiff="http://ns.adobe.com/tiff/1.0/" tiff:Make="a>b"></rdf:Description></rdf:RDF>`,
+		), ratingProperties)
+		a.Nil(err)
+		a.Equal(`<rdf:RDF><rdf:Description rdf:about="" xmlns:tiff="http://ns.adobe.com/tiff/1.0/" tiff:Make="a>b"`+
+			` xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="4" xmp:Label="Red"></rdf:Description></rdf:RDF>`, string(content))
+	})
+
+	t.Run("Add to self-closing description with existing namespace", func(t *testing.T) {
+		content, err := SetXmpProperties([]byte(
+			`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="Camera"/>`,
+		), []XmpProperty{{Name: "Rating", Value: "2"}})
+		a.Nil(err)
+		a.Equal(`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="Camera" xmp:Rating="2"/>`, string(content))
+	})
+
+	t.Run("Empty value removes the property", func(t *testing.T) {
+		content, err := SetXmpProperties([]byte(
+			"<rdf:Description xmp:Rating=\"3\" xmp:Label=\"Red\">\n  <xmp:Label>Red</xmp:Label>\n</rdf:Description>",
+		), []XmpProperty{{Name: "Label", Value: ""}})
+		a.Nil(err)
+		a.Equal("<rdf:Description xmp:Rating=\"3\">\n</rdf:Description>", string(content))
+	})
+
+	t.Run("No description", func(t *testing.T) {
+		_, err := SetXmpProperties([]byte(`<x:xmpmeta/>`), ratingProperties)
+		a.NotNil(err)
+	})
+}
+
+func TestSetXmpSubject(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("Add to self-closing description", func(t *testing.T) {
+		content, err := SetXmpSubject(NewXmp(ratingProperties), []string{"beach", "<sunset>"})
+		a.Nil(err)
+		a.Equal(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmp:Rating="4"
+    xmp:Label="Red" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>beach</rdf:li>
+     <rdf:li>&lt;sunset&gt;</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`, string(content))
+	})
+
+	t.Run("Replace keywords", func(t *testing.T) {
+		content, err := SetXmpSubject([]byte(
+			`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">`+
+				`<dc:title>Title</dc:title><dc:subject><rdf:Bag><rdf:li>old</rdf:li></rdf:Bag></dc:subject></rdf:Description>`,
+		), []string{"new"})
+		a.Nil(err)
+		a.Equal(`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">`+
+			"\n   <dc:subject>\n    <rdf:Bag>\n     <rdf:li>new</rdf:li>\n    </rdf:Bag>\n   </dc:subject>"+
+			`<dc:title>Title</dc:title></rdf:Description>`, string(content))
+	})
+
+	t.Run("Remove keywords", func(t *testing.T) {
+		content, err := SetXmpSubject([]byte(
+			"<rdf:Description>\n <dc:subject><rdf:Bag><rdf:li>old</rdf:li></rdf:Bag></dc:subject>\n</rdf:Description>",
+		), nil)
+		a.Nil(err)
+		a.Equal("<rdf:Description>\n</rdf:Description>", string(content))
+	})
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// Files are reported when they have been written or moved so that the images
+// that are still being copied are not read. Directories are reported when they
+// are created so that they can be watched before the files are added.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+type inotifyWatcher struct {
+	// File.Fd() can't be used since it makes the file blocking
+	fd          int
+	file        *os.File
+	directories map[int]string
+	mux         sync.Mutex
+	events      chan fileEvent
+}
+
+func newFileWatcher() (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// Non-blocking file is read with the runtime poller so that closing the file stops the reading
+	watcher := &inotifyWatcher{
+		fd:          fd,
+		file:        os.NewFile(uintptr(fd), "inotify"),
+		directories: map[int]string{},
+		events:      make(chan fileEvent, 100),
+	}
+	go watcher.readEvents()
+	return watcher, nil
+}
+
+func (s *inotifyWatcher) Add(directory string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if wd, err := syscall.InotifyAddWatch(s.fd, directory, inotifyMask|syscall.IN_ONLYDIR); err != nil {
+		return err
+	} else {
+		s.directories[wd] = directory
+		return nil
+	}
+}
+
+func (s *inotifyWatcher) Events() <-chan fileEvent {
+	return s.events
+}
+
+func (s *inotifyWatcher) Close() error {
+	return s.file.Close()
+}
+
+// Private API
+
+func (s *inotifyWatcher) readEvents() {
+	defer close(s.events)
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := s.file.Read(buffer)
+		if err != nil {
+			logger.Debug.Printf("Stopped watching files: %s", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buffer[nameStart:nameStart+int(event.Len)], "\x00"))
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				s.events <- fileEvent{overflow: true}
+			} else if fileEvent, ok := s.toFileEvent(event, name); ok {
+				s.events <- fileEvent
+			}
+		}
+	}
+}
+
+func (s *inotifyWatcher) toFileEvent(event *syscall.InotifyEvent, name string) (fileEvent, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	directory, ok := s.directories[int(event.Wd)]
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		// Directory was removed or moved away
+		delete(s.directories, int(event.Wd))
+		return fileEvent{}, false
+	} else if !ok || name == "" {
+		return fileEvent{}, false
+	}
+
+	isDirectory := event.Mask&syscall.IN_ISDIR != 0
+	if event.Mask&syscall.IN_CREATE != 0 && !isDirectory {
+		// Reported once the file has been written
+		return fileEvent{}, false
+	}
+	return fileEvent{
+		path:        filepath.Join(directory, name),
+		isDirectory: isDirectory,
+		created:     event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0,
+	}, true
+}
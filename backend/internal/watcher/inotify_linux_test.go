@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, fileWatcher fileWatcher) fileEvent {
+	select {
+	case event := <-fileWatcher.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("No events were received")
+		return fileEvent{}
+	}
+}
+
+func TestInotifyWatcher(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	sut, err := newFileWatcher()
+	a.Nil(err)
+	defer sut.Close()
+	a.Nil(sut.Add(directory))
+
+	t.Run("Written file", func(t *testing.T) {
+		a.Nil(os.WriteFile(filepath.Join(directory, "foo.jpg"), []byte{1, 2, 3}, 0644))
+
+		a.Equal(fileEvent{path: filepath.Join(directory, "foo.jpg")}, receiveEvent(t, sut))
+	})
+
+	t.Run("Moved file", func(t *testing.T) {
+		a.Nil(os.Rename(filepath.Join(directory, "foo.jpg"), filepath.Join(directory, "bar.jpg")))
+
+		a.Equal(fileEvent{path: filepath.Join(directory, "foo.jpg")}, receiveEvent(t, sut))
+		a.Equal(fileEvent{path: filepath.Join(directory, "bar.jpg"), created: true}, receiveEvent(t, sut))
+	})
+
+	t.Run("Created directory", func(t *testing.T) {
+		a.Nil(os.Mkdir(filepath.Join(directory, "sub"), 0755))
+
+		a.Equal(fileEvent{path: filepath.Join(directory, "sub"), isDirectory: true, created: true}, receiveEvent(t, sut))
+	})
+
+	t.Run("Removed file", func(t *testing.T) {
+		a.Nil(os.Remove(filepath.Join(directory, "bar.jpg")))
+
+		a.Equal(fileEvent{path: filepath.Join(directory, "bar.jpg")}, receiveEvent(t, sut))
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		a.Nil(sut.Close())
+
+		select {
+		case _, ok := <-sut.Events():
+			a.False(ok)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Events were not closed")
+		}
+	})
+}
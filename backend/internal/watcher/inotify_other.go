@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// Watching the files is only supported on Linux
+func newFileWatcher() (fileWatcher, error) {
+	return nil, errors.New("watching files is not supported on this platform")
+}
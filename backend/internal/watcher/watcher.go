@@ -0,0 +1,200 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+const defaultDebounceTime = time.Second
+
+type fileEvent struct {
+	path        string
+	isDirectory bool
+	// Created or moved to the watched directory
+	created bool
+	// Some events were lost, so everything has to be scanned again
+	overflow bool
+}
+
+// Platform specific way to get the file system events
+type fileWatcher interface {
+	Add(directory string) error
+	Events() <-chan fileEvent
+	Close() error
+}
+
+// Collects the changed files and sends them in a single ImageFilesChanged message
+// once no more changes have been seen for a while, e.g. when many images are copied.
+type Watcher struct {
+	sender       api.Sender
+	fileWatcher  fileWatcher
+	debounceTime time.Duration
+	changedPaths map[string]bool
+	timer        *time.Timer
+	mux          sync.Mutex
+
+	api.Watcher
+}
+
+func NewWatcher(sender api.Sender) api.Watcher {
+	return &Watcher{
+		sender:       sender,
+		debounceTime: defaultDebounceTime,
+		changedPaths: map[string]bool{},
+	}
+}
+
+// Stops watching the previous directory. Sub directories are watched if the scan is recursive.
+func (s *Watcher) Watch(directory string, options *api.ScanOptions) error {
+	s.Close()
+
+	if fileWatcher, err := newFileWatcher(); err != nil {
+		return err
+	} else {
+		return s.watch(fileWatcher, directory, options)
+	}
+}
+
+// Changes that have not been sent yet are discarded
+func (s *Watcher) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.fileWatcher != nil {
+		_ = s.fileWatcher.Close()
+		s.fileWatcher = nil
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.changedPaths = map[string]bool{}
+}
+
+// Private API
+
+func (s *Watcher) watch(fileWatcher fileWatcher, directory string, options *api.ScanOptions) error {
+	s.mux.Lock()
+	s.fileWatcher = fileWatcher
+	s.mux.Unlock()
+
+	if err := s.addDirectory(fileWatcher, directory, directory, options); err != nil {
+		s.Close()
+		return err
+	}
+	go s.handleEvents(fileWatcher, directory, options)
+	logger.Info.Printf("Watching '%s' for changes", directory)
+	return nil
+}
+
+func (s *Watcher) handleEvents(fileWatcher fileWatcher, directory string, options *api.ScanOptions) {
+	for event := range fileWatcher.Events() {
+		if event.overflow {
+			logger.Warn.Printf("Too many changes in '%s', scanning all images", directory)
+			s.addChangedPath(fileWatcher, "")
+			continue
+		}
+
+		relativePath, err := filepath.Rel(directory, event.path)
+		if err != nil || isIgnored(relativePath, event, options) {
+			continue
+		}
+		if event.isDirectory && event.created {
+			if err := s.addDirectory(fileWatcher, directory, event.path, options); err != nil {
+				logger.Warn.Printf("Could not watch directory '%s': %s", event.path, err)
+			}
+		}
+		logger.Trace.Printf("File '%s' changed", relativePath)
+		s.addChangedPath(fileWatcher, relativePath)
+	}
+}
+
+// Sub directories are watched too if the scan is recursive
+func (s *Watcher) addDirectory(fileWatcher fileWatcher, rootDirectory string, directory string, options *api.ScanOptions) error {
+	if !options.Recursive {
+		return fileWatcher.Add(directory)
+	}
+	return filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !entry.IsDir() {
+			return nil
+		}
+		if path != rootDirectory {
+			if relativePath, err := filepath.Rel(rootDirectory, path); err != nil {
+				return err
+			} else if isIgnoredPath(relativePath, options) {
+				return filepath.SkipDir
+			}
+		}
+		return fileWatcher.Add(path)
+	})
+}
+
+func (s *Watcher) addChangedPath(fileWatcher fileWatcher, relativePath string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.fileWatcher != fileWatcher {
+		return
+	}
+	s.changedPaths[relativePath] = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.debounceTime, func() {
+		s.sendChangedPaths(fileWatcher)
+	})
+}
+
+func (s *Watcher) sendChangedPaths(fileWatcher fileWatcher) {
+	s.mux.Lock()
+	if s.fileWatcher != fileWatcher || len(s.changedPaths) == 0 {
+		s.mux.Unlock()
+		return
+	}
+	var paths []string
+	for path := range s.changedPaths {
+		paths = append(paths, path)
+	}
+	s.changedPaths = map[string]bool{}
+	s.timer = nil
+	s.mux.Unlock()
+
+	sort.Strings(paths)
+	logger.Debug.Printf("%d files changed", len(paths))
+	s.sender.SendCommandToTopic(api.ImageFilesChanged, &api.ImageFilesChangedCommand{Paths: paths})
+}
+
+// Hidden files (e.g. the .image-sorter directory and temporary files), excluded directories
+// and the files that are not images or sidecars are ignored. New directories are only
+// needed if the scan is recursive.
+func isIgnored(relativePath string, event fileEvent, options *api.ScanOptions) bool {
+	if isIgnoredPath(relativePath, options) {
+		return true
+	} else if event.isDirectory {
+		return event.created && !options.Recursive
+	} else {
+		return !apitype.IsLibraryFile(filepath.Base(relativePath), options.SidecarExtensions)
+	}
+}
+
+func isIgnoredPath(relativePath string, options *api.ScanOptions) bool {
+	for _, name := range strings.Split(relativePath, string(filepath.Separator)) {
+		if strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	for _, excludedDirectory := range options.ExcludedDirectories {
+		excludedDirectory = filepath.Clean(excludedDirectory)
+		if relativePath == excludedDirectory || strings.HasPrefix(relativePath, excludedDirectory+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+type StubSender struct {
+	commands chan *api.ImageFilesChangedCommand
+
+	api.Sender
+}
+
+func (s *StubSender) SendCommandToTopic(topic api.Topic, command apitype.Command) {
+	if topic == api.ImageFilesChanged {
+		s.commands <- command.(*api.ImageFilesChangedCommand)
+	}
+}
+
+type StubFileWatcher struct {
+	directories []string
+	events      chan fileEvent
+}
+
+func (s *StubFileWatcher) Add(directory string) error {
+	s.directories = append(s.directories, directory)
+	return nil
+}
+
+func (s *StubFileWatcher) Events() <-chan fileEvent {
+	return s.events
+}
+
+func (s *StubFileWatcher) Close() error {
+	return nil
+}
+
+func initializeSut() (*Watcher, *StubSender, *StubFileWatcher) {
+	sender := &StubSender{commands: make(chan *api.ImageFilesChangedCommand, 10)}
+	fileWatcher := &StubFileWatcher{events: make(chan fileEvent, 10)}
+	sut := NewWatcher(sender).(*Watcher)
+	sut.debounceTime = 10 * time.Millisecond
+	return sut, sender, fileWatcher
+}
+
+func receiveCommand(t *testing.T, sender *StubSender) *api.ImageFilesChangedCommand {
+	select {
+	case command := <-sender.commands:
+		return command
+	case <-time.After(5 * time.Second):
+		t.Fatal("No changes were sent")
+		return nil
+	}
+}
+
+func TestWatcher_Watch(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	a.Nil(os.MkdirAll(filepath.Join(directory, "sub", "sub2"), 0755))
+	a.Nil(os.MkdirAll(filepath.Join(directory, ".image-sorter"), 0755))
+	a.Nil(os.MkdirAll(filepath.Join(directory, "excluded"), 0755))
+
+	t.Run("Not recursive", func(t *testing.T) {
+		sut, _, fileWatcher := initializeSut()
+		defer sut.Close()
+
+		a.Nil(sut.watch(fileWatcher, directory, &api.ScanOptions{}))
+		a.Equal([]string{directory}, fileWatcher.directories)
+	})
+
+	t.Run("Recursive", func(t *testing.T) {
+		sut, _, fileWatcher := initializeSut()
+		defer sut.Close()
+
+		a.Nil(sut.watch(fileWatcher, directory, &api.ScanOptions{
+			Recursive:           true,
+			ExcludedDirectories: []string{"excluded"},
+		}))
+		a.Equal([]string{
+			directory,
+			filepath.Join(directory, "sub"),
+			filepath.Join(directory, "sub", "sub2"),
+		}, fileWatcher.directories)
+	})
+}
+
+func TestWatcher_handleEvents(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+
+	t.Run("Changes are sent together", func(t *testing.T) {
+		sut, sender, fileWatcher := initializeSut()
+		defer sut.Close()
+		a.Nil(sut.watch(fileWatcher, directory, &api.ScanOptions{}))
+
+		fileWatcher.events <- fileEvent{path: filepath.Join(directory, "b.jpg")}
+		fileWatcher.events <- fileEvent{path: filepath.Join(directory, "a.jpg"), created: true}
+		fileWatcher.events <- fileEvent{path: filepath.Join(directory, "a.jpg")}
+		fileWatcher.events <- fileEvent{path: filepath.Join(directory, "a.txt")}
+		fileWatcher.events <- fileEvent{path: filepath.Join(directory, ".a.jpg.tmp")}
+
+		a.Equal([]string{"a.jpg", "b.jpg"}, receiveCommand(t, sender).Paths)
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		sut, sender, fileWatcher := initializeSut()
+		defer sut.Close()
+		a.Nil(sut.watch(fileWatcher, directory, &api.ScanOptions{}))
+
+		fileWatcher.events <- fileEvent{overflow: true}
+
+		a.Equal([]string{""}, receiveCommand(t, sender).Paths)
+	})
+
+	t.Run("New directory is watched", func(t *testing.T) {
+		sut, sender, fileWatcher := initializeSut()
+		defer sut.Close()
+		a.Nil(sut.watch(fileWatcher, directory, &api.ScanOptions{Recursive: true}))
+		newDirectory := filepath.Join(directory, "new")
+		a.Nil(os.Mkdir(newDirectory, 0755))
+
+		fileWatcher.events <- fileEvent{path: newDirectory, isDirectory: true, created: true}
+
+		a.Equal([]string{"new"}, receiveCommand(t, sender).Paths)
+		a.Equal([]string{directory, newDirectory}, fileWatcher.directories)
+	})
+}
+
+func TestIsIgnored(t *testing.T) {
+	a := assert.New(t)
+
+	options := &api.ScanOptions{
+		ExcludedDirectories: []string{"excluded"},
+		SidecarExtensions:   []string{".xmp"},
+	}
+	recursiveOptions := &api.ScanOptions{Recursive: true}
+
+	a.False(isIgnored("foo.jpg", fileEvent{}, options))
+	a.False(isIgnored("foo.jpg.xmp", fileEvent{}, options))
+	a.False(isIgnored(filepath.Join("sub", "foo.jpg"), fileEvent{}, options))
+	a.True(isIgnored("foo.txt", fileEvent{}, options))
+	a.True(isIgnored(".foo.jpg", fileEvent{}, options))
+	a.True(isIgnored(filepath.Join(".image-sorter", "foo.jpg"), fileEvent{}, options))
+	a.True(isIgnored(filepath.Join("excluded", "foo.jpg"), fileEvent{}, options))
+	a.False(isIgnored(filepath.Join("excluded2", "foo.jpg"), fileEvent{}, options))
+
+	a.False(isIgnored("sub", fileEvent{isDirectory: true}, options))
+	a.True(isIgnored("sub", fileEvent{isDirectory: true, created: true}, options))
+	a.False(isIgnored("sub", fileEvent{isDirectory: true, created: true}, recursiveOptions))
+}
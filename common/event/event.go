@@ -87,6 +87,14 @@ func (s *Broker) createDelayedSendFunc(entry *debounceEntry, duration time.Durat
 	})
 }
 
+// Initializes a broker which handles the messages synchronously.
+func InitSyncBus() *Broker {
+	return &Broker{
+		bus:       newSyncBus(),
+		debounced: map[string]*debounceEntry{},
+	}
+}
+
 func InitDevNullBus() *Broker {
 	return &Broker{
 		bus: nil,
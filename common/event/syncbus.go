@@ -0,0 +1,75 @@
+package event
+
+import (
+	"fmt"
+	messagebus "github.com/vardius/message-bus"
+	"reflect"
+	"sync"
+)
+
+// Message bus which calls the handlers in the same goroutine as the
+// publisher. Used when there is no UI loop to hand the messages to and
+// the caller needs to know that all the messages have been handled
+// when Publish returns (e.g. in CLI mode).
+type syncBus struct {
+	mux      sync.RWMutex
+	handlers map[string][]reflect.Value
+
+	messagebus.MessageBus
+}
+
+func newSyncBus() *syncBus {
+	return &syncBus{
+		handlers: map[string][]reflect.Value{},
+	}
+}
+
+func (s *syncBus) Publish(topic string, args ...any) {
+	reflectedArgs := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		reflectedArgs[i] = reflect.ValueOf(arg)
+	}
+
+	s.mux.RLock()
+	handlers := make([]reflect.Value, len(s.handlers[topic]))
+	copy(handlers, s.handlers[topic])
+	s.mux.RUnlock()
+
+	for _, handler := range handlers {
+		handler.Call(reflectedArgs)
+	}
+}
+
+func (s *syncBus) Subscribe(topic string, fn any) error {
+	if reflect.TypeOf(fn).Kind() != reflect.Func {
+		return fmt.Errorf("%s is not a reflect.Func", reflect.TypeOf(fn))
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.handlers[topic] = append(s.handlers[topic], reflect.ValueOf(fn))
+	return nil
+}
+
+func (s *syncBus) Unsubscribe(topic string, fn any) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rv := reflect.ValueOf(fn)
+	if handlers, ok := s.handlers[topic]; ok {
+		for i, handler := range handlers {
+			if handler == rv {
+				s.handlers[topic] = append(handlers[:i], handlers[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("topic %s doesn't exist", topic)
+}
+
+func (s *syncBus) Close(topic string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.handlers, topic)
+}
@@ -0,0 +1,205 @@
+package imagereader
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+// Decoder decodes images of a single format. When the image is read from a
+// file, the reader also implements io.ReaderAt.
+type Decoder interface {
+	Decode(reader io.Reader) (image.Image, error)
+	// Decodes the image so that it is at least the given size. Decoders that
+	// can't scale while decoding may return the image in full size.
+	DecodeScaled(reader io.Reader, width int, height int) (image.Image, error)
+	DecodeConfig(reader io.Reader) (image.Config, error)
+}
+
+type decoderEntry struct {
+	format  apitype.ImageFormat
+	magic   []string
+	decoder Decoder
+}
+
+// Long enough for all the registered magic bytes
+const maxMagicLength = 16
+
+var (
+	decoders   []*decoderEntry
+	decoderMux sync.RWMutex
+)
+
+// Registers a decoder for the format. Magic is the byte prefix of the
+// files of the format. A '?' in the magic matches any byte. If a decoder has
+// already been registered for the format, it will be replaced.
+func RegisterDecoder(format apitype.ImageFormat, decoder Decoder, magic ...string) {
+	decoderMux.Lock()
+	defer decoderMux.Unlock()
+
+	entry := &decoderEntry{
+		format:  format,
+		magic:   magic,
+		decoder: decoder,
+	}
+	for i, existing := range decoders {
+		if existing.format == format {
+			decoders[i] = entry
+			return
+		}
+	}
+	decoders = append(decoders, entry)
+}
+
+func LoadImage(path string, rotation float64, flipped bool) (image.Image, error) {
+	return loadImage(path, rotation, flipped, func(decoder Decoder, reader io.Reader) (image.Image, error) {
+		return decoder.Decode(reader)
+	})
+}
+
+func LoadScaledImage(path string, rotation float64, flipped bool, width int, height int) (image.Image, error) {
+	return loadImage(path, rotation, flipped, func(decoder Decoder, reader io.Reader) (image.Image, error) {
+		return decoder.DecodeScaled(reader, width, height)
+	})
+}
+
+// Reads the image size without decoding the whole image
+func LoadImageConfig(path string) (image.Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+
+	if decoder, err := findDecoder(path, file); err != nil {
+		return image.Config{}, err
+	} else {
+		return decoder.DecodeConfig(file)
+	}
+}
+
+func ConvertNrgbaToRgba(i image.Image) image.Image {
+	start := time.Now()
+	n := i.(*image.NRGBA)
+
+	rgba := image.NewRGBA(n.Rect)
+	for x := 0; x < n.Rect.Dx(); x++ {
+		for y := 0; y < n.Rect.Dy(); y++ {
+			nrgbaPixOffset := n.PixOffset(x, y)
+			ngrbaStride := n.Pix[nrgbaPixOffset : nrgbaPixOffset+4 : nrgbaPixOffset+4]
+
+			rgbaPixOffset := rgba.PixOffset(x, y)
+			rgbaStride := rgba.Pix[rgbaPixOffset : rgbaPixOffset+4 : rgbaPixOffset+4]
+
+			// Opaque pixels (e.g. all JPEG pixels) can be passed as-is,
+			// transparent ones need to be premultiplied with the alpha
+			alpha := uint32(ngrbaStride[3])
+			if alpha == 0xFF {
+				rgbaStride[0] = ngrbaStride[0]
+				rgbaStride[1] = ngrbaStride[1]
+				rgbaStride[2] = ngrbaStride[2]
+			} else {
+				rgbaStride[0] = uint8(uint32(ngrbaStride[0]) * alpha / 0xFF)
+				rgbaStride[1] = uint8(uint32(ngrbaStride[1]) * alpha / 0xFF)
+				rgbaStride[2] = uint8(uint32(ngrbaStride[2]) * alpha / 0xFF)
+			}
+			rgbaStride[3] = ngrbaStride[3]
+		}
+	}
+	end := time.Now()
+
+	if logger.IsLogLevel(logger.TRACE) {
+		logger.Trace.Printf("Converting from NRGBA to RGBA: %s", end.Sub(start))
+	}
+
+	return rgba
+}
+
+// Private API
+
+func loadImage(path string, rotation float64, flipped bool, decode func(Decoder, io.Reader) (image.Image, error)) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder, err := findDecoder(path, file)
+	if err != nil {
+		return nil, err
+	}
+
+	imageFile, err := decode(decoder, file)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := apitype.ExifRotateImage(imageFile, rotation, flipped)
+
+	return ConvertNrgbaToRgba(rotated), nil
+}
+
+// Finds the decoder by the file extension and the magic bytes of the file.
+// The decoder of the file extension is preferred because some formats share
+// the same magic (e.g. TIFF and RAW). If the magic doesn't match the
+// extension, the decoder is resolved by the magic alone. As the last resort
+// the decoder for the extension is used.
+func findDecoder(path string, file io.ReaderAt) (Decoder, error) {
+	decoderMux.RLock()
+	defer decoderMux.RUnlock()
+
+	header := make([]byte, maxMagicLength)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	format := apitype.ImageFormatOf(path)
+	var formatEntry *decoderEntry
+	for _, entry := range decoders {
+		if entry.format == format {
+			formatEntry = entry
+		}
+	}
+
+	if formatEntry != nil && formatEntry.matches(header) {
+		return formatEntry.decoder, nil
+	}
+	for _, entry := range decoders {
+		if entry.matches(header) {
+			return entry.decoder, nil
+		}
+	}
+	if formatEntry != nil {
+		logger.Debug.Printf("Unknown header in '%s', trying to decode as %s", path, format)
+		return formatEntry.decoder, nil
+	}
+
+	return nil, fmt.Errorf("no decoder found for '%s'", path)
+}
+
+func (s *decoderEntry) matches(header []byte) bool {
+	for _, magic := range s.magic {
+		if matchMagic(magic, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchMagic(magic string, header []byte) bool {
+	if len(header) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != header[i] && magic[i] != '?' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,272 @@
+package imagereader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+func init() {
+	RegisterDecoder(apitype.RAW, &RawDecoder{}, "II*\x00", "MM\x00*")
+}
+
+const (
+	tiffTagCompression     = 0x103
+	tiffTagStripOffsets    = 0x111
+	tiffTagStripByteCounts = 0x117
+	tiffTagSubIfds         = 0x14A
+	tiffTagJpegOffset      = 0x201
+	tiffTagJpegLength      = 0x202
+	tiffTypeShort          = 3
+	tiffTypeLong           = 4
+	tiffTypeIfd            = 13
+	tiffCompressionOldJpeg = 6
+	tiffCompressionJpeg    = 7
+	tiffIfdEntryLength     = 12
+	maxIfdsToRead          = 32
+	maxIfdEntries          = 1024
+	jpegStartOfImageMarker = 0xD8
+	jpegMarkerPrefix       = 0xFF
+	jpegMarkerLength       = 2
+)
+
+// RawDecoder decodes TIFF based camera RAW files (e.g. CR2, NEF, ARW and DNG).
+// The actual RAW data is not decoded. Instead, the largest embedded
+// JPEG preview is decoded using the libjpeg decoder.
+type RawDecoder struct {
+	jpeg LibJPEGDecoder
+
+	Decoder
+}
+
+func (s *RawDecoder) Decode(reader io.Reader) (image.Image, error) {
+	if preview, err := s.findPreview(reader); err != nil {
+		return nil, err
+	} else {
+		return s.jpeg.Decode(preview)
+	}
+}
+
+func (s *RawDecoder) DecodeScaled(reader io.Reader, width int, height int) (image.Image, error) {
+	if preview, err := s.findPreview(reader); err != nil {
+		return nil, err
+	} else {
+		return s.jpeg.DecodeScaled(preview, width, height)
+	}
+}
+
+func (s *RawDecoder) DecodeConfig(reader io.Reader) (image.Config, error) {
+	if preview, err := s.findPreview(reader); err != nil {
+		return image.Config{}, err
+	} else {
+		return s.jpeg.DecodeConfig(preview)
+	}
+}
+
+// Private API
+
+type jpegPreview struct {
+	offset int64
+	length int64
+}
+
+// Finds the embedded JPEG with most pixels. Some of the embedded JPEGs may be
+// lossless JPEGs (the RAW data itself) which can't be decoded, so they are skipped.
+func (s *RawDecoder) findPreview(reader io.Reader) (*io.SectionReader, error) {
+	readerAt, ok := reader.(io.ReaderAt)
+	if !ok {
+		if data, err := ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		} else {
+			readerAt = bytes.NewReader(data)
+		}
+	}
+
+	previews, err := findJpegPreviews(readerAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *io.SectionReader
+	bestPixels := 0
+	for _, preview := range previews {
+		section := io.NewSectionReader(readerAt, preview.offset, preview.length)
+		if !isJpeg(section) {
+			continue
+		}
+		if config, err := s.jpeg.DecodeConfig(io.NewSectionReader(readerAt, preview.offset, preview.length)); err != nil {
+			logger.Trace.Printf("Skipping embedded JPEG at %d: %s", preview.offset, err)
+		} else if pixels := config.Width * config.Height; pixels > bestPixels {
+			best = section
+			bestPixels = pixels
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New("no embedded JPEG preview found")
+	}
+	return best, nil
+}
+
+func isJpeg(section *io.SectionReader) bool {
+	header := make([]byte, jpegMarkerLength)
+	if _, err := section.ReadAt(header, 0); err != nil {
+		return false
+	}
+	return header[0] == jpegMarkerPrefix && header[1] == jpegStartOfImageMarker
+}
+
+type tiffReader struct {
+	reader    io.ReaderAt
+	byteOrder binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	value    []byte
+}
+
+// Walks all the IFDs and their sub IFDs and collects the locations of the
+// embedded JPEG images
+func findJpegPreviews(reader io.ReaderAt) ([]jpegPreview, error) {
+	header := make([]byte, 8)
+	if _, err := reader.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	tiff := &tiffReader{reader: reader}
+	switch string(header[0:4]) {
+	case "II*\x00":
+		tiff.byteOrder = binary.LittleEndian
+	case "MM\x00*":
+		tiff.byteOrder = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF based RAW file")
+	}
+
+	var previews []jpegPreview
+	visited := map[uint32]bool{}
+	ifdOffsets := []uint32{tiff.byteOrder.Uint32(header[4:8])}
+	for len(ifdOffsets) > 0 && len(visited) < maxIfdsToRead {
+		offset := ifdOffsets[0]
+		ifdOffsets = ifdOffsets[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		entries, next, err := tiff.readIfd(offset)
+		if err != nil {
+			logger.Debug.Printf("Could not read IFD at %d: %s", offset, err)
+			continue
+		}
+		ifdOffsets = append(ifdOffsets, next)
+		if subIfds, ok := entries[tiffTagSubIfds]; ok {
+			ifdOffsets = append(ifdOffsets, tiff.values(subIfds)...)
+		}
+
+		if jpegOffset, ok := entries[tiffTagJpegOffset]; ok {
+			if jpegLength, ok := entries[tiffTagJpegLength]; ok {
+				if preview, ok := newJpegPreview(tiff.values(jpegOffset), tiff.values(jpegLength)); ok {
+					previews = append(previews, preview)
+				}
+			}
+		}
+		if compression, ok := entries[tiffTagCompression]; ok {
+			values := tiff.values(compression)
+			if len(values) == 1 && (values[0] == tiffCompressionOldJpeg || values[0] == tiffCompressionJpeg) {
+				if stripOffsets, ok := entries[tiffTagStripOffsets]; ok {
+					if stripByteCounts, ok := entries[tiffTagStripByteCounts]; ok {
+						if preview, ok := newJpegPreview(tiff.values(stripOffsets), tiff.values(stripByteCounts)); ok {
+							previews = append(previews, preview)
+						}
+					}
+				}
+			}
+		}
+	}
+	return previews, nil
+}
+
+// Only single strip JPEGs are supported
+func newJpegPreview(offsets []uint32, lengths []uint32) (jpegPreview, bool) {
+	if len(offsets) != 1 || len(lengths) != 1 || lengths[0] < jpegMarkerLength {
+		return jpegPreview{}, false
+	}
+	return jpegPreview{
+		offset: int64(offsets[0]),
+		length: int64(lengths[0]),
+	}, true
+}
+
+func (s *tiffReader) readIfd(offset uint32) (map[uint16]*ifdEntry, uint32, error) {
+	countBytes := make([]byte, 2)
+	if _, err := s.reader.ReadAt(countBytes, int64(offset)); err != nil {
+		return nil, 0, err
+	}
+	count := int(s.byteOrder.Uint16(countBytes))
+	if count > maxIfdEntries {
+		return nil, 0, errors.New("too many IFD entries")
+	}
+
+	data := make([]byte, count*tiffIfdEntryLength+4)
+	if _, err := s.reader.ReadAt(data, int64(offset)+2); err != nil {
+		return nil, 0, err
+	}
+
+	entries := map[uint16]*ifdEntry{}
+	for i := 0; i < count; i++ {
+		entryData := data[i*tiffIfdEntryLength : (i+1)*tiffIfdEntryLength]
+		entry := &ifdEntry{
+			tag:      s.byteOrder.Uint16(entryData[0:2]),
+			dataType: s.byteOrder.Uint16(entryData[2:4]),
+			count:    s.byteOrder.Uint32(entryData[4:8]),
+			value:    entryData[8:12],
+		}
+		entries[entry.tag] = entry
+	}
+	next := s.byteOrder.Uint32(data[count*tiffIfdEntryLength:])
+	return entries, next, nil
+}
+
+// Reads SHORT, LONG and IFD values of the entry. Other types are ignored.
+func (s *tiffReader) values(entry *ifdEntry) []uint32 {
+	var valueLength int
+	switch entry.dataType {
+	case tiffTypeShort:
+		valueLength = 2
+	case tiffTypeLong, tiffTypeIfd:
+		valueLength = 4
+	default:
+		return nil
+	}
+	if entry.count > maxIfdEntries {
+		return nil
+	}
+
+	// Values are stored in the entry if they fit in 4 bytes
+	data := entry.value
+	if byteLength := int(entry.count) * valueLength; byteLength > 4 {
+		data = make([]byte, byteLength)
+		if _, err := s.reader.ReadAt(data, int64(s.byteOrder.Uint32(entry.value))); err != nil {
+			return nil
+		}
+	}
+
+	values := make([]uint32, entry.count)
+	for i := range values {
+		if valueLength == 2 {
+			values[i] = uint32(s.byteOrder.Uint16(data[i*2:]))
+		} else {
+			values[i] = s.byteOrder.Uint32(data[i*4:])
+		}
+	}
+	return values
+}
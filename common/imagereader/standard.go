@@ -0,0 +1,46 @@
+package imagereader
+
+import (
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"vincit.fi/image-sorter/api/apitype"
+)
+
+func init() {
+	RegisterDecoder(apitype.PNG, NewStandardDecoder(png.Decode, png.DecodeConfig), "\x89PNG\r\n\x1a\n")
+	RegisterDecoder(apitype.GIF, NewStandardDecoder(gif.Decode, gif.DecodeConfig), "GIF87a", "GIF89a")
+	RegisterDecoder(apitype.TIFF, NewStandardDecoder(tiff.Decode, tiff.DecodeConfig), "II*\x00", "MM\x00*")
+	RegisterDecoder(apitype.WEBP, NewStandardDecoder(webp.Decode, webp.DecodeConfig), "RIFF????WEBPVP8")
+}
+
+// StandardDecoder uses pure Go decoder functions. The images are
+// always decoded in full size.
+type StandardDecoder struct {
+	decode       func(io.Reader) (image.Image, error)
+	decodeConfig func(io.Reader) (image.Config, error)
+
+	Decoder
+}
+
+func NewStandardDecoder(decode func(io.Reader) (image.Image, error), decodeConfig func(io.Reader) (image.Config, error)) *StandardDecoder {
+	return &StandardDecoder{
+		decode:       decode,
+		decodeConfig: decodeConfig,
+	}
+}
+
+func (s *StandardDecoder) Decode(reader io.Reader) (image.Image, error) {
+	return s.decode(reader)
+}
+
+func (s *StandardDecoder) DecodeScaled(reader io.Reader, width int, height int) (image.Image, error) {
+	return s.decode(reader)
+}
+
+func (s *StandardDecoder) DecodeConfig(reader io.Reader) (image.Config, error) {
+	return s.decodeConfig(reader)
+}
@@ -1,7 +1,9 @@
 package common
 
 import (
+	"fmt"
 	"github.com/go-gl/glfw/v3.3/glfw"
+	"strings"
 )
 
 /*
@@ -258,3 +260,170 @@ func KeyvalName(keyval uint) string {
 func KeyToUint(key string) uint {
 	return uint(keymapStringToKey[key])
 }
+
+// Looks up the key by the name. Letters may be given in lower case too.
+func keyByName(name string) (uint, bool) {
+	if key, ok := keymapStringToKey[name]; ok {
+		return uint(key), true
+	}
+	for keyName, key := range keymapStringToKey {
+		if strings.EqualFold(keyName, name) {
+			return uint(key), true
+		}
+	}
+	return 0, false
+}
+
+// KeyModifier is a set of modifier keys that must be held down with a key
+type KeyModifier uint
+
+const (
+	ModifierShift KeyModifier = 1 << iota
+	ModifierControl
+	ModifierAlt
+	ModifierSuper
+)
+
+const NoModifiers = KeyModifier(0)
+
+// Modifiers in the order they are shown
+var modifierNames = []struct {
+	modifier KeyModifier
+	name     string
+}{
+	{modifier: ModifierControl, name: "Ctrl"},
+	{modifier: ModifierShift, name: "Shift"},
+	{modifier: ModifierAlt, name: "Alt"},
+	{modifier: ModifierSuper, name: "Super"},
+}
+
+func (s KeyModifier) Has(modifier KeyModifier) bool {
+	return s&modifier == modifier
+}
+
+// Number of modifiers in the set
+func (s KeyModifier) Count() int {
+	count := 0
+	for _, modifier := range modifierNames {
+		if s.Has(modifier.modifier) {
+			count++
+		}
+	}
+	return count
+}
+
+// KeyStroke is a key pressed together with modifiers, e.g. "Ctrl+G"
+type KeyStroke struct {
+	Key       uint
+	Modifiers KeyModifier
+}
+
+// Returns true if the key is the same and at least the modifiers of the stroke are held down.
+// Extra modifiers are allowed so that they can change what the shortcut does.
+func (s KeyStroke) Matches(key uint, heldModifiers KeyModifier) bool {
+	return s.Key == key && heldModifiers.Has(s.Modifiers)
+}
+
+func (s KeyStroke) String() string {
+	var builder strings.Builder
+	for _, modifier := range modifierNames {
+		if s.Modifiers.Has(modifier.modifier) {
+			builder.WriteString(modifier.name + "+")
+		}
+	}
+	builder.WriteString(KeyvalName(s.Key))
+	return builder.String()
+}
+
+// KeySequence is one or more key strokes pressed one after another, e.g. "G 3"
+type KeySequence []KeyStroke
+
+// Parses a sequence where the strokes are separated with spaces and the modifiers
+// with "+", e.g. "Ctrl+G 3". Empty value is an empty sequence.
+func ParseKeySequence(value string) (KeySequence, error) {
+	words := strings.Fields(value)
+	var sequence KeySequence
+	for i := 0; i < len(words); {
+		// Key names may contain spaces too, e.g. "Key Pad 1", so the longest name is used
+		found := false
+		for j := len(words); j > i && !found; j-- {
+			if stroke, ok := parseKeyStroke(strings.Join(words[i:j], " ")); ok {
+				sequence = append(sequence, stroke)
+				i = j
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown key '%s' in shortcut '%s'", words[i], value)
+		}
+	}
+	return sequence, nil
+}
+
+func (s KeySequence) String() string {
+	strokes := make([]string, len(s))
+	for i, stroke := range s {
+		strokes[i] = stroke.String()
+	}
+	return strings.Join(strokes, " ")
+}
+
+func (s KeySequence) Equals(other KeySequence) bool {
+	return len(s) == len(other) && s.HasPrefix(other)
+}
+
+// Returns true if the sequence starts with the given strokes
+func (s KeySequence) HasPrefix(prefix KeySequence) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i, stroke := range prefix {
+		if s[i] != stroke {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the sequences can't be told apart while typing, i.e. they
+// are the same or one of them is the beginning of the other
+func (s KeySequence) ConflictsWith(other KeySequence) bool {
+	if len(s) == 0 || len(other) == 0 {
+		return false
+	}
+	return s.HasPrefix(other) || other.HasPrefix(s)
+}
+
+// Returns true if the pressed strokes are the beginning of the sequence or the whole sequence.
+// Pressed strokes have the modifiers that were held down.
+func (s KeySequence) StartsWithPressed(pressed KeySequence) bool {
+	if len(pressed) == 0 || len(pressed) > len(s) {
+		return false
+	}
+	for i, stroke := range pressed {
+		if !s[i].Matches(stroke.Key, stroke.Modifiers) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseKeyStroke(value string) (KeyStroke, bool) {
+	stroke := KeyStroke{}
+	for matched := true; matched; {
+		matched = false
+		for _, modifier := range modifierNames {
+			prefix := modifier.name + "+"
+			if len(value) > len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+				stroke.Modifiers |= modifier.modifier
+				value = value[len(prefix):]
+				matched = true
+			}
+		}
+	}
+	if key, ok := keyByName(value); ok {
+		stroke.Key = key
+		return stroke, true
+	}
+	return KeyStroke{}, false
+}
@@ -44,3 +44,67 @@ func TestKeyvalName(t *testing.T) {
 		})
 	}
 }
+
+func TestParseKeySequence(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		value string
+		want  KeySequence
+	}{
+		{value: "", want: nil},
+		{value: "C", want: KeySequence{{Key: 0x43}}},
+		{value: "g 3", want: KeySequence{{Key: 0x47}, {Key: 0x33}}},
+		{value: "Ctrl+Shift+G", want: KeySequence{{Key: 0x47, Modifiers: ModifierControl | ModifierShift}}},
+		{value: "Key Pad 1 Alt+Key Pad +", want: KeySequence{{Key: 321}, {Key: 334, Modifiers: ModifierAlt}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			sequence, err := ParseKeySequence(tt.value)
+			a.Nil(err)
+			a.Equal(tt.want, sequence)
+		})
+	}
+
+	_, err := ParseKeySequence("G Foo")
+	a.NotNil(err)
+	_, err = ParseKeySequence("Ctrl+")
+	a.NotNil(err)
+}
+
+func TestKeySequence_String(t *testing.T) {
+	a := assert.New(t)
+
+	sequence, err := ParseKeySequence("shift+ctrl+g 3 Key Pad 1")
+	a.Nil(err)
+	a.Equal("Ctrl+Shift+G 3 Key Pad 1", sequence.String())
+}
+
+func TestKeySequence_ConflictsWith(t *testing.T) {
+	a := assert.New(t)
+
+	parse := func(value string) KeySequence {
+		sequence, _ := ParseKeySequence(value)
+		return sequence
+	}
+
+	a.True(parse("G").ConflictsWith(parse("G")))
+	a.True(parse("G").ConflictsWith(parse("G 3")))
+	a.True(parse("G 3").ConflictsWith(parse("G")))
+	a.False(parse("G 3").ConflictsWith(parse("G 4")))
+	a.False(parse("G").ConflictsWith(parse("Ctrl+G")))
+	a.False(parse("").ConflictsWith(parse("")))
+}
+
+func TestKeySequence_StartsWithPressed(t *testing.T) {
+	a := assert.New(t)
+
+	sequence, _ := ParseKeySequence("Ctrl+G 3")
+
+	a.True(sequence.StartsWithPressed(KeySequence{{Key: 0x47, Modifiers: ModifierControl}}))
+	// Extra modifiers are allowed
+	a.True(sequence.StartsWithPressed(KeySequence{{Key: 0x47, Modifiers: ModifierControl | ModifierShift}, {Key: 0x33}}))
+	a.False(sequence.StartsWithPressed(KeySequence{{Key: 0x47}}))
+	a.False(sequence.StartsWithPressed(KeySequence{{Key: 0x47, Modifiers: ModifierControl}, {Key: 0x34}}))
+	a.False(sequence.StartsWithPressed(nil))
+}
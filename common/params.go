@@ -5,6 +5,11 @@ import (
 	"strings"
 )
 
+const cliCommand = "cli"
+
+// Metadata (XMP), iOS edits (AAE), camera thumbnails (THM) and the video part of Live Photos (MOV)
+const defaultSidecarExtensions = "xmp,aae,thm,mov"
+
 type Params struct {
 	categories            []string
 	httpPort              int
@@ -12,6 +17,13 @@ type Params struct {
 	alwaysStartHttpServer bool
 	logLevel              string
 	rootPath              string
+	recursive             bool
+	sidecarExtensions     []string
+	thumbnailCacheSize    int
+	imageCacheSize        int
+	watch                 bool
+	cliMode               bool
+	cliArgs               []string
 }
 
 func NewEmptyParams() *Params {
@@ -22,19 +34,45 @@ func NewEmptyParams() *Params {
 		alwaysStartHttpServer: false,
 		logLevel:              "",
 		rootPath:              "",
+		recursive:             false,
+		sidecarExtensions:     []string{},
+		thumbnailCacheSize:    0,
+		imageCacheSize:        0,
+		watch:                 false,
+		cliMode:               false,
+		cliArgs:               []string{},
 	}
 }
 
 func ParseParams() *Params {
 	categories := flag.String("categories", "", "Comma separated categories. Each category in format <name>:<shortcut> e.g. Good:G")
-	categoryArr := strings.Split(*categories, ",")
 	httpPort := flag.Int("httpPort", 8080, "HTTP Server port for Chrome Cast")
 	secret := flag.String("secret", "", "Override default random secret for casting")
 	alwaysStartHttpServer := flag.Bool("alwaysStartHttpServer", false, "Always start HTTP server. Not only when casting.")
 	logLevel := flag.String("logLevel", "INFO", "Log level: ERROR, WARN, INFO, DEBUG, Trace")
+	recursive := flag.Bool("recursive", false, "Scan also the images in sub directories")
+	sidecars := flag.String("sidecars", defaultSidecarExtensions,
+		"Comma separated file extensions of sidecar files which are copied and removed together with the images. Empty to disable.")
+	thumbnailCacheSize := flag.Int("thumbnailCacheSize", 512,
+		"Max size of the thumbnails cached in the image directory in megabytes. 0 disables the cache.")
+	imageCacheSize := flag.Int("imageCacheSize", 1024,
+		"Max size of the decoded images kept in memory in megabytes. The shown image is always kept.")
+	watch := flag.Bool("watch", true,
+		"Update the images when they are added, modified or removed by other applications. Only supported on Linux.")
 
 	flag.Parse()
+	categoryArr := strings.Split(*categories, ",")
+
+	// "cli" as the first argument starts the headless mode and the rest
+	// of the arguments are passed to the CLI as is
 	rootPath := flag.Arg(0)
+	cliMode := false
+	var cliArgs []string
+	if rootPath == cliCommand {
+		cliMode = true
+		cliArgs = flag.Args()[1:]
+		rootPath = ""
+	}
 
 	return &Params{
 		categories:            categoryArr,
@@ -43,6 +81,13 @@ func ParseParams() *Params {
 		alwaysStartHttpServer: *alwaysStartHttpServer,
 		logLevel:              *logLevel,
 		rootPath:              rootPath,
+		recursive:             *recursive,
+		sidecarExtensions:     parseFileExtensions(*sidecars),
+		thumbnailCacheSize:    *thumbnailCacheSize,
+		imageCacheSize:        *imageCacheSize,
+		watch:                 *watch,
+		cliMode:               cliMode,
+		cliArgs:               cliArgs,
 	}
 }
 
@@ -69,3 +114,49 @@ func (s *Params) LogLevel() string {
 func (s *Params) RootPath() string {
 	return s.rootPath
 }
+
+func (s *Params) Recursive() bool {
+	return s.recursive
+}
+
+func (s *Params) SidecarExtensions() []string {
+	return s.sidecarExtensions
+}
+
+// Max size of the thumbnail cache in bytes
+func (s *Params) ThumbnailCacheSize() int64 {
+	return int64(s.thumbnailCacheSize) * 1024 * 1024
+}
+
+func (s *Params) ImageCacheSize() uint64 {
+	return uint64(s.imageCacheSize) * 1024 * 1024
+}
+
+func (s *Params) Watch() bool {
+	return s.watch
+}
+
+func (s *Params) CliMode() bool {
+	return s.cliMode
+}
+
+func (s *Params) CliArgs() []string {
+	return s.cliArgs
+}
+
+// Parses a comma separated list of file extensions, e.g. "xmp,.AAE".
+// The returned extensions are lower case and start with a dot.
+func parseFileExtensions(value string) []string {
+	extensions := []string{}
+	for _, extension := range strings.Split(value, ",") {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions
+}
@@ -0,0 +1,14 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseFileExtensions(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal([]string{".xmp", ".aae", ".thm", ".mov"}, parseFileExtensions(defaultSidecarExtensions))
+	a.Equal([]string{".xmp", ".aae"}, parseFileExtensions(" XMP, .aae ,"))
+	a.Equal([]string{}, parseFileExtensions(""))
+}
@@ -13,7 +13,7 @@ func LoadExifData(imageFile *apitype.ImageFile) (*apitype.ExifData, error) {
 		defer fileForExif.Close()
 
 		if decodedExif, err := exif.Decode(fileForExif); err != nil {
-			logger.Error.Print("Could not decode Exif data", err)
+			logger.Debug.Print("Could not decode Exif data ", err)
 			return nil, err
 		} else {
 			return apitype.NewExifData(decodedExif)
@@ -19,6 +19,7 @@ require (
 	github.com/stretchr/testify v1.7.1
 	github.com/upper/db/v4 v4.0.1
 	github.com/vardius/message-bus v1.1.4
+	golang.org/x/image v0.0.0-20220302094943-723b81ca9867
 )
 
 require (
@@ -40,7 +41,6 @@ require (
 	github.com/sahilm/fuzzy v0.1.0 // indirect
 	github.com/stretchr/objx v0.1.0 // indirect
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
-	golang.org/x/image v0.0.0-20220302094943-723b81ca9867 // indirect
 	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
 	golang.org/x/sys v0.0.0-20220315194320-039c03cc5b86 // indirect
 	gopkg.in/eapache/queue.v1 v1.1.0 // indirect
@@ -2,13 +2,14 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/backend"
-	"vincit.fi/image-sorter/backend/dbapi"
 	"vincit.fi/image-sorter/common"
 	"vincit.fi/image-sorter/common/logger"
 	"vincit.fi/image-sorter/common/util"
+	"vincit.fi/image-sorter/ui/cli"
 	giuUi "vincit.fi/image-sorter/ui/giu"
 )
 
@@ -19,9 +20,26 @@ func main() {
 	params := common.ParseParams()
 	logger.Initialize(logger.StringToLogLevel(params.LogLevel()))
 
+	if params.CliMode() {
+		os.Exit(initAndRunCli(params))
+	}
 	initAndRun(params)
 }
 
+// Run without GUI so that event brokers are synchronous
+// and no UI is initialized
+func initAndRunCli(params *common.Params) int {
+	stores := backend.InitializeStores(databaseFileName)
+	defer stores.Close()
+
+	brokers := backend.InitializeSyncEventBrokers()
+
+	services := backend.InitializeServices(params, stores, brokers)
+	defer services.Close()
+
+	return cli.NewCli(params, stores, services, brokers, databaseFileName).Run()
+}
+
 func initAndRun(params *common.Params) {
 	printHeaderToLogger()
 
@@ -67,23 +85,15 @@ func connectUiAndServices(params *common.Params, stores *backend.Stores, service
 		brokers.Broker.SendToTopic(api.BackendLoading)
 		logger.Info.Printf("Directory changed to '%s'", directory)
 
-		if err := stores.InitializeForDirectory(directory, databaseFileName); err != nil {
+		if err := backend.InitializeForDirectory(params, stores, services, directory, databaseFileName); err != nil {
 			logger.Error.Fatal("Error opening database", err)
 		} else {
-			if tableExist := stores.Migrate(); tableExist == dbapi.TableNotExist {
-				if defaultCategories, err := stores.DefaultCategoryStore.GetCategories(); err != nil {
-					logger.Error.Print("Error while trying to load default categories ", err)
-				} else {
-					services.CategoryService.InitializeFromDirectory(params.Categories(), defaultCategories)
-				}
-			}
-			services.ImageService.InitializeFromDirectory(directory)
-
 			if len(services.ImageService.GetImageFiles()) > 0 {
 				services.ImageCache.Initialize(services.ImageService.GetImageFiles(), api.NewSenderProgressReporter(brokers.Broker))
 			}
-
-			services.ImageCategoryService.InitializeForDirectory(directory)
+			if err := backend.WatchDirectory(params, services, directory); err != nil {
+				logger.Warn.Printf("Could not watch '%s' for changes: %s", directory, err)
+			}
 
 			services.CategoryService.RequestCategories()
 			brokers.Broker.SendToTopic(api.BackendReady)
@@ -106,6 +116,9 @@ func connectUiAndServices(params *common.Params, stores *backend.Stores, service
 	brokers.Broker.Subscribe(api.ImageListSizeChanged, services.ImageService.SetImageListSize)
 	brokers.Broker.Subscribe(api.ImageShowAll, services.ImageService.ShowAllImages)
 	brokers.Broker.Subscribe(api.ImageShowOnly, services.ImageService.ShowOnlyImages)
+	brokers.Broker.Subscribe(api.ImageShowMatching, services.ImageService.ShowOnlyMatchingImages)
+	brokers.Broker.Subscribe(api.ImageSetSortOrder, services.ImageService.SetSortOrder)
+	brokers.Broker.Subscribe(api.ImageFilesChanged, services.ImageService.UpdateImageFiles)
 
 	brokers.Broker.Subscribe(api.SimilarRequestSearch, services.ImageService.RequestGenerateHashes)
 	brokers.Broker.Subscribe(api.SimilarRequestStop, services.ImageService.RequestStopHashes)
@@ -119,12 +132,45 @@ func connectUiAndServices(params *common.Params, stores *backend.Stores, service
 
 	// UI -> Image Categorization
 	brokers.Broker.Subscribe(api.CategorizeImage, services.ImageCategoryService.SetCategory)
+	brokers.Broker.Subscribe(api.CategorizeUndo, services.ImageCategoryService.UndoCategorization)
+	brokers.Broker.Subscribe(api.CategorizeRedo, services.ImageCategoryService.RedoCategorization)
 	brokers.Broker.Subscribe(api.CategoryPersistAll, services.ImageCategoryService.PersistImageCategories)
+	brokers.Broker.Subscribe(api.CategoryPlanRequest, services.ImageCategoryService.RequestApplyPlan)
+	brokers.Broker.Subscribe(api.CategoryRollback, services.ImageCategoryService.RollbackApplyJob)
+	brokers.Broker.Subscribe(api.CategoryDiscardJob, services.ImageCategoryService.DiscardApplyJob)
 	brokers.Broker.Subscribe(api.ImageChanged, services.ImageCategoryService.RequestCategory)
 	brokers.Broker.Subscribe(api.CategoriesShowOnly, services.ImageCategoryService.ShowOnlyCategoryImages)
 
+	// UI -> Image Rating
+	brokers.Broker.Subscribe(api.ImageRate, services.ImageRatingService.SetRating)
+	brokers.Broker.Subscribe(api.ImageSetColorLabel, services.ImageRatingService.SetColorLabel)
+	brokers.Broker.Subscribe(api.ImageChanged, services.ImageRatingService.RequestRating)
+	brokers.Broker.Subscribe(api.ImageShowRated, services.ImageService.ShowOnlyRatedImages)
+
+	// Image Rating -> UI
+	brokers.Broker.Subscribe(api.ImageRatingUpdated, gui.SetImageRating)
+
+	// UI -> Tags
+	brokers.Broker.Subscribe(api.ImageTag, services.TagService.TagImage)
+	brokers.Broker.Subscribe(api.ImageTagFiltered, services.TagService.TagFilteredImages)
+	brokers.Broker.Subscribe(api.ImageChanged, services.TagService.RequestTags)
+
+	// Tags -> UI
+	brokers.Broker.Subscribe(api.ImageTagsUpdated, gui.SetImageTags)
+	brokers.Broker.Subscribe(api.TagsUpdated, gui.SetTags)
+
+	// UI -> Saved searches
+	brokers.Broker.Subscribe(api.SavedSearchSave, services.SavedSearchService.SaveSearch)
+	brokers.Broker.Subscribe(api.SavedSearchRemove, services.SavedSearchService.RemoveSavedSearch)
+	brokers.Broker.Subscribe(api.BackendReady, services.SavedSearchService.RequestSavedSearches)
+	brokers.Broker.Subscribe(api.ImageAnnotationsUpdated, services.SavedSearchService.RequestSavedSearches)
+
+	// Saved searches -> UI
+	brokers.Broker.Subscribe(api.SavedSearchesUpdated, gui.SetSavedSearches)
+
 	// Image Categorization -> UI
 	brokers.Broker.Subscribe(api.CategoryImageUpdate, gui.SetImageCategory)
+	brokers.Broker.Subscribe(api.CategoryPlanUpdated, gui.ShowApplyPlan)
 
 	// UI -> Caster
 	brokers.Broker.Subscribe(api.CastDeviceSearch, services.CasterInstance.FindDevices)
@@ -0,0 +1,844 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"vincit.fi/image-sorter/api"
+	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/backend"
+	"vincit.fi/image-sorter/common"
+	"vincit.fi/image-sorter/common/logger"
+)
+
+const (
+	exitOk    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name        string
+	arguments   string
+	description string
+	run         func(s *Cli, args []string) error
+}
+
+var commands = []*command{
+	{
+		name:        "scan",
+		arguments:   "[-dir <directory>]",
+		description: "Scan the directory and update the image library",
+		run:         (*Cli).scan,
+	},
+	{
+		name:        "categorize",
+		arguments:   "[-dir <directory>] [-remove] [-force] <file> <category>",
+		description: "Set (or remove) category for an image",
+		run:         (*Cli).categorize,
+	},
+	{
+		name:        "rate",
+		arguments:   "[-dir <directory>] [-rating <0-5>] [-label <color>] <file>",
+		description: "Set the star rating and/or the color label of an image. Rating 0 and an empty label clear them",
+		run:         (*Cli).rate,
+	},
+	{
+		name:        "tag",
+		arguments:   "[-dir <directory>] [-remove] <file> <tags>",
+		description: "Add (or remove) comma separated tags to an image",
+		run:         (*Cli).tag,
+	},
+	{
+		name:        "tag-all",
+		arguments:   "[-dir <directory>] [-remove] [-category <category>] [-min-rating <1-5>] [-label <color>] [-tag <tag>] [-query <query>] [-search <name>] <tags>",
+		description: "Add (or remove) comma separated tags to all the images that list would list with the same options",
+		run:         (*Cli).tagAll,
+	},
+	{
+		name:        "tags",
+		arguments:   "[-dir <directory>] [<file>]",
+		description: "List all the tags or the tags of an image",
+		run:         (*Cli).tags,
+	},
+	{
+		name:        "list",
+		arguments:   "[-dir <directory>] [-category <category>] [-min-rating <1-5>] [-label <color>] [-tag <tag>] [-query <query>] [-search <name>] [-sort <order>]",
+		description: "List images. Optionally only the images in the given category, rated at least the given stars, with the color label or with the tag",
+		run:         (*Cli).list,
+	},
+	{
+		name:        "sort",
+		arguments:   "[-dir <directory>] [<order>]",
+		description: "Show or set the order of the images in the directory, e.g. \"date desc\" or \"random:42\". Fields: " + apitype.SortFieldNames(),
+		run:         (*Cli).sort,
+	},
+	{
+		name:        "save-search",
+		arguments:   "[-dir <directory>] [-shortcut <keys>] <name> <query>",
+		description: "Save the search query with the name. Existing search with the same name is replaced",
+		run:         (*Cli).saveSearch,
+	},
+	{
+		name:        "remove-search",
+		arguments:   "[-dir <directory>] <name>",
+		description: "Remove the saved search",
+		run:         (*Cli).removeSearch,
+	},
+	{
+		name:        "searches",
+		arguments:   "[-dir <directory>]",
+		description: "List the saved searches and how many images match them",
+		run:         (*Cli).searches,
+	},
+	{
+		name:        "prune-thumbnails",
+		arguments:   "[-dir <directory>]",
+		description: "Remove the cached thumbnails of removed and modified images and the least recently used ones that don't fit in -thumbnailCacheSize",
+		run:         (*Cli).pruneThumbnails,
+	},
+	{
+		name:        "apply",
+		arguments:   "[-dir <directory>] [-keep-originals] [-fix-orientation] [-quality <0-100>] [-flatten] [-conflict <policy>] [-copy-method <method>] [-output <mode>] [-rename <template>] [-write-ratings] [-write-tags] [-dry-run]",
+		description: "Copy/move/link the categorized images to the category directories. With -dry-run the changes are printed as JSON",
+		run:         (*Cli).apply,
+	},
+	{
+		name:        "jobs",
+		arguments:   "[-dir <directory>]",
+		description: "List the apply jobs, latest first",
+		run:         (*Cli).jobs,
+	},
+	{
+		name:        "rollback",
+		arguments:   "[-dir <directory>]",
+		description: "Roll back the latest completed apply job. Removes the copies and restores the removed and overwritten files",
+		run:         (*Cli).rollback,
+	},
+	{
+		name:        "discard",
+		arguments:   "[-dir <directory>] [-job <id>]",
+		description: "Remove the files held for rolling back the completed apply jobs, or only the given job. The jobs can't be rolled back anymore",
+		run:         (*Cli).discard,
+	},
+}
+
+// Cli runs the image sorter without the GUI. Services are called directly
+// and the messages services send to the broker are printed out.
+type Cli struct {
+	params           *common.Params
+	stores           *backend.Stores
+	services         *backend.Services
+	brokers          *backend.Brokers
+	databaseFileName string
+	out              io.Writer
+	errOut           io.Writer
+	errorCount       int
+	lastProgressName string
+}
+
+// Creates a new CLI. Brokers should be synchronous (see backend.InitializeSyncEventBrokers)
+// so that the errors sent by the services have been handled before a command returns.
+func NewCli(params *common.Params, stores *backend.Stores, services *backend.Services, brokers *backend.Brokers, databaseFileName string) *Cli {
+	cli := &Cli{
+		params:           params,
+		stores:           stores,
+		services:         services,
+		brokers:          brokers,
+		databaseFileName: databaseFileName,
+		out:              os.Stdout,
+		errOut:           os.Stderr,
+	}
+
+	brokers.Broker.Subscribe(api.ShowError, cli.showError)
+	brokers.Broker.Subscribe(api.ProcessStatusUpdated, cli.updateProgress)
+
+	return cli
+}
+
+// Runs the command given in the params and returns the exit code
+func (s *Cli) Run() int {
+	return s.run(s.params.CliArgs())
+}
+
+func (s *Cli) run(args []string) int {
+	if len(args) == 0 {
+		s.printUsage()
+		return exitUsage
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(s.errOut, "Unknown command '%s'\n\n", args[0])
+		s.printUsage()
+		return exitUsage
+	}
+
+	logger.Debug.Printf("Running CLI command '%s'", cmd.name)
+	if err := cmd.run(s, args[1:]); err == errUsage || err == flag.ErrHelp {
+		fmt.Fprintf(s.errOut, "Usage: %s %s\n", cmd.name, cmd.arguments)
+		return exitUsage
+	} else if err != nil {
+		fmt.Fprintf(s.errOut, "Error: %s\n", err)
+		return exitError
+	} else if s.errorCount > 0 {
+		return exitError
+	} else {
+		return exitOk
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (s *Cli) printUsage() {
+	fmt.Fprintln(s.errOut, "Usage: image-sorter [options] cli <command> [command options]")
+	fmt.Fprintln(s.errOut, "")
+	fmt.Fprintln(s.errOut, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(s.errOut, "  %s %s\n", cmd.name, cmd.arguments)
+		fmt.Fprintf(s.errOut, "      %s\n", cmd.description)
+	}
+}
+
+// Commands
+
+func (s *Cli) scan(args []string) error {
+	flags, directory := s.newFlagSet("scan")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "Found %d images\n", len(s.services.ImageLibrary.GetImages()))
+	return nil
+}
+
+func (s *Cli) categorize(args []string) error {
+	flags, directory := s.newFlagSet("categorize")
+	remove := flags.Bool("remove", false, "Remove the category instead of setting it")
+	force := flags.Bool("force", false, "Remove all other categories from the image")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 2 {
+		return errUsage
+	}
+	categoryName := flags.Arg(1)
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	imageFile, err := s.findImage(*directory, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	category, err := s.findCategory(categoryName)
+	if err != nil {
+		return err
+	}
+
+	operation := apitype.CATEGORIZE
+	if *remove {
+		operation = apitype.UNCATEGORIZE
+	}
+
+	s.services.ImageCategoryService.SetCategory(&api.CategorizeCommand{
+		ImageId:         imageFile.Id(),
+		CategoryId:      category.Id(),
+		Operation:       operation,
+		StayOnSameImage: true,
+		ForceToCategory: *force,
+	})
+	return nil
+}
+
+func (s *Cli) rate(args []string) error {
+	flags, directory := s.newFlagSet("rate")
+	ratingValue := flags.String("rating", "", "Number of stars, 0-5")
+	labelName := flags.String("label", "", "Color label: "+colorLabelNames())
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 1 {
+		return errUsage
+	}
+	isSet := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		isSet[f.Name] = true
+	})
+	if !isSet["rating"] && !isSet["label"] {
+		return errUsage
+	}
+	rating, err := apitype.RatingFromString(*ratingValue)
+	if err != nil {
+		return err
+	}
+	colorLabel, err := apitype.ColorLabelFromString(*labelName)
+	if err != nil {
+		return err
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	imageFile, err := s.findImage(*directory, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if isSet["rating"] {
+		s.services.ImageRatingService.SetRating(&api.RateImageCommand{
+			ImageId: imageFile.Id(),
+			Rating:  rating,
+		})
+	}
+	if isSet["label"] {
+		s.services.ImageRatingService.SetColorLabel(&api.ColorLabelCommand{
+			ImageId:    imageFile.Id(),
+			ColorLabel: colorLabel,
+		})
+	}
+	return nil
+}
+
+func (s *Cli) tag(args []string) error {
+	flags, directory := s.newFlagSet("tag")
+	remove := flags.Bool("remove", false, "Remove the tags instead of adding them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 2 {
+		return errUsage
+	}
+	tags := apitype.ParseTags(flags.Arg(1))
+	if len(tags) == 0 {
+		return fmt.Errorf("no valid tags in '%s'", flags.Arg(1))
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	imageFile, err := s.findImage(*directory, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		s.services.TagService.TagImage(&api.TagImageCommand{
+			ImageId: imageFile.Id(),
+			Tag:     tag,
+			Remove:  *remove,
+		})
+	}
+	return nil
+}
+
+func (s *Cli) tagAll(args []string) error {
+	flags, directory := s.newFlagSet("tag-all")
+	remove := flags.Bool("remove", false, "Remove the tags instead of adding them")
+	filterFlags := addFilterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 1 {
+		return errUsage
+	}
+	tags := apitype.ParseTags(flags.Arg(0))
+	if len(tags) == 0 {
+		return fmt.Errorf("no valid tags in '%s'", flags.Arg(0))
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	filter, err := s.toImageFilter(filterFlags)
+	if err != nil {
+		return err
+	}
+	images, err := s.services.ImageLibrary.GetFilteredImages(-1, 0, filter)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		s.services.TagService.TagFilteredImages(&api.TagFilteredImagesCommand{
+			Filter: filter,
+			Tag:    tag,
+			Remove: *remove,
+		})
+	}
+	fmt.Fprintf(s.out, "Updated the tags of %d images\n", len(images))
+	return nil
+}
+
+func (s *Cli) tags(args []string) error {
+	flags, directory := s.newFlagSet("tags")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() > 1 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	var tags []string
+	if flags.NArg() == 0 {
+		tags = s.services.TagService.GetTags()
+	} else if imageFile, err := s.findImage(*directory, flags.Arg(0)); err != nil {
+		return err
+	} else {
+		tags = s.services.TagService.GetImageTags(imageFile.Id())
+	}
+	for _, tag := range tags {
+		fmt.Fprintln(s.out, tag)
+	}
+	return nil
+}
+
+func (s *Cli) saveSearch(args []string) error {
+	flags, directory := s.newFlagSet("save-search")
+	shortcut := flags.String("shortcut", "", "Key sequence that shows the search in the GUI, e.g. Alt+U")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 2 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	s.services.SavedSearchService.SaveSearch(&api.SaveSearchCommand{
+		Name:     flags.Arg(0),
+		Query:    flags.Arg(1),
+		Shortcut: *shortcut,
+	})
+	return nil
+}
+
+func (s *Cli) removeSearch(args []string) error {
+	flags, directory := s.newFlagSet("remove-search")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	if savedSearch, err := s.findSavedSearch(flags.Arg(0)); err != nil {
+		return err
+	} else {
+		s.services.SavedSearchService.RemoveSavedSearch(&api.RemoveSavedSearchCommand{Id: savedSearch.Id()})
+		return nil
+	}
+}
+
+func (s *Cli) searches(args []string) error {
+	flags, directory := s.newFlagSet("searches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	for _, savedSearchCount := range s.services.SavedSearchService.GetSavedSearches() {
+		savedSearch := savedSearchCount.SavedSearch
+		fmt.Fprintf(s.out, "%s\t%d\t%s\t%s\n", savedSearch.Name(), savedSearchCount.ImageCount, savedSearch.ShortcutAsString(), savedSearch.Query())
+	}
+	return nil
+}
+
+func (s *Cli) list(args []string) error {
+	flags, directory := s.newFlagSet("list")
+	filterFlags := addFilterFlags(flags)
+	sortOrder := flags.String("sort", "", "Order of the images, defaults to the order set with the sort command")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	filter, err := s.toImageFilter(filterFlags)
+	if err != nil {
+		return err
+	}
+	filter.Order = s.services.ImageService.GetSortOrder()
+	if *sortOrder != "" {
+		if filter.Order, err = apitype.ParseSortOrder(*sortOrder); err != nil {
+			return err
+		}
+	}
+	if images, err := s.services.ImageLibrary.GetFilteredImages(-1, 0, filter); err != nil {
+		return err
+	} else {
+		for _, image := range images {
+			fmt.Fprintln(s.out, image.Path())
+		}
+		return nil
+	}
+}
+
+func (s *Cli) sort(args []string) error {
+	flags, directory := s.newFlagSet("sort")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() > 1 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(s.out, s.services.ImageService.GetSortOrder())
+	} else if order, err := apitype.ParseSortOrder(flags.Arg(0)); err != nil {
+		return err
+	} else {
+		s.services.ImageService.SetSortOrder(&api.SortOrderCommand{Order: order})
+	}
+	return nil
+}
+
+func (s *Cli) pruneThumbnails(args []string) error {
+	flags, directory := s.newFlagSet("prune-thumbnails")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	absDirectory, err := filepath.Abs(*directory)
+	if err != nil {
+		return err
+	}
+	if removed, byteSize, err := s.services.ThumbnailCache.Prune(absDirectory, s.services.ImageService.GetImageFiles()); err != nil {
+		return err
+	} else {
+		fmt.Fprintf(s.out, "Removed %d thumbnails, %.1f MB left\n", removed, float64(byteSize)/(1024*1024))
+		return nil
+	}
+}
+
+func (s *Cli) apply(args []string) error {
+	flags, directory := s.newFlagSet("apply")
+	keepOriginals := flags.Bool("keep-originals", false, "Keep the original images")
+	fixOrientation := flags.Bool("fix-orientation", false, "Rotate the images based on EXIF orientation")
+	quality := flags.Int("quality", 90, "JPEG quality used if the image needs to be re-encoded")
+	flatten := flags.Bool("flatten", false, "Don't preserve sub directories under the category directories")
+	conflict := flags.String("conflict", string(apitype.ConflictOverwrite), "What to do when the target file exists: "+conflictPolicyNames())
+	copyMethodName := flags.String("copy-method", string(apitype.CopyMethodCopy), "How images that are not modified are copied: copy, hardlink or reflink")
+	outputModeName := flags.String("output", string(apitype.OutputFiles), "Write files or link the originals to the categories: files, symlink or hardlink")
+	renameTemplate := flags.String("rename", "", "Template for the copied file names, e.g. '{exif:DateTimeOriginal:2006-01-02}_{seq:3}'")
+	writeRatings := flags.Bool("write-ratings", false, "Write the ratings and the color labels to the XMP sidecars of the copies")
+	writeTags := flags.Bool("write-tags", false, "Write the tags as keywords to the XMP sidecars and to the JPEG copies")
+	dryRun := flags.Bool("dry-run", false, "Print the planned changes as JSON without touching any files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	} else if *quality < 0 || *quality > 100 {
+		return fmt.Errorf("quality must be between 0 and 100, was %d", *quality)
+	}
+	conflictPolicy, err := apitype.ConflictPolicyFromString(*conflict)
+	if err != nil {
+		return err
+	}
+	copyMethod, err := apitype.CopyMethodFromString(*copyMethodName)
+	if err != nil {
+		return err
+	}
+	outputMode, err := apitype.OutputModeFromString(*outputModeName)
+	if err != nil {
+		return err
+	}
+	if _, err := apitype.ParseFileNameTemplate(*renameTemplate); err != nil {
+		return err
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	options := &api.PersistCategorizationCommand{
+		KeepOriginals:         *keepOriginals,
+		FixOrientation:        *fixOrientation,
+		Quality:               *quality,
+		FlattenSubDirectories: *flatten,
+		ConflictPolicy:        conflictPolicy,
+		CopyMethod:            copyMethod,
+		OutputMode:            outputMode,
+		RenameTemplate:        *renameTemplate,
+		WriteRatings:          *writeRatings,
+		WriteTags:             *writeTags,
+	}
+	if *dryRun {
+		encoder := json.NewEncoder(s.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s.services.ImageCategoryService.PlanImageCategories(options))
+	}
+
+	s.services.ImageCategoryService.PersistImageCategories(options)
+	return nil
+}
+
+func (s *Cli) jobs(args []string) error {
+	flags, directory := s.newFlagSet("jobs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	for _, job := range s.services.ImageCategoryService.GetApplyJobs() {
+		fmt.Fprintf(s.out, "%d\t%s\t%s\t%d applied\t%d failed\n",
+			job.Id, job.CreatedTime.Format("2006-01-02 15:04:05"), job.Status, job.DoneImages, job.FailedImages)
+	}
+	return nil
+}
+
+func (s *Cli) rollback(args []string) error {
+	flags, directory := s.newFlagSet("rollback")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	for _, job := range s.services.ImageCategoryService.GetApplyJobs() {
+		if job.Status == api.ApplyJobCompleted {
+			s.services.ImageCategoryService.RollbackApplyJob()
+			fmt.Fprintf(s.out, "Rolled back apply job %d\n", job.Id)
+			return nil
+		}
+	}
+	return errors.New("no completed apply job to roll back")
+}
+
+func (s *Cli) discard(args []string) error {
+	flags, directory := s.newFlagSet("discard")
+	jobId := flags.Int64("job", 0, "Only discard the apply job with the ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := s.initializeDirectory(*directory); err != nil {
+		return err
+	}
+
+	var discarded []int64
+	for _, job := range s.services.ImageCategoryService.GetApplyJobs() {
+		if job.Status == api.ApplyJobCompleted && (*jobId == 0 || job.Id == *jobId) {
+			discarded = append(discarded, job.Id)
+		}
+	}
+	if len(discarded) == 0 && *jobId != 0 {
+		return fmt.Errorf("no completed apply job %d to discard", *jobId)
+	}
+
+	s.services.ImageCategoryService.DiscardApplyJob(&api.DiscardApplyJobCommand{JobId: *jobId})
+	for _, id := range discarded {
+		fmt.Fprintf(s.out, "Discarded apply job %d\n", id)
+	}
+	return nil
+}
+
+// Private API
+
+// Options that select the images, shared by the commands that handle many images
+type filterFlags struct {
+	categoryName   *string
+	minRatingValue *string
+	labelName      *string
+	tag            *string
+	query          *string
+	savedSearch    *string
+}
+
+func addFilterFlags(flags *flag.FlagSet) *filterFlags {
+	return &filterFlags{
+		categoryName:   flags.String("category", "", "Only images in the category"),
+		minRatingValue: flags.String("min-rating", "", "Only images rated with at least this many stars"),
+		labelName:      flags.String("label", "", "Only images with the color label: "+colorLabelNames()),
+		tag:            flags.String("tag", "", "Only images with the tag"),
+		query:          flags.String("query", "", `Only images that match the search query, e.g. "category:Good AND width>3000"`),
+		savedSearch:    flags.String("search", "", "Only images that match the saved search"),
+	}
+}
+
+// Categories are only known after the directory has been initialized
+func (s *Cli) toImageFilter(flags *filterFlags) (*apitype.ImageFilter, error) {
+	minRating, err := apitype.RatingFromString(*flags.minRatingValue)
+	if err != nil {
+		return nil, err
+	}
+	colorLabel, err := apitype.ColorLabelFromString(*flags.labelName)
+	if err != nil {
+		return nil, err
+	}
+	query, err := apitype.ParseSearchQuery(*flags.query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err)
+	}
+	if *flags.savedSearch != "" {
+		if savedSearch, err := s.findSavedSearch(*flags.savedSearch); err != nil {
+			return nil, err
+		} else if query == nil {
+			query = savedSearch.Query()
+		} else if query, err = apitype.ParseSearchQuery("(" + savedSearch.Query().String() + ") AND (" + query.String() + ")"); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := &apitype.ImageFilter{
+		CategoryId: apitype.NoCategory,
+		MinRating:  minRating,
+		ColorLabel: colorLabel,
+		Tag:        apitype.NormalizeTagName(*flags.tag),
+		Query:      query,
+	}
+	if *flags.categoryName != "" {
+		if category, err := s.findCategory(*flags.categoryName); err != nil {
+			return nil, err
+		} else {
+			filter.CategoryId = category.Id()
+		}
+	}
+	return filter, nil
+}
+
+func (s *Cli) newFlagSet(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(s.errOut)
+	directory := flags.String("dir", ".", "Image directory")
+	return flags, directory
+}
+
+func (s *Cli) initializeDirectory(directory string) error {
+	if absDirectory, err := filepath.Abs(directory); err != nil {
+		return err
+	} else if info, err := os.Stat(absDirectory); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", directory)
+	} else {
+		return backend.InitializeForDirectory(s.params, s.stores, s.services, absDirectory, s.databaseFileName)
+	}
+}
+
+// Finds the image by the path given by the user. The path may be relative to
+// the current working directory or to the image directory.
+func (s *Cli) findImage(directory string, file string) (*apitype.ImageFile, error) {
+	relativePath := filepath.Clean(file)
+	if absDirectory, err := filepath.Abs(directory); err != nil {
+		return nil, err
+	} else if absFile, err := filepath.Abs(file); err != nil {
+		return nil, err
+	} else if rel, err := filepath.Rel(absDirectory, absFile); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// File names may start with dots, e.g. "..foo.jpg"
+		relativePath = rel
+	}
+
+	imageFile, err := s.stores.ImageStore.FindByRelativePath(apitype.NewImageFile("", relativePath))
+	if err != nil {
+		return nil, err
+	} else if imageFile == nil {
+		return nil, fmt.Errorf("image '%s' not found", file)
+	}
+	return imageFile, nil
+}
+
+// Sub categories are found with the full name, e.g. "Travel/Japan/Kyoto"
+func (s *Cli) findCategory(name string) (*apitype.Category, error) {
+	for _, category := range s.services.CategoryService.GetCategories() {
+		if strings.EqualFold(category.FullName(), name) {
+			return category, nil
+		}
+	}
+	return nil, fmt.Errorf("category '%s' not found", name)
+}
+
+func (s *Cli) findSavedSearch(name string) (*apitype.SavedSearch, error) {
+	for _, savedSearchCount := range s.services.SavedSearchService.GetSavedSearches() {
+		if strings.EqualFold(savedSearchCount.SavedSearch.Name(), name) {
+			return savedSearchCount.SavedSearch, nil
+		}
+	}
+	return nil, fmt.Errorf("saved search '%s' not found", name)
+}
+
+func (s *Cli) showError(command *api.ErrorCommand) {
+	s.errorCount++
+	fmt.Fprintf(s.errOut, "Error: %s\n", command.Message)
+}
+
+func (s *Cli) updateProgress(command *api.UpdateProgressCommand) {
+	if command.Name != s.lastProgressName {
+		s.lastProgressName = command.Name
+		fmt.Fprintln(s.errOut, command.Name)
+	}
+	if command.Details != "" {
+		fmt.Fprintln(s.errOut, "  "+command.Details)
+	}
+}
+
+func conflictPolicyNames() string {
+	names := make([]string, len(apitype.ConflictPolicies))
+	for i, policy := range apitype.ConflictPolicies {
+		names[i] = string(policy)
+	}
+	return strings.Join(names, ", ")
+}
+
+func colorLabelNames() string {
+	names := make([]string, len(apitype.ColorLabels))
+	for i, label := range apitype.ColorLabels {
+		names[i] = strings.ToLower(string(label))
+	}
+	return strings.Join(names, ", ")
+}
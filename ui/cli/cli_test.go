@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"vincit.fi/image-sorter/backend"
+	"vincit.fi/image-sorter/common"
+	"vincit.fi/image-sorter/common/constants"
+)
+
+const testDatabaseFileName = "image-sorter.db"
+
+func newTestCli(t *testing.T) (*Cli, *bytes.Buffer, *bytes.Buffer) {
+	params := common.NewEmptyParams()
+	stores := backend.InitializeStoresWithHomeDirectory(t.TempDir(), testDatabaseFileName)
+	brokers := backend.InitializeSyncEventBrokers()
+	services := backend.InitializeServices(params, stores, brokers)
+	t.Cleanup(func() {
+		services.Close()
+		stores.Close()
+	})
+
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	sut := NewCli(params, stores, services, brokers, testDatabaseFileName)
+	sut.out = out
+	sut.errOut = errOut
+	return sut, out, errOut
+}
+
+func createTestImages(t *testing.T, directory string, fileNames ...string) {
+	for _, fileName := range fileNames {
+		file, err := os.Create(filepath.Join(directory, fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil)
+		_ = file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCli_Scan(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	createTestImages(t, directory, "a.jpg", "b.jpg")
+	sut, out, _ := newTestCli(t)
+
+	exitCode := sut.run([]string{"scan", "-dir", directory})
+
+	a.Equal(exitOk, exitCode)
+	a.Equal("Found 2 images\n", out.String())
+	a.FileExists(filepath.Join(directory, constants.ImageSorterDir, testDatabaseFileName))
+}
+
+func TestCli_List(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	createTestImages(t, directory, "b.jpg", "a.jpg")
+	sut, out, _ := newTestCli(t)
+
+	exitCode := sut.run([]string{"list", "-dir", directory})
+
+	a.Equal(exitOk, exitCode)
+	a.Equal(filepath.Join(directory, "a.jpg")+"\n"+filepath.Join(directory, "b.jpg")+"\n", out.String())
+}
+
+func TestCli_FindImage(t *testing.T) {
+	a := assert.New(t)
+
+	directory := t.TempDir()
+	createTestImages(t, directory, "..foo.jpg")
+	sut, _, _ := newTestCli(t)
+	a.Equal(exitOk, sut.run([]string{"scan", "-dir", directory}))
+
+	imageFile, err := sut.findImage(directory, filepath.Join(directory, "..foo.jpg"))
+
+	a.Nil(err)
+	if a.NotNil(imageFile) {
+		a.Equal("..foo.jpg", imageFile.RelativePath())
+	}
+}
+
+func TestCli_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	t.Run("Missing directory", func(t *testing.T) {
+		sut, out, errOut := newTestCli(t)
+
+		exitCode := sut.run([]string{"scan", "-dir", filepath.Join(t.TempDir(), "missing")})
+
+		a.Equal(exitError, exitCode)
+		a.Empty(out.String())
+		a.Contains(errOut.String(), "Error: ")
+	})
+
+	t.Run("Unknown command", func(t *testing.T) {
+		sut, _, errOut := newTestCli(t)
+
+		exitCode := sut.run([]string{"unknown"})
+
+		a.Equal(exitUsage, exitCode)
+		a.Contains(errOut.String(), "Unknown command 'unknown'")
+	})
+
+	t.Run("Invalid arguments", func(t *testing.T) {
+		sut, _, errOut := newTestCli(t)
+
+		exitCode := sut.run([]string{"scan", "extra"})
+
+		a.Equal(exitUsage, exitCode)
+		a.Contains(errOut.String(), "Usage: scan")
+	})
+
+	t.Run("Unknown apply job", func(t *testing.T) {
+		sut, out, errOut := newTestCli(t)
+
+		exitCode := sut.run([]string{"discard", "-dir", t.TempDir(), "-job", "3"})
+
+		a.Equal(exitError, exitCode)
+		a.Empty(out.String())
+		a.Contains(errOut.String(), "no completed apply job 3 to discard")
+	})
+}
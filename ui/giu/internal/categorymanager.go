@@ -2,48 +2,175 @@ package internal
 
 import (
 	"github.com/AllenDang/giu"
+	"time"
 	"vincit.fi/image-sorter/api/apitype"
+	"vincit.fi/image-sorter/common"
 	"vincit.fi/image-sorter/ui/giu/internal/guiapi"
 )
 
+// Unfinished key sequence is forgotten if the next key is not pressed in time
+const keySequenceTimeout = 1500 * time.Millisecond
+
 type CategoryDef struct {
 	Name       string
-	Key        giu.Key
+	Shortcut   common.KeySequence
 	CategoryId apitype.CategoryId
+	// Set if the shortcut shows a saved search instead of categorizing
+	SavedSearch *apitype.SavedSearch
 }
 
 type CategoryKeyManager struct {
-	Categories     map[string]*CategoryDef
-	CategoryKeyMap map[giu.Key]*CategoryDef
-	CategoryIdMap  map[apitype.CategoryId]*CategoryDef
-	Callback       func(def *CategoryDef, action *guiapi.CategoryAction)
+	Categories    map[string]*CategoryDef
+	CategoryIdMap map[apitype.CategoryId]*CategoryDef
+	Callback      func(def *CategoryDef, action *guiapi.CategoryAction)
+	// Shortcuts of the saved searches
+	savedSearchDefs []*CategoryDef
+
+	// Keys used in the shortcuts
+	keys []giu.Key
+	// Strokes of an unfinished sequence, e.g. "G" when waiting for "3" of "G 3"
+	pressed     common.KeySequence
+	pressedTime time.Time
 }
 
 func (s *CategoryKeyManager) Reset(categories []*apitype.Category) {
 	s.Categories = map[string]*CategoryDef{}
-	s.CategoryKeyMap = map[giu.Key]*CategoryDef{}
 	s.CategoryIdMap = map[apitype.CategoryId]*CategoryDef{}
+	s.pressed = nil
 
 	for _, category := range categories {
-		key := giu.Key(category.Shortcut())
 		def := CategoryDef{
 			CategoryId: category.Id(),
-			Name:       category.Name(),
-			Key:        key,
+			Name:       category.FullName(),
+			Shortcut:   category.Shortcut(),
 		}
-		s.CategoryKeyMap[key] = &def
+		s.Categories[def.Name] = &def
 		s.CategoryIdMap[category.Id()] = &def
 	}
+	s.updateKeys()
+}
+
+func (s *CategoryKeyManager) SetSavedSearches(savedSearches []*apitype.SavedSearch) {
+	s.savedSearchDefs = nil
+	s.pressed = nil
+
+	for _, savedSearch := range savedSearches {
+		if len(savedSearch.Shortcut()) > 0 {
+			s.savedSearchDefs = append(s.savedSearchDefs, &CategoryDef{
+				CategoryId:  apitype.NoCategory,
+				Name:        savedSearch.Name(),
+				Shortcut:    savedSearch.Shortcut(),
+				SavedSearch: savedSearch,
+			})
+		}
+	}
+	s.updateKeys()
 }
 
 func (s *CategoryKeyManager) HandleCategory(id apitype.CategoryId, action *guiapi.CategoryAction) {
 	s.Callback(s.CategoryIdMap[id], action)
 }
 
-func (s *CategoryKeyManager) HandleKeys(action *guiapi.CategoryAction) {
-	for key, def := range s.CategoryKeyMap {
+// Strokes pressed so far of an unfinished sequence, e.g. "G"
+func (s *CategoryKeyManager) PendingSequence() string {
+	return s.pressed.String()
+}
+
+// Whether the first stroke of some shortcut uses the key
+func (s *CategoryKeyManager) HasShortcutStartingWith(key giu.Key) bool {
+	for _, def := range s.allDefs() {
+		if len(def.Shortcut) > 0 && giu.Key(def.Shortcut[0].Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches the pressed keys to the shortcuts. Modifiers that are held down but not
+// part of the shortcut select the action, e.g. Shift + G stays on the same image.
+func (s *CategoryKeyManager) HandleKeys(heldModifiers common.KeyModifier) {
+	if len(s.pressed) > 0 && time.Since(s.pressedTime) > keySequenceTimeout {
+		s.pressed = nil
+	}
+	if len(s.pressed) > 0 && giu.IsKeyPressed(giu.KeyEscape) {
+		s.pressed = nil
+		return
+	}
+
+	for _, key := range s.keys {
 		if giu.IsKeyPressed(key) {
-			s.Callback(def, action)
+			s.handleStroke(common.KeyStroke{Key: uint(key), Modifiers: heldModifiers})
 		}
 	}
 }
+
+// Private API
+
+func (s *CategoryKeyManager) allDefs() []*CategoryDef {
+	defs := make([]*CategoryDef, 0, len(s.CategoryIdMap)+len(s.savedSearchDefs))
+	for _, def := range s.CategoryIdMap {
+		defs = append(defs, def)
+	}
+	return append(defs, s.savedSearchDefs...)
+}
+
+func (s *CategoryKeyManager) updateKeys() {
+	s.keys = nil
+	usedKeys := map[giu.Key]bool{}
+	for _, def := range s.allDefs() {
+		for _, stroke := range def.Shortcut {
+			if key := giu.Key(stroke.Key); !usedKeys[key] {
+				usedKeys[key] = true
+				s.keys = append(s.keys, key)
+			}
+		}
+	}
+}
+
+func (s *CategoryKeyManager) handleStroke(stroke common.KeyStroke) {
+	pressed := append(append(common.KeySequence{}, s.pressed...), stroke)
+	completed, waiting := s.findMatches(pressed)
+	if completed == nil && !waiting && len(s.pressed) > 0 {
+		// The stroke may start a new sequence
+		pressed = common.KeySequence{stroke}
+		completed, waiting = s.findMatches(pressed)
+	}
+
+	if completed != nil {
+		s.pressed = nil
+		lastStroke := completed.Shortcut[len(completed.Shortcut)-1]
+		extraModifiers := stroke.Modifiers &^ lastStroke.Modifiers
+		s.Callback(completed, &guiapi.CategoryAction{
+			StayOnImage:      extraModifiers.Has(common.ModifierShift),
+			ForceCategory:    extraModifiers.Has(common.ModifierControl),
+			ShowOnlyCategory: extraModifiers.Has(common.ModifierAlt),
+		})
+	} else if waiting {
+		s.pressed = pressed
+		s.pressedTime = time.Now()
+	} else {
+		s.pressed = nil
+	}
+}
+
+// Returns the category whose shortcut the pressed strokes complete and whether a longer
+// shortcut starts with them. If several shortcuts match because of the extra modifiers,
+// the one that requires most of the held modifiers is used, e.g. "Ctrl+G" instead of "G".
+func (s *CategoryKeyManager) findMatches(pressed common.KeySequence) (*CategoryDef, bool) {
+	var completed *CategoryDef
+	waiting := false
+	for _, def := range s.allDefs() {
+		if !def.Shortcut.StartsWithPressed(pressed) {
+			continue
+		} else if len(def.Shortcut) > len(pressed) {
+			waiting = true
+		} else if completed == nil || modifierCount(def) > modifierCount(completed) {
+			completed = def
+		}
+	}
+	return completed, waiting
+}
+
+func modifierCount(def *CategoryDef) int {
+	return def.Shortcut[len(def.Shortcut)-1].Modifiers.Count()
+}
@@ -0,0 +1,35 @@
+package guiapi
+
+import (
+	"github.com/AllenDang/giu"
+	"vincit.fi/image-sorter/common"
+)
+
+// Modifiers that are currently held down
+func HeldModifiers() common.KeyModifier {
+	modifiers := common.NoModifiers
+	if giu.IsKeyDown(giu.KeyLeftShift) || giu.IsKeyDown(giu.KeyRightShift) {
+		modifiers |= common.ModifierShift
+	}
+	if giu.IsKeyDown(giu.KeyLeftControl) || giu.IsKeyDown(giu.KeyRightControl) {
+		modifiers |= common.ModifierControl
+	}
+	if giu.IsKeyDown(giu.KeyLeftAlt) || giu.IsKeyDown(giu.KeyRightAlt) {
+		modifiers |= common.ModifierAlt
+	}
+	if giu.IsKeyDown(giu.KeyLeftSuper) || giu.IsKeyDown(giu.KeyRightSuper) {
+		modifiers |= common.ModifierSuper
+	}
+	return modifiers
+}
+
+// Modifier keys are not shortcuts by themselves
+func IsModifierKey(key giu.Key) bool {
+	switch key {
+	case giu.KeyLeftShift, giu.KeyRightShift, giu.KeyLeftControl, giu.KeyRightControl,
+		giu.KeyLeftAlt, giu.KeyRightAlt, giu.KeyLeftSuper, giu.KeyRightSuper:
+		return true
+	default:
+		return false
+	}
+}
@@ -17,6 +17,10 @@ type CategoryButtonWidget struct {
 	highlight  bool
 	onClick    func(*guiapi.CategoryAction)
 	onShowOnly func()
+	// Sub categories are shown below the button when it is expanded
+	subCategories []*CategoryButtonWidget
+	expanded      bool
+	onToggle      func()
 	giu.Widget
 }
 
@@ -31,6 +35,25 @@ func CategoryButton(categoryId apitype.CategoryId, name string, shortcut string,
 	}
 }
 
+// Sub categories are shown with a button to expand and collapse them
+func (s *CategoryButtonWidget) SubCategories(subCategories []*CategoryButtonWidget, expanded bool, onToggle func()) *CategoryButtonWidget {
+	s.subCategories = subCategories
+	s.expanded = expanded
+	s.onToggle = onToggle
+	return s
+}
+
+func (s *CategoryButtonWidget) hasSubCategories() bool {
+	return len(s.subCategories) > 0
+}
+
+func (s *CategoryButtonWidget) width() float32 {
+	if s.hasSubCategories() {
+		return categoryPrimaryButtonWidth + 2*categoryArrowButtonWidth
+	}
+	return categoryPrimaryButtonWidth + categoryArrowButtonWidth
+}
+
 const categoryPrimaryButtonWidth = 100
 const categoryArrowButtonWidth = 20
 const categoryPrimaryButtonHeight = 20
@@ -103,6 +126,16 @@ func (s *CategoryButtonWidget) Build() {
 	menuButton := giu.ArrowButton(giu.DirectionDown).ID("Menu").OnClick(func() {
 		giu.OpenPopup(menuName)
 	})
+	buttons := []giu.Widget{primaryButton, menuButton}
+	if s.hasSubCategories() {
+		toggleLabel := "+"
+		if s.expanded {
+			toggleLabel = "-"
+		}
+		buttons = append(buttons, giu.Button(toggleLabel+"##Toggle").
+			Size(categoryArrowButtonWidth, categoryPrimaryButtonHeight).
+			OnClick(s.onToggle))
+	}
 
 	statusColor := statusActiveColor
 	if !s.active {
@@ -117,7 +150,7 @@ func (s *CategoryButtonWidget) Build() {
 	statusIndicator := giu.Custom(func() {
 		canvas := giu.GetCanvas()
 		start := giu.GetCursorScreenPos()
-		end := start.Add(image.Pt(categoryPrimaryButtonWidth+categoryArrowButtonWidth, categoryIndicatorButtonHeight))
+		end := start.Add(image.Pt(int(s.width()), categoryIndicatorButtonHeight))
 
 		canvas.AddRectFilled(start, end, statusColor, 0, 0)
 	})
@@ -127,16 +160,13 @@ func (s *CategoryButtonWidget) Build() {
 		SetStyle(giu.StyleVarItemInnerSpacing, 0, 0).
 		To(giu.Child().
 			Size(
-				categoryPrimaryButtonWidth+categoryArrowButtonWidth,
+				s.width(),
 				categoryPrimaryButtonHeight+categoryIndicatorButtonHeight,
 			).
 			Flags(giu.WindowFlagsNoBackground).
 			Border(false).
 			Layout(
-				giu.Row(
-					primaryButton,
-					menuButton,
-				),
+				giu.Row(buttons...),
 				menu,
 				statusIndicator,
 			),
@@ -2,41 +2,50 @@ package widget
 
 import "github.com/AllenDang/giu"
 
+// CategoryButtonViewWidget shows the top level categories on the first row.
+// Sub categories of the expanded categories are shown on the following rows.
 type CategoryButtonViewWidget struct {
-	buttons []giu.Widget
+	buttons []*CategoryButtonWidget
 }
 
 func CategoryButtonView(buttons []*CategoryButtonWidget) *CategoryButtonViewWidget {
-	var widgets []giu.Widget
-
-	for _, button := range buttons {
-		widgets = append(widgets, button)
-	}
-
 	return &CategoryButtonViewWidget{
-		buttons: widgets,
+		buttons: buttons,
 	}
 }
 
-const approximateButtonWidth = categoryPrimaryButtonWidth + categoryArrowButtonWidth + 8
+const buttonSpacing = 8
 
 func (s *CategoryButtonViewWidget) Build() {
 	giu.Custom(func() {
 		regionWidth, _ := giu.GetAvailableRegion()
 
-		approximateButtonsWidth := float32(len(s.buttons) * approximateButtonWidth)
-
-		offsetWidth := (regionWidth - approximateButtonsWidth) / 2.0
-		if offsetWidth < 0 {
-			offsetWidth = 0
+		var rows []giu.Widget
+		for level := s.buttons; len(level) > 0; {
+			var widgets []giu.Widget
+			var nextLevel []*CategoryButtonWidget
+			approximateButtonsWidth := float32(0)
+			for _, button := range level {
+				widgets = append(widgets, button)
+				approximateButtonsWidth += button.width() + buttonSpacing
+				if button.expanded {
+					nextLevel = append(nextLevel, button.subCategories...)
+				}
+			}
+
+			offsetWidth := (regionWidth - approximateButtonsWidth) / 2.0
+			if offsetWidth < 0 {
+				offsetWidth = 0
+			}
+			rows = append(rows, giu.Row(
+				giu.Dummy(offsetWidth, 0),
+				giu.Row(widgets...),
+			))
+			level = nextLevel
 		}
 
 		giu.Column(
-			giu.Row(
-				giu.Dummy(offsetWidth, 0),
-				giu.Row(s.buttons...),
-			),
-			giu.Dummy(0, 5),
+			append(rows, giu.Dummy(0, 5))...,
 		).Build()
 	}).Build()
 }
@@ -1,40 +1,96 @@
 package widget
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/AllenDang/giu"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"vincit.fi/image-sorter/api/apitype"
 	"vincit.fi/image-sorter/common"
+	"vincit.fi/image-sorter/ui/giu/internal/guiapi"
 )
 
+var invalidPathColor = color.RGBA{R: 255, G: 100, B: 100, A: 255}
+
 type editableCategory struct {
-	id       apitype.CategoryId
-	name     string
-	subPath  string
-	shortcut string
+	id             apitype.CategoryId
+	name           string
+	subPath        string
+	shortcut       string
+	renameTemplate string
 }
 
+// Nested categories are edited with the full name, e.g. "Travel/Japan/Kyoto"
 func fromCategory(category *apitype.Category) *editableCategory {
 	return &editableCategory{
-		id:       category.Id(),
-		name:     category.Name(),
-		subPath:  category.SubPath(),
-		shortcut: category.ShortcutAsString(),
+		id:             category.Id(),
+		name:           category.FullName(),
+		subPath:        category.SubPath(),
+		shortcut:       category.ShortcutAsString(),
+		renameTemplate: category.RenameTemplate(),
 	}
 }
 
 func (s *editableCategory) toCategory() *apitype.Category {
 	path := s.subPath
 	if path == "" {
-		path = s.name
+		path = s.defaultPath()
 	}
-	return apitype.NewCategoryWithId(
+	category := apitype.NewCategoryWithId(
 		s.id, s.name, path, s.shortcut,
 	)
+	category.SetRenameTemplate(s.renameTemplate)
+	return category
+}
+
+// Sub categories are under the parent's directory, so the default is the last part of the name
+func (s *editableCategory) defaultPath() string {
+	if names := apitype.SplitCategoryName(s.name); len(names) > 0 {
+		return names[len(names)-1]
+	}
+	return s.name
+}
+
+// Shows where the image would be written in the category or why the path is invalid.
+// The sequence number is always the first one in the preview.
+func (s *editableCategory) preview(parent *apitype.Category, imageFile *apitype.ImageFile, metaData *apitype.ImageMetaData, hash func() string) (string, error) {
+	category := apitype.NewCategoryWithParent(s.id, parent, s.defaultPath(), s.toCategory().SubPath(), s.shortcut)
+	if template, err := apitype.ParsePathTemplate(category.Path()); err != nil {
+		return "", err
+	} else if renameTemplate, err := apitype.ParseFileNameTemplate(s.renameTemplate); err != nil {
+		return "", err
+	} else if imageFile == nil || !imageFile.IsValid() {
+		return "", nil
+	} else {
+		values := &apitype.PathTemplateValues{
+			Category:  category,
+			ImageFile: imageFile,
+			MetaData:  metaData,
+			Sequence:  1,
+		}
+		if template.UsesHash() || (renameTemplate != nil && renameTemplate.UsesHash()) {
+			values.Hash = hash()
+		}
+		if dir, file, err := apitype.ResolveCategoryTarget(values, renameTemplate, imageFile.SubDirectory()); err != nil {
+			return "", err
+		} else {
+			return filepath.Join(dir, file), nil
+		}
+	}
 }
 
 type CategoryEditWidget struct {
 	categories             []*editableCategory
+	previewImage           *apitype.ImageFile
+	previewMetaData        *apitype.ImageMetaData
+	previewHash            string
 	selectedCategoryToEdit int
+	recordedShortcut       common.KeySequence
 	onSave                 func(asDefault bool, categories []*apitype.Category)
 	onClose                func()
 	rowAdded               int
@@ -57,24 +113,105 @@ func (s *CategoryEditWidget) SetCategories(categories []*apitype.Category) {
 	}
 }
 
+// Paths are previewed with the given image
+func (s *CategoryEditWidget) SetPreviewImage(imageFile *apitype.ImageFile, metaData *apitype.ImageMetaData) {
+	s.previewImage = imageFile
+	s.previewMetaData = metaData
+	s.previewHash = ""
+}
+
+// Parents are looked up from the edited categories by the name so that the
+// preview shows the path derived from the hierarchy before the categories are saved
+func (s *CategoryEditWidget) getParent(category *editableCategory) *apitype.Category {
+	names := apitype.SplitCategoryName(category.name)
+	var parent *apitype.Category
+	for i := 1; i < len(names); i++ {
+		parentName := strings.Join(names[:i], apitype.CategoryNameSeparator)
+		subPath := names[i-1]
+		for _, other := range s.categories {
+			if strings.Join(apitype.SplitCategoryName(other.name), apitype.CategoryNameSeparator) == parentName {
+				subPath = other.toCategory().SubPath()
+			}
+		}
+		parent = apitype.NewCategoryWithParent(apitype.NoCategory, parent, names[i-1], subPath, "")
+	}
+	return parent
+}
+
+// Hash is only calculated when a path uses it
+func (s *CategoryEditWidget) getPreviewHash() string {
+	if s.previewHash == "" {
+		if file, err := os.Open(s.previewImage.Path()); err == nil {
+			defer file.Close()
+			hash := sha256.New()
+			if _, err := io.Copy(hash, file); err == nil {
+				s.previewHash = hex.EncodeToString(hash.Sum(nil))
+			}
+		}
+	}
+	return s.previewHash
+}
+
+// Records the shortcut of the selected category. Keys are added to the sequence until
+// Enter is pressed. Escape cancels and Backspace removes the latest key.
 func (s *CategoryEditWidget) HandleKeys() {
-	if s.selectedCategoryToEdit >= 0 {
+	if s.selectedCategoryToEdit < 0 {
+		return
+	}
+
+	if giu.IsKeyPressed(giu.KeyEnter) || giu.IsKeyPressed(giu.KeyKPEnter) {
+		s.categories[s.selectedCategoryToEdit].shortcut = s.recordedShortcut.String()
+		s.stopRecording()
+	} else if giu.IsKeyPressed(giu.KeyEscape) {
+		s.stopRecording()
+	} else if giu.IsKeyPressed(giu.KeyBackspace) {
+		if len(s.recordedShortcut) > 0 {
+			s.recordedShortcut = s.recordedShortcut[:len(s.recordedShortcut)-1]
+		}
+	} else {
 		for i := giu.KeyUnknown; i < giu.KeyLast; i++ {
-			if giu.IsKeyPressed(i) {
-				s.categories[s.selectedCategoryToEdit].shortcut = common.KeyvalName(uint(i))
-				s.selectedCategoryToEdit = -1
+			if giu.IsKeyPressed(i) && !guiapi.IsModifierKey(i) && common.KeyvalName(uint(i)) != "" {
+				s.recordedShortcut = append(s.recordedShortcut, common.KeyStroke{Key: uint(i), Modifiers: guiapi.HeldModifiers()})
 			}
 		}
 	}
 }
 
+func (s *CategoryEditWidget) stopRecording() {
+	s.selectedCategoryToEdit = -1
+	s.recordedShortcut = nil
+}
+
+// Returns the name of the other category if the shortcuts can't be told apart,
+// e.g. "G" and "G 3"
+func (s *CategoryEditWidget) findShortcutConflict(category *editableCategory) string {
+	shortcut := category.toCategory().Shortcut()
+	for _, other := range s.categories {
+		if other != category && shortcut.ConflictsWith(other.toCategory().Shortcut()) {
+			return other.name
+		}
+	}
+	return ""
+}
+
 func (s *CategoryEditWidget) Build() {
 	giu.Custom(func() {
 		width, height := giu.GetAvailableRegion()
 		var categoryRows []*giu.TableRowWidget
+		valid := true
 		for i, category := range s.categories {
 			ci := i
 			cat := category
+			if s.findShortcutConflict(cat) != "" {
+				valid = false
+			}
+			var preview giu.Widget
+			if path, err := cat.preview(s.getParent(cat), s.previewImage, s.previewMetaData, s.getPreviewHash); err != nil {
+				valid = false
+				preview = giu.Style().SetColor(giu.StyleColorText, invalidPathColor).To(giu.Label(err.Error()))
+			} else {
+				preview = giu.Label(path)
+			}
 			tableRow := giu.TableRow(
 				giu.Custom(func() {
 					giu.InputText(&cat.name).Build()
@@ -83,16 +220,23 @@ func (s *CategoryEditWidget) Build() {
 						s.rowAdded = -1
 					}
 				}),
-				giu.InputText(&cat.subPath).Hint(cat.name),
+				giu.InputText(&cat.subPath).Hint(cat.defaultPath()),
+				giu.InputText(&cat.renameTemplate).Hint("Same as when applying"),
+				preview,
 				giu.Custom(func() {
 					n := cat.shortcut
 					if s.selectedCategoryToEdit == ci {
-						n = "Press any key..."
+						n = s.recordedShortcut.String() + " Press keys, Enter to finish..."
 					}
-					giu.Selectable(n).OnClick(func() {
-						// Change to "wait for key" mode
+					giu.Selectable(fmt.Sprintf("%s##Shortcut%d", n, ci)).OnClick(func() {
+						// Change to "wait for keys" mode
 						s.selectedCategoryToEdit = ci
+						s.recordedShortcut = nil
 					}).Build()
+					if conflict := s.findShortcutConflict(cat); conflict != "" {
+						giu.Style().SetColor(giu.StyleColorText, invalidPathColor).
+							To(giu.Label("Same as " + conflict)).Build()
+					}
 				}),
 				giu.Row(
 					giu.Button("Remove").OnClick(func() {
@@ -105,15 +249,18 @@ func (s *CategoryEditWidget) Build() {
 		categoryRows = append(categoryRows, giu.TableRow(
 			giu.Button("Add new").OnClick(func() {
 				s.categories = append(s.categories, &editableCategory{
-					name:     "",
-					subPath:  "",
-					shortcut: "",
+					name:           "",
+					subPath:        "",
+					shortcut:       "",
+					renameTemplate: "",
 				})
 				s.rowAdded = len(s.categories) - 1
 			}),
 			giu.Row(),
 			giu.Row(),
 			giu.Row(),
+			giu.Row(),
+			giu.Row(),
 		))
 
 		giu.Column(
@@ -122,6 +269,8 @@ func (s *CategoryEditWidget) Build() {
 					Columns(
 						giu.TableColumn("Category"),
 						giu.TableColumn("Path"),
+						giu.TableColumn("File names"),
+						giu.TableColumn("Preview"),
 						giu.TableColumn("Shortcut"),
 						giu.TableColumn("Actions"),
 					).
@@ -129,14 +278,14 @@ func (s *CategoryEditWidget) Build() {
 					Size(width, height-20),
 			),
 			giu.Row(
-				giu.Button("Save").OnClick(func() {
+				giu.Button("Save").Disabled(!valid).OnClick(func() {
 					var cats []*apitype.Category
 					for _, category := range s.categories {
 						cats = append(cats, category.toCategory())
 					}
 					s.onSave(false, cats)
 				}),
-				giu.Button("Save as Defaults").OnClick(func() {
+				giu.Button("Save as Defaults").Disabled(!valid).OnClick(func() {
 					var cats []*apitype.Category
 					for _, category := range s.categories {
 						cats = append(cats, category.toCategory())
@@ -6,6 +6,7 @@ import (
 	"github.com/OpenDiablo2/dialog"
 	"image/color"
 	"sort"
+	"strings"
 	"time"
 	"vincit.fi/image-sorter/api"
 	"vincit.fi/image-sorter/api/apitype"
@@ -32,11 +33,22 @@ type Ui struct {
 	similarImages          []*guiapi.TexturedImage
 	categoryKeyManager     *internal.CategoryKeyManager
 	currentImageCategories map[apitype.CategoryId]bool
-	currentCategoryId      apitype.CategoryId
+	currentImageRating     *apitype.ImageRating
+	currentImageTags       []string
+	allTags                []string
+	tagInput               string
+	tagInputActive         bool
+	searchInput            string
+	searchInputActive      bool
+	searchError            string
+	savedSearches          []*api.SavedSearchCount
+	currentFilter          *apitype.ImageFilter
+	expandedCategories     map[apitype.CategoryId]bool
 	progressModal          progressModal
 	progressBackground     progressModal
 	deviceModal            deviceModal
 	applyChangesModal      applyChangesModal
+	saveSearchModal        saveSearchModal
 	showCategoryEditModal  bool
 	categoryEditWidget     *widget.CategoryEditWidget
 	showMetaData           bool
@@ -58,6 +70,7 @@ type Ui struct {
 type progressModal struct {
 	open      bool
 	label     string
+	details   string
 	position  int
 	max       int
 	canCancel bool
@@ -72,12 +85,68 @@ type deviceModal struct {
 	showBackground bool
 }
 
+type saveSearchModal struct {
+	open     bool
+	name     string
+	shortcut string
+	query    string
+}
+
 type applyChangesModal struct {
 	open           bool
 	label          string
 	keepOriginals  bool
 	fixOrientation bool
 	quality        int32
+	flatten        bool
+	conflictPolicy int32
+	copyMethod     int32
+	outputMode     int32
+	renameTemplate string
+	writeRatings   bool
+	writeTags      bool
+	// Plan for the current options, nil while the plan is being resolved
+	plan *api.ApplyPlan
+}
+
+func (s *applyChangesModal) options() *api.PersistCategorizationCommand {
+	return &api.PersistCategorizationCommand{
+		KeepOriginals:         s.keepOriginals,
+		FixOrientation:        s.fixOrientation,
+		Quality:               int(s.quality),
+		FlattenSubDirectories: s.flatten,
+		ConflictPolicy:        apitype.ConflictPolicies[s.conflictPolicy],
+		CopyMethod:            apitype.CopyMethods[s.copyMethod],
+		OutputMode:            apitype.OutputModes[s.outputMode],
+		RenameTemplate:        s.renameTemplate,
+		WriteRatings:          s.writeRatings,
+		WriteTags:             s.writeTags,
+	}
+}
+
+// Returns the error if the rename template can't be parsed
+func (s *applyChangesModal) renameTemplateError() error {
+	_, err := apitype.ParseFileNameTemplate(s.renameTemplate)
+	return err
+}
+
+// Files are not touched until the plan has been shown and the user applies it
+func (s *applyChangesModal) requestPlan(sender api.Sender) {
+	s.plan = nil
+	if s.renameTemplateError() != nil {
+		return
+	}
+	sender.SendCommandToTopic(api.CategoryPlanRequest, s.options())
+}
+
+// Keys 0-5 set the number of stars
+var ratingKeys = []giu.Key{giu.Key0, giu.Key1, giu.Key2, giu.Key3, giu.Key4, giu.Key5}
+
+var colorLabelKeys = map[giu.Key]apitype.ColorLabel{
+	giu.Key6: apitype.ColorRed,
+	giu.Key7: apitype.ColorYellow,
+	giu.Key8: apitype.ColorGreen,
+	giu.Key9: apitype.ColorBlue,
 }
 
 const (
@@ -117,7 +186,11 @@ func NewUi(params *common.Params, broker api.Sender, imageCache api.ImageStore)
 			keepOriginals:  true,
 			fixOrientation: false,
 			quality:        90,
+			flatten:        false,
 		},
+		currentImageRating: &apitype.ImageRating{},
+		currentFilter:      apitype.NoImageFilter(),
+		expandedCategories: map[apitype.CategoryId]bool{},
 		similarImagesShown: false,
 		widthInNumOfImage:  0,
 		zoomStatus:         internal.NewZoomStatus(),
@@ -147,6 +220,11 @@ func NewUi(params *common.Params, broker api.Sender, imageCache api.ImageStore)
 
 	gui.categoryKeyManager = &internal.CategoryKeyManager{
 		Callback: func(def *internal.CategoryDef, action *guiapi.CategoryAction) {
+			if def.SavedSearch != nil {
+				gui.toggleSavedSearch(def.SavedSearch)
+				return
+			}
+
 			operation := apitype.CATEGORIZE
 			if !action.ForceCategory {
 				if _, ok := gui.currentImageCategories[def.CategoryId]; ok {
@@ -155,7 +233,7 @@ func NewUi(params *common.Params, broker api.Sender, imageCache api.ImageStore)
 			}
 
 			if action.ShowOnlyCategory {
-				if gui.currentCategoryId == apitype.NoCategory {
+				if gui.currentFilter.CategoryId == apitype.NoCategory {
 					broker.SendCommandToTopic(api.CategoriesShowOnly, &api.SelectCategoryCommand{
 						CategoryId: def.CategoryId,
 					})
@@ -233,6 +311,8 @@ func (s *Ui) Run() {
 		renderStart := time.Now()
 
 		var categories []*widget.CategoryButtonWidget
+		categoryButtons := map[apitype.CategoryId]*widget.CategoryButtonWidget{}
+		subCategories := map[apitype.CategoryId][]*widget.CategoryButtonWidget{}
 		for _, cat := range s.categories {
 			categoryId := cat.Id()
 			text := cat.Name()
@@ -240,12 +320,25 @@ func (s *Ui) Run() {
 			// FIXME: Potential concurrent write to map
 			_, active := s.currentImageCategories[cat.Id()]
 
-			highlight := s.currentCategoryId == categoryId
+			highlight := s.currentFilter.CategoryId == categoryId
 			onClick := func(action *guiapi.CategoryAction) {
 				s.categoryKeyManager.HandleCategory(categoryId, action)
 			}
 			categorizeButton := widget.CategoryButton(categoryId, text, cat.ShortcutAsString(), active, highlight, onClick)
-			categories = append(categories, categorizeButton)
+			categoryButtons[categoryId] = categorizeButton
+			if cat.Parent() == nil {
+				categories = append(categories, categorizeButton)
+			} else {
+				subCategories[cat.ParentId()] = append(subCategories[cat.ParentId()], categorizeButton)
+			}
+		}
+		for categoryId, buttons := range subCategories {
+			id := categoryId
+			if button, ok := categoryButtons[id]; ok {
+				button.SubCategories(buttons, s.expandedCategories[id], func() {
+					s.expandedCategories[id] = !s.expandedCategories[id]
+				})
+			}
 		}
 
 		if s.showCategoryEditModal {
@@ -273,12 +366,21 @@ func (s *Ui) Run() {
 				progressPercent = int(float32(s.currentImagePos) / float32(s.totalImageCount) * 100.0)
 			}
 			progress := fmt.Sprintf("%d/%d (%d %%): ", s.currentImagePos, s.totalImageCount, progressPercent)
+			if pending := s.categoryKeyManager.PendingSequence(); pending != "" {
+				progress = pending + "... " + progress
+			}
+			if ratingFilter := describeRatingFilter(s.currentFilter); ratingFilter != "" {
+				progress = ratingFilter + " " + progress
+			}
 			if highlightedImage != nil {
-				imageName = highlightedImage.FileName()
+				imageName = highlightedImage.RelativePath()
 				imageInfo = fmt.Sprintf("(%d x %d)",
 					highlightedImage.Width(),
 					highlightedImage.Height(),
 				)
+				if associatedFiles := highlightedImage.AssociatedFiles(); len(associatedFiles) > 0 {
+					imageInfo += fmt.Sprintf(" + %d files", len(associatedFiles))
+				}
 			}
 			var categoriesView giu.Widget
 			if len(categories) > 0 {
@@ -300,13 +402,18 @@ func (s *Ui) Run() {
 					giu.Button(showMetaDataButtonLable).OnClick(s.toggleShowMetaData),
 					giu.Label(progress),
 					giu.Label(imageName),
+					giu.Label(describeRating(s.currentImageRating)),
 					giu.Condition(imageInfo != "", giu.Layout{giu.Label(imageInfo)}, giu.Layout{giu.Label("")}),
 				),
+				s.searchWidget(),
+				s.tagsWidget(),
 				categoriesView,
+				giu.Condition(len(s.savedSearches) > 0, giu.Layout{s.savedSearchesWidget()}, nil),
 				// Modals
 				getProgressModal("ProgressModal", s.sender, &s.progressModal),
 				getDeviceModal("DeviceModal", s.sender, &s.deviceModal),
 				getApplyChangesModal("ApplyCategoriesModal", s.sender, &s.applyChangesModal),
+				getSaveSearchModal("SaveSearchModal", s.sender, &s.saveSearchModal),
 				giu.Custom(func() {
 					// Process modal states
 					if s.progressModal.open {
@@ -318,6 +425,9 @@ func (s *Ui) Run() {
 					if s.applyChangesModal.open {
 						giu.OpenPopup("ApplyCategoriesModal")
 					}
+					if s.saveSearchModal.open {
+						giu.OpenPopup("SaveSearchModal")
+					}
 				}),
 				s.mainImageWidget(
 					s.showMetaData,
@@ -333,7 +443,8 @@ func (s *Ui) Run() {
 					nil),
 				giu.Condition(s.progressBackground.open, giu.Layout{
 					giu.Row(
-						giu.Label("Caching images..."),
+						giu.Label(s.progressBackground.label),
+						giu.Label(s.progressBackground.details),
 						giu.ProgressBar(float32(s.progressBackground.position)/float32(s.progressBackground.max)).
 							Overlay(fmt.Sprintf("%d/%d", s.progressBackground.position, s.progressBackground.max)).
 							Size(giu.Auto, progressHeight),
@@ -345,7 +456,8 @@ func (s *Ui) Run() {
 
 			// Ignore all input when the progress bar is shown
 			// This prevents any unexpected changes
-			if !s.progressModal.open && !s.deviceModal.open && !s.applyChangesModal.open {
+			// Keys typed to the tag input are not shortcuts
+			if !s.progressModal.open && !s.deviceModal.open && !s.applyChangesModal.open && !s.saveSearchModal.open && !giu.Context.IO().WantTextInput() {
 				s.handleKeyPress()
 			}
 		}
@@ -414,6 +526,211 @@ func (s *Ui) actionsWidget(bottomHeight float32) giu.Widget {
 		))
 }
 
+// Tags of the current image and the input for adding tags. Clicking a tag removes it.
+// Enter in the input picks the first suggestion and adds it to the image.
+func (s *Ui) tagsWidget() giu.Widget {
+	widgets := []giu.Widget{giu.Label("Tags:")}
+	for _, tag := range s.currentImageTags {
+		name := tag
+		widgets = append(widgets, giu.Button(name+" x").OnClick(func() {
+			s.sender.SendCommandToTopic(api.ImageTag, &api.TagImageCommand{
+				ImageId: s.imageManager.ActiveImageId(),
+				Tag:     name,
+				Remove:  true,
+			})
+		}))
+	}
+	widgets = append(widgets,
+		giu.InputText(&s.tagInput).Hint("Add tags").AutoComplete(s.allTags).Size(200),
+		giu.Custom(func() {
+			// Input is deactivated when Enter is pressed
+			active := giu.IsItemActive()
+			if s.tagInputActive && !active && (giu.IsKeyPressed(giu.KeyEnter) || giu.IsKeyPressed(giu.KeyKPEnter)) {
+				s.addTagsToImage()
+			}
+			s.tagInputActive = active
+		}),
+		giu.Button("Add").OnClick(s.addTagsToImage),
+		giu.Button(fmt.Sprintf("Add to all %d shown", s.totalImageCount)).OnClick(func() {
+			s.tagShownImages(false)
+		}),
+		giu.Button("Remove from all shown").OnClick(func() {
+			s.tagShownImages(true)
+		}),
+	)
+	return giu.Row(widgets...)
+}
+
+func (s *Ui) addTagsToImage() {
+	for _, tag := range apitype.ParseTags(s.tagInput) {
+		s.sender.SendCommandToTopic(api.ImageTag, &api.TagImageCommand{
+			ImageId: s.imageManager.ActiveImageId(),
+			Tag:     tag,
+		})
+	}
+	s.tagInput = ""
+}
+
+// Tags all the images shown with the current filter, not only the current image
+func (s *Ui) tagShownImages(remove bool) {
+	for _, tag := range apitype.ParseTags(s.tagInput) {
+		s.sender.SendCommandToTopic(api.ImageTagFiltered, &api.TagFilteredImagesCommand{
+			Filter: s.currentFilter,
+			Tag:    tag,
+			Remove: remove,
+		})
+	}
+	s.tagInput = ""
+}
+
+// Search query input. Enter shows only the images that match the query and an
+// empty query shows all of them again. Invalid query is reported next to the input.
+// The order of the images is selected next to the search.
+func (s *Ui) searchWidget() giu.Widget {
+	order := s.currentFilter.Order
+	sortField := int32(0)
+	for i, field := range apitype.SortFields {
+		if field == order.SortField() {
+			sortField = int32(i)
+		}
+	}
+	descending := order.Descending
+	return giu.Row(
+		giu.Label("Search:"),
+		giu.InputText(&s.searchInput).Hint(`e.g. category:Good AND NOT tag:blurry AND width>3000`).Size(400),
+		giu.Custom(func() {
+			// Input is deactivated when Enter is pressed
+			active := giu.IsItemActive()
+			if s.searchInputActive && !active && (giu.IsKeyPressed(giu.KeyEnter) || giu.IsKeyPressed(giu.KeyKPEnter)) {
+				s.search()
+			}
+			s.searchInputActive = active
+		}),
+		giu.Button("Search").OnClick(s.search),
+		giu.Button("Clear").OnClick(func() {
+			s.searchInput = ""
+			s.search()
+		}),
+		giu.Button("Save as...").OnClick(s.openSaveSearchView),
+		giu.Condition(s.searchError != "", giu.Layout{
+			giu.Style().SetColor(giu.StyleColorText, conflictColor).To(giu.Label(s.searchError)),
+		}, nil),
+		giu.Label("Sort:"),
+		giu.Combo("##SortField", order.SortField().Description(), sortFieldDescriptions, &sortField).
+			Size(120).
+			OnChange(func() {
+				s.setSortOrder(apitype.SortOrder{Field: apitype.SortFields[sortField], Descending: descending})
+			}),
+		giu.Checkbox("Descending", &descending).OnChange(func() {
+			order.Descending = descending
+			s.setSortOrder(order)
+		}),
+		giu.Condition(order.SortField() == apitype.SortByRandom, giu.Layout{
+			giu.Button("Shuffle").OnClick(func() {
+				s.setSortOrder(apitype.SortOrder{Field: apitype.SortByRandom, Descending: descending})
+			}),
+		}, nil),
+	)
+}
+
+// Random order gets a new seed every time it is selected
+func (s *Ui) setSortOrder(order apitype.SortOrder) {
+	if order.Field == apitype.SortByRandom && order.Seed == 0 {
+		order.Seed = time.Now().UnixNano()
+	}
+	s.sender.SendCommandToTopic(api.ImageSetSortOrder, &api.SortOrderCommand{Order: order})
+}
+
+func (s *Ui) search() {
+	if query, err := apitype.ParseSearchQuery(s.searchInput); err != nil {
+		s.searchError = err.Error()
+	} else {
+		s.searchError = ""
+		s.sender.SendCommandToTopic(api.ImageShowMatching, &api.SearchCommand{Query: query})
+	}
+}
+
+// Saved searches with the number of images that match them. Clicking a search shows
+// the matching images and clicking it again shows all the images.
+func (s *Ui) savedSearchesWidget() giu.Widget {
+	widgets := []giu.Widget{giu.Label("Saved searches:")}
+	for _, savedSearchCount := range s.savedSearches {
+		savedSearch := savedSearchCount.SavedSearch
+		label := fmt.Sprintf("%s (%d)", savedSearch.Name(), savedSearchCount.ImageCount)
+		if s.isSavedSearchShown(savedSearch) {
+			label = "[" + label + "]"
+		}
+		tooltip := savedSearch.Query().String()
+		if shortcut := savedSearch.ShortcutAsString(); shortcut != "" {
+			tooltip += "\nShortcut: " + shortcut
+		}
+		widgets = append(widgets,
+			giu.Button(fmt.Sprintf("%s##SavedSearch%d", label, savedSearch.Id())).OnClick(func() {
+				s.toggleSavedSearch(savedSearch)
+			}),
+			giu.Tooltip(tooltip),
+			giu.Button(fmt.Sprintf("x##RemoveSavedSearch%d", savedSearch.Id())).OnClick(func() {
+				s.sender.SendCommandToTopic(api.SavedSearchRemove, &api.RemoveSavedSearchCommand{Id: savedSearch.Id()})
+			}),
+		)
+	}
+	return giu.Row(widgets...)
+}
+
+func (s *Ui) isSavedSearchShown(savedSearch *apitype.SavedSearch) bool {
+	return s.currentFilter.Query != nil && s.currentFilter.Query.String() == savedSearch.Query().String()
+}
+
+func (s *Ui) toggleSavedSearch(savedSearch *apitype.SavedSearch) {
+	if s.isSavedSearchShown(savedSearch) {
+		s.searchInput = ""
+	} else {
+		s.searchInput = savedSearch.Query().String()
+	}
+	s.search()
+}
+
+func (s *Ui) openSaveSearchView() {
+	if query, err := apitype.ParseSearchQuery(s.searchInput); err != nil {
+		s.searchError = err.Error()
+	} else if query == nil {
+		s.searchError = "Type a query to save"
+	} else {
+		s.searchError = ""
+		s.saveSearchModal = saveSearchModal{open: true, query: query.String()}
+	}
+}
+
+func getSaveSearchModal(id string, sender api.Sender, modal *saveSearchModal) giu.Widget {
+	return giu.PopupModal(id).
+		Flags(giu.WindowFlagsAlwaysAutoResize|giu.WindowFlagsNoDecoration).
+		Layout(
+			giu.Label("Save search: "+modal.query),
+			giu.InputText(&modal.name).Label("Name").Size(300),
+			giu.InputText(&modal.shortcut).Label("Shortcut").Hint("e.g. Alt+U").Size(300),
+			giu.Row(
+				giu.Button("Save##SaveSearch").
+					Disabled(strings.TrimSpace(modal.name) == "").
+					OnClick(func() {
+						sender.SendCommandToTopic(api.SavedSearchSave, &api.SaveSearchCommand{
+							Name:     modal.name,
+							Query:    modal.query,
+							Shortcut: modal.shortcut,
+						})
+						modal.open = false
+					}),
+				giu.Button("Cancel##CancelSaveSearch").
+					OnClick(func() {
+						modal.open = false
+					}),
+			),
+			giu.Custom(func() {
+				if !modal.open {
+					giu.CloseCurrentPopup()
+				}
+			}))
+}
+
 func conditionalSize(condition bool, size float32) float32 {
 	if condition {
 		return size
@@ -560,6 +877,7 @@ func getProgressModal(id string, sender api.Sender, modal *progressModal) giu.Wi
 		Flags(giu.WindowFlagsAlwaysAutoResize|giu.WindowFlagsNoTitleBar).
 		Layout(
 			giu.Label(modal.label),
+			giu.Condition(modal.details != "", giu.Layout{giu.Label(modal.details)}, nil),
 			giu.Row(
 				giu.ProgressBar(float32(modal.position)/float32(modal.max)).
 					Overlay(fmt.Sprintf("%d/%d", modal.position, modal.max)),
@@ -621,21 +939,33 @@ func getDeviceModal(id string, sender api.Sender, modal *deviceModal) giu.Widget
 }
 
 func getApplyChangesModal(id string, sender api.Sender, modal *applyChangesModal) giu.Widget {
+	requestPlan := func() {
+		modal.requestPlan(sender)
+	}
 	return giu.PopupModal(id).
 		Flags(giu.WindowFlagsAlwaysAutoResize|giu.WindowFlagsNoDecoration).
 		Layout(
 			giu.Label(modal.label),
-			giu.Checkbox("Keep original images", &modal.keepOriginals),
-			giu.Checkbox("Fix orientation", &modal.fixOrientation),
+			giu.Checkbox("Keep original images", &modal.keepOriginals).OnChange(requestPlan),
+			giu.Checkbox("Fix orientation", &modal.fixOrientation).OnChange(requestPlan),
 			giu.SliderInt(&modal.quality, 0, 100).Label("Quality"),
+			giu.Checkbox("Flatten sub directories", &modal.flatten).OnChange(requestPlan),
+			giu.Checkbox("Write ratings to XMP sidecars", &modal.writeRatings).OnChange(requestPlan),
+			giu.Checkbox("Write tags as keywords", &modal.writeTags).OnChange(requestPlan),
+			giu.Combo("If target exists", apitype.ConflictPolicies[modal.conflictPolicy].Description(), conflictPolicyDescriptions, &modal.conflictPolicy),
+			giu.Combo("Copy method", apitype.CopyMethods[modal.copyMethod].Description(), copyMethodDescriptions, &modal.copyMethod),
+			giu.Combo("Output", apitype.OutputModes[modal.outputMode].Description(), outputModeDescriptions, &modal.outputMode).OnChange(requestPlan),
+			giu.InputText(&modal.renameTemplate).
+				Label("Rename files").
+				Hint("e.g. {exif:DateTimeOriginal:2006-01-02}_{seq:3}").
+				OnChange(requestPlan),
+			renameTemplateErrorWidget(modal.renameTemplateError()),
+			applyPlanWidget(modal.plan, apitype.ConflictPolicies[modal.conflictPolicy]),
 			giu.Row(
 				giu.Button("Apply##ApplyChanges").
+					Disabled(modal.plan == nil).
 					OnClick(func() {
-						sender.SendCommandToTopic(api.CategoryPersistAll, &api.PersistCategorizationCommand{
-							KeepOriginals:  modal.keepOriginals,
-							FixOrientation: modal.fixOrientation,
-							Quality:        int(modal.quality),
-						})
+						sender.SendCommandToTopic(api.CategoryPersistAll, modal.options())
 						modal.open = false
 					}),
 				giu.Button("Cancel##ApplyChanges").
@@ -643,6 +973,16 @@ func getApplyChangesModal(id string, sender api.Sender, modal *applyChangesModal
 						sender.SendToTopic(api.SimilarRequestStop)
 						modal.open = false
 					}),
+				giu.Button("Roll back previous apply##ApplyChanges").
+					OnClick(func() {
+						sender.SendToTopic(api.CategoryRollback)
+						modal.open = false
+					}),
+				giu.Button("Discard rollback data##ApplyChanges").
+					OnClick(func() {
+						sender.SendCommandToTopic(api.CategoryDiscardJob, &api.DiscardApplyJobCommand{})
+						modal.open = false
+					}),
 			),
 			giu.Custom(func() {
 				if !modal.open {
@@ -651,8 +991,123 @@ func getApplyChangesModal(id string, sender api.Sender, modal *applyChangesModal
 			}))
 }
 
+var conflictColor = color.RGBA{R: 255, G: 100, B: 100, A: 255}
+
+func renameTemplateErrorWidget(err error) giu.Widget {
+	if err == nil {
+		return giu.Dummy(0, 0)
+	}
+	return giu.Style().SetColor(giu.StyleColorText, conflictColor).To(giu.Label(err.Error()))
+}
+
+var sortFieldDescriptions = func() []string {
+	descriptions := make([]string, len(apitype.SortFields))
+	for i, field := range apitype.SortFields {
+		descriptions[i] = field.Description()
+	}
+	return descriptions
+}()
+
+var conflictPolicyDescriptions = func() []string {
+	descriptions := make([]string, len(apitype.ConflictPolicies))
+	for i, policy := range apitype.ConflictPolicies {
+		descriptions[i] = policy.Description()
+	}
+	return descriptions
+}()
+
+var copyMethodDescriptions = func() []string {
+	descriptions := make([]string, len(apitype.CopyMethods))
+	for i, method := range apitype.CopyMethods {
+		descriptions[i] = method.Description()
+	}
+	return descriptions
+}()
+
+var outputModeDescriptions = func() []string {
+	descriptions := make([]string, len(apitype.OutputModes))
+	for i, mode := range apitype.OutputModes {
+		descriptions[i] = mode.Description()
+	}
+	return descriptions
+}()
+
+func applyPlanWidget(plan *api.ApplyPlan, conflictPolicy apitype.ConflictPolicy) giu.Widget {
+	if plan == nil {
+		return giu.Label("Resolving changes...")
+	}
+
+	var rows []*giu.TableRowWidget
+	copyCount := 0
+	removedLinkCount := 0
+	for _, imagePlan := range plan.Images {
+		filters := strings.Join(imagePlan.Filters, ", ")
+		removeOriginal := ""
+		if imagePlan.RemoveOriginal {
+			removeOriginal = "Remove"
+		}
+		for _, plannedCopy := range imagePlan.Copies {
+			original := removeOriginal
+			if plannedCopy.Move {
+				original = "Move"
+			} else if plannedCopy.Link {
+				original = "Link"
+			}
+			target := plannedCopy.Target
+			if len(plannedCopy.AssociatedTargets) > 0 {
+				target += fmt.Sprintf(" (+%d files)", len(plannedCopy.AssociatedTargets))
+			}
+			var conflict giu.Widget = giu.Label("")
+			if plannedCopy.Conflict {
+				conflict = giu.Style().SetColor(giu.StyleColorText, conflictColor).To(giu.Label("Exists: " + conflictPolicy.Description()))
+			}
+			rows = append(rows, giu.TableRow(
+				giu.Label(imagePlan.Source),
+				giu.Label(target),
+				giu.Label(filters),
+				giu.Label(original),
+				conflict,
+			))
+			copyCount++
+		}
+		for _, removedLink := range imagePlan.RemovedLinks {
+			rows = append(rows, giu.TableRow(
+				giu.Label(imagePlan.Source),
+				giu.Label(removedLink),
+				giu.Label(""),
+				giu.Label("Remove link"),
+				giu.Label(""),
+			))
+			removedLinkCount++
+		}
+	}
+
+	summary := fmt.Sprintf("%d images, %d copies", len(plan.Images), copyCount)
+	if removedLinkCount > 0 {
+		summary += fmt.Sprintf(", %d links removed", removedLinkCount)
+	}
+	if plan.Conflicts > 0 {
+		summary += fmt.Sprintf(", %d targets already exist", plan.Conflicts)
+	}
+	return giu.Layout{
+		giu.Label(summary),
+		giu.Table().
+			FastMode(true).
+			Freeze(0, 1).
+			Size(800, 300).
+			Columns(
+				giu.TableColumn("Source"),
+				giu.TableColumn("Target"),
+				giu.TableColumn("Filters"),
+				giu.TableColumn("Original"),
+				giu.TableColumn("Conflict"),
+			).
+			Rows(rows...),
+	}
+}
+
 func (s *Ui) handleKeyPress() bool {
-	shiftDown, altDown, controlDown := getModifierStates()
+	shiftDown, _, controlDown := getModifierStates()
 
 	if giu.IsKeyPressed(giu.KeyF8) {
 		s.openCastToDeviceView()
@@ -670,6 +1125,16 @@ func (s *Ui) handleKeyPress() bool {
 		s.applyCategories()
 	}
 
+	// Undo and redo take precedence over the category shortcuts with the same keys
+	if controlDown && (giu.IsKeyPressed(giu.KeyY) || (shiftDown && giu.IsKeyPressed(giu.KeyZ))) {
+		s.sender.SendToTopic(api.CategorizeRedo)
+		return true
+	}
+	if controlDown && giu.IsKeyPressed(giu.KeyZ) {
+		s.sender.SendToTopic(api.CategorizeUndo)
+		return true
+	}
+
 	// Navigation
 
 	if giu.IsKeyPressed(giu.KeyPageDown) {
@@ -701,14 +1166,61 @@ func (s *Ui) handleKeyPress() bool {
 		s.zoomOut()
 	}
 
-	s.categoryKeyManager.HandleKeys(&guiapi.CategoryAction{
-		StayOnImage:      shiftDown,
-		ForceCategory:    controlDown,
-		ShowOnlyCategory: altDown,
-	})
+	heldModifiers := guiapi.HeldModifiers()
+	s.handleRatingKeys(heldModifiers)
+	s.categoryKeyManager.HandleKeys(heldModifiers)
 	return true
 }
 
+// Number keys rate the image the same way as in Lightroom unless a category
+// shortcut starts with the key. Alt shows only the images with the rating or the label.
+func (s *Ui) handleRatingKeys(heldModifiers common.KeyModifier) {
+	showOnly := heldModifiers == common.ModifierAlt
+	if s.categoryKeyManager.PendingSequence() != "" || (heldModifiers != common.NoModifiers && !showOnly) {
+		return
+	}
+
+	for i, key := range ratingKeys {
+		if !giu.IsKeyPressed(key) || s.categoryKeyManager.HasShortcutStartingWith(key) {
+			continue
+		}
+		if showOnly {
+			s.sender.SendCommandToTopic(api.ImageShowRated, &api.RatingFilterCommand{
+				MinRating:  apitype.Rating(i),
+				ColorLabel: s.currentFilter.ColorLabel,
+			})
+		} else {
+			s.sender.SendCommandToTopic(api.ImageRate, &api.RateImageCommand{
+				ImageId: s.imageManager.ActiveImageId(),
+				Rating:  apitype.Rating(i),
+			})
+		}
+	}
+	for key, colorLabel := range colorLabelKeys {
+		if !giu.IsKeyPressed(key) || s.categoryKeyManager.HasShortcutStartingWith(key) {
+			continue
+		}
+		if showOnly {
+			if s.currentFilter.ColorLabel == colorLabel {
+				colorLabel = apitype.NoColorLabel
+			}
+			s.sender.SendCommandToTopic(api.ImageShowRated, &api.RatingFilterCommand{
+				MinRating:  s.currentFilter.MinRating,
+				ColorLabel: colorLabel,
+			})
+		} else {
+			// Same label again removes it
+			if s.currentImageRating.ColorLabel == colorLabel {
+				colorLabel = apitype.NoColorLabel
+			}
+			s.sender.SendCommandToTopic(api.ImageSetColorLabel, &api.ColorLabelCommand{
+				ImageId:    s.imageManager.ActiveImageId(),
+				ColorLabel: colorLabel,
+			})
+		}
+	}
+}
+
 func getModifierStates() (shiftDown bool, altDown bool, controlDown bool) {
 	shiftDown = isShiftDown()
 	altDown = isAltDown()
@@ -792,18 +1304,32 @@ func (s *Ui) SetImages(command *api.SetImagesCommand) {
 func (s *Ui) SetCurrentImage(command *api.UpdateImageCommand) {
 	width, height := s.win.GetSize()
 	s.imageManager.SetCurrentImage(command.Image, float32(width), float32(height), s.zoomStatus)
-	s.currentCategoryId = command.CategoryId
+	if command.Filter.Query == nil && s.currentFilter.Query != nil {
+		// Query was cleared, e.g. by showing all images
+		s.searchInput = ""
+	}
+	s.currentFilter = command.Filter
 	s.sendCurrentImageChangedEvent()
 
-	s.imageCache.Purge()
 	s.totalImageCount = command.Total
 	s.currentImagePos = command.Index + 1
+	s.categoryEditWidget.SetPreviewImage(command.Image, command.MetaData)
 	s.currentImageMetaData = []string{}
 	for k, v := range command.MetaData.MetaData() {
 		s.currentImageMetaData = append(s.currentImageMetaData, fmt.Sprintf("%s: %s", k, v))
 	}
 	sort.Strings(s.currentImageMetaData)
 
+	// Files that are copied and removed together with the image are listed first
+	var associatedFiles []string
+	for _, companion := range command.Image.CompanionFiles() {
+		associatedFiles = append(associatedFiles, fmt.Sprintf("Companion file: %s", companion))
+	}
+	for _, sidecar := range command.Image.SidecarFiles() {
+		associatedFiles = append(associatedFiles, fmt.Sprintf("Sidecar file: %s", sidecar))
+	}
+	s.currentImageMetaData = append(associatedFiles, s.currentImageMetaData...)
+
 	giu.Update()
 }
 
@@ -822,6 +1348,34 @@ func (s *Ui) SetImageCategory(command *api.CategoriesCommand) {
 	}
 }
 
+func (s *Ui) SetImageRating(command *api.ImageRatingCommand) {
+	if command.ImageId == s.imageManager.ActiveImageId() {
+		s.currentImageRating = command.Rating
+		giu.Update()
+	}
+}
+
+func (s *Ui) SetImageTags(command *api.ImageTagsCommand) {
+	if command.ImageId == s.imageManager.ActiveImageId() {
+		s.currentImageTags = command.Tags
+		giu.Update()
+	}
+}
+
+func (s *Ui) SetTags(command *api.TagsCommand) {
+	s.allTags = command.Tags
+}
+
+func (s *Ui) SetSavedSearches(command *api.SavedSearchesCommand) {
+	s.savedSearches = command.SavedSearches
+	savedSearches := make([]*apitype.SavedSearch, len(command.SavedSearches))
+	for i, savedSearchCount := range command.SavedSearches {
+		savedSearches[i] = savedSearchCount.SavedSearch
+	}
+	s.categoryKeyManager.SetSavedSearches(savedSearches)
+	giu.Update()
+}
+
 func (s *Ui) UpdateProgress(command *api.UpdateProgressCommand) {
 	var progress *progressModal
 	if command.Modal {
@@ -834,6 +1388,7 @@ func (s *Ui) UpdateProgress(command *api.UpdateProgressCommand) {
 		logger.Trace.Printf("Progress '%s' completed", command.Name)
 		progress.open = false
 		progress.label = ""
+		progress.details = ""
 		progress.position = 1
 		progress.max = 1
 		progress.canCancel = false
@@ -841,6 +1396,7 @@ func (s *Ui) UpdateProgress(command *api.UpdateProgressCommand) {
 		logger.Trace.Printf("Update progress '%s' %d/%d", command.Name, command.Current, command.Total)
 		progress.open = true
 		progress.label = command.Name
+		progress.details = command.Details
 		progress.position = command.Current
 		progress.max = command.Total
 		progress.canCancel = command.CanCancel
@@ -926,6 +1482,20 @@ func (s *Ui) openCastToDeviceView() {
 
 func (s *Ui) applyCategories() {
 	s.applyChangesModal.open = true
+	s.applyChangesModal.requestPlan(s.sender)
+}
+
+func (s *Ui) ShowApplyPlan(command *api.ApplyPlanCommand) {
+	// Options may have been changed while the plan was resolved
+	modal := &s.applyChangesModal
+	if command.Options.KeepOriginals != modal.keepOriginals ||
+		command.Options.FixOrientation != modal.fixOrientation ||
+		command.Options.FlattenSubDirectories != modal.flatten {
+		logger.Debug.Printf("Ignoring outdated apply plan")
+		return
+	}
+	modal.plan = command.Plan
+	giu.Update()
 }
 
 func (s *Ui) changeDirectory() {
@@ -956,3 +1526,25 @@ func getJumpSizeWithModifiers() int {
 		ctrlText:   hugeJumpSize,
 	})
 }
+
+func describeRating(rating *apitype.ImageRating) string {
+	if rating.ColorLabel == apitype.NoColorLabel {
+		return rating.Rating.String()
+	}
+	return fmt.Sprintf("%s, %s", rating.Rating, rating.ColorLabel)
+}
+
+// E.g. "[3+ stars, Red]" or empty if the images are not filtered by the rating
+func describeRatingFilter(filter *apitype.ImageFilter) string {
+	var conditions []string
+	if filter.MinRating != apitype.NoRating {
+		conditions = append(conditions, fmt.Sprintf("%d+ stars", filter.MinRating))
+	}
+	if filter.ColorLabel != apitype.NoColorLabel {
+		conditions = append(conditions, string(filter.ColorLabel))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(conditions, ", ") + "]"
+}